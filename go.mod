@@ -6,10 +6,13 @@ require (
 	github.com/gin-gonic/gin v1.7.7
 	github.com/go-playground/validator/v10 v10.6.0 // indirect
 	github.com/golang-jwt/jwt v3.2.1+incompatible
+	github.com/klauspost/compress v1.13.6
+	github.com/lib/pq v1.10.9
 	github.com/saashqdev/kubeworkz v1.2.0
 	github.com/olivere/elastic/v7 v7.0.24
 	github.com/swaggo/gin-swagger v1.3.0
 	github.com/swaggo/swag v1.7.1-0.20210326183817-17c1766b6349
+	google.golang.org/protobuf v1.27.1
 	k8s.io/api v0.23.2
 	k8s.io/apimachinery v0.23.2
 	k8s.io/apiserver v0.20.6
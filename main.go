@@ -25,10 +25,18 @@ import (
 	ginSwagger "github.com/swaggo/gin-swagger"
 	"github.com/swaggo/gin-swagger/swaggerFiles"
 
+	"audit/pkg/alert"
+	"audit/pkg/anomaly"
 	"audit/pkg/audit"
 	"audit/pkg/backend"
+	"audit/pkg/detection"
+	"audit/pkg/encryption"
 	"audit/pkg/healthz"
 	"audit/pkg/listener"
+	"audit/pkg/otlp"
+	"audit/pkg/redact"
+	"audit/pkg/session"
+	"audit/pkg/syslog"
 	"audit/pkg/utils/env"
 )
 
@@ -60,12 +68,42 @@ func main() {
 	router.POST(apiPathAuditRoot+"/kube", audit.HandleCubeAuditLog)
 	router.POST(apiPathAuditRoot+"/webconsole", audit.HandleWebconsoleAuditLog)
 	router.POST(apiPathAuditRoot+"/generic", audit.HandleGenericAuditLog)
+	router.POST(apiPathAuditRoot+"/cloudevents", audit.HandleCloudEvents)
+	router.POST(apiPathAuditRoot+"/otlp/v1/logs", audit.HandleOTLPLogs)
 
 	router.GET(apiPathAuditRoot, audit.SearchAuditLog)
+	router.POST(apiPathAuditRoot+"/search", audit.SearchAuditLog)
+	router.GET(apiPathAuditRoot+"/tail", audit.TailAuditLog)
+	router.GET(apiPathAuditRoot+"/statistics", audit.AggregateAuditLog)
+	router.POST(apiPathAuditRoot+"/statistics", audit.AggregateAuditLog)
 	router.GET(apiPathAuditRoot+"/export", audit.ExportAuditLog)
-
+	router.POST(apiPathAuditRoot+"/export/jobs", audit.CreateExportJob)
+	router.GET(apiPathAuditRoot+"/export/jobs", audit.ListExportJobs)
+	router.GET(apiPathAuditRoot+"/export/jobs/:id", audit.GetExportJob)
+	router.GET(apiPathAuditRoot+"/export/jobs/:id/download", audit.DownloadExportJob)
+
+	router.GET(apiPathAuditRoot+"/baselines/:user", audit.GetBaseline)
+	router.GET(apiPathAuditRoot+"/sessions", audit.ListSessions)
+	router.GET(apiPathAuditRoot+"/sessions/:id", audit.GetSession)
+	router.GET(apiPathAuditRoot+"/sessions/:id/cast", audit.GetSessionCast)
+	router.GET(apiPathAuditRoot+"/alert/rules", audit.ListAlertRules)
+	router.POST(apiPathAuditRoot+"/alert/rules", audit.CreateAlertRule)
+	router.GET(apiPathAuditRoot+"/alert/rules/:id", audit.GetAlertRule)
+	router.PUT(apiPathAuditRoot+"/alert/rules/:id", audit.UpdateAlertRule)
+	router.DELETE(apiPathAuditRoot+"/alert/rules/:id", audit.DeleteAlertRule)
+	router.GET(apiPathAuditRoot+"/alert/alerts", audit.ListAlerts)
+
+	redact.Start()
+	encryption.Start()
 	b := backend.NewBackend()
+	anomaly.Start()
+	alert.Start()
+	detection.Start()
+	session.Start()
+	otlp.Start()
+	syslog.Start()
 	go b.Run()
+	audit.StartExportJobs()
 
 	err := router.Run(":" + env.Port())
 	if err != nil {
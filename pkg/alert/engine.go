@@ -0,0 +1,283 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alert
+
+import (
+	"audit/pkg/backend"
+	v1 "audit/pkg/backend/v1"
+	"audit/pkg/utils/env"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/saashqdev/kubeworkz/pkg/clog"
+)
+
+const (
+	MaxRules = 500
+
+	alertQueueSize     = 1000
+	recentAlertsSize   = 100
+	alertPruneInterval = time.Minute
+)
+
+var (
+	ErrNotFound     = errors.New("alert rule not found")
+	ErrTooManyRules = errors.New("too many alert rules")
+)
+
+var engine *Engine
+
+// Engine evaluates the alert rules on every ingested event and sends the
+// alerts of the firing rules to their notifiers in the background
+type Engine struct {
+	path string
+
+	mu     sync.Mutex
+	rules  map[string]*compiledRule
+	recent []Alert
+	queue  chan *alertDelivery
+}
+
+type alertDelivery struct {
+	alert     *Alert
+	notifiers []Notifier
+}
+
+// Start loads the persisted rules and evaluates them on the events taken from
+// the backend cache, it must be called before the backend runs
+func Start() {
+	e := &Engine{
+		path:  env.AlertRulePath(),
+		rules: make(map[string]*compiledRule),
+		queue: make(chan *alertDelivery, alertQueueSize),
+	}
+	if err := e.load(); err != nil {
+		clog.Error("load alert rules from %s error: %s", e.path, err)
+		return
+	}
+	go e.deliver()
+	go e.prune()
+	backend.AddObserver(e.observe)
+	engine = e
+}
+
+// GetEngine returns the running engine, nil when its rules could not be loaded
+func GetEngine() *Engine {
+	return engine
+}
+
+func (e *Engine) load() error {
+	bs, err := ioutil.ReadFile(e.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var rules []Rule
+	if err = json.Unmarshal(bs, &rules); err != nil {
+		return err
+	}
+	for i := range rules {
+		r, err := compile(&rules[i])
+		if err != nil {
+			// keep the rule so it is not lost, an update can fix it
+			clog.Error("compile alert rule %s error: %s", rules[i].ID, err)
+			rules[i].Enabled = false
+			r = &compiledRule{Rule: rules[i]}
+		}
+		e.rules[r.ID] = r
+	}
+	return nil
+}
+
+// persist writes all rules, it must be called with the lock held
+func (e *Engine) persist() error {
+	bs, err := json.Marshal(e.list())
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(e.path), 0755); err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(e.path+".tmp", bs, 0600); err != nil {
+		return err
+	}
+	return os.Rename(e.path+".tmp", e.path)
+}
+
+func (e *Engine) list() []Rule {
+	rules := make([]Rule, 0, len(e.rules))
+	for _, r := range e.rules {
+		rules = append(rules, r.Rule)
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].CreatedAt < rules[j].CreatedAt
+	})
+	return rules
+}
+
+// Rules returns the rules, oldest first
+func (e *Engine) Rules() []Rule {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.list()
+}
+
+func (e *Engine) Rule(id string) (*Rule, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	r, ok := e.rules[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	rule := r.Rule
+	return &rule, nil
+}
+
+// Create validates and adds a rule, returning it with its ID
+func (e *Engine) Create(rule Rule) (*Rule, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	rule.ID = hex.EncodeToString(id)
+	rule.CreatedAt = time.Now().Unix()
+	rule.UpdatedAt = rule.CreatedAt
+	r, err := compile(&rule)
+	if err != nil {
+		return nil, err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.rules) >= MaxRules {
+		return nil, ErrTooManyRules
+	}
+	e.rules[r.ID] = r
+	if err = e.persist(); err != nil {
+		delete(e.rules, r.ID)
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// Update replaces a rule, its groups start counting again
+func (e *Engine) Update(id string, rule Rule) (*Rule, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	old, ok := e.rules[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	rule.ID = id
+	rule.CreatedAt = old.CreatedAt
+	rule.UpdatedAt = time.Now().Unix()
+	r, err := compile(&rule)
+	if err != nil {
+		return nil, err
+	}
+	e.rules[id] = r
+	if err = e.persist(); err != nil {
+		e.rules[id] = old
+		return nil, err
+	}
+	return &rule, nil
+}
+
+func (e *Engine) Delete(id string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	old, ok := e.rules[id]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(e.rules, id)
+	if err := e.persist(); err != nil {
+		e.rules[id] = old
+		return err
+	}
+	return nil
+}
+
+// RecentAlerts returns the latest alerts fired since the start, newest first
+func (e *Engine) RecentAlerts() []Alert {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	alerts := make([]Alert, len(e.recent))
+	for i, a := range e.recent {
+		alerts[len(alerts)-1-i] = a
+	}
+	return alerts
+}
+
+// observe evaluates the enabled rules on an event
+func (e *Engine) observe(event *v1.Event) {
+	now := time.Now()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, r := range e.rules {
+		if !r.Enabled {
+			continue
+		}
+		a := r.observe(event, now)
+		if a == nil {
+			continue
+		}
+		clog.Info("alert rule %s fires: %s", r.Name, a.summary())
+		if len(e.recent) >= recentAlertsSize {
+			e.recent = e.recent[1:]
+		}
+		e.recent = append(e.recent, *a)
+		select {
+		case e.queue <- &alertDelivery{alert: a, notifiers: r.notifiers}:
+		default:
+			clog.Warn("alert queue is full, drop alert of rule %s", r.Name)
+		}
+	}
+}
+
+func (e *Engine) deliver() {
+	for d := range e.queue {
+		for _, n := range d.notifiers {
+			if err := n.Notify(d.alert); err != nil {
+				clog.Error("notify alert of rule %s error: %s", d.alert.RuleName, err)
+			}
+		}
+	}
+}
+
+// prune forgets the groups that stopped counting
+func (e *Engine) prune() {
+	ticker := time.NewTicker(alertPruneInterval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		e.mu.Lock()
+		for _, r := range e.rules {
+			r.prune(now)
+		}
+		e.mu.Unlock()
+	}
+}
@@ -0,0 +1,279 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alert
+
+import (
+	v1 "audit/pkg/backend/v1"
+	"audit/pkg/cloudevents"
+	"audit/pkg/utils/env"
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	NotifierWebhook = "webhook"
+	NotifierSlack   = "slack"
+	NotifierEmail   = "email"
+
+	NotifyTimeout = time.Second * 10
+)
+
+// Alert is sent to the notifiers of a rule when it fires
+type Alert struct {
+	RuleID    string
+	RuleName  string
+	Severity  string
+	Threshold int
+	Window    string
+	// Group holds the values of the GroupBy fields of the rule
+	Group   map[string]string
+	FiredAt int64
+	// Event is the event that made the rule fire, with its encrypted fields encrypted
+	Event v1.Event
+}
+
+// NotifierConfig tells where the alerts of a rule are sent
+type NotifierConfig struct {
+	// Type is webhook, slack or email
+	Type string
+	// URL of a webhook or Slack compatible incoming webhook
+	URL string
+	// To are the mail recipients
+	To []string
+	// CloudEvents posts the alerts of a webhook as CloudEvents in structured mode
+	CloudEvents bool
+}
+
+type Notifier interface {
+	Notify(a *Alert) error
+}
+
+// notifierTypes builds the notifiers of each type, more types plug in here
+var notifierTypes = map[string]func(cfg *NotifierConfig) (Notifier, error){
+	NotifierWebhook: newWebhookNotifier,
+	NotifierSlack:   newSlackNotifier,
+	NotifierEmail:   newEmailNotifier,
+}
+
+var httpClient = &http.Client{Timeout: NotifyTimeout}
+
+func newNotifier(cfg *NotifierConfig) (Notifier, error) {
+	newFunc, ok := notifierTypes[cfg.Type]
+	if !ok {
+		return nil, fmt.Errorf("type %q is not webhook, slack or email", cfg.Type)
+	}
+	if cfg.CloudEvents && cfg.Type != NotifierWebhook {
+		return nil, fmt.Errorf("only webhooks send CloudEvents")
+	}
+	return newFunc(cfg)
+}
+
+// summary describes the alert in one line
+func (a *Alert) summary() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "[%s] %s", a.Severity, a.RuleName)
+	if a.Threshold > 0 {
+		fmt.Fprintf(&b, ": more than %d events in %s", a.Threshold, a.Window)
+	}
+	keys := make([]string, 0, len(a.Group))
+	for k := range a.Group {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for i, k := range keys {
+		sep := ", "
+		if i == 0 {
+			sep = " for "
+		}
+		fmt.Fprintf(&b, "%s%s %s", sep, k, a.Group[k])
+	}
+	e := &a.Event
+	user := ""
+	if e.UserIdentity != nil {
+		user = e.UserIdentity.AccountId
+	}
+	fmt.Fprintf(&b, ", last event: %s %s by %s from %s, status %d", e.RequestMethod, e.EventName, user, e.SourceIpAddress, e.ResponseStatus)
+	return b.String()
+}
+
+func validURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url %q is not an http or https url", s)
+	}
+	return nil
+}
+
+func post(url string, body interface{}) error {
+	return postAs(url, "application/json", body)
+}
+
+func postAs(url, contentType string, body interface{}) error {
+	bs, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	resp, err := httpClient.Post(url, contentType, bytes.NewReader(bs))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("post alert to %s error[%d]", url, resp.StatusCode)
+	}
+	return nil
+}
+
+// webhookNotifier posts the alert as json, or as the data of a CloudEvent
+type webhookNotifier struct {
+	url         string
+	cloudEvents bool
+}
+
+func newWebhookNotifier(cfg *NotifierConfig) (Notifier, error) {
+	if err := validURL(cfg.URL); err != nil {
+		return nil, err
+	}
+	return &webhookNotifier{url: cfg.URL, cloudEvents: cfg.CloudEvents}, nil
+}
+
+func (n *webhookNotifier) Notify(a *Alert) error {
+	if !n.cloudEvents {
+		return post(n.url, a)
+	}
+	e, err := cloudevents.New(env.CloudEventsSource(), cloudevents.TypeAlert, a.RuleName, time.Unix(a.FiredAt, 0), a)
+	if err != nil {
+		return err
+	}
+	return postAs(n.url, cloudevents.ContentType, e)
+}
+
+// slackNotifier posts the summary of the alert as a message to a Slack
+// compatible incoming webhook
+type slackNotifier struct {
+	url string
+}
+
+func newSlackNotifier(cfg *NotifierConfig) (Notifier, error) {
+	if err := validURL(cfg.URL); err != nil {
+		return nil, err
+	}
+	return &slackNotifier{url: cfg.URL}, nil
+}
+
+func (n *slackNotifier) Notify(a *Alert) error {
+	return post(n.url, map[string]string{"text": a.summary()})
+}
+
+// emailNotifier mails the alert with the SMTP server of the environment
+type emailNotifier struct {
+	server *env.SMTPServer
+	to     []string
+}
+
+func newEmailNotifier(cfg *NotifierConfig) (Notifier, error) {
+	server := env.SMTP()
+	if server.Addr == "" || server.From == "" {
+		return nil, fmt.Errorf("AUDIT_SMTP_ADDR and AUDIT_SMTP_FROM must be set to send mails")
+	}
+	if len(cfg.To) == 0 {
+		return nil, fmt.Errorf("recipients are required")
+	}
+	for _, to := range cfg.To {
+		if strings.ContainsAny(to, "\r\n") || !strings.Contains(to, "@") {
+			return nil, fmt.Errorf("recipient %q is not a mail address", to)
+		}
+	}
+	return &emailNotifier{server: server, to: cfg.To}, nil
+}
+
+func (n *emailNotifier) Notify(a *Alert) error {
+	event, err := json.MarshalIndent(&a.Event, "", "  ")
+	if err != nil {
+		return err
+	}
+	subject := strings.NewReplacer("\r", " ", "\n", " ").Replace(a.summary())
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", n.server.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(n.to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Unix(a.FiredAt, 0).Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n")
+	fmt.Fprintf(&msg, "%s\r\n\r\n%s\r\n", a.summary(), event)
+
+	return n.send(msg.Bytes())
+}
+
+// send mails the message like smtp.SendMail, within NotifyTimeout
+func (n *emailNotifier) send(msg []byte) error {
+	host, _, err := net.SplitHostPort(n.server.Addr)
+	if err != nil {
+		return err
+	}
+	conn, err := net.DialTimeout("tcp", n.server.Addr, NotifyTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err = conn.SetDeadline(time.Now().Add(NotifyTimeout)); err != nil {
+		return err
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err = c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if n.server.Username != "" {
+		if err = c.Auth(smtp.PlainAuth("", n.server.Username, n.server.Password, host)); err != nil {
+			return err
+		}
+	}
+	if err = c.Mail(n.server.From); err != nil {
+		return err
+	}
+	for _, to := range n.to {
+		if err = c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(msg); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
@@ -0,0 +1,237 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alert
+
+import (
+	v1 "audit/pkg/backend/v1"
+	"audit/pkg/encryption"
+	"audit/pkg/store"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+
+	maxThreshold = 10000
+	maxWindow    = time.Hour * 24
+	// maxGroups bounds the groups counted by one rule
+	maxGroups = 10000
+)
+
+// Rule fires an alert when more than Threshold events matching its query
+// arrive within Window, e.g. more than 20 events with status 403 from one
+// user in 5 minutes is
+//
+//	{"Query": "status:403", "Threshold": 20, "Window": "5m", "GroupBy": ["user"]}
+type Rule struct {
+	ID          string
+	Name        string
+	Description string
+	Enabled     bool
+	// Severity is info, warning or critical, warning by default
+	Severity string
+	// Query is a search expression selecting the events counted, all events when empty
+	Query string
+	// Threshold is the number of events the rule tolerates within Window, 0 fires on every event
+	Threshold int
+	// Window is the duration events are counted over, e.g. 5m
+	Window string
+	// GroupBy are fields counted separately, e.g. user counts the events of each user
+	GroupBy []string
+	// Suppress is how long a group does not fire again after firing, Window by default
+	Suppress  string
+	Notifiers []NotifierConfig
+	CreatedAt int64
+	UpdatedAt int64
+}
+
+// InvalidRuleError tells why a rule can not be used
+type InvalidRuleError struct {
+	Reason string
+}
+
+func (e *InvalidRuleError) Error() string {
+	return e.Reason
+}
+
+func invalidRule(format string, a ...interface{}) error {
+	return &InvalidRuleError{Reason: fmt.Sprintf(format, a...)}
+}
+
+// compiledRule is a rule ready to evaluate events, with the recent events of each group
+type compiledRule struct {
+	Rule
+	expr      store.Expr
+	window    time.Duration
+	suppress  time.Duration
+	groupBy   []store.Field
+	notifiers []Notifier
+	groups    map[string]*group
+}
+
+type group struct {
+	values map[string]string
+	// times of the latest events, at most Threshold+1
+	times   []time.Time
+	firedAt time.Time
+}
+
+// compile validates the rule and fills its defaults
+func compile(rule *Rule) (*compiledRule, error) {
+	r := &compiledRule{groups: make(map[string]*group)}
+	rule.Name = strings.TrimSpace(rule.Name)
+	if rule.Name == "" {
+		return nil, invalidRule("name is required")
+	}
+	switch rule.Severity {
+	case "":
+		rule.Severity = SeverityWarning
+	case SeverityInfo, SeverityWarning, SeverityCritical:
+	default:
+		return nil, invalidRule("severity %q is not info, warning or critical", rule.Severity)
+	}
+
+	if strings.TrimSpace(rule.Query) != "" {
+		expr, err := store.ParseExpr(rule.Query)
+		if err != nil {
+			return nil, invalidRule("query: %s", err)
+		}
+		r.expr = expr
+	}
+
+	if rule.Threshold < 0 || rule.Threshold > maxThreshold {
+		return nil, invalidRule("threshold must be between 0 and %d", maxThreshold)
+	}
+	var err error
+	if rule.Threshold > 0 || rule.Window != "" {
+		if r.window, err = time.ParseDuration(rule.Window); err != nil || r.window < time.Second || r.window > maxWindow {
+			return nil, invalidRule("window %q is not a duration between 1s and 24h", rule.Window)
+		}
+	}
+	r.suppress = r.window
+	if rule.Suppress != "" {
+		if r.suppress, err = time.ParseDuration(rule.Suppress); err != nil || r.suppress < 0 || r.suppress > maxWindow {
+			return nil, invalidRule("suppress %q is not a duration up to 24h", rule.Suppress)
+		}
+	}
+
+	for _, name := range rule.GroupBy {
+		f, ok := store.FieldByName(name)
+		if !ok || !f.Aggregatable() {
+			return nil, invalidRule("events can not be grouped by %q", name)
+		}
+		r.groupBy = append(r.groupBy, f)
+	}
+
+	if len(rule.Notifiers) == 0 {
+		return nil, invalidRule("at least one notifier is required")
+	}
+	for i := range rule.Notifiers {
+		n, err := newNotifier(&rule.Notifiers[i])
+		if err != nil {
+			return nil, invalidRule("notifier %d: %s", i+1, err)
+		}
+		r.notifiers = append(r.notifiers, n)
+	}
+	r.Rule = *rule
+	return r, nil
+}
+
+// observe counts a matching event and returns the alert when the rule fires
+func (r *compiledRule) observe(e *v1.Event, now time.Time) *Alert {
+	if r.expr != nil && !store.Match(r.expr, e) {
+		return nil
+	}
+
+	key, values := r.groupKey(e)
+	g, ok := r.groups[key]
+	if !ok {
+		if len(r.groups) >= maxGroups {
+			r.prune(now)
+			if len(r.groups) >= maxGroups {
+				return nil
+			}
+		}
+		g = &group{values: values}
+		r.groups[key] = g
+	}
+
+	// only the latest Threshold+1 events matter to the threshold
+	times := g.times[:0]
+	for _, t := range g.times {
+		if now.Sub(t) < r.window {
+			times = append(times, t)
+		}
+	}
+	g.times = append(times, now)
+	if len(g.times) > r.Threshold+1 {
+		g.times = g.times[1:]
+	}
+
+	if len(g.times) <= r.Threshold {
+		return nil
+	}
+	if !g.firedAt.IsZero() && now.Sub(g.firedAt) < r.suppress {
+		return nil
+	}
+	g.firedAt = now
+	// the alert is notified and listed to every administrator, so it carries
+	// the event with its encrypted fields encrypted like the stored one
+	event := *e
+	encryption.Event(&event)
+	return &Alert{
+		RuleID:    r.ID,
+		RuleName:  r.Name,
+		Severity:  r.Severity,
+		Threshold: r.Threshold,
+		Window:    r.Window,
+		Group:     g.values,
+		FiredAt:   now.Unix(),
+		Event:     event,
+	}
+}
+
+// groupKey returns the key and the field values of the group of an event
+func (r *compiledRule) groupKey(e *v1.Event) (string, map[string]string) {
+	if len(r.groupBy) == 0 {
+		return "", nil
+	}
+	values := make(map[string]string, len(r.groupBy))
+	parts := make([]string, len(r.groupBy))
+	for i, f := range r.groupBy {
+		parts[i] = strings.Join(store.FieldValues(f, e), ",")
+		values[string(f)] = parts[i]
+	}
+	return strings.Join(parts, "\x00"), values
+}
+
+// prune forgets the groups with no event in the window and no running suppression
+func (r *compiledRule) prune(now time.Time) {
+	for key, g := range r.groups {
+		last := g.firedAt
+		if n := len(g.times); n > 0 && g.times[n-1].After(last) {
+			last = g.times[n-1]
+		}
+		if now.Sub(last) >= r.window && now.Sub(g.firedAt) >= r.suppress {
+			delete(r.groups, key)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alert
+
+import (
+	v1 "audit/pkg/backend/v1"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testNotifiers = []NotifierConfig{{Type: NotifierWebhook, URL: "http://127.0.0.1/alerts"}}
+
+func mustCompile(t *testing.T, rule Rule) *compiledRule {
+	rule.Notifiers = testNotifiers
+	r, err := compile(&rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func userEvent(user string, status int) *v1.Event {
+	return &v1.Event{
+		EventName:      "get pods",
+		ResponseStatus: status,
+		UserIdentity:   &v1.UserIdentity{AccountId: user},
+	}
+}
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		name      string
+		rule      Rule
+		wantErr   string
+		notifiers []NotifierConfig
+	}{
+		{name: "no name", rule: Rule{Name: " "}, wantErr: "name is required"},
+		{name: "severity", rule: Rule{Name: "r", Severity: "fatal"}, wantErr: "severity"},
+		{name: "query", rule: Rule{Name: "r", Query: "usr:alice"}, wantErr: "query"},
+		{name: "negative threshold", rule: Rule{Name: "r", Threshold: -1}, wantErr: "threshold"},
+		{name: "threshold without window", rule: Rule{Name: "r", Threshold: 5}, wantErr: "window"},
+		{name: "short window", rule: Rule{Name: "r", Threshold: 5, Window: "10ms"}, wantErr: "window"},
+		{name: "long suppress", rule: Rule{Name: "r", Suppress: "48h"}, wantErr: "suppress"},
+		{name: "group by", rule: Rule{Name: "r", GroupBy: []string{"requestparameters"}}, wantErr: "grouped"},
+		{name: "no notifier", rule: Rule{Name: "r"}, wantErr: "notifier", notifiers: []NotifierConfig{}},
+		{name: "notifier type", rule: Rule{Name: "r"}, wantErr: "notifier 1", notifiers: []NotifierConfig{{Type: "pager"}}},
+		{name: "valid", rule: Rule{Name: "r", Query: "status:403", Threshold: 5, Window: "5m", GroupBy: []string{"user"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.rule.Notifiers = testNotifiers
+			if tt.notifiers != nil {
+				tt.rule.Notifiers = tt.notifiers
+			}
+			r, err := compile(&tt.rule)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if r.Severity != SeverityWarning || r.suppress != r.window {
+					t.Errorf("severity %s and suppress %s, want %s and the window", r.Severity, r.suppress, SeverityWarning)
+				}
+				return
+			}
+			if _, ok := err.(*InvalidRuleError); !ok || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want an invalid rule about %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestObserve(t *testing.T) {
+	type step struct {
+		after time.Duration
+		user  string
+		fires bool
+	}
+	tests := []struct {
+		name  string
+		rule  Rule
+		steps []step
+	}{
+		{
+			name: "every event",
+			rule: Rule{Name: "r", Suppress: "0s"},
+			steps: []step{
+				{0, "alice", true},
+				{time.Second, "alice", true},
+			},
+		},
+		{
+			name: "threshold within the window",
+			rule: Rule{Name: "r", Threshold: 2, Window: "1m"},
+			steps: []step{
+				{0, "alice", false},
+				{10 * time.Second, "alice", false},
+				{20 * time.Second, "alice", true},
+			},
+		},
+		{
+			name: "events out of the window are not counted",
+			rule: Rule{Name: "r", Threshold: 2, Window: "1m"},
+			steps: []step{
+				{0, "alice", false},
+				{40 * time.Second, "alice", false},
+				{70 * time.Second, "alice", false},
+				{80 * time.Second, "alice", true},
+			},
+		},
+		{
+			name: "suppressed after firing",
+			rule: Rule{Name: "r", Threshold: 1, Window: "1m", Suppress: "5m"},
+			steps: []step{
+				{0, "alice", false},
+				{time.Second, "alice", true},
+				{2 * time.Second, "alice", false},
+				{4 * time.Minute, "alice", false},
+				{4*time.Minute + 30*time.Second, "alice", false},
+				{5*time.Minute + time.Second, "alice", true},
+			},
+		},
+		{
+			name: "groups are counted apart",
+			rule: Rule{Name: "r", Threshold: 1, Window: "1m", GroupBy: []string{"user"}},
+			steps: []step{
+				{0, "alice", false},
+				{time.Second, "bob", false},
+				{2 * time.Second, "alice", true},
+				{3 * time.Second, "bob", true},
+			},
+		},
+		{
+			name: "events not matching the query",
+			rule: Rule{Name: "r", Query: "user:alice"},
+			steps: []step{
+				{0, "bob", false},
+				{time.Second, "alice", true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := mustCompile(t, tt.rule)
+			start := time.Unix(1700000000, 0)
+			for i, s := range tt.steps {
+				a := r.observe(userEvent(s.user, 403), start.Add(s.after))
+				if (a != nil) != s.fires {
+					t.Fatalf("step %d: fired %v, want %v", i, a != nil, s.fires)
+				}
+				if a == nil {
+					continue
+				}
+				if a.RuleName != "r" || a.Event.UserIdentity.AccountId != s.user || a.FiredAt != start.Add(s.after).Unix() {
+					t.Errorf("step %d: alert = %+v", i, a)
+				}
+				if len(tt.rule.GroupBy) > 0 && a.Group["user"] != s.user {
+					t.Errorf("step %d: group = %v, want user %s", i, a.Group, s.user)
+				}
+			}
+		})
+	}
+}
+
+func TestPrune(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	tests := []struct {
+		name  string
+		rule  Rule
+		after time.Duration
+		want  int
+	}{
+		{"counting", Rule{Name: "r", Threshold: 5, Window: "1m", GroupBy: []string{"user"}}, 30 * time.Second, 2},
+		{"window passed", Rule{Name: "r", Threshold: 5, Window: "1m", GroupBy: []string{"user"}}, 2 * time.Minute, 0},
+		{"suppression running", Rule{Name: "r", Threshold: 0, Window: "1m", Suppress: "10m", GroupBy: []string{"user"}}, 2 * time.Minute, 2},
+		{"suppression over", Rule{Name: "r", Threshold: 0, Window: "1m", Suppress: "10m", GroupBy: []string{"user"}}, 11 * time.Minute, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := mustCompile(t, tt.rule)
+			r.observe(userEvent("alice", 200), start)
+			r.observe(userEvent("bob", 200), start)
+			r.prune(start.Add(tt.after))
+			if len(r.groups) != tt.want {
+				t.Errorf("%d groups left, want %d", len(r.groups), tt.want)
+			}
+		})
+	}
+}
+
+func TestMaxGroups(t *testing.T) {
+	r := mustCompile(t, Rule{Name: "r", Threshold: 5, Window: "1m", GroupBy: []string{"user"}})
+	start := time.Unix(1700000000, 0)
+	for i := 0; i < maxGroups; i++ {
+		r.observe(userEvent(fmt.Sprintf("user-%d", i), 200), start)
+	}
+
+	// a new group is not counted while the others are in the window
+	r.observe(userEvent("late", 200), start.Add(time.Second))
+	if _, ok := r.groups["late"]; ok || len(r.groups) != maxGroups {
+		t.Errorf("%d groups counted, want %d without the late one", len(r.groups), maxGroups)
+	}
+
+	// once they are out of it, they are pruned to make room
+	r.observe(userEvent("late", 200), start.Add(2*time.Minute))
+	if _, ok := r.groups["late"]; !ok || len(r.groups) != 1 {
+		t.Errorf("%d groups counted, want the late one only", len(r.groups))
+	}
+}
@@ -0,0 +1,193 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package anomaly
+
+import (
+	"audit/pkg/backend"
+	v1 "audit/pkg/backend/v1"
+	"audit/pkg/utils/env"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/saashqdev/kubeworkz/pkg/clog"
+)
+
+const (
+	// maxUsers bounds the baselines, users past it are not learned
+	maxUsers     = 10000
+	saveInterval = time.Minute
+)
+
+var learner *Learner
+
+// Learner learns the baselines of the users from the ingested events and
+// flags the events deviating from them with a finding
+type Learner struct {
+	path string
+	// minEvents is how many events of a user are learned before its events are flagged
+	minEvents int64
+
+	mu        sync.Mutex
+	baselines map[string]*Baseline
+	dirty     bool
+}
+
+// Start loads the baselines and learns from the events taken from the backend
+// cache, it must be called before the backend runs and before the observers
+// that should see the anomalies, like alert rules
+func Start() {
+	l := &Learner{
+		path:      env.BaselinePath(),
+		minEvents: int64(env.BaselineMinEvents()),
+		baselines: make(map[string]*Baseline),
+	}
+	if err := l.load(); err != nil {
+		clog.Error("load baselines from %s error: %s", l.path, err)
+	}
+	go l.save()
+	backend.AddObserver(l.observe)
+	learner = l
+}
+
+// GetBaseline returns a copy of the baseline of a user, nil when it has none
+func GetBaseline(user string) *Baseline {
+	if learner == nil {
+		return nil
+	}
+	return learner.baseline(user)
+}
+
+func (l *Learner) baseline(user string) *Baseline {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.baselines[user]
+	if !ok {
+		return nil
+	}
+	copied := *b
+	copied.Verbs = copyCounts(b.Verbs)
+	copied.Resources = copyCounts(b.Resources)
+	copied.Namespaces = copyCounts(b.Namespaces)
+	copied.IPs = copyCounts(b.IPs)
+	return &copied
+}
+
+// observe flags the event when it deviates from the baseline of its user, then learns it
+func (l *Learner) observe(e *v1.Event) {
+	if e.UserIdentity == nil || e.UserIdentity.AccountId == "" {
+		return
+	}
+	user := e.UserIdentity.AccountId
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.baselines[user]
+	if !ok {
+		if len(l.baselines) >= maxUsers {
+			return
+		}
+		b = newBaseline(user)
+		l.baselines[user] = b
+	}
+	if b.Events >= l.minEvents {
+		if f := finding(user, b.deviations(e)); f != nil {
+			e.Findings = append(e.Findings, *f)
+		}
+	}
+	b.learn(e)
+	l.dirty = true
+}
+
+func (l *Learner) load() error {
+	bs, err := ioutil.ReadFile(l.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var baselines []*Baseline
+	if err = json.Unmarshal(bs, &baselines); err != nil {
+		return err
+	}
+	for _, b := range baselines {
+		b.Verbs, b.Resources = orEmpty(b.Verbs), orEmpty(b.Resources)
+		b.Namespaces, b.IPs = orEmpty(b.Namespaces), orEmpty(b.IPs)
+		l.baselines[b.User] = b
+	}
+	return nil
+}
+
+func (l *Learner) save() {
+	ticker := time.NewTicker(saveInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := l.persist(); err != nil {
+			clog.Error("save baselines to %s error: %s", l.path, err)
+			l.mu.Lock()
+			l.dirty = true
+			l.mu.Unlock()
+		}
+	}
+}
+
+// persist writes the baselines when they changed
+func (l *Learner) persist() error {
+	l.mu.Lock()
+	if !l.dirty {
+		l.mu.Unlock()
+		return nil
+	}
+	baselines := make([]*Baseline, 0, len(l.baselines))
+	for _, b := range l.baselines {
+		baselines = append(baselines, b)
+	}
+	bs, err := json.Marshal(baselines)
+	l.dirty = false
+	l.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(l.path+".tmp", bs, 0600); err != nil {
+		return err
+	}
+	return os.Rename(l.path+".tmp", l.path)
+}
+
+func copyCounts(counts map[string]int64) map[string]int64 {
+	copied := make(map[string]int64, len(counts))
+	for k, v := range counts {
+		copied[k] = v
+	}
+	return copied
+}
+
+// orEmpty returns an empty map for the null counts of a loaded baseline
+func orEmpty(counts map[string]int64) map[string]int64 {
+	if counts == nil {
+		return make(map[string]int64)
+	}
+	return counts
+}
@@ -0,0 +1,178 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package anomaly
+
+import (
+	v1 "audit/pkg/backend/v1"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// usualTime is at 10:00 in the time zone of the server, which hours are counted in
+var usualTime = time.Date(2024, 5, 1, 10, 0, 0, 0, time.Local)
+
+// usualEvent is what the user of the tests does every day
+func usualEvent() *v1.Event {
+	return &v1.Event{
+		EventTime:       usualTime.Unix(),
+		RequestMethod:   "get",
+		Url:             "/api/v1/namespaces/dev/pods",
+		SourceIpAddress: "10.0.0.1",
+		UserIdentity:    &v1.UserIdentity{AccountId: "alice"},
+		ResourceReports: []v1.Resource{{ResourceType: "pods"}},
+	}
+}
+
+func newTestLearner(minEvents int64) *Learner {
+	return &Learner{minEvents: minEvents, baselines: make(map[string]*Baseline)}
+}
+
+func TestScoring(t *testing.T) {
+	tests := []struct {
+		name         string
+		change       func(e *v1.Event)
+		wantSeverity string
+		wantMessage  string
+	}{
+		{"usual", func(e *v1.Event) {}, "", ""},
+		{"new verb", func(e *v1.Event) { e.RequestMethod = "delete" }, "", ""},
+		{"new verb and address", func(e *v1.Event) {
+			e.RequestMethod = "delete"
+			e.SourceIpAddress = "192.0.2.7"
+		}, SeverityMedium, "unusual for alice: verb delete, ip 192.0.2.7"},
+		{"sensitive resource", func(e *v1.Event) {
+			e.ResourceReports = []v1.Resource{{ResourceType: "secrets"}}
+		}, SeverityMedium, "unusual for alice: resource secrets"},
+		{"across namespaces", func(e *v1.Event) { e.Url = "/api/v1/secrets" }, SeverityMedium, "namespace *"},
+		{"namespace of the event", func(e *v1.Event) {
+			e.Namespace = "prod"
+			e.SourceIpAddress = "192.0.2.7"
+		}, SeverityMedium, "unusual for alice: namespace prod, ip 192.0.2.7"},
+		{"secrets across namespaces", func(e *v1.Event) {
+			e.Url = "/api/v1/secrets"
+			e.ResourceReports = []v1.Resource{{ResourceType: "secrets"}}
+		}, SeverityHigh, "unusual for alice: resource secrets, namespace *"},
+		{"unusual hour", func(e *v1.Event) {
+			e.EventTime = usualTime.Add(12 * time.Hour).Unix()
+			e.SourceIpAddress = "192.0.2.7"
+		}, SeverityMedium, "hour 22:00"},
+		{"next hour", func(e *v1.Event) {
+			e.EventTime = usualTime.Add(time.Hour).Unix()
+			e.SourceIpAddress = "192.0.2.7"
+		}, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newTestLearner(10)
+			for i := 0; i < 10; i++ {
+				l.observe(usualEvent())
+			}
+			e := usualEvent()
+			tt.change(e)
+			l.observe(e)
+
+			if tt.wantSeverity == "" {
+				if len(e.Findings) != 0 {
+					t.Errorf("findings = %+v, want none", e.Findings)
+				}
+				return
+			}
+			if len(e.Findings) != 1 {
+				t.Fatalf("findings = %+v, want one", e.Findings)
+			}
+			f := e.Findings[0]
+			if f.Detection != DetectionUserAnomaly || f.Severity != tt.wantSeverity || !strings.Contains(f.Message, tt.wantMessage) {
+				t.Errorf("finding = %+v, want %s about %q", f, tt.wantSeverity, tt.wantMessage)
+			}
+		})
+	}
+}
+
+func TestMinEvents(t *testing.T) {
+	l := newTestLearner(3)
+	for i := 0; i < 5; i++ {
+		e := usualEvent()
+		// every event comes from another address in another namespace
+		e.SourceIpAddress = fmt.Sprintf("10.0.1.%d", i)
+		e.Url = fmt.Sprintf("/api/v1/namespaces/ns-%d/pods", i)
+		l.observe(e)
+		if flagged := len(e.Findings) > 0; flagged != (i >= 3) {
+			t.Errorf("event %d flagged %v, want %v", i, flagged, i >= 3)
+		}
+	}
+	if b := GetBaseline("alice"); b != nil {
+		t.Errorf("baseline without a learner = %+v", b)
+	}
+	if b := l.baseline("alice"); b == nil || b.Events != 5 || len(b.IPs) != 5 || b.Hours[usualTime.Hour()] != 5 {
+		t.Errorf("baseline = %+v", b)
+	}
+}
+
+func TestLearnBounds(t *testing.T) {
+	l := newTestLearner(1)
+	e := usualEvent()
+	e.UserIdentity = nil
+	l.observe(e)
+	if len(l.baselines) != 0 {
+		t.Errorf("an event without user was learned")
+	}
+
+	b := newBaseline("alice")
+	for i := 0; i < maxValues+10; i++ {
+		e := usualEvent()
+		e.SourceIpAddress = fmt.Sprintf("ip-%d", i)
+		b.learn(e)
+	}
+	if len(b.IPs) != maxValues || b.Events != maxValues+10 {
+		t.Errorf("%d addresses learned from %d events, want %d", len(b.IPs), b.Events, maxValues)
+	}
+	b.learn(usualEvent())
+	if b.IPs["10.0.0.1"] != 0 {
+		t.Errorf("a new address was learned past %d", maxValues)
+	}
+	b.learn(&v1.Event{EventTime: usualTime.Unix(), SourceIpAddress: "ip-1"})
+	if b.IPs["ip-1"] != 2 {
+		t.Errorf("a known address is counted %d times, want 2", b.IPs["ip-1"])
+	}
+}
+
+func TestPersist(t *testing.T) {
+	l := newTestLearner(1)
+	l.path = filepath.Join(t.TempDir(), "baselines", "baselines.json")
+	l.observe(usualEvent())
+	if err := l.persist(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := newTestLearner(1)
+	loaded.path = l.path
+	if err := loaded.load(); err != nil {
+		t.Fatal(err)
+	}
+	b := loaded.baseline("alice")
+	if b == nil || b.Events != 1 || b.Verbs["get"] != 1 || b.Namespaces["dev"] != 1 || b.FirstSeen != usualTime.Unix() {
+		t.Fatalf("loaded baseline = %+v", b)
+	}
+	// the copy returned is not the baseline learning
+	b.Verbs["delete"] = 1
+	if loaded.baselines["alice"].Verbs["delete"] != 0 {
+		t.Errorf("the baseline returned shares its counts")
+	}
+}
@@ -0,0 +1,206 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package anomaly
+
+import (
+	v1 "audit/pkg/backend/v1"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	DetectionUserAnomaly = "user-anomaly"
+
+	SeverityMedium = "medium"
+	SeverityHigh   = "high"
+
+	// clusterScope is the namespace of requests not made in a namespace
+	clusterScope = "*"
+
+	// maxValues bounds the values learned for each dimension of a baseline
+	maxValues = 1000
+	// rareHourRatio is the share of the activity under which an hour is unusual
+	rareHourRatio = 0.005
+
+	// an event is flagged from minScore, and high from highScore
+	minScore  = 2
+	highScore = 4
+)
+
+var namespaceRegexp = regexp.MustCompile(`/namespaces/([^/?]+)`)
+
+// sensitiveResources weigh more when a user touches them for the first time
+var sensitiveResources = map[string]bool{
+	"secrets":             true,
+	"serviceaccounts":     true,
+	"roles":               true,
+	"rolebindings":        true,
+	"clusterroles":        true,
+	"clusterrolebindings": true,
+}
+
+// Baseline is the usual activity of a user or service account, learned from its events
+type Baseline struct {
+	User      string
+	Events    int64
+	FirstSeen int64
+	LastSeen  int64
+	// the dimensions count the events of each value
+	Verbs      map[string]int64
+	Resources  map[string]int64
+	Namespaces map[string]int64
+	IPs        map[string]int64
+	// Hours count the events of each hour of the day, in the time zone of the server
+	Hours [24]int64
+}
+
+// deviation is a value a user has not been seen with, weighted by how suspicious it is
+type deviation struct {
+	dimension string
+	value     string
+	weight    int
+}
+
+func newBaseline(user string) *Baseline {
+	return &Baseline{
+		User:       user,
+		Verbs:      make(map[string]int64),
+		Resources:  make(map[string]int64),
+		Namespaces: make(map[string]int64),
+		IPs:        make(map[string]int64),
+	}
+}
+
+// deviations returns what is unusual about the event for the user
+func (b *Baseline) deviations(e *v1.Event) []deviation {
+	var devs []deviation
+	if e.RequestMethod != "" && b.Verbs[e.RequestMethod] == 0 {
+		devs = append(devs, deviation{"verb", e.RequestMethod, 1})
+	}
+	for _, resource := range resourceTypes(e) {
+		if b.Resources[resource] == 0 {
+			weight := 1
+			if sensitiveResources[resource] {
+				weight = 2
+			}
+			devs = append(devs, deviation{"resource", resource, weight})
+		}
+	}
+	if ns := namespace(e); b.Namespaces[ns] == 0 {
+		weight := 1
+		if ns == clusterScope {
+			weight = 2
+		}
+		devs = append(devs, deviation{"namespace", ns, weight})
+	}
+	if e.SourceIpAddress != "" && b.IPs[e.SourceIpAddress] == 0 {
+		devs = append(devs, deviation{"ip", e.SourceIpAddress, 1})
+	}
+	hour := eventHour(e)
+	around := b.Hours[(hour+23)%24] + b.Hours[hour] + b.Hours[(hour+1)%24]
+	if float64(around) < float64(b.Events)*rareHourRatio {
+		devs = append(devs, deviation{"hour", fmt.Sprintf("%02d:00", hour), 1})
+	}
+	return devs
+}
+
+// learn adds the event to the baseline
+func (b *Baseline) learn(e *v1.Event) {
+	b.Events++
+	if b.FirstSeen == 0 {
+		b.FirstSeen = e.EventTime
+	}
+	if e.EventTime > b.LastSeen {
+		b.LastSeen = e.EventTime
+	}
+	count(b.Verbs, e.RequestMethod)
+	for _, resource := range resourceTypes(e) {
+		count(b.Resources, resource)
+	}
+	count(b.Namespaces, namespace(e))
+	count(b.IPs, e.SourceIpAddress)
+	b.Hours[eventHour(e)]++
+}
+
+func count(values map[string]int64, value string) {
+	if value == "" {
+		return
+	}
+	if _, ok := values[value]; ok || len(values) < maxValues {
+		values[value]++
+	}
+}
+
+// finding scores the deviations, nil when they are not strong enough
+func finding(user string, devs []deviation) *v1.Finding {
+	score := 0
+	for _, d := range devs {
+		score += d.weight
+	}
+	if score < minScore {
+		return nil
+	}
+	severity := SeverityMedium
+	if score >= highScore {
+		severity = SeverityHigh
+	}
+	sort.SliceStable(devs, func(i, j int) bool {
+		return devs[i].weight > devs[j].weight
+	})
+	parts := make([]string, len(devs))
+	for i, d := range devs {
+		parts[i] = d.dimension + " " + d.value
+	}
+	return &v1.Finding{
+		Detection: DetectionUserAnomaly,
+		Severity:  severity,
+		Message:   fmt.Sprintf("unusual for %s: %s", user, strings.Join(parts, ", ")),
+	}
+}
+
+func resourceTypes(e *v1.Event) []string {
+	var types []string
+	for _, r := range e.ResourceReports {
+		if r.ResourceType != "" {
+			types = append(types, r.ResourceType)
+		}
+	}
+	return types
+}
+
+// namespace returns the namespace of the event, or else the one in the request
+// url, clusterScope when there is none
+func namespace(e *v1.Event) string {
+	if e.Namespace != "" {
+		return e.Namespace
+	}
+	if m := namespaceRegexp.FindStringSubmatch(e.Url); m != nil {
+		return m[1]
+	}
+	return clusterScope
+}
+
+func eventHour(e *v1.Event) int {
+	t := time.Now()
+	if e.EventTime > 0 {
+		t = time.Unix(e.EventTime, 0)
+	}
+	return t.Hour()
+}
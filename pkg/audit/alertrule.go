@@ -0,0 +1,186 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"audit/pkg/alert"
+	"audit/pkg/encryption"
+	"audit/pkg/utils/auth"
+	"audit/pkg/utils/errcode"
+	"audit/pkg/utils/response"
+
+	"github.com/gin-gonic/gin"
+	"github.com/saashqdev/kubeworkz/pkg/clog"
+)
+
+// alertEngine authenticates an administrator and returns the alert engine
+func alertEngine(c *gin.Context) (*alert.Engine, bool) {
+	engine := alert.GetEngine()
+	if engine == nil {
+		response.FailReturn(c, errcode.InternalServerError)
+		return nil, false
+	}
+	user := auth.GetUserFromReq(c)
+	if user == "" {
+		response.FailReturn(c, errcode.AuthenticateError)
+		return nil, false
+	}
+	if !checkIsAdmin(user) {
+		response.FailReturn(c, errcode.NoAuthority)
+		return nil, false
+	}
+	return engine, true
+}
+
+// alertRuleError maps the errors of the alert engine to error codes
+func alertRuleError(err error) *errcode.ErrorInfo {
+	switch e := err.(type) {
+	case *alert.InvalidRuleError:
+		return errcode.InvalidAlertRule(e.Reason)
+	}
+	switch err {
+	case alert.ErrNotFound:
+		return errcode.NotFound
+	case alert.ErrTooManyRules:
+		return errcode.TooManyAlertRules
+	}
+	clog.Error("alert rule error: %s", err)
+	return errcode.InternalServerError
+}
+
+// @Summary list alert rules
+// @Tags alert
+// @Success 200 {array} alert.Rule
+// @Failure 500 {object} errcode.ErrorInfo
+// @Router /api/v1/kube/audit/alert/rules  [get]
+func ListAlertRules(c *gin.Context) {
+	engine, ok := alertEngine(c)
+	if !ok {
+		return
+	}
+	response.SuccessReturn(c, engine.Rules())
+}
+
+// @Summary get alert rule
+// @Tags alert
+// @Param	id	path	string  true  "rule id"
+// @Success 200 {object} alert.Rule
+// @Failure 500 {object} errcode.ErrorInfo
+// @Router /api/v1/kube/audit/alert/rules/{id}  [get]
+func GetAlertRule(c *gin.Context) {
+	engine, ok := alertEngine(c)
+	if !ok {
+		return
+	}
+	rule, err := engine.Rule(c.Param("id"))
+	if err != nil {
+		response.FailReturn(c, alertRuleError(err))
+		return
+	}
+	response.SuccessReturn(c, rule)
+}
+
+// @Summary create alert rule
+// @Description the rule is evaluated on every event received from then on
+// @Tags alert
+// @Param	rule	body	alert.Rule  true  "rule"
+// @Success 200 {object} alert.Rule
+// @Failure 500 {object} errcode.ErrorInfo
+// @Router /api/v1/kube/audit/alert/rules  [post]
+func CreateAlertRule(c *gin.Context) {
+	engine, ok := alertEngine(c)
+	if !ok {
+		return
+	}
+	var rule alert.Rule
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		clog.Error("parse alert rule error: %s", err)
+		response.FailReturn(c, errcode.InvalidBodyFormat)
+		return
+	}
+	created, err := engine.Create(rule)
+	if err != nil {
+		response.FailReturn(c, alertRuleError(err))
+		return
+	}
+	response.SuccessReturn(c, created)
+}
+
+// @Summary update alert rule
+// @Description replace an alert rule, its counts start over
+// @Tags alert
+// @Param	id	path	string  true  "rule id"
+// @Param	rule	body	alert.Rule  true  "rule"
+// @Success 200 {object} alert.Rule
+// @Failure 500 {object} errcode.ErrorInfo
+// @Router /api/v1/kube/audit/alert/rules/{id}  [put]
+func UpdateAlertRule(c *gin.Context) {
+	engine, ok := alertEngine(c)
+	if !ok {
+		return
+	}
+	var rule alert.Rule
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		clog.Error("parse alert rule error: %s", err)
+		response.FailReturn(c, errcode.InvalidBodyFormat)
+		return
+	}
+	updated, err := engine.Update(c.Param("id"), rule)
+	if err != nil {
+		response.FailReturn(c, alertRuleError(err))
+		return
+	}
+	response.SuccessReturn(c, updated)
+}
+
+// @Summary delete alert rule
+// @Tags alert
+// @Param	id	path	string  true  "rule id"
+// @Success 200
+// @Failure 500 {object} errcode.ErrorInfo
+// @Router /api/v1/kube/audit/alert/rules/{id}  [delete]
+func DeleteAlertRule(c *gin.Context) {
+	engine, ok := alertEngine(c)
+	if !ok {
+		return
+	}
+	if err := engine.Delete(c.Param("id")); err != nil {
+		response.FailReturn(c, alertRuleError(err))
+		return
+	}
+	response.SuccessReturn(c, nil)
+}
+
+// @Summary list recent alerts
+// @Description the latest 100 alerts fired since the service started, newest first, with the encrypted fields of their events decrypted for the users allowed to read them
+// @Tags alert
+// @Success 200 {array} alert.Alert
+// @Failure 500 {object} errcode.ErrorInfo
+// @Router /api/v1/kube/audit/alert/alerts  [get]
+func ListAlerts(c *gin.Context) {
+	engine, ok := alertEngine(c)
+	if !ok {
+		return
+	}
+	alerts := engine.RecentAlerts()
+	if user := auth.GetUserFromReq(c); canDecrypt(user) {
+		for i := range alerts {
+			encryption.DecryptEvent(&alerts[i].Event)
+		}
+	}
+	response.SuccessReturn(c, alerts)
+}
@@ -0,0 +1,54 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"audit/pkg/anomaly"
+	"audit/pkg/utils/auth"
+	"audit/pkg/utils/errcode"
+	"audit/pkg/utils/response"
+
+	"github.com/gin-gonic/gin"
+)
+
+// @Summary get user baseline
+// @Description the usual verbs, resources, namespaces, source ips and hours of a user, learned from its events
+// @Tags audit
+// @Param	user	path	string  true  "user or service account name"
+// @Success 200 {object} anomaly.Baseline
+// @Failure 500 {object} errcode.ErrorInfo
+// @Router /api/v1/kube/audit/baselines/{user}  [get]
+func GetBaseline(c *gin.Context) {
+
+	// authority check
+	user := auth.GetUserFromReq(c)
+	if user == "" {
+		response.FailReturn(c, errcode.AuthenticateError)
+		return
+	}
+	if !checkIsAdmin(user) {
+		response.FailReturn(c, errcode.NoAuthority)
+		return
+	}
+
+	baseline := anomaly.GetBaseline(c.Param("user"))
+	if baseline == nil {
+		response.FailReturn(c, errcode.NotFound)
+		return
+	}
+	response.SuccessReturn(c, baseline)
+}
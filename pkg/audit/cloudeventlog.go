@@ -0,0 +1,59 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"audit/pkg/backend"
+	v1 "audit/pkg/backend/v1"
+	"audit/pkg/cloudevents"
+	"audit/pkg/utils/errcode"
+	"audit/pkg/utils/response"
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	"github.com/saashqdev/kubeworkz/pkg/clog"
+)
+
+// receive audit logs as CloudEvents, in structured or binary mode, single or batch
+func HandleCloudEvents(c *gin.Context) {
+
+	clog.Info("receive cloudevents")
+	events, err := cloudevents.Read(c.Request)
+	if err != nil {
+		clog.Error("read cloudevents error: %s", err)
+		response.FailReturn(c, errcode.InvalidEvent(err.Error()))
+		return
+	}
+	// the events are checked before any is cached, a batch is taken as a whole
+	auditEvents := make([]*v1.Event, 0, len(events))
+	for i := range events {
+		e, err := cloudevents.ToV1(&events[i])
+		if err != nil {
+			clog.Error("map cloudevent %s from %s error: %s", events[i].ID, events[i].Source, err)
+			response.FailReturn(c, errcode.InvalidEvent(fmt.Sprintf("event %s: %s", events[i].ID, err)))
+			return
+		}
+		auditEvents = append(auditEvents, e)
+	}
+	response.SuccessReturn(c, nil)
+
+	// send events to channel
+	ch := backend.GetCacheCh()
+	for _, e := range auditEvents {
+		backend.CacheEvent(ch, e)
+	}
+}
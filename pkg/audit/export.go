@@ -0,0 +1,207 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	v1 "audit/pkg/backend/v1"
+	"audit/pkg/encryption"
+	"audit/pkg/utils/env"
+	"audit/pkg/utils/errcode"
+	"audit/pkg/utils/response"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/saashqdev/kubeworkz/pkg/authentication/authenticators/token"
+	"github.com/saashqdev/kubeworkz/pkg/clog"
+	"github.com/saashqdev/kubeworkz/pkg/utils/constants"
+)
+
+const (
+	// exportPageSize is how many events are fetched from the store at a time
+	exportPageSize = 1000
+	// headerExportTruncated is set on exports cut short by the row limit
+	headerExportTruncated = "X-Export-Truncated"
+	// headerExportTotal holds the number of events matching the query of an export
+	headerExportTotal = "X-Export-Total"
+)
+
+type exportQuery struct {
+	auditQuery
+	// Format is one of csv, json, ndjson and xlsx, negotiated from the
+	// Accept header when empty
+	Format string `form:"format,omitempty"`
+	// Columns of the csv and xlsx exports, comma separated or repeated,
+	// see the README for the available columns
+	Columns []string `form:"columns,omitempty"`
+	// TimeZone is the IANA name of the time zone of the time column, e.g. Asia/Shanghai
+	TimeZone string `form:"timeZone,omitempty"`
+	// TimeFormat of the time column is one of datetime, rfc3339 and unix
+	TimeFormat string `form:"timeFormat,omitempty"`
+	// Lang of the headers is en or zh, taken from Accept-Language when empty
+	Lang string `form:"lang,omitempty"`
+}
+
+// eventStream pages through all events matching a query with a cursor,
+// up to a limit of events
+type eventStream struct {
+	query   auditQuery
+	limit   int
+	fetched int
+	total   int64
+	done    bool
+	// decrypt decrypts the encrypted fields of the events
+	decrypt bool
+}
+
+func newEventStream(query auditQuery, limit int) *eventStream {
+	query.Page = 1
+	query.Size = exportPageSize
+	query.Cursor = ""
+	query.useCursor = true
+	return &eventStream{query: query, limit: limit}
+}
+
+// next returns the next page of events, an empty page when the stream is exhausted
+func (s *eventStream) next() ([]v1.Event, *errcode.ErrorInfo) {
+	if s.done {
+		return nil, nil
+	}
+	result, err := searchLog(s.query)
+	if err != nil {
+		return nil, err
+	}
+	s.total = result.Total
+	events := result.Events
+	if s.limit > 0 && s.fetched+len(events) >= s.limit {
+		events = events[:s.limit-s.fetched]
+		s.done = true
+	}
+	s.fetched += len(events)
+	if s.decrypt {
+		for i := range events {
+			encryption.DecryptEvent(&events[i])
+		}
+	}
+	s.query.Cursor = result.Cursor
+	if result.Cursor == "" {
+		s.done = true
+	}
+	return events, nil
+}
+
+// truncated reports whether more events match than the limit lets through,
+// known once the first page is fetched
+func (s *eventStream) truncated() bool {
+	return s.limit > 0 && s.total > int64(s.limit)
+}
+
+// @Summary export audit log
+// @Description query and export audit log from the store, the file is streamed as it is read
+// @Tags audit
+// @Param	query	query	exportQuery  false  "key and value for query"
+// @Success 200 {string} string
+// @Failure 500 {object} errcode.ErrorInfo
+// @Router /api/v1/kube/audit/export  [get]
+func ExportAuditLog(c *gin.Context) {
+
+	// authority check
+	user, userErr := token.GetUserFromReq(c.Request)
+	if userErr != nil {
+		response.FailReturn(c, errcode.AuthenticateError)
+		return
+	}
+	if !checkIsAdmin(user.Username) {
+		response.FailReturn(c, errcode.NoAuthority)
+		return
+	}
+
+	var query exportQuery
+	if err := c.Bind(&query); err != nil {
+		clog.Error("parse search audit log param error: %s", err)
+		response.FailReturn(c, errcode.InvalidBodyFormat)
+		return
+	}
+	format := getExportFormat(c, query.Format)
+	if format == nil {
+		response.FailReturn(c, errcode.UnsupportedExportFormat)
+		return
+	}
+	opts, errInfo := newExportOptions(c, &query)
+	if errInfo != nil {
+		response.FailReturn(c, errInfo)
+		return
+	}
+
+	// fetch the first page before answering, so that errors still get a status
+	stream := newEventStream(query.auditQuery, env.ExportMaxRows())
+	stream.decrypt = canDecrypt(user.Username)
+	events, err := stream.next()
+	if err != nil {
+		response.FailReturn(c, err)
+		return
+	}
+	if len(events) == 0 {
+		response.FailReturn(c, errcode.NotFound)
+		return
+	}
+
+	if stream.truncated() {
+		clog.Warn("export of %s is truncated to %d of %d audit logs", user.Username, stream.limit, stream.total)
+		c.Writer.Header().Set(headerExportTruncated, "true")
+	}
+	c.Writer.Header().Set(headerExportTotal, strconv.FormatInt(stream.total, 10))
+
+	fileName := strconv.FormatInt(time.Now().Unix(), 10)
+	c.Writer.Header().Set(constants.HttpHeaderContentType, format.contentType)
+	c.Writer.Header().Set(constants.HttpHeaderContentDisposition, fmt.Sprintf("attachment;filename=%s.%s", fileName, format.fileExtension()))
+	c.Status(http.StatusOK)
+
+	wr := format.newWriter(c.Writer, opts)
+	for len(events) > 0 {
+		if err := wr.write(events); err != nil {
+			clog.Warn("write audit log export error: %s", err)
+			return
+		}
+		c.Writer.Flush()
+
+		events, err = stream.next()
+		if err != nil {
+			clog.Error("read audit log export page error: %s", err.Message)
+			abortStream(c)
+			return
+		}
+	}
+	if err := wr.close(); err != nil {
+		clog.Warn("write audit log export error: %s", err)
+		return
+	}
+	clog.Info("export %d of %d audit logs for %s", stream.fetched, stream.total, user.Username)
+}
+
+// abortStream drops the connection of a response that is already being
+// streamed, so that the client sees a failed download rather than a short file
+func abortStream(c *gin.Context) {
+	conn, _, err := c.Writer.Hijack()
+	if err != nil {
+		clog.Error("abort audit log export error: %s", err)
+		return
+	}
+	conn.Close()
+}
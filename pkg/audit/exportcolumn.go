@@ -0,0 +1,210 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	v1 "audit/pkg/backend/v1"
+	"audit/pkg/utils/errcode"
+	"strconv"
+	"strings"
+	"time"
+	// the image has no zoneinfo, so the time zones are built in
+	_ "time/tzdata"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	LangEn = "en"
+	LangZh = "zh"
+
+	TimeFormatDateTime = "datetime"
+	TimeFormatRFC3339  = "rfc3339"
+	TimeFormatUnix     = "unix"
+)
+
+var timeLayouts = map[string]string{
+	TimeFormatDateTime: "2006-01-02 15:04:05",
+	TimeFormatRFC3339:  time.RFC3339,
+}
+
+// exportColumn is a column of the csv and xlsx exports
+type exportColumn struct {
+	name string
+	// zh is the header in chinese, the english header is the name
+	zh      string
+	numeric bool
+	value   func(e *v1.Event) string
+}
+
+// exportColumns are all the columns that can be exported, the first
+// nine are exported by default
+var exportColumns = []*exportColumn{
+	{name: "eventID", zh: "事件ID", value: func(e *v1.Event) string { return e.RequestId }},
+	{name: "userIdentity", zh: "操作人", value: func(e *v1.Event) string {
+		if e.UserIdentity == nil {
+			return ""
+		}
+		return e.UserIdentity.AccountId
+	}},
+	// the time is formatted with the export options
+	{name: "time", zh: "操作时间"},
+	{name: "IPAddress", zh: "IP地址", value: func(e *v1.Event) string { return e.SourceIpAddress }},
+	{name: "eventName", zh: "事件名称", value: func(e *v1.Event) string { return e.EventName }},
+	{name: "requestMethod", zh: "请求方法", value: func(e *v1.Event) string { return e.RequestMethod }},
+	{name: "requestParams", zh: "请求参数", value: func(e *v1.Event) string { return e.RequestParameters }},
+	{name: "statusCode", zh: "状态码", numeric: true, value: func(e *v1.Event) string { return strconv.Itoa(e.ResponseStatus) }},
+	{name: "Url", zh: "请求URL", value: func(e *v1.Event) string { return e.Url }},
+	{name: "description", zh: "事件描述", value: func(e *v1.Event) string { return e.Description }},
+	{name: "eventType", zh: "事件类型", value: func(e *v1.Event) string { return e.EventType }},
+	{name: "userAgent", zh: "用户代理", value: func(e *v1.Event) string { return e.UserAgent }},
+	{name: "errorCode", zh: "错误码", value: func(e *v1.Event) string { return e.ErrorCode }},
+	{name: "errorMessage", zh: "错误信息", value: func(e *v1.Event) string { return e.ErrorMessage }},
+	{name: "responseElements", zh: "响应内容", value: func(e *v1.Event) string { return e.ResponseElements }},
+	{name: "resourceType", zh: "资源类型", value: func(e *v1.Event) string {
+		return joinResources(e, func(r *v1.Resource) string { return r.ResourceType })
+	}},
+	{name: "resourceName", zh: "资源名称", value: func(e *v1.Event) string {
+		return joinResources(e, func(r *v1.Resource) string { return r.ResourceName })
+	}},
+	{name: "resourceId", zh: "资源ID", value: func(e *v1.Event) string {
+		return joinResources(e, func(r *v1.Resource) string { return r.ResourceId })
+	}},
+}
+
+const defaultExportColumns = 9
+
+// joinResources joins a field of all the resources of an event
+func joinResources(e *v1.Event, field func(r *v1.Resource) string) string {
+	values := make([]string, len(e.ResourceReports))
+	for i := range e.ResourceReports {
+		values[i] = field(&e.ResourceReports[i])
+	}
+	return strings.Join(values, ";")
+}
+
+// exportOptions decide the columns of the csv and xlsx exports and how they are formatted
+type exportOptions struct {
+	columns    []*exportColumn
+	location   *time.Location
+	timeFormat string
+	lang       string
+}
+
+// newExportOptions checks the export options of the query. The language
+// falls back to the Accept-Language header and is written back to the
+// query, so that export jobs keep it.
+func newExportOptions(c *gin.Context, query *exportQuery) (*exportOptions, *errcode.ErrorInfo) {
+	if query.Lang == "" && c != nil {
+		query.Lang = LangEn
+		if strings.HasPrefix(strings.ToLower(c.GetHeader("Accept-Language")), LangZh) {
+			query.Lang = LangZh
+		}
+	}
+	return query.options()
+}
+
+func (q *exportQuery) options() (*exportOptions, *errcode.ErrorInfo) {
+	opts := &exportOptions{location: time.Local, timeFormat: TimeFormatDateTime, lang: LangEn}
+
+	for _, names := range q.Columns {
+		for _, name := range strings.Split(names, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			col := columnByName(name)
+			if col == nil {
+				return nil, errcode.InvalidExportOption("column " + name)
+			}
+			opts.columns = append(opts.columns, col)
+		}
+	}
+	if len(opts.columns) == 0 {
+		opts.columns = exportColumns[:defaultExportColumns]
+	}
+
+	if q.TimeZone != "" {
+		loc, err := time.LoadLocation(q.TimeZone)
+		if err != nil {
+			return nil, errcode.InvalidExportOption("time zone " + q.TimeZone)
+		}
+		opts.location = loc
+	}
+
+	if q.TimeFormat != "" {
+		opts.timeFormat = strings.ToLower(q.TimeFormat)
+		if _, ok := timeLayouts[opts.timeFormat]; !ok && opts.timeFormat != TimeFormatUnix {
+			return nil, errcode.InvalidExportOption("time format " + q.TimeFormat)
+		}
+	}
+
+	switch lang := strings.ToLower(q.Lang); {
+	case lang == "":
+	case strings.HasPrefix(lang, LangZh):
+		opts.lang = LangZh
+	case strings.HasPrefix(lang, LangEn):
+	default:
+		return nil, errcode.InvalidExportOption("language " + q.Lang)
+	}
+	return opts, nil
+}
+
+func columnByName(name string) *exportColumn {
+	for _, col := range exportColumns {
+		if strings.EqualFold(col.name, name) {
+			return col
+		}
+	}
+	return nil
+}
+
+func (o *exportOptions) header() []string {
+	header := make([]string, len(o.columns))
+	for i, col := range o.columns {
+		header[i] = col.name
+		if o.lang == LangZh {
+			header[i] = col.zh
+		}
+	}
+	return header
+}
+
+func (o *exportOptions) row(e *v1.Event) []string {
+	row := make([]string, len(o.columns))
+	for i, col := range o.columns {
+		if col.value == nil {
+			row[i] = o.formatTime(e.EventTime)
+			continue
+		}
+		row[i] = col.value(e)
+	}
+	return row
+}
+
+func (o *exportOptions) formatTime(t int64) string {
+	if o.timeFormat == TimeFormatUnix {
+		return strconv.FormatInt(t, 10)
+	}
+	return time.Unix(t, 0).In(o.location).Format(timeLayouts[o.timeFormat])
+}
+
+// isNumeric reports whether the i-th column holds numbers
+func (o *exportOptions) isNumeric(i int) bool {
+	col := o.columns[i]
+	return col.numeric || col.value == nil && o.timeFormat == TimeFormatUnix
+}
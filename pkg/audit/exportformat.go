@@ -0,0 +1,346 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"archive/zip"
+	v1 "audit/pkg/backend/v1"
+	"audit/pkg/cloudevents"
+	"audit/pkg/utils/env"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	ExportFormatCsv    = "csv"
+	ExportFormatJson   = "json"
+	ExportFormatNdjson = "ndjson"
+	ExportFormatXlsx   = "xlsx"
+	// ExportFormatCloudEvents is a batch of CloudEvents, each with an event in v2 as data
+	ExportFormatCloudEvents = "cloudevents"
+)
+
+// eventWriter writes exported events in one file format, close ends the file
+type eventWriter interface {
+	write(events []v1.Event) error
+	close() error
+}
+
+type exportFormat struct {
+	name        string
+	contentType string
+	// extension of the files, the name when it is empty
+	extension string
+	newWriter func(out io.Writer, opts *exportOptions) eventWriter
+}
+
+// exportFormats are offered in this order, the first one is the default
+var exportFormats = []*exportFormat{
+	{
+		name:        ExportFormatCsv,
+		contentType: "text/csv",
+		newWriter:   func(out io.Writer, opts *exportOptions) eventWriter { return newCsvWriter(out, opts) },
+	},
+	{
+		name:        ExportFormatJson,
+		contentType: "application/json",
+		newWriter:   func(out io.Writer, _ *exportOptions) eventWriter { return &jsonWriter{out: out} },
+	},
+	{
+		name:        ExportFormatNdjson,
+		contentType: "application/x-ndjson",
+		newWriter:   func(out io.Writer, _ *exportOptions) eventWriter { return &ndjsonWriter{enc: json.NewEncoder(out)} },
+	},
+	{
+		name:        ExportFormatXlsx,
+		contentType: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+		newWriter:   func(out io.Writer, opts *exportOptions) eventWriter { return newXlsxWriter(out, opts) },
+	},
+	{
+		name:        ExportFormatCloudEvents,
+		contentType: cloudevents.BatchContentType,
+		extension:   ExportFormatJson,
+		newWriter: func(out io.Writer, _ *exportOptions) eventWriter {
+			return &jsonWriter{out: out, convert: cloudEvent}
+		},
+	},
+}
+
+// getExportFormat returns the format named by the format parameter, or
+// negotiates it from the Accept header when the parameter is empty
+func getExportFormat(c *gin.Context, name string) *exportFormat {
+	if name == "" {
+		offered := make([]string, len(exportFormats))
+		for i, f := range exportFormats {
+			offered[i] = f.contentType
+		}
+		// clients accepting none of the formats still get the default one
+		contentType := c.NegotiateFormat(offered...)
+		for _, f := range exportFormats {
+			if f.contentType == contentType {
+				return f
+			}
+		}
+		return exportFormats[0]
+	}
+	return formatByName(strings.ToLower(name))
+}
+
+// fileExtension returns the extension of the exported files
+func (f *exportFormat) fileExtension() string {
+	if f.extension != "" {
+		return f.extension
+	}
+	return f.name
+}
+
+// formatByName returns the format with the name, nil if there is none
+func formatByName(name string) *exportFormat {
+	for _, f := range exportFormats {
+		if f.name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// csvWriter writes events as csv rows, led by the UTF-8 BOM and the header
+type csvWriter struct {
+	out           io.Writer
+	wr            *csv.Writer
+	opts          *exportOptions
+	headerWritten bool
+}
+
+func newCsvWriter(out io.Writer, opts *exportOptions) *csvWriter {
+	return &csvWriter{out: out, wr: csv.NewWriter(out), opts: opts}
+}
+
+func (w *csvWriter) write(events []v1.Event) error {
+	if !w.headerWritten {
+		w.headerWritten = true
+		if _, err := io.WriteString(w.out, "\xEF\xBB\xBF"); err != nil {
+			return err
+		}
+		if err := w.wr.Write(w.opts.header()); err != nil {
+			return err
+		}
+	}
+	for i := range events {
+		if err := w.wr.Write(w.opts.row(&events[i])); err != nil {
+			return err
+		}
+	}
+	w.wr.Flush()
+	return w.wr.Error()
+}
+
+func (w *csvWriter) close() error {
+	if !w.headerWritten {
+		return w.write(nil)
+	}
+	return nil
+}
+
+// jsonWriter writes the full events as one json array
+type jsonWriter struct {
+	out     io.Writer
+	started bool
+	empty   bool
+	// convert returns what is written for an event, the event itself when it is nil
+	convert func(e *v1.Event) (interface{}, error)
+}
+
+func (w *jsonWriter) write(events []v1.Event) error {
+	if !w.started {
+		w.started, w.empty = true, true
+		if _, err := io.WriteString(w.out, "["); err != nil {
+			return err
+		}
+	}
+	for i := range events {
+		var v interface{} = &events[i]
+		if w.convert != nil {
+			var err error
+			if v, err = w.convert(&events[i]); err != nil {
+				return err
+			}
+		}
+		bs, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		if !w.empty {
+			if _, err = io.WriteString(w.out, ","); err != nil {
+				return err
+			}
+		}
+		w.empty = false
+		if _, err = w.out.Write(bs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *jsonWriter) close() error {
+	if err := w.write(nil); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w.out, "]")
+	return err
+}
+
+func cloudEvent(e *v1.Event) (interface{}, error) {
+	return cloudevents.FromV1(env.CloudEventsSource(), e)
+}
+
+// ndjsonWriter writes the full events one json object per line
+type ndjsonWriter struct {
+	enc *json.Encoder
+}
+
+func (w *ndjsonWriter) write(events []v1.Event) error {
+	for i := range events {
+		if err := w.enc.Encode(&events[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *ndjsonWriter) close() error {
+	return nil
+}
+
+const (
+	// xlsxMaxRows and xlsxMaxCellLength are the limits of an excel worksheet
+	xlsxMaxRows       = 1048576
+	xlsxMaxCellLength = 32767
+
+	xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/></Types>`
+	xlsxRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`
+	xlsxWorkbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="audit" sheetId="1" r:id="rId1"/></sheets></workbook>`
+	xlsxWorkbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/></Relationships>`
+	xlsxSheetStart = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`
+	xlsxSheetEnd = `</sheetData></worksheet>`
+)
+
+// xlsxWriter writes events into a single worksheet. The package parts are
+// written up front so that the rows can be streamed as the last zip entry.
+type xlsxWriter struct {
+	zw    *zip.Writer
+	sheet io.Writer
+	opts  *exportOptions
+	rows  int
+}
+
+func newXlsxWriter(out io.Writer, opts *exportOptions) *xlsxWriter {
+	return &xlsxWriter{zw: zip.NewWriter(out), opts: opts}
+}
+
+func (w *xlsxWriter) write(events []v1.Event) error {
+	if w.sheet == nil {
+		if err := w.start(); err != nil {
+			return err
+		}
+	}
+	if w.rows+len(events) > xlsxMaxRows {
+		return fmt.Errorf("xlsx holds at most %d rows", xlsxMaxRows)
+	}
+	for i := range events {
+		if err := w.writeRow(w.opts.row(&events[i]), true); err != nil {
+			return err
+		}
+	}
+	return w.zw.Flush()
+}
+
+func (w *xlsxWriter) start() error {
+	parts := []struct{ name, content string }{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxRels},
+		{"xl/workbook.xml", xlsxWorkbook},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+	}
+	for _, part := range parts {
+		f, err := w.zw.Create(part.name)
+		if err != nil {
+			return err
+		}
+		if _, err = io.WriteString(f, part.content); err != nil {
+			return err
+		}
+	}
+	sheet, err := w.zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return err
+	}
+	if _, err = io.WriteString(sheet, xlsxSheetStart); err != nil {
+		return err
+	}
+	w.sheet = sheet
+	return w.writeRow(w.opts.header(), false)
+}
+
+// writeRow writes the cells as inline strings, except numeric columns which
+// are kept numbers so that they can be filtered and sorted in excel
+func (w *xlsxWriter) writeRow(cells []string, values bool) error {
+	w.rows++
+	var b strings.Builder
+	b.WriteString("<row>")
+	for i, cell := range cells {
+		if values && cell != "" && w.opts.isNumeric(i) {
+			b.WriteString(`<c><v>` + cell + `</v></c>`)
+			continue
+		}
+		if len(cell) > xlsxMaxCellLength {
+			cell = strings.ToValidUTF8(cell[:xlsxMaxCellLength], "")
+		}
+		b.WriteString(`<c t="inlineStr"><is><t xml:space="preserve">`)
+		if err := xml.EscapeText(&b, []byte(cell)); err != nil {
+			return err
+		}
+		b.WriteString(`</t></is></c>`)
+	}
+	b.WriteString("</row>")
+	_, err := io.WriteString(w.sheet, b.String())
+	return err
+}
+
+func (w *xlsxWriter) close() error {
+	if w.sheet == nil {
+		if err := w.start(); err != nil {
+			return err
+		}
+	}
+	if _, err := io.WriteString(w.sheet, xlsxSheetEnd); err != nil {
+		return err
+	}
+	return w.zw.Close()
+}
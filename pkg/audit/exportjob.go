@@ -0,0 +1,478 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"audit/pkg/utils/env"
+	"audit/pkg/utils/errcode"
+	"audit/pkg/utils/response"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/saashqdev/kubeworkz/pkg/authentication/authenticators/token"
+	"github.com/saashqdev/kubeworkz/pkg/clog"
+	"github.com/saashqdev/kubeworkz/pkg/utils/constants"
+)
+
+const (
+	ExportJobPending   = "pending"
+	ExportJobRunning   = "running"
+	ExportJobSucceeded = "succeeded"
+	ExportJobFailed    = "failed"
+
+	exportJobWorkers       = 2
+	exportJobQueueSize     = 100
+	exportJobCheckInterval = time.Minute * 10
+	exportJobMetaSuffix    = ".json"
+)
+
+var exportJobs *exportJobManager
+
+// ExportJob is an export running in the background, its file can be
+// downloaded by the user who started it once it succeeded
+type ExportJob struct {
+	ID    string
+	User  string
+	Query exportQuery
+	State string
+	// Message tells why a job failed
+	Message string
+	// Rows is the number of events written so far
+	Rows int
+	// Total is the number of events matching the query
+	Total int64
+	// Truncated tells the file stops at the row limit, short of Total
+	Truncated bool
+	// Progress is the finished percentage of the job
+	Progress   int
+	CreatedAt  int64
+	FinishedAt int64
+	// ExpiresAt is when a finished job and its file are removed
+	ExpiresAt int64
+	// Decrypted tells the file holds the encrypted fields in clear, only
+	// users allowed to decrypt them may download it
+	Decrypted bool
+}
+
+// exportJobManager runs export jobs with a few workers and keeps jobs and
+// their files in a directory, so that they survive restarts
+type exportJobManager struct {
+	dir string
+	ttl time.Duration
+
+	mu    sync.Mutex
+	jobs  map[string]*ExportJob
+	queue chan *ExportJob
+}
+
+// StartExportJobs loads the persisted export jobs and starts the workers,
+// jobs interrupted by a restart are run again from the beginning
+func StartExportJobs() {
+	m := &exportJobManager{
+		dir:   env.ExportJobPath(),
+		ttl:   env.ExportJobTTL(),
+		jobs:  make(map[string]*ExportJob),
+		queue: make(chan *ExportJob, exportJobQueueSize),
+	}
+	if err := m.load(); err != nil {
+		clog.Error("load export jobs from %s error: %s", m.dir, err)
+		return
+	}
+	for i := 0; i < exportJobWorkers; i++ {
+		go m.work()
+	}
+	go m.expire()
+	exportJobs = m
+}
+
+func (m *exportJobManager) load() error {
+	if err := os.MkdirAll(m.dir, 0755); err != nil {
+		return err
+	}
+	files, err := ioutil.ReadDir(m.dir)
+	if err != nil {
+		return err
+	}
+	var interrupted []*ExportJob
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), exportJobMetaSuffix) {
+			continue
+		}
+		bs, err := ioutil.ReadFile(filepath.Join(m.dir, f.Name()))
+		if err != nil {
+			return err
+		}
+		job := &ExportJob{}
+		if err = json.Unmarshal(bs, job); err != nil {
+			clog.Error("unmarshal export job %s error: %s", f.Name(), err)
+			continue
+		}
+		m.jobs[job.ID] = job
+		if job.State == ExportJobPending || job.State == ExportJobRunning {
+			interrupted = append(interrupted, job)
+		}
+	}
+	sort.Slice(interrupted, func(i, j int) bool {
+		return interrupted[i].CreatedAt < interrupted[j].CreatedAt
+	})
+	for _, job := range interrupted {
+		job.State = ExportJobPending
+		job.Rows, job.Progress = 0, 0
+		select {
+		case m.queue <- job:
+		default:
+			m.finish(job, fmt.Errorf("export job queue is full"))
+		}
+	}
+	return nil
+}
+
+func (m *exportJobManager) submit(user string, query exportQuery) (*ExportJob, *errcode.ErrorInfo) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		clog.Error("generate export job id error: %s", err)
+		return nil, errcode.InternalServerError
+	}
+	job := &ExportJob{
+		ID:        hex.EncodeToString(id),
+		User:      user,
+		Query:     query,
+		State:     ExportJobPending,
+		CreatedAt: time.Now().Unix(),
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	select {
+	case m.queue <- job:
+	default:
+		return nil, errcode.TooManyExportJobs
+	}
+	m.jobs[job.ID] = job
+	if err := m.persist(job); err != nil {
+		clog.Error("persist export job %s error: %s", job.ID, err)
+	}
+	copied := *job
+	return &copied, nil
+}
+
+// get returns a copy of the job if the user may see it
+func (m *exportJobManager) get(id, user string) (*ExportJob, *errcode.ErrorInfo) {
+	m.mu.Lock()
+	job, ok := m.jobs[id]
+	var copied ExportJob
+	if ok {
+		copied = *job
+	}
+	m.mu.Unlock()
+
+	if !ok {
+		return nil, errcode.NotFound
+	}
+	if copied.User != user && !checkIsAdmin(user) {
+		return nil, errcode.NoAuthority
+	}
+	return &copied, nil
+}
+
+// list returns the jobs of a user, newest first
+func (m *exportJobManager) list(user string) []ExportJob {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	jobs := make([]ExportJob, 0)
+	for _, job := range m.jobs {
+		if job.User == user {
+			jobs = append(jobs, *job)
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt > jobs[j].CreatedAt
+	})
+	return jobs
+}
+
+func (m *exportJobManager) work() {
+	for job := range m.queue {
+		m.update(job, func() { job.State = ExportJobRunning })
+		err := m.run(job)
+		m.finish(job, err)
+	}
+}
+
+// run writes the events matching the job query to the job file
+func (m *exportJobManager) run(job *ExportJob) error {
+	tmpPath := m.filePath(job) + ".tmp"
+	// exports hold decrypted payloads for the users allowed to read them
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+	defer f.Close()
+
+	opts, errInfo := job.Query.options()
+	if errInfo != nil {
+		return fmt.Errorf("%s", errInfo.Message)
+	}
+	limit := env.ExportMaxRows()
+	stream := newEventStream(job.Query.auditQuery, limit)
+	stream.decrypt = canDecrypt(job.User)
+	m.update(job, func() { job.Decrypted = stream.decrypt })
+	wr := job.format().newWriter(f, opts)
+	for {
+		events, errInfo := stream.next()
+		if errInfo != nil {
+			return fmt.Errorf("%s", errInfo.Message)
+		}
+		if err = wr.write(events); err != nil {
+			return err
+		}
+		if len(events) == 0 {
+			break
+		}
+		m.update(job, func() {
+			job.Rows, job.Total, job.Truncated = stream.fetched, stream.total, stream.truncated()
+			expected := stream.total
+			if limit > 0 && int64(limit) < expected {
+				expected = int64(limit)
+			}
+			if expected > 0 {
+				job.Progress = int(int64(job.Rows) * 100 / expected)
+			}
+		})
+	}
+
+	if err = wr.close(); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, m.filePath(job))
+}
+
+func (m *exportJobManager) finish(job *ExportJob, err error) {
+	m.update(job, func() {
+		now := time.Now()
+		job.FinishedAt = now.Unix()
+		job.ExpiresAt = now.Add(m.ttl).Unix()
+		if err != nil {
+			clog.Error("export job %s error: %s", job.ID, err)
+			job.State = ExportJobFailed
+			job.Message = err.Error()
+			return
+		}
+		job.State = ExportJobSucceeded
+		job.Progress = 100
+		if job.Truncated {
+			clog.Warn("export job %s of %s is truncated to %d of %d events", job.ID, job.User, job.Rows, job.Total)
+			return
+		}
+		clog.Info("export job %s of %s finished with %d events", job.ID, job.User, job.Rows)
+	})
+}
+
+// update changes a job under the lock and persists it
+func (m *exportJobManager) update(job *ExportJob, change func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	change()
+	if err := m.persist(job); err != nil {
+		clog.Error("persist export job %s error: %s", job.ID, err)
+	}
+}
+
+// persist writes the job metadata next to its file, it must be called with the lock held
+func (m *exportJobManager) persist(job *ExportJob) error {
+	bs, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(m.dir, job.ID+exportJobMetaSuffix)
+	if err = ioutil.WriteFile(path+".tmp", bs, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func (m *exportJobManager) filePath(job *ExportJob) string {
+	return filepath.Join(m.dir, job.ID+"."+job.format().name)
+}
+
+func (job *ExportJob) format() *exportFormat {
+	if f := formatByName(job.Query.Format); f != nil {
+		return f
+	}
+	return exportFormats[0]
+}
+
+// expire removes finished jobs and their files once they are past their TTL
+func (m *exportJobManager) expire() {
+	ticker := time.NewTicker(exportJobCheckInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		now := time.Now().Unix()
+		m.mu.Lock()
+		for id, job := range m.jobs {
+			if job.ExpiresAt == 0 || job.ExpiresAt > now {
+				continue
+			}
+			delete(m.jobs, id)
+			for _, path := range []string{m.filePath(job), filepath.Join(m.dir, id+exportJobMetaSuffix)} {
+				if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+					clog.Error("remove expired export job %s error: %s", id, err)
+				}
+			}
+			clog.Info("remove expired export job %s", id)
+		}
+		m.mu.Unlock()
+	}
+}
+
+// exportJobUser authenticates the request and checks the export jobs are available
+func exportJobUser(c *gin.Context) (string, bool) {
+	if exportJobs == nil {
+		response.FailReturn(c, errcode.InternalServerError)
+		return "", false
+	}
+	user, err := token.GetUserFromReq(c.Request)
+	if err != nil {
+		response.FailReturn(c, errcode.AuthenticateError)
+		return "", false
+	}
+	return user.Username, true
+}
+
+// @Summary create export job
+// @Description start exporting the audit logs matching the query in the background
+// @Tags audit
+// @Param	query	query	exportQuery  false  "key and value for query"
+// @Success 200 {object} ExportJob
+// @Failure 500 {object} errcode.ErrorInfo
+// @Router /api/v1/kube/audit/export/jobs  [post]
+func CreateExportJob(c *gin.Context) {
+	user, ok := exportJobUser(c)
+	if !ok {
+		return
+	}
+	if !checkIsAdmin(user) {
+		response.FailReturn(c, errcode.NoAuthority)
+		return
+	}
+
+	var query exportQuery
+	if err := c.ShouldBind(&query); err != nil {
+		clog.Error("parse export job param error: %s", err)
+		response.FailReturn(c, errcode.InvalidBodyFormat)
+		return
+	}
+	format := getExportFormat(c, query.Format)
+	if format == nil {
+		response.FailReturn(c, errcode.UnsupportedExportFormat)
+		return
+	}
+	query.Format = format.name
+	if _, errInfo := query.expr(); errInfo != nil {
+		response.FailReturn(c, errInfo)
+		return
+	}
+	if _, errInfo := newExportOptions(c, &query); errInfo != nil {
+		response.FailReturn(c, errInfo)
+		return
+	}
+
+	job, err := exportJobs.submit(user, query)
+	if err != nil {
+		response.FailReturn(c, err)
+		return
+	}
+	response.SuccessReturn(c, job)
+}
+
+// @Summary list export jobs
+// @Description list the export jobs of the current user
+// @Tags audit
+// @Success 200 {array} ExportJob
+// @Failure 500 {object} errcode.ErrorInfo
+// @Router /api/v1/kube/audit/export/jobs  [get]
+func ListExportJobs(c *gin.Context) {
+	user, ok := exportJobUser(c)
+	if !ok {
+		return
+	}
+	response.SuccessReturn(c, exportJobs.list(user))
+}
+
+// @Summary get export job
+// @Description get the state and progress of an export job
+// @Tags audit
+// @Param	id	path	string  true  "export job id"
+// @Success 200 {object} ExportJob
+// @Failure 500 {object} errcode.ErrorInfo
+// @Router /api/v1/kube/audit/export/jobs/{id}  [get]
+func GetExportJob(c *gin.Context) {
+	user, ok := exportJobUser(c)
+	if !ok {
+		return
+	}
+	job, err := exportJobs.get(c.Param("id"), user)
+	if err != nil {
+		response.FailReturn(c, err)
+		return
+	}
+	response.SuccessReturn(c, job)
+}
+
+// @Summary download export job
+// @Description download the file of a succeeded export job
+// @Tags audit
+// @Param	id	path	string  true  "export job id"
+// @Success 200 {string} string
+// @Failure 500 {object} errcode.ErrorInfo
+// @Router /api/v1/kube/audit/export/jobs/{id}/download  [get]
+func DownloadExportJob(c *gin.Context) {
+	user, ok := exportJobUser(c)
+	if !ok {
+		return
+	}
+	job, err := exportJobs.get(c.Param("id"), user)
+	if err != nil {
+		response.FailReturn(c, err)
+		return
+	}
+	if job.State != ExportJobSucceeded {
+		response.FailReturn(c, errcode.ExportJobNotReady)
+		return
+	}
+	if job.Decrypted && job.User != user && !canDecrypt(user) {
+		response.FailReturn(c, errcode.NoAuthority)
+		return
+	}
+	format := job.format()
+	c.Header(constants.HttpHeaderContentType, format.contentType)
+	c.FileAttachment(exportJobs.filePath(job), fmt.Sprintf("%d.%s", job.CreatedAt, format.fileExtension()))
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"audit/pkg/store"
+	"audit/pkg/utils/errcode"
+)
+
+// auditFilters are multi-value filters, an event passes a filter when it
+// matches any of its values and the Not filters drop events matching any of
+// their values. Values of user, ip, verb, type, resourceType, errorCode,
+// finding and severity may use * as wildcard, e.g. ops-*, and status takes classes like 4xx.
+type auditFilters struct {
+	User            []string `form:"user,omitempty"`
+	NotUser         []string `form:"notUser,omitempty"`
+	Ip              []string `form:"ip,omitempty"`
+	NotIp           []string `form:"notIp,omitempty"`
+	Verb            []string `form:"verb,omitempty"`
+	NotVerb         []string `form:"notVerb,omitempty"`
+	Event           []string `form:"event,omitempty"`
+	NotEvent        []string `form:"notEvent,omitempty"`
+	Type            []string `form:"type,omitempty"`
+	NotType         []string `form:"notType,omitempty"`
+	Resource        []string `form:"resource,omitempty"`
+	NotResource     []string `form:"notResource,omitempty"`
+	ResourceType    []string `form:"resourceType,omitempty"`
+	NotResourceType []string `form:"notResourceType,omitempty"`
+	Namespace       []string `form:"namespace,omitempty"`
+	NotNamespace    []string `form:"notNamespace,omitempty"`
+	Status          []string `form:"status,omitempty"`
+	NotStatus       []string `form:"notStatus,omitempty"`
+	ErrorCode       []string `form:"errorCode,omitempty"`
+	NotErrorCode    []string `form:"notErrorCode,omitempty"`
+	UserAgent       []string `form:"userAgent,omitempty"`
+	NotUserAgent    []string `form:"notUserAgent,omitempty"`
+	Finding         []string `form:"finding,omitempty"`
+	NotFinding      []string `form:"notFinding,omitempty"`
+	Severity        []string `form:"severity,omitempty"`
+	NotSeverity     []string `form:"notSeverity,omitempty"`
+}
+
+// expr returns the filters as a search expression, nil when there are none
+func (f *auditFilters) expr() (store.Expr, *errcode.ErrorInfo) {
+	filters := []struct {
+		field   store.Field
+		values  []string
+		negated bool
+	}{
+		{store.FieldUser, f.User, false},
+		{store.FieldUser, f.NotUser, true},
+		{store.FieldIP, f.Ip, false},
+		{store.FieldIP, f.NotIp, true},
+		{store.FieldVerb, f.Verb, false},
+		{store.FieldVerb, f.NotVerb, true},
+		{store.FieldEventName, f.Event, false},
+		{store.FieldEventName, f.NotEvent, true},
+		{store.FieldEventType, f.Type, false},
+		{store.FieldEventType, f.NotType, true},
+		{store.FieldResourceName, f.Resource, false},
+		{store.FieldResourceName, f.NotResource, true},
+		{store.FieldResourceType, f.ResourceType, false},
+		{store.FieldResourceType, f.NotResourceType, true},
+		{store.FieldNamespace, f.Namespace, false},
+		{store.FieldNamespace, f.NotNamespace, true},
+		{store.FieldStatus, f.Status, false},
+		{store.FieldStatus, f.NotStatus, true},
+		{store.FieldErrorCode, f.ErrorCode, false},
+		{store.FieldErrorCode, f.NotErrorCode, true},
+		{store.FieldUserAgent, f.UserAgent, false},
+		{store.FieldUserAgent, f.NotUserAgent, true},
+		{store.FieldFinding, f.Finding, false},
+		{store.FieldFinding, f.NotFinding, true},
+		{store.FieldSeverity, f.Severity, false},
+		{store.FieldSeverity, f.NotSeverity, true},
+	}
+
+	var exprs store.And
+	for _, filter := range filters {
+		if len(filter.values) == 0 {
+			continue
+		}
+		var values store.Or
+		for _, value := range filter.values {
+			term, err := store.NewTerm(filter.field, value)
+			if err != nil {
+				return nil, errcode.InvalidFilter(err.Error())
+			}
+			values = append(values, term)
+		}
+		var expr store.Expr = values
+		if len(values) == 1 {
+			expr = values[0]
+		}
+		if filter.negated {
+			expr = store.Not{Expr: expr}
+		}
+		exprs = append(exprs, expr)
+	}
+	if len(exprs) == 0 {
+		return nil, nil
+	}
+	return exprs, nil
+}
@@ -18,29 +18,36 @@ package audit
 
 import (
 	"audit/pkg/backend"
-	v1 "audit/pkg/backend/v1"
-	"audit/pkg/utils/errcode"
 	"audit/pkg/utils/response"
 
 	"github.com/gin-gonic/gin"
 	"github.com/saashqdev/kubeworkz/pkg/clog"
 )
 
-// receive audit log from the third party
+// receive audit log from the third party, or a batch of them as a json array or ndjson
 func HandleGenericAuditLog(c *gin.Context) {
 
 	eventResource := c.Query("resource")
 	clog.Info("receive audit event from %s", eventResource)
-	event := &v1.Event{}
-	if err := c.ShouldBindJSON(event); err != nil {
+	events, batch, err := bindEvents(c)
+	if err != nil {
 		clog.Error("unmarshal event from %s error: %v", eventResource, err)
-		response.FailReturn(c, errcode.InvalidBodyFormat)
+		failIngest(c, err)
 		return
 	}
-	event.EventName = "[" + eventResource + "] " + event.EventName
-	response.SuccessReturn(c, nil)
+	if batch != nil {
+		clog.Info("receive %d audit events from %s, %d rejected", batch.Accepted, eventResource, batch.Rejected)
+		response.SuccessReturn(c, batch)
+	} else {
+		response.SuccessReturn(c, nil)
+	}
 
-	// send event to channel
+	// send events to channel
 	ch := backend.GetCacheCh()
-	backend.CacheEvent(ch, event)
+	for _, event := range events {
+		if event != nil {
+			event.EventName = "[" + eventResource + "] " + event.EventName
+			backend.CacheEvent(ch, event)
+		}
+	}
 }
@@ -0,0 +1,205 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	v1 "audit/pkg/backend/v1"
+	v2 "audit/pkg/backend/v2"
+	"audit/pkg/utils/errcode"
+	"audit/pkg/utils/response"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/klauspost/compress/zstd"
+	"github.com/saashqdev/kubeworkz/pkg/utils/constants"
+)
+
+// maxBodySize is the largest request body read, in bytes, once decompressed
+const maxBodySize = 10 << 20
+
+// ndjsonContentTypes are the content types of a batch of one event per line
+var ndjsonContentTypes = map[string]bool{
+	"application/x-ndjson":     true,
+	"application/ndjson":       true,
+	"application/jsonl":        true,
+	"application/x-jsonlines":  true,
+	"application/jsonlines":    true,
+	"application/json-lines":   true,
+	"application/x-json-lines": true,
+}
+
+// BatchResult is the reply to a batch of events, one item result per event in order
+type BatchResult struct {
+	Accepted int
+	Rejected int
+	Items    []ItemResult
+}
+
+// ItemResult tells whether an event of a batch was accepted, and why not when rejected
+type ItemResult struct {
+	// Index is the position of the event in the batch, blank lines of ndjson not counted
+	Index    int
+	Accepted bool
+	Error    string `json:",omitempty"`
+}
+
+// encodingError is the content encoding of a body which can not be read
+type encodingError struct {
+	encoding string
+}
+
+func (e *encodingError) Error() string {
+	return fmt.Sprintf("content encoding %s is not supported", e.encoding)
+}
+
+// bindEvents reads the event of a request, or the events of a batch, a json
+// array or ndjson. A single event fails the request when it can not be read,
+// and is taken as it was before batches, while the events of a batch which can
+// not be read or are not valid are left nil and rejected in the result, which
+// is nil for a single event.
+func bindEvents(c *gin.Context) ([]*v1.Event, *BatchResult, error) {
+	body, err := readBody(c.Request)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var items [][]byte
+	if isNDJSON(c.GetHeader(constants.HttpHeaderContentType)) {
+		for _, line := range bytes.Split(body, []byte("\n")) {
+			if line = bytes.TrimSpace(line); len(line) > 0 {
+				items = append(items, line)
+			}
+		}
+	} else if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '[' {
+		var raws []json.RawMessage
+		if err = json.Unmarshal(trimmed, &raws); err != nil {
+			return nil, nil, err
+		}
+		for _, raw := range raws {
+			items = append(items, raw)
+		}
+	} else {
+		event, err := v2.DecodeV1(body)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []*v1.Event{event}, nil, nil
+	}
+
+	events := make([]*v1.Event, len(items))
+	result := &BatchResult{Items: make([]ItemResult, len(items))}
+	for i, item := range items {
+		result.Items[i].Index = i
+		event, err := decodeItem(item)
+		if err != nil {
+			result.Items[i].Error = err.Error()
+			result.Rejected++
+			continue
+		}
+		events[i] = event
+		result.Items[i].Accepted = true
+		result.Accepted++
+	}
+	return events, result, nil
+}
+
+// decodeItem reads an event of a batch, which must be a json object
+func decodeItem(item []byte) (*v1.Event, error) {
+	if item[0] != '{' {
+		return nil, errors.New("event is not a json object")
+	}
+	event, err := v2.DecodeV1(item)
+	if err != nil {
+		return nil, err
+	}
+	if err = validateEvent(event); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+// validateEvent checks the fields an audit log of a batch can not do without
+func validateEvent(e *v1.Event) error {
+	if strings.TrimSpace(e.EventName) == "" {
+		return errors.New("event name is empty")
+	}
+	if e.EventTime < 0 {
+		return fmt.Errorf("event time %d is negative", e.EventTime)
+	}
+	if e.ResponseStatus < 0 || e.ResponseStatus > 999 {
+		return fmt.Errorf("response status %d is not an http status", e.ResponseStatus)
+	}
+	return nil
+}
+
+// isNDJSON tells whether a content type is of one event per line
+func isNDJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && ndjsonContentTypes[strings.ToLower(mediaType)]
+}
+
+// failIngest replies to a request whose events can not be read
+func failIngest(c *gin.Context, err error) {
+	var encodingErr *encodingError
+	if errors.As(err, &encodingErr) {
+		response.FailReturn(c, errcode.UnsupportedContentEncoding(encodingErr.encoding))
+		return
+	}
+	response.FailReturn(c, errcode.InvalidEvent(err.Error()))
+}
+
+// readBody reads the body of a request, gzip or zstd compressed or not
+func readBody(r *http.Request) ([]byte, error) {
+	var body io.Reader = r.Body
+	switch encoding := strings.ToLower(r.Header.Get("Content-Encoding")); encoding {
+	case "", "identity":
+	case "gzip", "x-gzip":
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		body = zr
+	case "zstd":
+		// the window bounds the memory of a frame, as the body read bounds its size
+		zr, err := zstd.NewReader(r.Body, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxWindow(maxBodySize))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		body = zr
+	default:
+		return nil, &encodingError{encoding: encoding}
+	}
+	bs, err := ioutil.ReadAll(io.LimitReader(body, maxBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(bs) > maxBodySize {
+		return nil, fmt.Errorf("body is larger than %d bytes", maxBodySize)
+	}
+	return bs, nil
+}
@@ -19,6 +19,7 @@ package audit
 import (
 	"audit/pkg/backend"
 	v1 "audit/pkg/backend/v1"
+	"audit/pkg/detection"
 	"net/http"
 	"strconv"
 	"strings"
@@ -44,7 +45,7 @@ func HandleK8sAuditLog(c *gin.Context) {
 		// transform K8s event to v1.event
 		e := &v1.Event{
 			EventTime:       event.StageTimestamp.Unix(),
-			EventVersion:    "V1",
+			EventVersion:    v1.Version,
 			SourceIpAddress: event.SourceIPs[0],
 			RequestMethod:   event.Verb,
 			ResponseStatus:  int(event.ResponseStatus.Code),
@@ -55,6 +56,7 @@ func HandleK8sAuditLog(c *gin.Context) {
 			RequestId:       string(event.AuditID),
 		}
 		e = getEventName(e)
+		e.Findings = detection.Detect(&event)
 		if e.ResponseStatus != http.StatusOK {
 			e.ErrorCode = strconv.Itoa(e.ResponseStatus)
 		}
@@ -18,27 +18,34 @@ package audit
 
 import (
 	"audit/pkg/backend"
-	v1 "audit/pkg/backend/v1"
-	"audit/pkg/utils/errcode"
 	"audit/pkg/utils/response"
 
 	"github.com/gin-gonic/gin"
 	"github.com/saashqdev/kubeworkz/pkg/clog"
 )
 
-// receive audit log from Kubeworkz
+// receive audit log from Kubeworkz, or a batch of them as a json array or ndjson
 func HandleCubeAuditLog(c *gin.Context) {
 
 	clog.Info("receive kube audit event")
-	event := &v1.Event{}
-	if err := c.ShouldBindJSON(event); err != nil {
+	events, batch, err := bindEvents(c)
+	if err != nil {
 		clog.Error("unmarshal kubeworkz event failed, error: %s", err)
-		response.FailReturn(c, errcode.InvalidBodyFormat)
+		failIngest(c, err)
 		return
 	}
-	response.SuccessReturn(c, nil)
+	if batch != nil {
+		clog.Info("receive %d kube audit events, %d rejected", batch.Accepted, batch.Rejected)
+		response.SuccessReturn(c, batch)
+	} else {
+		response.SuccessReturn(c, nil)
+	}
 
-	// send event to channel
+	// send events to channel
 	ch := backend.GetCacheCh()
-	backend.CacheEvent(ch, event)
+	for _, event := range events {
+		if event != nil {
+			backend.CacheEvent(ch, event)
+		}
+	}
 }
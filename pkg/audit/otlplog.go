@@ -0,0 +1,68 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"audit/pkg/backend"
+	"audit/pkg/otlp"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/saashqdev/kubeworkz/pkg/clog"
+	"github.com/saashqdev/kubeworkz/pkg/utils/constants"
+)
+
+// receive audit logs as OTLP/HTTP log exports, in protobuf or json. The
+// replies follow OTLP rather than the other endpoints, so that exporters retry
+// only what can succeed.
+func HandleOTLPLogs(c *gin.Context) {
+
+	clog.Info("receive otlp logs")
+	contentType := c.GetHeader(constants.HttpHeaderContentType)
+	// replies are in json to the requests in neither format
+	replyType := otlp.ContentTypeJSON
+	if otlp.IsProtobuf(contentType) {
+		replyType = otlp.ContentTypeProtobuf
+	}
+	fail := func(status int, err error) {
+		clog.Error("read otlp logs error: %s", err)
+		c.Data(status, replyType, otlp.Status(replyType, otlp.CodeInvalidArgument, err.Error()))
+	}
+
+	if !otlp.IsProtobuf(contentType) && !otlp.IsJSON(contentType) {
+		fail(http.StatusUnsupportedMediaType, fmt.Errorf("content type %q is not %s or %s", contentType, otlp.ContentTypeProtobuf, otlp.ContentTypeJSON))
+		return
+	}
+	body, err := readBody(c.Request)
+	if err != nil {
+		fail(http.StatusBadRequest, err)
+		return
+	}
+	records, err := otlp.Decode(contentType, body)
+	if err != nil {
+		fail(http.StatusBadRequest, err)
+		return
+	}
+	c.Data(http.StatusOK, replyType, otlp.Response(replyType))
+
+	// send events to channel
+	ch := backend.GetCacheCh()
+	for i := range records {
+		backend.CacheEvent(ch, otlp.ToV1(&records[i]))
+	}
+}
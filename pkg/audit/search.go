@@ -19,32 +19,24 @@ package audit
 import (
 	"audit/pkg/backend"
 	v1 "audit/pkg/backend/v1"
+	v2 "audit/pkg/backend/v2"
+	"audit/pkg/encryption"
+	"audit/pkg/store"
 	"audit/pkg/utils/auth"
 	"audit/pkg/utils/env"
 	"audit/pkg/utils/errcode"
 	"audit/pkg/utils/response"
-	"bytes"
-	"context"
-	"encoding/csv"
 	"encoding/json"
-	"fmt"
 	"net/http"
-	"strconv"
 	"strings"
-	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/olivere/elastic/v7"
-	"github.com/saashqdev/kubeworkz/pkg/authentication/authenticators/token"
+	"github.com/gin-gonic/gin/binding"
 	"github.com/saashqdev/kubeworkz/pkg/authorizer/rbac"
 	"github.com/saashqdev/kubeworkz/pkg/clog"
 	"github.com/saashqdev/kubeworkz/pkg/utils/constants"
 )
 
-const (
-	exportQueryEventMaxSize = 10000
-)
-
 type auditQuery struct {
 	UserName        string `form:"userName,omitempty"`
 	SourceIpAddress string `form:"sourceIpAddress,omitempty"`
@@ -57,24 +49,45 @@ type auditQuery struct {
 	Size            int    `form:"size,omitempty"`
 	SortBy          string `form:"sortBy,omitempty"`
 	SortAsc         bool   `form:"sortAsc,omitempty"`
+	// Cursor continues a cursor paginated search, pass it empty to start one.
+	// Unlike pages, cursors can go past the first 10000 results.
+	Cursor string `form:"cursor,omitempty"`
+	// Query is a search expression on top of the filters above, e.g.
+	// user:alice AND verb:(delete OR patch) AND NOT namespace:kube-system AND status>=400
+	Query string `form:"query,omitempty"`
+	// Version is the version of the events returned, v1 by default or v2
+	Version string `form:"version,omitempty"`
+	auditFilters
+
+	useCursor bool
 }
 
 type EsResult struct {
 	Total  int64
 	Events []v1.Event
+	// Cursor fetches the next page of a cursor paginated search, empty on the last page
+	Cursor string
+}
+
+// ResultV2 is the result of a search for events in v2
+type ResultV2 struct {
+	Total  int64
+	Events []v2.Event
+	Cursor string
 }
 
 // @Summary query audit log
-// @Description query audit log from es
+// @Description query audit log from the store, a POST takes the same keys in a json body
 // @Tags audit
 // @Param	query	query	auditQuery  false  "key and value for query"
 // @Success 200 {object} EsResult
 // @Failure 500 {object} errcode.ErrorInfo
 // @Router /api/v1/kube/audit  [get]
+// @Router /api/v1/kube/audit/search  [post]
 func SearchAuditLog(c *gin.Context) {
 
-	if !backend.SendElasticSearch {
-		response.FailReturn(c, errcode.New(&errcode.ErrorInfo{Code: http.StatusBadRequest, Message: "Audit or ElasticSearch is disabled."}))
+	if !backend.StoreEnabled {
+		response.FailReturn(c, errcode.New(&errcode.ErrorInfo{Code: http.StatusBadRequest, Message: "Audit or its store is disabled."}))
 		return
 	}
 
@@ -89,200 +102,166 @@ func SearchAuditLog(c *gin.Context) {
 		return
 	}
 
-	var query auditQuery
-	if err := c.Bind(&query); err != nil {
+	query, err := bindSearchQuery(c)
+	if err != nil {
 		clog.Error("parse search audit log param error: %s", err)
 		response.FailReturn(c, errcode.InvalidBodyFormat)
 		return
 	}
-	if query.Page < 0 {
-		query.Page = 0
+	if query.Page <= 0 {
+		query.Page = 1
 	}
 	if query.Size <= 0 {
 		query.Size = 10
 	}
-
-	result, err := searchLog(query)
-	if err != nil {
-		response.FailReturn(c, err)
+	if query.Version != "" && !strings.EqualFold(query.Version, v1.Version) && !strings.EqualFold(query.Version, v2.Version) {
+		response.FailReturn(c, errcode.InvalidFilter("version "+query.Version+" is not v1 or v2"))
 		return
 	}
-	response.SuccessReturn(c, result)
-}
-
-// @Summary export audit log
-// @Description query and export audit log from es
-// @Tags audit
-// @Param	query	query	auditQuery  false  "key and value for query"
-// @Success 200 {string} string
-// @Failure 500 {object} errcode.ErrorInfo
-// @Router /api/v1/kube/audit/export  [get]
-func ExportAuditLog(c *gin.Context) {
 
-	// authority check
-	user, userErr := token.GetUserFromReq(c.Request)
-	if userErr != nil {
-		response.FailReturn(c, errcode.AuthenticateError)
+	result, errInfo := searchLog(query)
+	if errInfo != nil {
+		response.FailReturn(c, errInfo)
 		return
 	}
-	if !checkIsAdmin(user.Username) {
-		response.FailReturn(c, errcode.NoAuthority)
+	decryptEvents(user, result.Events)
+	if strings.EqualFold(query.Version, v2.Version) {
+		response.SuccessReturn(c, resultV2(result))
+		return
 	}
+	response.SuccessReturn(c, result)
+}
 
+// bindSearchQuery reads the query from the query parameters, or from the
+// json body of a POST. Passing a cursor, even empty, asks for cursor pagination.
+func bindSearchQuery(c *gin.Context) (auditQuery, error) {
 	var query auditQuery
-	if err := c.Bind(&query); err != nil {
-		clog.Error("parse search audit log param error: %s", err)
-		response.FailReturn(c, errcode.InvalidBodyFormat)
-		return
+	if c.Request.Method != http.MethodPost {
+		err := c.ShouldBindQuery(&query)
+		_, query.useCursor = c.GetQuery("cursor")
+		return query, err
 	}
-	query.Page = 0
-	query.Size = exportQueryEventMaxSize
 
-	result, err := searchLog(query)
-	if err != nil {
-		response.FailReturn(c, err)
-		return
-	}
-	if result.Total == 0 {
-		response.FailReturn(c, errcode.NotFound)
-		return
+	if err := c.ShouldBindBodyWith(&query, binding.JSON); err != nil {
+		return query, err
 	}
-	//for i := 0; i <= int(result.Total)/exportEventMaxSize; i++ {
-	//	end := 0
-	//	if (i+1)*exportEventMaxSize < int(result.Total) {
-	//		end = (i + 1) * exportEventMaxSize
-	//	} else {
-	//		end = int(result.Total)
-	//	}
-
-	dataBytes, err := writeCsv(result.Events)
-	if err != nil {
-		response.FailReturn(c, err)
-		return
+	var keys map[string]json.RawMessage
+	if err := c.ShouldBindBodyWith(&keys, binding.JSON); err != nil {
+		return query, err
 	}
-
-	fileName := strconv.FormatInt(time.Now().Unix(), 10)
-	c.Writer.Header().Set(constants.HttpHeaderContentType, constants.HttpHeaderContentTypeOctet)
-	c.Writer.Header().Set(constants.HttpHeaderContentDisposition, fmt.Sprintf("attachment;filename=%s.csv", fileName))
-	c.Data(http.StatusOK, "text/csv", dataBytes.Bytes())
-	//}
-
-}
-
-func writeCsv(events []v1.Event) (bytes.Buffer, *errcode.ErrorInfo) {
-	data := [][]string{
-		{"eventID", "userIdentity", "time", "IPAddress", "eventName", "requestMethod", "requestParams", "statusCode", "Url"},
-	}
-	for _, event := range events {
-		timef := time.Unix(event.EventTime, 0).Format("2006-01-02 15:04:05")
-		var accountId string
-		if event.UserIdentity == nil {
-			accountId = ""
-		} else {
-			accountId = event.UserIdentity.AccountId
+	for key := range keys {
+		if strings.EqualFold(key, "cursor") {
+			query.useCursor = true
 		}
-		data = append(data, []string{event.RequestId, accountId, timef, event.SourceIpAddress, event.EventName, event.RequestMethod, event.RequestParameters, strconv.Itoa(event.ResponseStatus), event.Url})
 	}
+	return query, nil
+}
 
-	dataBytes := &bytes.Buffer{}
-	dataBytes.WriteString("\xEF\xBB\xBF")
-	wr := csv.NewWriter(dataBytes)
-
-	if err := wr.WriteAll(data); err != nil {
-		clog.Error("write user template file error: %s", err)
-		return *dataBytes, errcode.InternalServerError
+func resultV2(result EsResult) ResultV2 {
+	events := make([]v2.Event, 0, len(result.Events))
+	for i := range result.Events {
+		events = append(events, *v2.FromV1(&result.Events[i]))
 	}
-	// clear
-	wr.Flush()
-	return *dataBytes, nil
+	return ResultV2{Total: result.Total, Events: events, Cursor: result.Cursor}
 }
 
 func searchLog(query auditQuery) (EsResult, *errcode.ErrorInfo) {
 
 	var esResult EsResult
-	// connect to es
-	client, err := elastic.NewClient(elastic.SetSniff(false), elastic.SetURL(env.ElasticSearchHost().Host))
-	if err != nil {
-		clog.Error("connect to elasticsearch error: %s, url: %s ", err, env.ElasticSearchHost().Host)
-		return esResult, errcode.InternalServerError
+	storeQuery, errInfo := query.storeQuery()
+	if errInfo != nil {
+		return esResult, errInfo
 	}
-
-	// structure filter
-	boolQ := elastic.NewBoolQuery()
-
-	// filter username
-	if len(strings.TrimSpace(query.UserName)) > 0 {
-		boolQ.Filter(elastic.NewTermQuery("UserIdentity.AccountId", query.UserName))
+	if query.useCursor {
+		cursor, err := store.DecodeCursor(query.Cursor)
+		if err != nil {
+			return esResult, errcode.InvalidCursor
+		}
+		storeQuery.Cursor = cursor
 	}
 
-	// filter time
-	if query.EndTime > 0 && query.StartTime <= 0 {
-		boolQ.Filter(elastic.NewRangeQuery("EventTime").Lte(query.EndTime))
-	} else if query.EndTime <= 0 && query.StartTime > 0 {
-		boolQ.Filter(elastic.NewRangeQuery("EventTime").Gte(query.StartTime))
-	} else if query.EndTime > 0 && query.StartTime > 0 {
-		boolQ.Filter(elastic.NewRangeQuery("EventTime").Lte(query.EndTime).Gte(query.StartTime))
+	result, err := backend.GetStore().Search(storeQuery)
+	if sortErr, ok := err.(*store.UnsupportedSortError); ok {
+		return esResult, errcode.InvalidFilter(sortErr.Error())
 	}
-
-	// filter ip
-	if len(strings.TrimSpace(query.SourceIpAddress)) > 0 {
-		boolQ.Filter(elastic.NewTermQuery("SourceIpAddress", query.SourceIpAddress))
+	switch err {
+	case nil:
+	case store.ErrInvalidCursor:
+		return esResult, errcode.InvalidCursor
+	case store.ErrResultWindowExceeded:
+		return esResult, errcode.ResultWindowExceeded
+	default:
+		clog.Error("search audit log error: %s", err)
+		return esResult, errcode.InternalServerError
 	}
 
-	// fuzzy filter resource name
-	if len(strings.TrimSpace(query.ResourceName)) > 0 {
-		boolQ.Must(elastic.NewMatchQuery("ResourceReports.ResourceName", query.ResourceName))
-	}
+	esResult.Total = result.Total
+	esResult.Events = result.Events
+	esResult.Cursor = store.EncodeCursor(result.Cursor)
+	return esResult, nil
+}
 
-	// fuzzy filter event name
-	if len(strings.TrimSpace(query.EventName)) > 0 {
-		boolQ.Must(elastic.NewMatchQuery("EventName", query.EventName))
+// storeQuery returns the filters, sorting and page of the query for the store
+func (q *auditQuery) storeQuery() (*store.Query, *errcode.ErrorInfo) {
+	expr, errInfo := q.expr()
+	if errInfo != nil {
+		return nil, errInfo
 	}
+	return &store.Query{
+		UserName:        q.UserName,
+		SourceIpAddress: q.SourceIpAddress,
+		ResourceName:    q.ResourceName,
+		EventName:       q.EventName,
+		ResponseStatus:  q.ResponseStatus,
+		StartTime:       q.StartTime,
+		EndTime:         q.EndTime,
+		From:            (q.Page - 1) * q.Size,
+		Size:            q.Size,
+		SortBy:          q.SortBy,
+		SortAsc:         q.SortAsc,
+		Expr:            expr,
+	}, nil
+}
 
-	// filter status code
-	if query.ResponseStatus > 0 {
-		boolQ.Filter(elastic.NewTermQuery("ResponseStatus", query.ResponseStatus))
+// expr joins the filters and the parsed search expression of the query, nil when there are none
+func (q *auditQuery) expr() (store.Expr, *errcode.ErrorInfo) {
+	filters, errInfo := q.auditFilters.expr()
+	if errInfo != nil {
+		return nil, errInfo
 	}
-
-	if query.SortBy == "" {
-		query.SortBy = "EventTime"
+	if strings.TrimSpace(q.Query) == "" {
+		return filters, nil
 	}
-
-	res, err := client.Search().
-		Index(env.ElasticSearchHost().Index).
-		Query(boolQ).
-		From((query.Page-1)*query.Size).
-		Size(query.Size).
-		Sort(query.SortBy, query.SortAsc).
-		Do(context.Background())
+	expr, err := store.ParseExpr(q.Query)
 	if err != nil {
-		if strings.Contains(err.Error(), "404") {
-			clog.Debug("search audit log from es error: %s", err)
-			return esResult, nil
-		}
-		clog.Error("search audit log from es error: %s", err)
-		return esResult, errcode.InternalServerError
+		return nil, errcode.InvalidQuery(err.Error())
 	}
-
-	if res != nil && res.Hits.TotalHits.Value > 0 {
-		esResult.Total = res.Hits.TotalHits.Value
-		for _, hit := range res.Hits.Hits {
-			var event v1.Event
-			err = json.Unmarshal(hit.Source, &event)
-			if err != nil {
-				clog.Error("json unmarshal audit log error: %s", err)
-				continue
-			}
-			esResult.Events = append(esResult.Events, event)
-		}
-	} else {
-		esResult.Total = 0
-		clog.Debug("search audit log result from es is 0")
+	if filters == nil {
+		return expr, nil
 	}
-	return esResult, nil
+	return store.And{filters, expr}, nil
 }
 
 func checkIsAdmin(userName string) bool {
+	return hasClusterRole(userName, constants.PlatformAdmin)
+}
+
+// canDecrypt reports whether the user may read the encrypted fields of the events
+func canDecrypt(userName string) bool {
+	return hasClusterRole(userName, env.DecryptRole())
+}
+
+// decryptEvents decrypts the encrypted fields of the events for a user allowed to read them
+func decryptEvents(userName string, events []v1.Event) {
+	if len(events) == 0 || !canDecrypt(userName) {
+		return
+	}
+	for i := range events {
+		encryption.DecryptEvent(&events[i])
+	}
+}
+
+func hasClusterRole(userName, role string) bool {
 	h := rbac.NewDefaultResolver(constants.LocalCluster)
 	user, err := h.GetUser(userName)
 	if err != nil {
@@ -296,7 +275,7 @@ func checkIsAdmin(userName string) bool {
 		return false
 	}
 	for _, clusterRole := range clusterRoles {
-		if clusterRole.Name == constants.PlatformAdmin {
+		if clusterRole.Name == role {
 			return true
 		}
 	}
@@ -304,5 +283,5 @@ func checkIsAdmin(userName string) bool {
 }
 
 func IsEnabled(c *gin.Context) {
-	response.SuccessReturn(c, backend.SendElasticSearch)
+	response.SuccessReturn(c, backend.StoreEnabled)
 }
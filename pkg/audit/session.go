@@ -0,0 +1,186 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"audit/pkg/encryption"
+	"audit/pkg/session"
+	"audit/pkg/utils/auth"
+	"audit/pkg/utils/errcode"
+	"audit/pkg/utils/response"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/saashqdev/kubeworkz/pkg/clog"
+	"github.com/saashqdev/kubeworkz/pkg/utils/constants"
+)
+
+// maxCastSize bounds the terminal width and height asked for a cast
+const maxCastSize = 1000
+
+type sessionQuery struct {
+	UserName  string `form:"userName,omitempty"`
+	PodName   string `form:"podName,omitempty"`
+	Namespace string `form:"namespace,omitempty"`
+	Cluster   string `form:"cluster,omitempty"`
+	StartTime int64  `form:"startTime,omitempty"`
+	EndTime   int64  `form:"endTime,omitempty"`
+	Page      int    `form:"page,omitempty"`
+	Size      int    `form:"size,omitempty"`
+}
+
+type castQuery struct {
+	Width  int `form:"width,omitempty"`
+	Height int `form:"height,omitempty"`
+}
+
+type SessionResult struct {
+	Total    int64
+	Sessions []session.Session
+}
+
+// sessionAdmin authenticates an administrator
+func sessionAdmin(c *gin.Context) (string, bool) {
+	user := auth.GetUserFromReq(c)
+	if user == "" {
+		response.FailReturn(c, errcode.AuthenticateError)
+		return "", false
+	}
+	if !checkIsAdmin(user) {
+		response.FailReturn(c, errcode.NoAuthority)
+		return "", false
+	}
+	return user, true
+}
+
+func sessionError(err error) *errcode.ErrorInfo {
+	if err == session.ErrNotFound {
+		return errcode.NotFound
+	}
+	clog.Error("read webconsole session error: %s", err)
+	return errcode.InternalServerError
+}
+
+// @Summary list webconsole sessions
+// @Description sessions overlapping startTime to endTime, newest first
+// @Tags audit
+// @Param	query	query	sessionQuery  false  "key and value for query"
+// @Success 200 {object} SessionResult
+// @Failure 500 {object} errcode.ErrorInfo
+// @Router /api/v1/kube/audit/sessions  [get]
+func ListSessions(c *gin.Context) {
+	if _, ok := sessionAdmin(c); !ok {
+		return
+	}
+	var query sessionQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		clog.Error("parse list sessions param error: %s", err)
+		response.FailReturn(c, errcode.InvalidBodyFormat)
+		return
+	}
+	if query.Page <= 0 {
+		query.Page = 1
+	}
+	if query.Size <= 0 {
+		query.Size = 10
+	}
+
+	sessions := session.List(session.Filter{
+		User:      query.UserName,
+		Pod:       query.PodName,
+		Namespace: query.Namespace,
+		Cluster:   query.Cluster,
+		StartTime: query.StartTime,
+		EndTime:   query.EndTime,
+	})
+	result := SessionResult{Total: int64(len(sessions)), Sessions: []session.Session{}}
+	if from := (query.Page - 1) * query.Size; from < len(sessions) {
+		to := from + query.Size
+		if to > len(sessions) {
+			to = len(sessions)
+		}
+		result.Sessions = sessions[from:to]
+	}
+	response.SuccessReturn(c, result)
+}
+
+// @Summary get webconsole session
+// @Tags audit
+// @Param	id	path	string  true  "session id"
+// @Success 200 {object} session.Session
+// @Failure 500 {object} errcode.ErrorInfo
+// @Router /api/v1/kube/audit/sessions/{id}  [get]
+func GetSession(c *gin.Context) {
+	if _, ok := sessionAdmin(c); !ok {
+		return
+	}
+	s, err := session.Info(c.Param("id"))
+	if err != nil {
+		response.FailReturn(c, sessionError(err))
+		return
+	}
+	response.SuccessReturn(c, s)
+}
+
+// @Summary replay webconsole session
+// @Description the session as an asciinema v2 cast, width and height default to 120x40
+// @Tags audit
+// @Param	id	path	string  true  "session id"
+// @Param	query	query	castQuery  false  "terminal size"
+// @Success 200 {string} string
+// @Failure 500 {object} errcode.ErrorInfo
+// @Router /api/v1/kube/audit/sessions/{id}/cast  [get]
+func GetSessionCast(c *gin.Context) {
+	user, ok := sessionAdmin(c)
+	if !ok {
+		return
+	}
+	var query castQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		clog.Error("parse session cast param error: %s", err)
+		response.FailReturn(c, errcode.InvalidBodyFormat)
+		return
+	}
+	if query.Width <= 0 || query.Width > maxCastSize {
+		query.Width = session.DefaultCastWidth
+	}
+	if query.Height <= 0 || query.Height > maxCastSize {
+		query.Height = session.DefaultCastHeight
+	}
+
+	s, chunks, err := session.Get(c.Param("id"))
+	if err != nil {
+		response.FailReturn(c, sessionError(err))
+		return
+	}
+	if canDecrypt(user) {
+		for i := range chunks {
+			data, err := encryption.Decrypt(encryption.FieldSession, chunks[i].Data)
+			if err != nil {
+				clog.Warn("decrypt webconsole session %s error: %s", s.ID, err)
+				continue
+			}
+			chunks[i].Data = data
+		}
+	}
+	c.Header(constants.HttpHeaderContentType, session.CastContentType)
+	c.Header(constants.HttpHeaderContentDisposition, "attachment;filename=session.cast")
+	c.Status(http.StatusOK)
+	if err = session.WriteCast(c.Writer, s, chunks, query.Width, query.Height); err != nil {
+		clog.Error("write cast of webconsole session %s error: %s", s.ID, err)
+	}
+}
@@ -0,0 +1,197 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"audit/pkg/backend"
+	"audit/pkg/store"
+	"audit/pkg/utils/auth"
+	"audit/pkg/utils/errcode"
+	"audit/pkg/utils/response"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/saashqdev/kubeworkz/pkg/clog"
+)
+
+const (
+	defaultTopSize   = 10
+	maxTopSize       = 100
+	maxHistogramSize = 1000
+)
+
+type statisticsQuery struct {
+	auditQuery
+	// Interval of the histogram, e.g. 30m, 1h or 1d, no histogram when empty
+	Interval string `form:"interval,omitempty"`
+	// Top are the fields to find the most frequent values of, e.g. user,ip
+	Top []string `form:"top,omitempty"`
+	// TopSize is how many values are returned for each top field, 10 by default
+	TopSize int `form:"topSize,omitempty"`
+	// Distinct are the fields to count the distinct values of
+	Distinct []string `form:"distinct,omitempty"`
+}
+
+// @Summary audit log statistics
+// @Description histogram, error rate, top values and distinct counts of the audit log matching the search filters
+// @Tags audit
+// @Param	query	query	statisticsQuery  false  "filters and aggregations"
+// @Success 200 {object} store.Statistics
+// @Failure 500 {object} errcode.ErrorInfo
+// @Router /api/v1/kube/audit/statistics  [get]
+// @Router /api/v1/kube/audit/statistics  [post]
+func AggregateAuditLog(c *gin.Context) {
+
+	if !backend.StoreEnabled {
+		response.FailReturn(c, errcode.New(&errcode.ErrorInfo{Code: http.StatusBadRequest, Message: "Audit or its store is disabled."}))
+		return
+	}
+
+	// authority check
+	user := auth.GetUserFromReq(c)
+	if user == "" {
+		response.FailReturn(c, errcode.AuthenticateError)
+		return
+	}
+	if !checkIsAdmin(user) {
+		response.FailReturn(c, errcode.NoAuthority)
+		return
+	}
+
+	var query statisticsQuery
+	var err error
+	if c.Request.Method == http.MethodPost {
+		err = c.ShouldBindBodyWith(&query, binding.JSON)
+	} else {
+		err = c.ShouldBindQuery(&query)
+	}
+	if err != nil {
+		clog.Error("parse audit log statistics param error: %s", err)
+		response.FailReturn(c, errcode.InvalidBodyFormat)
+		return
+	}
+
+	stats, errInfo := aggregateLog(&query)
+	if errInfo != nil {
+		response.FailReturn(c, errInfo)
+		return
+	}
+	response.SuccessReturn(c, stats)
+}
+
+func aggregateLog(query *statisticsQuery) (*store.Statistics, *errcode.ErrorInfo) {
+	agg, errInfo := query.aggregation()
+	if errInfo != nil {
+		return nil, errInfo
+	}
+	storeQuery, errInfo := query.storeQuery()
+	if errInfo != nil {
+		return nil, errInfo
+	}
+
+	stats, err := backend.GetStore().Aggregate(storeQuery, agg)
+	if err != nil {
+		clog.Error("aggregate audit log error: %s", err)
+		return nil, errcode.InternalServerError
+	}
+	return stats, nil
+}
+
+// aggregation validates the interval and fields of the query
+func (q *statisticsQuery) aggregation() (*store.Aggregation, *errcode.ErrorInfo) {
+	agg := &store.Aggregation{TopSize: q.TopSize}
+	if agg.TopSize <= 0 {
+		agg.TopSize = defaultTopSize
+	}
+	if agg.TopSize > maxTopSize {
+		return nil, errcode.InvalidStatisticsOption(fmt.Sprintf("topSize can not exceed %d", maxTopSize))
+	}
+
+	if q.Interval != "" {
+		interval, err := parseInterval(q.Interval)
+		if err != nil || interval < time.Second {
+			return nil, errcode.InvalidStatisticsOption(fmt.Sprintf("interval %q is not a duration of at least 1s", q.Interval))
+		}
+		agg.Interval = int64(interval / time.Second)
+		if q.StartTime <= 0 {
+			return nil, errcode.InvalidStatisticsOption("a histogram needs a startTime")
+		}
+		end := q.EndTime
+		if end <= 0 {
+			end = time.Now().Unix()
+		}
+		if (end-q.StartTime)/agg.Interval >= maxHistogramSize {
+			return nil, errcode.InvalidStatisticsOption(fmt.Sprintf("the histogram can not have more than %d buckets", maxHistogramSize))
+		}
+	}
+
+	var errInfo *errcode.ErrorInfo
+	if agg.Top, errInfo = aggregatedFields(q.Top); errInfo != nil {
+		return nil, errInfo
+	}
+	if agg.Distinct, errInfo = aggregatedFields(q.Distinct); errInfo != nil {
+		return nil, errInfo
+	}
+	return agg, nil
+}
+
+// parseInterval parses a duration, accepting days like 1d on top of time.ParseDuration
+func parseInterval(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// aggregatedFields resolves field names, each value may hold several names separated by commas
+func aggregatedFields(names []string) ([]store.Field, *errcode.ErrorInfo) {
+	var fields []store.Field
+	for _, value := range names {
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			f, ok := store.FieldByName(name)
+			if !ok || !f.Aggregatable() {
+				return nil, errcode.InvalidStatisticsOption(fmt.Sprintf("field %q can not be aggregated", name))
+			}
+			if !containsField(fields, f) {
+				fields = append(fields, f)
+			}
+		}
+	}
+	return fields, nil
+}
+
+func containsField(fields []store.Field, f store.Field) bool {
+	for _, field := range fields {
+		if field == f {
+			return true
+		}
+	}
+	return false
+}
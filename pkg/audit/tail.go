@@ -0,0 +1,159 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"audit/pkg/backend"
+	"audit/pkg/cloudevents"
+	"audit/pkg/encryption"
+	"audit/pkg/store"
+	"audit/pkg/utils/auth"
+	"audit/pkg/utils/env"
+	"audit/pkg/utils/errcode"
+	"audit/pkg/utils/response"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/saashqdev/kubeworkz/pkg/clog"
+)
+
+const TailHeartbeatInterval = time.Second * 15
+
+// server-sent event names of the live tail
+const (
+	tailEventAudit     = "audit"
+	tailEventDropped   = "dropped"
+	tailEventHeartbeat = "heartbeat"
+)
+
+type tailQuery struct {
+	// Query is a search expression, like in search
+	Query string `form:"query,omitempty"`
+	// Format of the events is the event itself by default, or cloudevents
+	Format string `form:"format,omitempty"`
+	auditFilters
+}
+
+// @Summary live tail of audit log
+// @Description streams the audit events matching the filters as they arrive, as server-sent events:
+// @Description "audit" with an event, "dropped" with the number of events skipped because the client is too slow,
+// @Description and "heartbeat" with the server time every 15 seconds
+// @Tags audit
+// @Param	query	query	tailQuery  false  "filters"
+// @Produce text/event-stream
+// @Failure 500 {object} errcode.ErrorInfo
+// @Router /api/v1/kube/audit/tail  [get]
+func TailAuditLog(c *gin.Context) {
+
+	if !backend.StoreEnabled {
+		response.FailReturn(c, errcode.New(&errcode.ErrorInfo{Code: http.StatusBadRequest, Message: "Audit or its store is disabled."}))
+		return
+	}
+
+	// authority check
+	user := auth.GetUserFromReq(c)
+	if user == "" {
+		response.FailReturn(c, errcode.AuthenticateError)
+		return
+	}
+	if !checkIsAdmin(user) {
+		response.FailReturn(c, errcode.NoAuthority)
+		return
+	}
+
+	var query tailQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		clog.Error("parse tail audit log param error: %s", err)
+		response.FailReturn(c, errcode.InvalidBodyFormat)
+		return
+	}
+	expr, errInfo := query.expr()
+	if errInfo != nil {
+		response.FailReturn(c, errInfo)
+		return
+	}
+	asCloudEvents := strings.EqualFold(query.Format, ExportFormatCloudEvents)
+	if query.Format != "" && !asCloudEvents {
+		response.FailReturn(c, errcode.InvalidFilter("format "+query.Format+" is not cloudevents"))
+		return
+	}
+
+	sub, err := backend.Subscribe()
+	if err != nil {
+		response.FailReturn(c, errcode.TooManyTailSubscribers)
+		return
+	}
+	defer sub.Close()
+
+	// the events are in clear until they are stored, they are encrypted for
+	// the users who can not read them in search
+	decrypt := canDecrypt(user)
+
+	clog.Info("user %s starts a live tail of audit log", user)
+	c.Header("Cache-Control", "no-cache")
+	// keep proxies from buffering the stream
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.SSEvent(tailEventHeartbeat, time.Now().Unix())
+
+	heartbeat := time.NewTicker(TailHeartbeatInterval)
+	defer heartbeat.Stop()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case event := <-sub.Events():
+			if dropped := sub.Dropped(); dropped > 0 {
+				c.SSEvent(tailEventDropped, dropped)
+			}
+			if !decrypt {
+				encrypted := *event
+				encryption.Event(&encrypted)
+				event = &encrypted
+			}
+			if expr != nil && !store.Match(expr, event) {
+				break
+			}
+			if !asCloudEvents {
+				c.SSEvent(tailEventAudit, event)
+				break
+			}
+			e, err := cloudevents.FromV1(env.CloudEventsSource(), event)
+			if err != nil {
+				clog.Error("convert event %s to cloudevent error: %s", event.RequestId, err)
+				break
+			}
+			c.SSEvent(tailEventAudit, e)
+		case <-heartbeat.C:
+			if dropped := sub.Dropped(); dropped > 0 {
+				c.SSEvent(tailEventDropped, dropped)
+			}
+			c.SSEvent(tailEventHeartbeat, time.Now().Unix())
+		case <-c.Request.Context().Done():
+			return false
+		}
+		return true
+	})
+	clog.Info("user %s stops the live tail of audit log", user)
+}
+
+// expr joins the filters and the parsed search expression, nil when there are none
+func (q *tailQuery) expr() (store.Expr, *errcode.ErrorInfo) {
+	search := auditQuery{Query: q.Query, auditFilters: q.auditFilters}
+	return search.expr()
+}
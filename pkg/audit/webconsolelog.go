@@ -19,8 +19,13 @@ package audit
 import (
 	"audit/pkg/backend"
 	v1 "audit/pkg/backend/v1"
+	"audit/pkg/detection"
+	"audit/pkg/encryption"
+	"audit/pkg/redact"
+	"audit/pkg/session"
 	"audit/pkg/utils/errcode"
 	"audit/pkg/utils/response"
+	"encoding/json"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -56,20 +61,67 @@ func HandleWebconsoleAuditLog(c *gin.Context) {
 	}
 	response.SuccessReturn(c, nil)
 
+	secret := msg.DataType == session.ChunkStdin && msg.SessionID != "" && session.ReadingSecret(msg.SessionID)
+	recordSession(msg, secret)
+	ch := backend.GetCacheCh()
+
+	// keystrokes are logged once per command they run
+	if msg.DataType == session.ChunkStdin && msg.SessionID != "" {
+		for _, command := range session.Input(msg.SessionID, msg.CreateTime, msg.Data) {
+			event, err := buildCommandEvent(msg, command)
+			if err != nil {
+				clog.Error("build event with audit message err: %v", err)
+				continue
+			}
+			backend.CacheEvent(ch, event)
+		}
+		return
+	}
+	if msg.DataType == session.ChunkStdout && msg.SessionID != "" {
+		session.Output(msg.SessionID, msg.Data)
+	}
+
 	event, err := buildEvent(msg)
 	if err != nil {
 		clog.Error("build event with audit message err: %v", err)
 		return
 	}
 	// send event to channel
-	ch := backend.GetCacheCh()
 	backend.CacheEvent(ch, event)
 }
 
+// recordSession adds the message to the recording of its session, with
+// passwords typed and the sensitive data of the output masked, and the data
+// encrypted when sessions are
+func recordSession(msg *webconsoleAuditMsg, secret bool) {
+	var t int64
+	if !msg.CreateTime.IsZero() {
+		t = msg.CreateTime.UnixNano()
+	}
+	data := redact.Mask
+	if !secret {
+		data, _ = redact.String(msg.Data)
+	}
+	data = encryption.Encrypt(encryption.FieldSession, data)
+	session.Record(session.Session{
+		ID:            msg.SessionID,
+		User:          msg.WebUser,
+		Cluster:       msg.ClusterName,
+		Namespace:     msg.Namespace,
+		Pod:           msg.PodName,
+		ContainerUser: msg.ContainerUser,
+		RemoteIP:      msg.RemoteIP,
+		UserAgent:     msg.UserAgent,
+		Platform:      msg.Platform,
+	}, session.Chunk{Time: t, Type: msg.DataType, Data: data})
+}
+
+// buildEvent names the event after the type of the data, the terminal data is
+// only kept in the request parameters, which can be encrypted
 func buildEvent(msg *webconsoleAuditMsg) (*v1.Event, error) {
 	event := &v1.Event{
 		EventTime: msg.CreateTime.Unix(),
-		EventName: eventResourceWebconsole + " " + msg.Data,
+		EventName: eventResourceWebconsole + " " + msg.DataType,
 		Description: "ClusterName: " + msg.ClusterName + ", Namespace: " + msg.Namespace +
 			", ContainerUser: " + msg.ContainerUser + ", Platform: " + msg.Platform,
 		SourceIpAddress:   msg.RemoteIP,
@@ -82,3 +134,43 @@ func buildEvent(msg *webconsoleAuditMsg) (*v1.Event, error) {
 	}
 	return event, nil
 }
+
+// webconsoleCommand is the request parameters of a command event
+type webconsoleCommand struct {
+	Command    string
+	Keystrokes string
+}
+
+// buildCommandEvent keeps the command line and the keystrokes typed for it in
+// the request parameters and flags dangerous commands. Lines answering a
+// password prompt are masked.
+func buildCommandEvent(msg *webconsoleAuditMsg, command session.Command) (*v1.Event, error) {
+	commandMsg := *msg
+	commandMsg.CreateTime = command.Time
+	commandMsg.Data = command.Line
+	if command.Secret {
+		commandMsg.Data = redact.Mask
+	}
+	event, err := buildEvent(&commandMsg)
+	if err != nil {
+		return nil, err
+	}
+	if command.Secret {
+		event.RequestParameters = redact.Mask
+		event.Redactions = 1
+		return event, nil
+	}
+	params, err := json.Marshal(webconsoleCommand{Command: command.Line, Keystrokes: command.Keystrokes})
+	if err != nil {
+		return nil, err
+	}
+	event.RequestParameters = string(params)
+	event.Findings = detection.DetectCommand(detection.CommandContext{
+		User:          msg.WebUser,
+		Cluster:       msg.ClusterName,
+		Namespace:     msg.Namespace,
+		Pod:           msg.PodName,
+		ContainerUser: msg.ContainerUser,
+	}, command.Line)
+	return event, nil
+}
@@ -18,12 +18,14 @@ package backend
 
 import (
 	v1 "audit/pkg/backend/v1"
+	"audit/pkg/encryption"
+	"audit/pkg/redact"
+	"audit/pkg/store"
+	"audit/pkg/store/elasticsearch"
+	"audit/pkg/store/local"
+	"audit/pkg/store/postgres"
 	"audit/pkg/utils/env"
-	"bytes"
 	"context"
-	"crypto/tls"
-	"encoding/json"
-	"net/http"
 	"time"
 
 	"github.com/saashqdev/kubeworkz/pkg/clog"
@@ -38,16 +40,17 @@ const (
 	DefaultBatchInterval = time.Second * 3
 	DefaultCacheCapacity = 10000
 	CacheTimeout         = time.Second * 3
-	MaxRetryTime         = 10
 )
 
 var (
-	SendElasticSearch bool
+	// StoreEnabled reports whether audit is on and its store is available
+	StoreEnabled bool
+
+	activeStore store.Store
 )
 
 type Backend struct {
-	url                string
-	client             http.Client
+	store              store.Store
 	sendTimeout        time.Duration
 	getSenderTimeout   time.Duration
 	senderCh           chan interface{}
@@ -59,10 +62,7 @@ type Backend struct {
 
 func NewBackend() *Backend {
 
-	esWebhook := env.ElasticSearchHost()
-	esUrl := esWebhook.Host + "/" + esWebhook.Index + "/" + esWebhook.Type
 	b := Backend{
-		url:                esUrl,
 		sendTimeout:        SendTimeout,
 		eventBatchInterval: DefaultBatchInterval,
 		eventBatchSize:     DefaultBatchSize,
@@ -71,22 +71,60 @@ func NewBackend() *Backend {
 
 	b.senderCh = make(chan interface{}, DefaultSendersNum)
 	b.cache = cacheCh
+	b.store = newStore(b.sendTimeout)
+	activeStore = b.store
+
+	return &b
+}
 
-	b.client = http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: true,
-			},
-		},
-		Timeout: b.sendTimeout,
+func newStore(sendTimeout time.Duration) store.Store {
+	switch env.StoreType() {
+	case env.StoreLocal:
+		retention := time.Duration(env.LocalStoreRetentionDays()) * 24 * time.Hour
+		s, err := local.New(env.LocalStorePath(), retention)
+		if err != nil {
+			clog.Fatal("open local audit store at %s error: %s", env.LocalStorePath(), err)
+		}
+		return s
+	case env.StorePostgres:
+		retention := time.Duration(env.PostgresRetentionDays()) * 24 * time.Hour
+		s, err := postgres.New(env.PostgresDSN(), retention)
+		if err != nil {
+			clog.Fatal("open postgres audit store error: %s", err)
+		}
+		return s
+	case env.StoreElasticSearch:
+	default:
+		clog.Warn("unknown audit store %s, use %s", env.StoreType(), env.StoreElasticSearch)
 	}
+	return elasticsearch.New(env.ElasticSearchHost(), sendTimeout)
+}
 
-	return &b
+// Observer sees each event taken from the cache before it is saved, it runs
+// inline with the ingestion and must not block. Observers see the findings
+// added by the observers registered before them.
+type Observer func(e *v1.Event)
+
+var observers []Observer
+
+// AddObserver registers an observer, it must be called before the backend
+// runs. Observers run in the order they are added.
+func AddObserver(o Observer) {
+	observers = append(observers, o)
 }
 
-// send event to es/webhook
+// GetStore returns the store events are saved to and searched from
+func GetStore() store.Store {
+	return activeStore
+}
+
+// send event to store, its payloads encrypted once the observers and the tail have seen them
 func (b *Backend) sendEvents(events *v1.EventList) {
 
+	for i := range events.Items {
+		encryption.Event(&events.Items[i])
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), b.sendTimeout)
 	defer cancel()
 
@@ -108,32 +146,9 @@ func (b *Backend) sendEvents(events *v1.EventList) {
 			stopCh <- struct{}{}
 			clog.Info("send %d auditing logs used %d", len(events.Items), time.Since(start).Milliseconds())
 		}()
-		retry := 0
-		for _, event := range events.Items {
-
-			bs, err := json.Marshal(event)
-			if err != nil {
-				clog.Error("json marshal error, %s", err)
-				return
-			}
-
-			response, err := b.client.Post(b.url, "application/json", bytes.NewBuffer(bs))
-			if err != nil {
-				clog.Error("send audit event error, %s", err)
-				retry++
-				if retry >= MaxRetryTime {
-					b.dealFailSend()
-				}
-				return
-			}
-
-			if response.StatusCode != http.StatusOK && response.StatusCode != http.StatusCreated {
-				clog.Error("send audit event error[%d]", response.StatusCode)
-				return
-			}
-			clog.Debug("send event %s success", event.EventName)
+		if err := b.store.Save(events.Items); err != nil {
+			clog.Error("save audit events error: %s", err)
 		}
-
 	}
 
 	go send()
@@ -149,12 +164,6 @@ func (b *Backend) sendEvents(events *v1.EventList) {
 	}
 }
 
-func (b *Backend) dealFailSend() {
-	clog.Info("send es fail exceed max retry times")
-	b.senderCh = make(chan interface{}, DefaultSendersNum)
-	b.cache = make(chan *v1.Event, DefaultCacheCapacity)
-}
-
 // get event from backend cache channel
 func (b *Backend) getEvents() *v1.EventList {
 
@@ -168,6 +177,10 @@ func (b *Backend) getEvents() *v1.EventList {
 			if event == nil {
 				break
 			}
+			for _, observe := range observers {
+				observe(event)
+			}
+			tail.publish(event)
 			events.Items = append(events.Items, *event)
 			if len(events.Items) >= b.eventBatchSize {
 				return events
@@ -194,14 +207,15 @@ func (b *Backend) Run() {
 		if len(events.Items) == 0 {
 			continue
 		}
-		if SendElasticSearch {
+		if StoreEnabled {
 			go b.sendEvents(events)
 		}
 	}
 }
 
-// send event to cache channel
+// send event to cache channel, its sensitive data masked
 func CacheEvent(ch chan *v1.Event, e *v1.Event) {
+	redact.Event(e)
 	select {
 	case ch <- e:
 		return
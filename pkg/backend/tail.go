@@ -0,0 +1,89 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	v1 "audit/pkg/backend/v1"
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	DefaultTailBufferSize = 1000
+	MaxTailSubscribers    = 100
+)
+
+var ErrTooManySubscribers = errors.New("too many live tail subscribers")
+
+var tail = &tailHub{subscribers: make(map[*Subscription]struct{})}
+
+// tailHub hands the events drained from the cache to the live tail subscribers
+type tailHub struct {
+	mu          sync.RWMutex
+	subscribers map[*Subscription]struct{}
+}
+
+// Subscription receives the events cached after it was made. Events are
+// dropped rather than slowing down the store when its buffer is full.
+type Subscription struct {
+	events  chan *v1.Event
+	dropped int64
+}
+
+// Subscribe starts receiving the cached events, the subscription must be closed when done
+func Subscribe() (*Subscription, error) {
+	tail.mu.Lock()
+	defer tail.mu.Unlock()
+
+	if len(tail.subscribers) >= MaxTailSubscribers {
+		return nil, ErrTooManySubscribers
+	}
+	s := &Subscription{events: make(chan *v1.Event, DefaultTailBufferSize)}
+	tail.subscribers[s] = struct{}{}
+	return s, nil
+}
+
+// Events returns the channel of the events, receivers must not modify them
+func (s *Subscription) Events() <-chan *v1.Event {
+	return s.events
+}
+
+// Dropped returns the number of events dropped since it was last called
+func (s *Subscription) Dropped() int64 {
+	return atomic.SwapInt64(&s.dropped, 0)
+}
+
+func (s *Subscription) Close() {
+	tail.mu.Lock()
+	defer tail.mu.Unlock()
+	delete(tail.subscribers, s)
+}
+
+// publish hands an event to every subscriber without waiting for them
+func (h *tailHub) publish(e *v1.Event) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for s := range h.subscribers {
+		select {
+		case s.events <- e:
+		default:
+			atomic.AddInt64(&s.dropped, 1)
+		}
+	}
+}
@@ -16,8 +16,12 @@ limitations under the License.
 
 package v1
 
+const Version = "V1"
+
 type Event struct {
-	EventTime         int64
+	EventTime int64
+	// EventTimeNanos is the fraction of the second of EventTime, kept from V2 events
+	EventTimeNanos    int `json:",omitempty"`
 	EventVersion      string
 	EventName         string
 	Description       string
@@ -36,6 +40,14 @@ type Event struct {
 	ApiAction         string
 	ApiVersion        string
 	ResourceReports   []Resource
+	// Cluster, Namespace and Labels are kept from V2 events
+	Cluster   string            `json:",omitempty"`
+	Namespace string            `json:",omitempty"`
+	Labels    map[string]string `json:",omitempty"`
+	// Findings are the security detections the event raised
+	Findings []Finding
+	// Redactions is the number of sensitive values masked before the event was stored
+	Redactions int
 }
 
 type UserIdentity struct {
@@ -46,6 +58,18 @@ type Resource struct {
 	ResourceType string
 	ResourceId   string
 	ResourceName string
+	// Namespace and APIVersion are kept from the objects of V2 events
+	Namespace  string `json:",omitempty"`
+	APIVersion string `json:",omitempty"`
+}
+
+// Finding tags an event with a security detection
+type Finding struct {
+	// Detection names what was detected, e.g. k8s-pod-exec
+	Detection string
+	// Severity is low, medium, high or critical
+	Severity string
+	Message  string
 }
 
 type EventList struct {
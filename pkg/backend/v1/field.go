@@ -0,0 +1,90 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import "strconv"
+
+// names of the fields the ingest mappings fill from text
+const (
+	FieldEventName         = "EventName"
+	FieldDescription       = "Description"
+	FieldUser              = "User"
+	FieldSourceIpAddress   = "SourceIpAddress"
+	FieldUserAgent         = "UserAgent"
+	FieldRequestId         = "RequestId"
+	FieldRequestMethod     = "RequestMethod"
+	FieldRequestParameters = "RequestParameters"
+	FieldResponseStatus    = "ResponseStatus"
+	FieldResponseElements  = "ResponseElements"
+	FieldEventType         = "EventType"
+	FieldErrorCode         = "ErrorCode"
+	FieldErrorMessage      = "ErrorMessage"
+	FieldUrl               = "Url"
+	FieldApiAction         = "ApiAction"
+	FieldApiVersion        = "ApiVersion"
+	FieldResourceType      = "ResourceType"
+	FieldResourceName      = "ResourceName"
+	FieldResourceId        = "ResourceId"
+)
+
+var fieldSetters = map[string]func(e *Event, value string){
+	FieldEventName:         func(e *Event, value string) { e.EventName = value },
+	FieldDescription:       func(e *Event, value string) { e.Description = value },
+	FieldUser:              func(e *Event, value string) { e.UserIdentity = &UserIdentity{AccountId: value} },
+	FieldSourceIpAddress:   func(e *Event, value string) { e.SourceIpAddress = value },
+	FieldUserAgent:         func(e *Event, value string) { e.UserAgent = value },
+	FieldRequestId:         func(e *Event, value string) { e.RequestId = value },
+	FieldRequestMethod:     func(e *Event, value string) { e.RequestMethod = value },
+	FieldRequestParameters: func(e *Event, value string) { e.RequestParameters = value },
+	FieldResponseStatus: func(e *Event, value string) {
+		if status, err := strconv.Atoi(value); err == nil {
+			e.ResponseStatus = status
+		}
+	},
+	FieldResponseElements: func(e *Event, value string) { e.ResponseElements = value },
+	FieldEventType:        func(e *Event, value string) { e.EventType = value },
+	FieldErrorCode:        func(e *Event, value string) { e.ErrorCode = value },
+	FieldErrorMessage:     func(e *Event, value string) { e.ErrorMessage = value },
+	FieldUrl:              func(e *Event, value string) { e.Url = value },
+	FieldApiAction:        func(e *Event, value string) { e.ApiAction = value },
+	FieldApiVersion:       func(e *Event, value string) { e.ApiVersion = value },
+	FieldResourceType:     func(e *Event, value string) { e.resource().ResourceType = value },
+	FieldResourceName:     func(e *Event, value string) { e.resource().ResourceName = value },
+	FieldResourceId:       func(e *Event, value string) { e.resource().ResourceId = value },
+}
+
+// IsField reports whether a field can be set from text
+func IsField(field string) bool {
+	_, ok := fieldSetters[field]
+	return ok
+}
+
+// SetField sets a field from text, the user as the account of the user
+// identity and the resource fields on the first resource report. A status
+// which is not a number is ignored.
+func SetField(e *Event, field, value string) {
+	if set, ok := fieldSetters[field]; ok {
+		set(e, value)
+	}
+}
+
+func (e *Event) resource() *Resource {
+	if len(e.ResourceReports) == 0 {
+		e.ResourceReports = []Resource{{}}
+	}
+	return &e.ResourceReports[0]
+}
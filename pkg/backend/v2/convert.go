@@ -0,0 +1,194 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	v1 "audit/pkg/backend/v1"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/saashqdev/kubeworkz/pkg/clog"
+)
+
+var namespaceRegexp = regexp.MustCompile(`/namespaces/([^/?]+)`)
+
+// FromV1 converts a v1 event. Payloads which are json are kept as json, the
+// namespace is read from the url when the event has none and the outcome from
+// the status and error.
+func FromV1(e *v1.Event) *Event {
+	event := &Event{
+		EventVersion:      Version,
+		EventName:         e.EventName,
+		Description:       e.Description,
+		SourceIpAddress:   e.SourceIpAddress,
+		UserAgent:         e.UserAgent,
+		RequestId:         e.RequestId,
+		RequestMethod:     e.RequestMethod,
+		RequestParameters: payloadFromV1(e.RequestParameters),
+		ResponseStatus:    e.ResponseStatus,
+		ResponseElements:  payloadFromV1(e.ResponseElements),
+		Outcome:           outcome(e),
+		EventType:         e.EventType,
+		ErrorCode:         e.ErrorCode,
+		ErrorMessage:      e.ErrorMessage,
+		Url:               e.Url,
+		UserIdentity:      e.UserIdentity,
+		ApiAction:         e.ApiAction,
+		ApiVersion:        e.ApiVersion,
+		Cluster:           e.Cluster,
+		Namespace:         e.Namespace,
+		Labels:            e.Labels,
+		Findings:          e.Findings,
+		Redactions:        e.Redactions,
+	}
+	if e.EventTime > 0 {
+		event.EventTime = time.Unix(e.EventTime, int64(e.EventTimeNanos)).UTC()
+	}
+	if m := namespaceRegexp.FindStringSubmatch(e.Url); m != nil && event.Namespace == "" {
+		event.Namespace = m[1]
+	}
+	for _, r := range e.ResourceReports {
+		event.Objects = append(event.Objects, ObjectReference{
+			Resource:   r.ResourceType,
+			Namespace:  r.Namespace,
+			Name:       r.ResourceName,
+			UID:        r.ResourceId,
+			APIVersion: r.APIVersion,
+		})
+	}
+	return event
+}
+
+// ToV1 converts an event to v1, which keeps the fraction of the second of its
+// time apart. The version is kept to tell where it came from, the outcome is
+// told again from the status and error.
+func ToV1(e *Event) *v1.Event {
+	event := &v1.Event{
+		EventVersion:      e.EventVersion,
+		EventName:         e.EventName,
+		Description:       e.Description,
+		SourceIpAddress:   e.SourceIpAddress,
+		UserAgent:         e.UserAgent,
+		RequestId:         e.RequestId,
+		RequestMethod:     e.RequestMethod,
+		RequestParameters: payloadToV1(e.RequestParameters),
+		ResponseStatus:    e.ResponseStatus,
+		ResponseElements:  payloadToV1(e.ResponseElements),
+		EventType:         e.EventType,
+		ErrorCode:         e.ErrorCode,
+		ErrorMessage:      e.ErrorMessage,
+		Url:               e.Url,
+		UserIdentity:      e.UserIdentity,
+		ApiAction:         e.ApiAction,
+		ApiVersion:        e.ApiVersion,
+		Cluster:           e.Cluster,
+		Namespace:         e.Namespace,
+		Labels:            e.Labels,
+		Findings:          e.Findings,
+		Redactions:        e.Redactions,
+	}
+	if !e.EventTime.IsZero() {
+		event.EventTime = e.EventTime.Unix()
+		event.EventTimeNanos = e.EventTime.Nanosecond()
+	}
+	for _, o := range e.Objects {
+		event.ResourceReports = append(event.ResourceReports, v1.Resource{
+			ResourceType: o.Resource,
+			ResourceId:   o.UID,
+			ResourceName: o.Name,
+			Namespace:    o.Namespace,
+			APIVersion:   o.APIVersion,
+		})
+	}
+	return event
+}
+
+// DecodeV1 reads an event in json of the version named by its EventVersion,
+// V1 when it names none or an unknown version, and returns it in v1
+func DecodeV1(data []byte) (*v1.Event, error) {
+	var header struct {
+		EventVersion string
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, err
+	}
+	switch strings.ToUpper(header.EventVersion) {
+	case Version:
+		event := &Event{}
+		if err := json.Unmarshal(data, event); err != nil {
+			return nil, err
+		}
+		if event.Outcome != "" && event.Outcome != OutcomeSuccess && event.Outcome != OutcomeFailure && event.Outcome != OutcomeUnknown {
+			return nil, fmt.Errorf("outcome %q is not success, failure or unknown", event.Outcome)
+		}
+		event.EventVersion = Version
+		return ToV1(event), nil
+	case "", v1.Version:
+	default:
+		clog.Warn("event version %q is not supported, the event is read as %s", header.EventVersion, v1.Version)
+	}
+	event := &v1.Event{}
+	if err := json.Unmarshal(data, event); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+// outcome tells the outcome of a v1 event from its status and error
+func outcome(e *v1.Event) string {
+	switch {
+	case e.ErrorCode != "" || e.ErrorMessage != "" || e.ResponseStatus >= http.StatusBadRequest:
+		return OutcomeFailure
+	case e.ResponseStatus > 0:
+		return OutcomeSuccess
+	default:
+		return OutcomeUnknown
+	}
+}
+
+// payloadFromV1 keeps a json object or array, and writes other texts as json strings
+func payloadFromV1(s string) json.RawMessage {
+	trimmed := strings.TrimSpace(s)
+	if trimmed == "" {
+		return nil
+	}
+	if (trimmed[0] == '{' || trimmed[0] == '[') && json.Valid([]byte(trimmed)) {
+		return json.RawMessage(trimmed)
+	}
+	bs, _ := json.Marshal(s)
+	return bs
+}
+
+// payloadToV1 returns the text of a json string, and other json values as they are
+func payloadToV1(raw json.RawMessage) string {
+	trimmed := bytes.TrimSpace(raw)
+	if len(trimmed) == 0 || bytes.Equal(trimmed, []byte("null")) {
+		return ""
+	}
+	if trimmed[0] == '"' {
+		var s string
+		if err := json.Unmarshal(trimmed, &s); err == nil {
+			return s
+		}
+	}
+	return string(trimmed)
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	v1 "audit/pkg/backend/v1"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestToV1KeepsV2Fields(t *testing.T) {
+	eventTime := time.Date(2024, 5, 1, 8, 30, 12, 345678901, time.UTC)
+	event := &Event{
+		EventVersion: Version,
+		EventTime:    eventTime,
+		EventName:    "[Billing] update invoice",
+		Outcome:      OutcomeFailure,
+		Cluster:      "pivot",
+		Namespace:    "billing",
+		Labels:       map[string]string{"team": "finance"},
+		Objects: []ObjectReference{
+			{Resource: "invoices", Namespace: "billing", Name: "inv-42", UID: "42", APIVersion: "billing/v1"},
+		},
+	}
+
+	converted := ToV1(event)
+	if converted.EventTime != eventTime.Unix() || converted.EventTimeNanos != 345678901 {
+		t.Errorf("time = %d.%09d, want %s", converted.EventTime, converted.EventTimeNanos, eventTime)
+	}
+	if converted.Cluster != "pivot" || converted.Namespace != "billing" || converted.Labels["team"] != "finance" {
+		t.Errorf("cluster, namespace and labels = %q, %q, %v", converted.Cluster, converted.Namespace, converted.Labels)
+	}
+	wantResources := []v1.Resource{
+		{ResourceType: "invoices", ResourceId: "42", ResourceName: "inv-42", Namespace: "billing", APIVersion: "billing/v1"},
+	}
+	if !reflect.DeepEqual(converted.ResourceReports, wantResources) {
+		t.Errorf("resources = %+v, want %+v", converted.ResourceReports, wantResources)
+	}
+	if converted.ErrorCode != "" {
+		t.Errorf("error code = %q, want none", converted.ErrorCode)
+	}
+
+	back := FromV1(converted)
+	if !back.EventTime.Equal(eventTime) || back.Cluster != event.Cluster || back.Namespace != event.Namespace ||
+		!reflect.DeepEqual(back.Labels, event.Labels) || !reflect.DeepEqual(back.Objects, event.Objects) {
+		t.Errorf("event converted back = %+v, want %+v", back, event)
+	}
+}
+
+func TestFromV1Namespace(t *testing.T) {
+	tests := []struct {
+		name  string
+		event v1.Event
+		want  string
+	}{
+		{"from the url", v1.Event{Url: "/api/v1/namespaces/dev/pods"}, "dev"},
+		{"kept", v1.Event{Url: "/api/v1/namespaces/dev/pods", Namespace: "prod"}, "prod"},
+		{"none", v1.Event{Url: "/api/v1/nodes"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FromV1(&tt.event).Namespace; got != tt.want {
+				t.Errorf("namespace = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeV1(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        string
+		wantName    string
+		wantVersion string
+		wantErr     bool
+	}{
+		{"no version", `{"EventName":"get pods"}`, "get pods", "", false},
+		{"v1", `{"EventVersion":"V1","EventName":"get pods"}`, "get pods", "V1", false},
+		{"v2", `{"EventVersion":"v2","EventName":"get pods","EventTime":"2024-05-01T08:30:12Z"}`, "get pods", Version, false},
+		{"unknown version read as v1", `{"EventVersion":"V3","EventName":"get pods"}`, "get pods", "V3", false},
+		{"invalid outcome", `{"EventVersion":"V2","Outcome":"maybe"}`, "", "", true},
+		{"not json", `{`, "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event, err := DecodeV1([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if event.EventName != tt.wantName || event.EventVersion != tt.wantVersion {
+				t.Errorf("event = %q in version %q, want %q in version %q", event.EventName, event.EventVersion, tt.wantName, tt.wantVersion)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	v1 "audit/pkg/backend/v1"
+	"encoding/json"
+	"time"
+)
+
+const Version = "V2"
+
+// Outcome is the normalized result of the request of an event
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+	OutcomeUnknown = "unknown"
+)
+
+// Event is an audit event with structured payloads. EventTime is written in
+// RFC 3339 with nanoseconds, RequestParameters and ResponseElements are json
+// values, strings for the payloads which were not json.
+type Event struct {
+	EventTime         time.Time
+	EventVersion      string
+	EventName         string
+	Description       string
+	SourceIpAddress   string
+	UserAgent         string
+	RequestId         string
+	RequestMethod     string
+	RequestParameters json.RawMessage `json:",omitempty"`
+	ResponseStatus    int
+	ResponseElements  json.RawMessage `json:",omitempty"`
+	// Outcome is success, failure or unknown
+	Outcome      string
+	EventType    string
+	ErrorCode    string
+	ErrorMessage string
+	Url          string
+	UserIdentity *v1.UserIdentity
+	ApiAction    string
+	ApiVersion   string
+	Cluster      string
+	Namespace    string
+	// Objects are the objects the request was on
+	Objects []ObjectReference
+	Labels  map[string]string `json:",omitempty"`
+	// Findings are the security detections the event raised
+	Findings []v1.Finding
+	// Redactions is the number of sensitive values masked before the event was stored
+	Redactions int
+}
+
+// ObjectReference is an object of a request, with the namespace of the event when it has none
+type ObjectReference struct {
+	Resource   string
+	Namespace  string `json:",omitempty"`
+	Name       string `json:",omitempty"`
+	UID        string `json:",omitempty"`
+	APIVersion string `json:",omitempty"`
+}
+
+type EventList struct {
+	Items []Event
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudevents
+
+import (
+	v1 "audit/pkg/backend/v1"
+	v2 "audit/pkg/backend/v2"
+	"strconv"
+	"time"
+)
+
+const (
+	// TypeAuditEvent is an audit event, its data is the event in v1 or v2
+	TypeAuditEvent = "io.kubeworkz.audit.event"
+	// TypeAlert is an alert fired by an alert rule
+	TypeAlert = "io.kubeworkz.audit.alert"
+)
+
+// extensions fill these fields of the events of other types
+const (
+	ExtensionUser      = "user"
+	ExtensionSourceIP  = "sourceip"
+	ExtensionUserAgent = "useragent"
+	ExtensionMethod    = "method"
+	ExtensionStatus    = "status"
+	// ExtensionRequestID holds the request id of a log, which many events can share
+	ExtensionRequestID = "requestid"
+)
+
+// ToV1 maps an event onto a v1 event. The data of an audit event is read as
+// such, the events of other types are named after their source and type, with
+// their data as request parameters, their subject as resource and the user,
+// ip, user agent, method and status taken from their extensions.
+func ToV1(e *Event) (*v1.Event, error) {
+	data, err := e.DataBytes()
+	if err != nil {
+		return nil, err
+	}
+	if e.Type == TypeAuditEvent {
+		event, err := v2.DecodeV1(data)
+		if err != nil {
+			return nil, err
+		}
+		if event.RequestId == "" {
+			event.RequestId = requestID(e)
+		}
+		if event.EventTime == 0 {
+			event.EventTime = unix(e.EventTime())
+		}
+		return event, nil
+	}
+
+	event := &v1.Event{
+		EventTime:         unix(e.EventTime()),
+		EventName:         "[" + e.Source + "] " + e.Type,
+		RequestId:         requestID(e),
+		RequestParameters: string(data),
+		SourceIpAddress:   e.Extensions[ExtensionSourceIP],
+		UserAgent:         e.Extensions[ExtensionUserAgent],
+		RequestMethod:     e.Extensions[ExtensionMethod],
+	}
+	if user := e.Extensions[ExtensionUser]; user != "" {
+		event.UserIdentity = &v1.UserIdentity{AccountId: user}
+	}
+	if status, err := strconv.Atoi(e.Extensions[ExtensionStatus]); err == nil {
+		event.ResponseStatus = status
+	}
+	if e.Subject != "" {
+		event.ResourceReports = []v1.Resource{{ResourceName: e.Subject}}
+	}
+	return event, nil
+}
+
+// FromV1 returns an audit event with the event in v2 as data, about its first
+// resource. Its id is random, as the stages of a request and the chunks of a
+// webconsole session share their request id, which goes in an extension.
+func FromV1(source string, e *v1.Event) (Event, error) {
+	subject := ""
+	if len(e.ResourceReports) > 0 {
+		subject = e.ResourceReports[0].ResourceName
+	}
+	var t time.Time
+	if e.EventTime > 0 {
+		t = time.Unix(e.EventTime, 0)
+	}
+	event, err := New(source, TypeAuditEvent, subject, t, v2.FromV1(e))
+	if err != nil {
+		return event, err
+	}
+	if e.RequestId != "" {
+		event.Extensions = map[string]string{ExtensionRequestID: e.RequestId}
+	}
+	return event, nil
+}
+
+// requestID returns the request id extension of an event, its id when it has none
+func requestID(e *Event) string {
+	if id := e.Extensions[ExtensionRequestID]; id != "" {
+		return id
+	}
+	return e.ID
+}
+
+func unix(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudevents
+
+import (
+	v1 "audit/pkg/backend/v1"
+	"encoding/json"
+	"testing"
+)
+
+func TestFromV1RequestID(t *testing.T) {
+	// the chunks of a webconsole session share their request id
+	e := &v1.Event{EventTime: 1700000000, EventName: "[Webconsole] stdout", RequestId: "session-1"}
+	first, err := FromV1("test", e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := FromV1("test", e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.ID == "" || first.ID == second.ID || first.ID == e.RequestId {
+		t.Errorf("ids = %q and %q, want distinct random ones", first.ID, second.ID)
+	}
+	if got := first.Extensions[ExtensionRequestID]; got != "session-1" {
+		t.Errorf("requestid extension = %q", got)
+	}
+	if err = first.Validate(); err != nil {
+		t.Errorf("event is invalid: %s", err)
+	}
+
+	// the extension survives the json of the event
+	bs, err := json.Marshal(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Event
+	if err = json.Unmarshal(bs, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	back, err := ToV1(&decoded)
+	if err != nil || back.RequestId != "session-1" || back.EventName != e.EventName {
+		t.Errorf("ToV1 = %+v, %v", back, err)
+	}
+
+	e.RequestId = ""
+	if event, err := FromV1("test", e); err != nil || event.Extensions != nil {
+		t.Errorf("event without a request id = %+v, %v", event, err)
+	}
+}
+
+func TestToV1RequestID(t *testing.T) {
+	tests := []struct {
+		name       string
+		extensions map[string]string
+		want       string
+	}{
+		{"id", nil, "event-1"},
+		{"extension", map[string]string{ExtensionRequestID: "request-1"}, "request-1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &Event{SpecVersion: SpecVersion, ID: "event-1", Source: "app", Type: "login", Extensions: tt.extensions}
+			got, err := ToV1(e)
+			if err != nil || got.RequestId != tt.want {
+				t.Errorf("ToV1 request id = %v, %v, want %s", got, err, tt.want)
+			}
+		})
+	}
+}
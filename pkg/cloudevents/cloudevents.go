@@ -0,0 +1,308 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudevents
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	SpecVersion = "1.0"
+
+	// ContentType is the structured mode of a single event, BatchContentType of a json array of events
+	ContentType      = "application/cloudevents+json"
+	BatchContentType = "application/cloudevents-batch+json"
+
+	// headerPrefix starts the headers of the attributes in binary mode
+	headerPrefix = "Ce-"
+	// maxBodySize is the largest request read, in bytes
+	maxBodySize = 10 << 20
+)
+
+var extensionRegexp = regexp.MustCompile(`^[a-z0-9]{1,20}$`)
+
+// knownAttributes are the attributes of the spec, the others are extensions
+var knownAttributes = map[string]bool{
+	"specversion": true, "id": true, "source": true, "type": true, "subject": true, "time": true,
+	"datacontenttype": true, "dataschema": true, "data": true, "data_base64": true,
+}
+
+// Event is a CloudEvent 1.0 in its json format
+type Event struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Subject         string          `json:"subject,omitempty"`
+	Time            string          `json:"time,omitempty"`
+	DataContentType string          `json:"datacontenttype,omitempty"`
+	DataSchema      string          `json:"dataschema,omitempty"`
+	Data            json.RawMessage `json:"data,omitempty"`
+	DataBase64      string          `json:"data_base64,omitempty"`
+	// Extensions are the other attributes, as strings
+	Extensions map[string]string `json:"-"`
+}
+
+// event has the fields of Event without its json methods
+type event Event
+
+func (e *Event) UnmarshalJSON(bs []byte) error {
+	var attributes map[string]json.RawMessage
+	if err := json.Unmarshal(bs, &attributes); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(bs, (*event)(e)); err != nil {
+		return err
+	}
+	for name, value := range attributes {
+		if knownAttributes[name] {
+			continue
+		}
+		if e.Extensions == nil {
+			e.Extensions = make(map[string]string)
+		}
+		var s string
+		if err := json.Unmarshal(value, &s); err != nil {
+			// numbers and booleans are kept as they are written
+			s = string(bytes.TrimSpace(value))
+		}
+		e.Extensions[name] = s
+	}
+	return nil
+}
+
+func (e Event) MarshalJSON() ([]byte, error) {
+	bs, err := json.Marshal(event(e))
+	if err != nil || len(e.Extensions) == 0 {
+		return bs, err
+	}
+	var attributes map[string]json.RawMessage
+	if err = json.Unmarshal(bs, &attributes); err != nil {
+		return nil, err
+	}
+	for name, value := range e.Extensions {
+		if knownAttributes[name] {
+			continue
+		}
+		if attributes[name], err = json.Marshal(value); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(attributes)
+}
+
+// New returns an event of a type with its data in json and a random id
+func New(source, eventType, subject string, t time.Time, data interface{}) (Event, error) {
+	bs, err := json.Marshal(data)
+	if err != nil {
+		return Event{}, err
+	}
+	id := make([]byte, 16)
+	if _, err = rand.Read(id); err != nil {
+		return Event{}, err
+	}
+	e := Event{
+		SpecVersion:     SpecVersion,
+		ID:              hex.EncodeToString(id),
+		Source:          source,
+		Type:            eventType,
+		Subject:         subject,
+		DataContentType: "application/json",
+		Data:            bs,
+	}
+	if !t.IsZero() {
+		e.Time = t.UTC().Format(time.RFC3339Nano)
+	}
+	return e, nil
+}
+
+// Validate checks the required attributes and the format of the others
+func (e *Event) Validate() error {
+	switch {
+	case e.SpecVersion != SpecVersion:
+		return fmt.Errorf("specversion %q is not %s", e.SpecVersion, SpecVersion)
+	case e.ID == "":
+		return errors.New("id is required")
+	case e.Source == "":
+		return errors.New("source is required")
+	case e.Type == "":
+		return errors.New("type is required")
+	case e.Data != nil && e.DataBase64 != "":
+		return errors.New("data and data_base64 are exclusive")
+	}
+	if e.Time != "" {
+		if _, err := time.Parse(time.RFC3339Nano, e.Time); err != nil {
+			return fmt.Errorf("time %q is not in RFC 3339", e.Time)
+		}
+	}
+	if e.DataBase64 != "" {
+		if _, err := base64.StdEncoding.DecodeString(e.DataBase64); err != nil {
+			return errors.New("data_base64 is not in base64")
+		}
+	}
+	for name := range e.Extensions {
+		if !extensionRegexp.MatchString(name) {
+			return fmt.Errorf("extension %q is not 1 to 20 lower case letters or digits", name)
+		}
+	}
+	return nil
+}
+
+// EventTime returns the time of the event, zero when it has none
+func (e *Event) EventTime() time.Time {
+	t, _ := time.Parse(time.RFC3339Nano, e.Time)
+	return t
+}
+
+// DataBytes returns the data of the event, json data as it is written and
+// other data as its bytes
+func (e *Event) DataBytes() ([]byte, error) {
+	if e.DataBase64 != "" {
+		return base64.StdEncoding.DecodeString(e.DataBase64)
+	}
+	data := bytes.TrimSpace(e.Data)
+	if len(data) > 0 && data[0] == '"' && !IsJSON(e.DataContentType) {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return nil, err
+		}
+		return []byte(s), nil
+	}
+	return data, nil
+}
+
+// IsJSON reports whether a content type is json, an empty one is taken for json
+func IsJSON(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || mediaType == "text/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// Read reads the events of a request in structured mode, single or batch,
+// or the event of a request in binary mode, and validates them
+func Read(r *http.Request) ([]Event, error) {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxBodySize {
+		return nil, fmt.Errorf("body is larger than %d bytes", maxBodySize)
+	}
+
+	var events []Event
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case ContentType:
+		var e Event
+		if err = json.Unmarshal(body, &e); err != nil {
+			return nil, err
+		}
+		events = []Event{e}
+	case BatchContentType:
+		if err = json.Unmarshal(body, &events); err != nil {
+			return nil, err
+		}
+	default:
+		e, err := readBinary(r.Header, body)
+		if err != nil {
+			return nil, err
+		}
+		events = []Event{*e}
+	}
+
+	for i := range events {
+		if err = events[i].Validate(); err != nil {
+			if len(events) > 1 {
+				return nil, fmt.Errorf("event %d: %s", i, err)
+			}
+			return nil, err
+		}
+	}
+	return events, nil
+}
+
+// readBinary reads an event whose attributes are in ce- headers and whose data is the body
+func readBinary(header http.Header, body []byte) (*Event, error) {
+	if header.Get(headerPrefix+"Specversion") == "" {
+		return nil, fmt.Errorf("content type is not %s or %s, and there is no ce-specversion header", ContentType, BatchContentType)
+	}
+	e := &Event{DataContentType: header.Get("Content-Type")}
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !strings.HasPrefix(name, headerPrefix) {
+			continue
+		}
+		// values are percent encoded outside of printable ascii
+		value, err := url.PathUnescape(header.Get(name))
+		if err != nil {
+			return nil, fmt.Errorf("header %s: %s", name, err)
+		}
+		switch attribute := strings.ToLower(strings.TrimPrefix(name, headerPrefix)); attribute {
+		case "specversion":
+			e.SpecVersion = value
+		case "id":
+			e.ID = value
+		case "source":
+			e.Source = value
+		case "type":
+			e.Type = value
+		case "subject":
+			e.Subject = value
+		case "time":
+			e.Time = value
+		case "dataschema":
+			e.DataSchema = value
+		default:
+			if e.Extensions == nil {
+				e.Extensions = make(map[string]string)
+			}
+			e.Extensions[attribute] = value
+		}
+	}
+	if len(body) > 0 {
+		if IsJSON(e.DataContentType) && json.Valid(body) {
+			e.Data = body
+		} else {
+			e.DataBase64 = base64.StdEncoding.EncodeToString(body)
+		}
+	}
+	return e, nil
+}
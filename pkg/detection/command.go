@@ -0,0 +1,388 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package detection
+
+import (
+	v1 "audit/pkg/backend/v1"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// CommandRule flags the webconsole commands matching its pattern or its
+// tokens, in the pods in its scope. Tokens match a command of the line,
+// pipelines and lists included, after sudo, env and the like, e.g.
+//
+//	{"Name": "webconsole-kubectl-delete", "Severity": "medium", "Commands": ["kubectl"], "Args": ["delete"]}
+type CommandRule struct {
+	// Name is the detection of the findings, a rule named after a built-in one replaces it
+	Name     string
+	Severity string
+	Disabled bool
+	// Pattern is a regular expression matched against the command line
+	Pattern string
+	// Commands are globs of the program run, without its directory
+	Commands []string
+	// Flags are the short flags all given to the command, e.g. rf for rm -r -f
+	Flags string
+	// Args are globs, one of which must match an argument of the command
+	Args []string
+	// Namespaces, Clusters and ContainerUsers are globs scoping the rule, any when empty
+	Namespaces     []string
+	Clusters       []string
+	ContainerUsers []string
+}
+
+// CommandContext is where a webconsole command was run
+type CommandContext struct {
+	User          string
+	Cluster       string
+	Namespace     string
+	Pod           string
+	ContainerUser string
+}
+
+// defaultCommandRules are the built-in rules
+var defaultCommandRules = []CommandRule{
+	{
+		Name:     "webconsole-destructive-delete",
+		Severity: SeverityCritical,
+		Commands: []string{"rm"},
+		Flags:    "rf",
+		Args:     []string{"/", "/*", "~", "~/", "~/*", "/bin", "/boot", "/etc", "/home", "/lib", "/usr", "/var", "--no-preserve-root"},
+	},
+	{
+		Name:     "webconsole-disk-wipe",
+		Severity: SeverityCritical,
+		Pattern:  `(^|[\s;&|/])(mkfs(\.\w+)?|wipefs|shred)\s|(^|[\s;&|/])dd\s.*\bof=/dev/`,
+	},
+	{
+		Name:     "webconsole-pipe-to-shell",
+		Severity: SeverityHigh,
+		Pattern:  `\b(curl|wget|fetch)\b[^|]*\|\s*(sudo\s+)?(\S*/)?(ba|da|z|k|a)?sh\b`,
+	},
+	{
+		Name:     "webconsole-reverse-shell",
+		Severity: SeverityHigh,
+		Pattern:  `/dev/(tcp|udp)/|\b(nc|ncat|netcat)\b.*\s-[a-z]*[ec]\s|\bsocat\b.*\bexec:`,
+	},
+	{
+		Name:     "webconsole-service-account-token",
+		Severity: SeverityHigh,
+		Pattern:  `/var/run/secrets|/run/secrets/kubernetes\.io`,
+	},
+	{
+		Name:       "webconsole-package-install",
+		Severity:   SeverityMedium,
+		Commands:   []string{"apt", "apt-get", "yum", "dnf", "microdnf", "zypper", "apk", "pip", "pip3", "npm", "gem"},
+		Args:       []string{"install", "add"},
+		Namespaces: []string{"prod", "prod-*", "*-prod", "production", "production-*", "*-production"},
+	},
+}
+
+// commandWrappers run the command in their arguments, with the options taking a value
+var commandWrappers = map[string][]string{
+	"sudo":    {"-u", "-g", "-C", "-h", "-p", "-r", "-t", "-U"},
+	"doas":    {"-u", "-C"},
+	"env":     {"-u", "-C", "-S"},
+	"nohup":   nil,
+	"time":    nil,
+	"exec":    {"-a"},
+	"nice":    {"-n"},
+	"timeout": {"-s", "-k"},
+	"busybox": nil,
+	"command": nil,
+	"xargs":   {"-I", "-n", "-P", "-d", "-L", "-s", "-E"},
+}
+
+var commandRules = struct {
+	sync.RWMutex
+	rules []*compiledCommandRule
+}{rules: mustCompileCommandRules(defaultCommandRules)}
+
+type compiledCommandRule struct {
+	CommandRule
+	pattern *regexp.Regexp
+}
+
+// LoadCommandRules replaces and adds to the built-in rules with those of a
+// json file, the built-in rules alone are used when the path is empty
+func LoadCommandRules(file string) error {
+	rules := append([]CommandRule{}, defaultCommandRules...)
+	if file != "" {
+		bs, err := ioutil.ReadFile(file)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err == nil {
+			var configured []CommandRule
+			if err = json.Unmarshal(bs, &configured); err != nil {
+				return err
+			}
+			rules = mergeCommandRules(rules, configured)
+		}
+	}
+	compiled, err := compileCommandRules(rules)
+	if err != nil {
+		return err
+	}
+	commandRules.Lock()
+	commandRules.rules = compiled
+	commandRules.Unlock()
+	return nil
+}
+
+func mergeCommandRules(rules, configured []CommandRule) []CommandRule {
+	for _, c := range configured {
+		replaced := false
+		for i := range rules {
+			if rules[i].Name == c.Name {
+				rules[i], replaced = c, true
+				break
+			}
+		}
+		if !replaced {
+			rules = append(rules, c)
+		}
+	}
+	return rules
+}
+
+func compileCommandRules(rules []CommandRule) ([]*compiledCommandRule, error) {
+	var compiled []*compiledCommandRule
+	for _, rule := range rules {
+		if rule.Disabled {
+			continue
+		}
+		if rule.Name == "" {
+			return nil, fmt.Errorf("command rule name is required")
+		}
+		switch rule.Severity {
+		case SeverityLow, SeverityMedium, SeverityHigh, SeverityCritical:
+		default:
+			return nil, fmt.Errorf("command rule %s: severity %q is not low, medium, high or critical", rule.Name, rule.Severity)
+		}
+		if rule.Pattern == "" && len(rule.Commands) == 0 {
+			return nil, fmt.Errorf("command rule %s: a pattern or commands are required", rule.Name)
+		}
+		r := &compiledCommandRule{CommandRule: rule}
+		if rule.Pattern != "" {
+			pattern, err := regexp.Compile(rule.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("command rule %s: %s", rule.Name, err)
+			}
+			r.pattern = pattern
+		}
+		for _, globs := range [][]string{rule.Commands, rule.Args, rule.Namespaces, rule.Clusters, rule.ContainerUsers} {
+			for _, glob := range globs {
+				if _, err := path.Match(glob, ""); err != nil {
+					return nil, fmt.Errorf("command rule %s: pattern %q: %s", rule.Name, glob, err)
+				}
+			}
+		}
+		compiled = append(compiled, r)
+	}
+	return compiled, nil
+}
+
+func mustCompileCommandRules(rules []CommandRule) []*compiledCommandRule {
+	compiled, err := compileCommandRules(rules)
+	if err != nil {
+		panic(err)
+	}
+	return compiled
+}
+
+// DetectCommand returns the findings of a command run in a webconsole
+func DetectCommand(ctx CommandContext, line string) []v1.Finding {
+	commandRules.RLock()
+	rules := commandRules.rules
+	commandRules.RUnlock()
+
+	var findings []v1.Finding
+	var commands [][]string
+	for _, r := range rules {
+		if !r.inScope(ctx) {
+			continue
+		}
+		matched := r.pattern != nil && r.pattern.MatchString(line)
+		if !matched && len(r.Commands) > 0 {
+			if commands == nil {
+				commands = splitCommands(line)
+			}
+			for _, command := range commands {
+				if r.matchTokens(command) {
+					matched = true
+					break
+				}
+			}
+		}
+		if matched {
+			// the command line is left out, it is in the request parameters which can be encrypted
+			findings = append(findings, v1.Finding{
+				Detection: r.Name,
+				Severity:  r.Severity,
+				Message:   fmt.Sprintf("%s runs a command in pod %s/%s as %s", ctx.User, ctx.Namespace, ctx.Pod, ctx.ContainerUser),
+			})
+		}
+	}
+	return findings
+}
+
+func (r *compiledCommandRule) inScope(ctx CommandContext) bool {
+	return matchAny(r.Namespaces, ctx.Namespace) && matchAny(r.Clusters, ctx.Cluster) && matchAny(r.ContainerUsers, ctx.ContainerUser)
+}
+
+// matchTokens reports whether the tokens of a command match the rule
+func (r *compiledCommandRule) matchTokens(command []string) bool {
+	if !matchAny(r.Commands, path.Base(command[0])) {
+		return false
+	}
+	args := command[1:]
+	for _, flag := range r.Flags {
+		if !hasFlag(args, flag) {
+			return false
+		}
+	}
+	if len(r.Args) == 0 {
+		return true
+	}
+	for _, arg := range args {
+		for _, glob := range r.Args {
+			if matched, _ := path.Match(glob, arg); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hasFlag reports whether a short flag is given, alone or grouped like -rf
+func hasFlag(args []string, flag rune) bool {
+	for _, arg := range args {
+		if arg == "--" {
+			return false
+		}
+		if len(arg) > 1 && arg[0] == '-' && arg[1] != '-' && strings.ContainsRune(arg[1:], flag) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchAny reports whether a value matches one of the globs, or there are none
+func matchAny(globs []string, value string) bool {
+	if len(globs) == 0 {
+		return true
+	}
+	for _, glob := range globs {
+		if matched, _ := path.Match(glob, value); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// splitCommands splits a command line in the commands it runs and their
+// arguments, following the quotes of the shell. Commands are separated by
+// pipes, lists and newlines, and start after variable assignments and wrappers
+// like sudo.
+func splitCommands(line string) [][]string {
+	var commands [][]string
+	var tokens []string
+	var token strings.Builder
+	inToken := false
+	var quote rune
+	escaped := false
+
+	endToken := func() {
+		if inToken {
+			tokens = append(tokens, token.String())
+			token.Reset()
+			inToken = false
+		}
+	}
+	endCommand := func() {
+		endToken()
+		if command := unwrapCommand(tokens); len(command) > 0 {
+			commands = append(commands, command)
+		}
+		tokens = nil
+	}
+
+	for _, r := range line {
+		switch {
+		case escaped:
+			token.WriteRune(r)
+			escaped = false
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else if r == '\\' && quote == '"' {
+				escaped = true
+			} else {
+				token.WriteRune(r)
+			}
+		case r == '\\':
+			escaped, inToken = true, true
+		case r == '\'' || r == '"':
+			quote, inToken = r, true
+		case r == '|' || r == ';' || r == '&' || r == '\n' || r == '(' || r == ')' || r == '`':
+			endCommand()
+		case r == ' ' || r == '\t':
+			endToken()
+		default:
+			token.WriteRune(r)
+			inToken = true
+		}
+	}
+	endCommand()
+	return commands
+}
+
+// unwrapCommand skips the variable assignments and the wrappers before a command
+func unwrapCommand(tokens []string) []string {
+	for len(tokens) > 0 {
+		first := tokens[0]
+		if i := strings.IndexByte(first, '='); i > 0 && !strings.ContainsAny(first[:i], "/-") {
+			tokens = tokens[1:]
+			continue
+		}
+		options, ok := commandWrappers[path.Base(first)]
+		if !ok {
+			return tokens
+		}
+		tokens = tokens[1:]
+		for len(tokens) > 0 && strings.HasPrefix(tokens[0], "-") {
+			withValue := contains(options, tokens[0])
+			tokens = tokens[1:]
+			if withValue && len(tokens) > 0 {
+				tokens = tokens[1:]
+			}
+		}
+		// the duration is the first argument of timeout
+		if path.Base(first) == "timeout" && len(tokens) > 0 {
+			tokens = tokens[1:]
+		}
+	}
+	return tokens
+}
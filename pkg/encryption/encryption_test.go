@@ -0,0 +1,236 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package encryption
+
+import (
+	v1 "audit/pkg/backend/v1"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeKey writes a key of the given byte to the key directory
+func writeKey(t *testing.T, dir, keyID string, b byte) {
+	if err := ioutil.WriteFile(filepath.Join(dir, keyID), bytes.Repeat([]byte{b}, keySize), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// useEncryptor encrypts the fields with the provider for the duration of a test
+func useEncryptor(t *testing.T, provider KeyProvider, encrypted ...string) {
+	e := &Encryptor{provider: provider, fields: make(map[string]bool)}
+	for _, field := range encrypted {
+		e.fields[field] = true
+	}
+	previous := encryptor
+	encryptor = e
+	t.Cleanup(func() { encryptor = previous })
+}
+
+// newTestProvider returns a file provider reading the keys of a new directory
+func newTestProvider(t *testing.T, keyIDs ...string) (*fileProvider, string) {
+	dir := t.TempDir()
+	for i, keyID := range keyIDs {
+		writeKey(t, dir, keyID, byte(i+1))
+	}
+	p := &fileProvider{dir: dir}
+	if err := p.load(); err != nil {
+		t.Fatal(err)
+	}
+	return p, dir
+}
+
+func TestEventRoundTrip(t *testing.T) {
+	p, _ := newTestProvider(t, "k1")
+	useEncryptor(t, p, FieldRequestParameters, FieldResponseElements)
+
+	original := v1.Event{
+		EventName:         "create pods",
+		Description:       "create a pod",
+		RequestParameters: `{"password":"secret"}`,
+		ResponseElements:  `{"kind":"Pod"}`,
+	}
+	e := original
+	Event(&e)
+
+	tests := []struct {
+		field     string
+		value     string
+		encrypted bool
+	}{
+		{FieldRequestParameters, e.RequestParameters, true},
+		{FieldResponseElements, e.ResponseElements, true},
+		{FieldEventName, e.EventName, false},
+		{FieldDescription, e.Description, false},
+	}
+	for _, tt := range tests {
+		if encrypted := strings.HasPrefix(tt.value, prefix+"k1:"); encrypted != tt.encrypted {
+			t.Errorf("%s = %q, encrypted %v, want %v", tt.field, tt.value, encrypted, tt.encrypted)
+		}
+	}
+	if strings.Contains(e.RequestParameters, "secret") {
+		t.Errorf("request parameters are in clear: %s", e.RequestParameters)
+	}
+
+	DecryptEvent(&e)
+	if e.RequestParameters != original.RequestParameters || e.ResponseElements != original.ResponseElements ||
+		e.EventName != original.EventName || e.Description != original.Description {
+		t.Errorf("decrypted event = %+v, want %+v", e, original)
+	}
+
+	empty := v1.Event{}
+	Event(&empty)
+	if empty.RequestParameters != "" {
+		t.Errorf("empty request parameters encrypted to %q", empty.RequestParameters)
+	}
+}
+
+func TestRotatedKey(t *testing.T) {
+	p, dir := newTestProvider(t, "k1")
+	useEncryptor(t, p, FieldRequestParameters)
+
+	old := Encrypt(FieldRequestParameters, "before")
+	writeKey(t, dir, "k2", 2)
+	if err := p.load(); err != nil {
+		t.Fatal(err)
+	}
+	rotated := Encrypt(FieldRequestParameters, "after")
+
+	tests := []struct {
+		value string
+		keyID string
+		want  string
+	}{
+		{old, "k1", "before"},
+		{rotated, "k2", "after"},
+	}
+	for _, tt := range tests {
+		if !strings.HasPrefix(tt.value, prefix+tt.keyID+":") {
+			t.Errorf("%q is not encrypted with key %s", tt.value, tt.keyID)
+		}
+		plain, err := Decrypt(FieldRequestParameters, tt.value)
+		if err != nil || plain != tt.want {
+			t.Errorf("decrypt with key %s = %q, %v, want %q", tt.keyID, plain, err, tt.want)
+		}
+	}
+
+	// once the previous key is removed, the values it encrypted can not be read
+	if err := os.Remove(filepath.Join(dir, "k1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.load(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Decrypt(FieldRequestParameters, old); err == nil || !strings.Contains(err.Error(), ErrUnknownKey.Error()) {
+		t.Errorf("decrypt with a removed key = %v, want %v", err, ErrUnknownKey)
+	}
+}
+
+func TestDecryptOtherField(t *testing.T) {
+	p, _ := newTestProvider(t, "k1")
+	useEncryptor(t, p, FieldRequestParameters, FieldResponseElements)
+	value := Encrypt(FieldRequestParameters, "secret")
+
+	tests := []struct {
+		name    string
+		field   string
+		value   string
+		wantErr bool
+	}{
+		{"same field", FieldRequestParameters, value, false},
+		{"moved to another field", FieldResponseElements, value, true},
+		{"tampered", FieldRequestParameters, value[:len(value)-4] + "AAA=", true},
+		{"malformed", FieldRequestParameters, prefix + "k1", true},
+		{"clear value", FieldResponseElements, "secret", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plain, err := Decrypt(tt.field, tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && plain != "secret" {
+				t.Errorf("decrypted %q", plain)
+			}
+		})
+	}
+
+	// a value moved to another field is left encrypted
+	e := v1.Event{ResponseElements: value}
+	DecryptEvent(&e)
+	if e.ResponseElements != value {
+		t.Errorf("value moved to another field decrypted to %q", e.ResponseElements)
+	}
+}
+
+// failingProvider fails like a KMS which can not be reached
+type failingProvider struct{}
+
+func (failingProvider) ActiveKey() (string, error) {
+	return "", errors.New("unreachable")
+}
+
+func (failingProvider) WrapKey(keyID string, dataKey []byte) ([]byte, error) {
+	return nil, errors.New("unreachable")
+}
+
+func (failingProvider) UnwrapKey(keyID string, wrapped []byte) ([]byte, error) {
+	return nil, errors.New("unreachable")
+}
+
+func TestProviderFailure(t *testing.T) {
+	p, _ := newTestProvider(t, "k1")
+	useEncryptor(t, p, FieldRequestParameters)
+	encrypted := Encrypt(FieldRequestParameters, "secret")
+
+	tests := []struct {
+		name     string
+		provider KeyProvider
+		wantErr  error
+	}{
+		{"no provider", nil, errNoProvider},
+		{"failing provider", failingProvider{}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useEncryptor(t, tt.provider, FieldRequestParameters)
+
+			e := v1.Event{RequestParameters: "secret", ResponseElements: "clear"}
+			Event(&e)
+			if e.RequestParameters != Unavailable || e.ResponseElements != "clear" {
+				t.Errorf("event = %+v, want the request parameters %s", e, Unavailable)
+			}
+			if value := Encrypt(FieldRequestParameters, "secret"); value != Unavailable {
+				t.Errorf("encrypt = %q, want %s", value, Unavailable)
+			}
+
+			_, err := Decrypt(FieldRequestParameters, encrypted)
+			if err == nil || tt.wantErr != nil && err != tt.wantErr {
+				t.Errorf("decrypt error = %v, want %v", err, tt.wantErr)
+			}
+			e = v1.Event{RequestParameters: encrypted}
+			DecryptEvent(&e)
+			if e.RequestParameters != encrypted {
+				t.Errorf("value decrypted to %q without a provider", e.RequestParameters)
+			}
+		})
+	}
+}
@@ -0,0 +1,221 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package encryption
+
+import (
+	"audit/pkg/utils/env"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/saashqdev/kubeworkz/pkg/clog"
+)
+
+const (
+	ProviderFile = "file"
+
+	keySize           = 32
+	keyReloadInterval = time.Minute
+)
+
+var (
+	ErrUnknownKey = errors.New("unknown key")
+
+	keyIDRegexp = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+)
+
+// KeyProvider wraps the data keys of the events with key encryption keys it
+// keeps, like a KMS. Keys are rotated by making another key active, the
+// previous ones must still unwrap the data keys they wrapped.
+type KeyProvider interface {
+	// ActiveKey returns the id of the key new data keys are wrapped with
+	ActiveKey() (string, error)
+	WrapKey(keyID string, dataKey []byte) ([]byte, error)
+	UnwrapKey(keyID string, wrapped []byte) ([]byte, error)
+}
+
+// providerTypes are the key providers by name, others can be registered
+var providerTypes = map[string]func() (KeyProvider, error){
+	ProviderFile: newFileProvider,
+}
+
+// RegisterProvider adds a key provider, to be selected by AUDIT_ENCRYPTION_PROVIDER
+func RegisterProvider(name string, factory func() (KeyProvider, error)) {
+	providerTypes[name] = factory
+}
+
+// fileProvider reads the key encryption keys from the files of a directory,
+// like a mounted Secret, each file holding a 32 bytes key, raw or in base64,
+// under its key id. It reloads them so that keys can be added to the Secret.
+type fileProvider struct {
+	dir    string
+	active string
+
+	mu   sync.RWMutex
+	keys map[string][]byte
+}
+
+func newFileProvider() (KeyProvider, error) {
+	p := &fileProvider{dir: env.EncryptionKeyPath(), active: env.EncryptionActiveKey()}
+	if err := p.load(); err != nil {
+		return nil, err
+	}
+	if _, err := p.ActiveKey(); err != nil {
+		return nil, err
+	}
+	go p.reload()
+	return p, nil
+}
+
+func (p *fileProvider) load() error {
+	files, err := ioutil.ReadDir(p.dir)
+	if err != nil {
+		return err
+	}
+	keys := make(map[string][]byte)
+	for _, f := range files {
+		// the entries of a mounted Secret are links, its hidden directories hold the data
+		if f.IsDir() || strings.HasPrefix(f.Name(), ".") || !keyIDRegexp.MatchString(f.Name()) {
+			continue
+		}
+		bs, err := ioutil.ReadFile(filepath.Join(p.dir, f.Name()))
+		if err != nil {
+			return err
+		}
+		key, err := parseKey(bs)
+		if err != nil {
+			return fmt.Errorf("key %s: %s", f.Name(), err)
+		}
+		keys[f.Name()] = key
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("no key in %s", p.dir)
+	}
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *fileProvider) reload() {
+	ticker := time.NewTicker(keyReloadInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := p.load(); err != nil {
+			clog.Error("reload encryption keys from %s error: %s", p.dir, err)
+		}
+	}
+}
+
+// parseKey reads a raw key, or a key in base64 like openssl rand -base64 32 writes
+func parseKey(bs []byte) ([]byte, error) {
+	if len(bs) == keySize {
+		return bs, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(bs)))
+	if err != nil || len(key) != keySize {
+		return nil, fmt.Errorf("not a %d bytes key", keySize)
+	}
+	return key, nil
+}
+
+// ActiveKey returns the configured key, or the last key id in sort order
+func (p *fileProvider) ActiveKey() (string, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.active != "" {
+		if _, ok := p.keys[p.active]; !ok {
+			return "", fmt.Errorf("active key %s: %s", p.active, ErrUnknownKey)
+		}
+		return p.active, nil
+	}
+	ids := make([]string, 0, len(p.keys))
+	for id := range p.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids[len(ids)-1], nil
+}
+
+func (p *fileProvider) key(keyID string) ([]byte, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	key, ok := p.keys[keyID]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return key, nil
+}
+
+func (p *fileProvider) WrapKey(keyID string, dataKey []byte) ([]byte, error) {
+	key, err := p.key(keyID)
+	if err != nil {
+		return nil, err
+	}
+	return seal(key, dataKey, []byte(keyID))
+}
+
+func (p *fileProvider) UnwrapKey(keyID string, wrapped []byte) ([]byte, error) {
+	key, err := p.key(keyID)
+	if err != nil {
+		return nil, err
+	}
+	return open(key, wrapped, []byte(keyID))
+}
+
+// seal encrypts with AES-GCM and returns the nonce followed by the ciphertext
+func seal(key, plaintext, additional []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additional), nil
+}
+
+func open(key, sealed, additional []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], additional)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
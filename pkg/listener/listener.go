@@ -78,7 +78,7 @@ func Listener() {
 		}
 		components := hotplug.Spec.Component
 		if hotplug.Spec.Component == nil {
-			backend.SendElasticSearch = false
+			backend.StoreEnabled = false
 			return
 		}
 		for _, component := range components {
@@ -86,7 +86,7 @@ func Listener() {
 				if component.Status == hotPlugComponentEnabled {
 					auditEnable = true
 				} else {
-					backend.SendElasticSearch = false
+					backend.StoreEnabled = false
 					return
 				}
 			}
@@ -101,8 +101,10 @@ func Listener() {
 		} else if internalElasticSearchEnable {
 			elasticSearchEnable = true
 		}
-		if auditEnable && elasticSearchEnable {
-			backend.SendElasticSearch = true
+		// the local and postgres stores need no elasticsearch
+		storeEnable := elasticSearchEnable || env.StoreType() == env.StoreLocal || env.StoreType() == env.StorePostgres
+		if auditEnable && storeEnable {
+			backend.StoreEnabled = true
 		}
 	}
 
@@ -0,0 +1,215 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package otlp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+// the json encoding of OTLP, int64 and fixed64 numbers may be strings and ids are in hex
+type jsonRequest struct {
+	ResourceLogs []struct {
+		Resource struct {
+			Attributes []jsonKeyValue `json:"attributes"`
+		} `json:"resource"`
+		ScopeLogs []jsonScopeLogs `json:"scopeLogs"`
+		// InstrumentationLibraryLogs are the scope logs of exporters before OTLP 0.19
+		InstrumentationLibraryLogs []jsonScopeLogs `json:"instrumentationLibraryLogs"`
+	} `json:"resourceLogs"`
+}
+
+type jsonScopeLogs struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	InstrumentationLibrary struct {
+		Name string `json:"name"`
+	} `json:"instrumentationLibrary"`
+	LogRecords []jsonLogRecord `json:"logRecords"`
+}
+
+type jsonLogRecord struct {
+	TimeUnixNano         jsonNumber     `json:"timeUnixNano"`
+	ObservedTimeUnixNano jsonNumber     `json:"observedTimeUnixNano"`
+	SeverityNumber       int32          `json:"severityNumber"`
+	SeverityText         string         `json:"severityText"`
+	EventName            string         `json:"eventName"`
+	Body                 *jsonAnyValue  `json:"body"`
+	Attributes           []jsonKeyValue `json:"attributes"`
+	TraceID              string         `json:"traceId"`
+	SpanID               string         `json:"spanId"`
+}
+
+type jsonKeyValue struct {
+	Key   string       `json:"key"`
+	Value jsonAnyValue `json:"value"`
+}
+
+type jsonAnyValue struct {
+	StringValue *string     `json:"stringValue"`
+	BoolValue   *bool       `json:"boolValue"`
+	IntValue    *jsonNumber `json:"intValue"`
+	DoubleValue *float64    `json:"doubleValue"`
+	ArrayValue  *struct {
+		Values []jsonAnyValue `json:"values"`
+	} `json:"arrayValue"`
+	KvlistValue *struct {
+		Values []jsonKeyValue `json:"values"`
+	} `json:"kvlistValue"`
+	BytesValue *string `json:"bytesValue"`
+}
+
+// jsonNumber is a 64 bits integer written as a number or a string
+type jsonNumber int64
+
+func (n *jsonNumber) UnmarshalJSON(bs []byte) error {
+	s := string(bytes.Trim(bs, `"`))
+	if s == "" || s == "null" {
+		*n = 0
+		return nil
+	}
+	if v, err := strconv.ParseInt(s, 10, 64); err == nil {
+		*n = jsonNumber(v)
+		return nil
+	}
+	// fixed64 times past 2262 do not fit an int64
+	v, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return err
+	}
+	*n = jsonNumber(v)
+	return nil
+}
+
+func decodeJSON(body []byte) ([]LogRecord, error) {
+	var req jsonRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	var records []LogRecord
+	for _, rl := range req.ResourceLogs {
+		var resource map[string]interface{}
+		if len(rl.Resource.Attributes) > 0 {
+			resource = make(map[string]interface{})
+			if err := jsonAttributes(rl.Resource.Attributes, resource, 0); err != nil {
+				return nil, err
+			}
+		}
+		for _, sl := range append(rl.ScopeLogs, rl.InstrumentationLibraryLogs...) {
+			scope := sl.Scope.Name
+			if scope == "" {
+				scope = sl.InstrumentationLibrary.Name
+			}
+			for _, lr := range sl.LogRecords {
+				r, err := lr.record()
+				if err != nil {
+					return nil, err
+				}
+				r.Scope = scope
+				r.ResourceAttributes = resource
+				records = append(records, r)
+			}
+		}
+	}
+	return records, nil
+}
+
+func (lr *jsonLogRecord) record() (LogRecord, error) {
+	r := LogRecord{
+		Time:           unixNano(uint64(lr.TimeUnixNano)),
+		SeverityNumber: lr.SeverityNumber,
+		SeverityText:   lr.SeverityText,
+		EventName:      lr.EventName,
+	}
+	if r.Time.IsZero() {
+		r.Time = unixNano(uint64(lr.ObservedTimeUnixNano))
+	}
+	if lr.Body != nil {
+		v, err := lr.Body.value(0)
+		if err != nil {
+			return r, err
+		}
+		r.Body = v
+	}
+	if len(lr.Attributes) > 0 {
+		r.Attributes = make(map[string]interface{})
+		if err := jsonAttributes(lr.Attributes, r.Attributes, 0); err != nil {
+			return r, err
+		}
+	}
+	for _, id := range []struct {
+		value string
+		into  *string
+	}{{lr.TraceID, &r.TraceID}, {lr.SpanID, &r.SpanID}} {
+		bs, err := hex.DecodeString(id.value)
+		if err != nil {
+			return r, errors.New("trace and span ids are not in hex")
+		}
+		*id.into = hexID(bs)
+	}
+	return r, nil
+}
+
+func jsonAttributes(kvs []jsonKeyValue, into map[string]interface{}, depth int) error {
+	for _, kv := range kvs {
+		v, err := kv.Value.value(depth)
+		if err != nil {
+			return err
+		}
+		if kv.Key != "" {
+			into[kv.Key] = v
+		}
+	}
+	return nil
+}
+
+func (v *jsonAnyValue) value(depth int) (interface{}, error) {
+	if depth > maxDepth {
+		return nil, errors.New("attribute values are nested too deep")
+	}
+	switch {
+	case v.StringValue != nil:
+		return *v.StringValue, nil
+	case v.BoolValue != nil:
+		return *v.BoolValue, nil
+	case v.IntValue != nil:
+		return int64(*v.IntValue), nil
+	case v.DoubleValue != nil:
+		return *v.DoubleValue, nil
+	case v.BytesValue != nil:
+		return base64.StdEncoding.DecodeString(*v.BytesValue)
+	case v.ArrayValue != nil:
+		values := []interface{}{}
+		for i := range v.ArrayValue.Values {
+			value, err := v.ArrayValue.Values[i].value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		return values, nil
+	case v.KvlistValue != nil:
+		values := map[string]interface{}{}
+		return values, jsonAttributes(v.KvlistValue.Values, values, depth+1)
+	}
+	return nil, nil
+}
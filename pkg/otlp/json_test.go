@@ -0,0 +1,141 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package otlp
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testJSON is the request of testRequest in json, numbers written both ways
+const testJSON = `{
+  "resourceLogs": [
+    {
+      "resource": {"attributes": [
+        {"key": "service.name", "value": {"stringValue": "billing"}},
+        {"key": "host.name", "value": {"stringValue": "h1"}}
+      ]},
+      "scopeLogs": [{
+        "scope": {"name": "audit-lib"},
+        "logRecords": [
+          {
+            "timeUnixNano": "1700000000123456789",
+            "severityNumber": 9,
+            "severityText": "INFO",
+            "eventName": "invoice.delete",
+            "body": {"stringValue": "user deleted an invoice\nwith details"},
+            "attributes": [
+              {"key": "enduser.id", "value": {"stringValue": "alice"}},
+              {"key": "client.address", "value": {"stringValue": "10.0.0.1"}},
+              {"key": "http.response.status_code", "value": {"intValue": "403"}},
+              {"key": "http.request.method", "value": {"stringValue": "DELETE"}},
+              {"key": "url.path", "value": {"stringValue": "/invoices/42"}},
+              {"key": "request", "value": {"kvlistValue": {"values": [
+                {"key": "id", "value": {"intValue": 42}},
+                {"key": "tags", "value": {"arrayValue": {"values": [
+                  {"stringValue": "a"}, {"boolValue": true}, {"doubleValue": 1.5}
+                ]}}}
+              ]}}},
+              {"key": "blob", "value": {"bytesValue": "AQI="}}
+            ],
+            "traceId": "5b8efff798038103d269b633813fc60c",
+            "spanId": "eee19b7ec3c1b174",
+            "droppedAttributesCount": 0
+          },
+          {
+            "observedTimeUnixNano": 1700000001000000000,
+            "severityText": "WARN",
+            "body": {"stringValue": "disk almost full\nsecond line"},
+            "traceId": "00000000000000000000000000000000"
+          }
+        ]
+      }]
+    },
+    {
+      "resource": {"attributes": [{"key": "service.name", "value": {"stringValue": "legacy"}}]},
+      "instrumentationLibraryLogs": [{
+        "instrumentationLibrary": {"name": "old-lib"},
+        "logRecords": [{"timeUnixNano": "1700000002000000000", "severityText": "ERROR"}]
+      }]
+    }
+  ]
+}`
+
+func TestDecodeJSON(t *testing.T) {
+	got, err := Decode("application/json; charset=utf-8", []byte(testJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := testRecords(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Decode =\n%#v\nwant\n%#v", got, want)
+	}
+}
+
+func TestDecodeJSONMatchesProtobuf(t *testing.T) {
+	fromJSON, err := Decode(ContentTypeJSON, []byte(testJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromProtobuf, err := Decode(ContentTypeProtobuf, testRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range fromJSON {
+		if !reflect.DeepEqual(ToV1(&fromJSON[i]), ToV1(&fromProtobuf[i])) {
+			t.Errorf("record %d maps to\n%+v\nfrom json and\n%+v\nfrom protobuf", i, ToV1(&fromJSON[i]), ToV1(&fromProtobuf[i]))
+		}
+	}
+}
+
+func TestDecodeJSONMalformed(t *testing.T) {
+	deep := `{"stringValue": "leaf"}`
+	for i := 0; i <= maxDepth+1; i++ {
+		deep = `{"arrayValue": {"values": [` + deep + `]}}`
+	}
+	record := func(fields string) string {
+		return `{"resourceLogs": [{"scopeLogs": [{"logRecords": [{` + fields + `}]}]}]}`
+	}
+	tests := []struct {
+		name string
+		in   string
+	}{
+		{"not json", "resourceLogs"},
+		{"truncated", testJSON[:len(testJSON)/2]},
+		{"time not a number", record(`"timeUnixNano": "yesterday"`)},
+		{"int not a number", record(`"attributes": [{"key": "a", "value": {"intValue": "x"}}]`)},
+		{"trace id not hex", record(`"traceId": "not-hex"`)},
+		{"bytes not base64", record(`"attributes": [{"key": "a", "value": {"bytesValue": "!!"}}]`)},
+		{"nested too deep", record(`"attributes": [{"key": "a", "value": ` + deep + `}]`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Decode(ContentTypeJSON, []byte(tt.in)); err == nil {
+				t.Error("Decode succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestDecodeContentType(t *testing.T) {
+	if _, err := Decode("text/plain", []byte(testJSON)); err == nil || !strings.Contains(err.Error(), "text/plain") {
+		t.Errorf("Decode of text/plain error = %v", err)
+	}
+	if !IsProtobuf("application/x-protobuf; proto=x") || IsProtobuf(ContentTypeJSON) || !IsJSON("application/json") {
+		t.Error("content types are not recognized")
+	}
+}
@@ -0,0 +1,193 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package otlp
+
+import (
+	v1 "audit/pkg/backend/v1"
+	"audit/pkg/utils/env"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/saashqdev/kubeworkz/pkg/clog"
+)
+
+// FieldSource prefixes the event name like [source] name, as for the generic
+// audit logs, the other fields are those of v1.SetField
+const FieldSource = "Source"
+
+// Mapping names the attributes filling each field of the events, looked up in
+// order in the attributes of a log record, then in those of its resource
+type Mapping map[string][]string
+
+// defaultMapping follows the semantic conventions of OpenTelemetry
+var defaultMapping = Mapping{
+	FieldSource:             {"service.name"},
+	v1.FieldEventName:       {"event.name"},
+	v1.FieldUser:            {"enduser.id", "user.name", "user.id"},
+	v1.FieldSourceIpAddress: {"client.address", "source.address", "http.client_ip", "net.peer.ip"},
+	v1.FieldUserAgent:       {"user_agent.original", "http.user_agent"},
+	v1.FieldRequestMethod:   {"http.request.method", "http.method"},
+	v1.FieldResponseStatus:  {"http.response.status_code", "http.status_code"},
+	v1.FieldErrorCode:       {"error.type", "exception.type"},
+	v1.FieldErrorMessage:    {"exception.message"},
+	v1.FieldUrl:             {"url.full", "http.url", "url.path", "http.target"},
+}
+
+var mapping = struct {
+	sync.RWMutex
+	Mapping
+}{Mapping: defaultMapping}
+
+// Start loads the attribute mapping of AUDIT_OTLP_ATTRIBUTE_MAPPING_PATH on top of the default one
+func Start() {
+	file := env.OTLPAttributeMappingPath()
+	if file == "" {
+		return
+	}
+	if err := LoadMapping(file); err != nil {
+		clog.Error("load otlp attribute mapping from %s error: %s, the default mapping is used", file, err)
+	}
+}
+
+// LoadMapping replaces the attributes of the fields named in a json file, a
+// field mapped to no attribute is left empty
+func LoadMapping(file string) error {
+	bs, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var configured Mapping
+	if err = json.Unmarshal(bs, &configured); err != nil {
+		return err
+	}
+	m := Mapping{}
+	for field, keys := range defaultMapping {
+		m[field] = keys
+	}
+	for field, keys := range configured {
+		if field != FieldSource && !v1.IsField(field) {
+			return fmt.Errorf("field %s can not be mapped", field)
+		}
+		m[field] = keys
+	}
+	mapping.Lock()
+	mapping.Mapping = m
+	mapping.Unlock()
+	return nil
+}
+
+// ToV1 maps a log record onto an event. The event is named after its mapped
+// attribute, the event name of the record or its body, and prefixed with its
+// source. The body is the description and the attributes of the record the
+// request parameters, unless they are mapped, and the trace id is the request
+// id when none is.
+func ToV1(r *LogRecord) *v1.Event {
+	mapping.RLock()
+	m := mapping.Mapping
+	mapping.RUnlock()
+
+	e := &v1.Event{
+		Description:       text(r.Body),
+		RequestParameters: attributesText(r.Attributes),
+		RequestId:         r.TraceID,
+	}
+	if !r.Time.IsZero() {
+		e.EventTime = r.Time.Unix()
+	}
+	// fields in a fixed order, so that the resource reports are too
+	fields := make([]string, 0, len(m))
+	for field := range m {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		if value, ok := r.lookup(m[field]); ok && field != FieldSource {
+			v1.SetField(e, field, value)
+		}
+	}
+
+	if e.EventName == "" {
+		e.EventName = r.EventName
+	}
+	if e.EventName == "" {
+		e.EventName = firstLine(text(r.Body))
+	}
+	if e.EventName == "" {
+		e.EventName = r.SeverityText
+	}
+	if source, ok := r.lookup(m[FieldSource]); ok && source != "" {
+		e.EventName = "[" + source + "] " + e.EventName
+	}
+	return e
+}
+
+// lookup returns the text of the first attribute of the keys the record or its resource has
+func (r *LogRecord) lookup(keys []string) (string, bool) {
+	for _, key := range keys {
+		if v, ok := r.Attributes[key]; ok {
+			return text(v), true
+		}
+		if v, ok := r.ResourceAttributes[key]; ok {
+			return text(v), true
+		}
+	}
+	return "", false
+}
+
+// text returns a value as text, arrays and maps in json
+func text(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return base64.StdEncoding.EncodeToString(v)
+	case bool, int64, float64:
+		return fmt.Sprint(v)
+	default:
+		return jsonText(v)
+	}
+}
+
+func attributesText(attributes map[string]interface{}) string {
+	if len(attributes) == 0 {
+		return ""
+	}
+	return jsonText(attributes)
+}
+
+func jsonText(v interface{}) string {
+	bs, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(bs)
+}
+
+func firstLine(s string) string {
+	return strings.TrimSpace(strings.SplitN(s, "\n", 2)[0])
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package otlp
+
+import (
+	v1 "audit/pkg/backend/v1"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestToV1(t *testing.T) {
+	records := testRecords()
+	tests := []struct {
+		name   string
+		record *LogRecord
+		want   *v1.Event
+	}{
+		{
+			name:   "mapped attributes",
+			record: &records[0],
+			want: &v1.Event{
+				EventTime:       1700000000,
+				EventName:       "[billing] invoice.delete",
+				Description:     "user deleted an invoice\nwith details",
+				UserIdentity:    &v1.UserIdentity{AccountId: "alice"},
+				SourceIpAddress: "10.0.0.1",
+				RequestId:       "5b8efff798038103d269b633813fc60c",
+				RequestMethod:   "DELETE",
+				ResponseStatus:  403,
+				Url:             "/invoices/42",
+				RequestParameters: `{"blob":"AQI=","client.address":"10.0.0.1","enduser.id":"alice",` +
+					`"http.request.method":"DELETE","http.response.status_code":403,` +
+					`"request":{"id":42,"tags":["a",true,1.5]},"url.path":"/invoices/42"}`,
+			},
+		},
+		{
+			name:   "named after the first line of the body",
+			record: &records[1],
+			want: &v1.Event{
+				EventTime:   1700000001,
+				EventName:   "[billing] disk almost full",
+				Description: "disk almost full\nsecond line",
+			},
+		},
+		{
+			name:   "named after the severity",
+			record: &records[2],
+			want:   &v1.Event{EventTime: 1700000002, EventName: "[legacy] ERROR"},
+		},
+		{
+			name: "event name attribute and no source",
+			record: &LogRecord{
+				EventName:  "ignored",
+				Body:       map[string]interface{}{"k": "v"},
+				Attributes: map[string]interface{}{"event.name": "login", "http.status_code": "not a number"},
+			},
+			want: &v1.Event{
+				EventName:         "login",
+				Description:       `{"k":"v"}`,
+				RequestParameters: `{"event.name":"login","http.status_code":"not a number"}`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ToV1(tt.record); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ToV1 =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadMapping(t *testing.T) {
+	defer func() { mapping.Mapping = defaultMapping }()
+	file := filepath.Join(t.TempDir(), "mapping.json")
+
+	if err := LoadMapping(filepath.Join(t.TempDir(), "missing.json")); err != nil {
+		t.Errorf("LoadMapping of a missing file error: %v", err)
+	}
+	for _, invalid := range []string{`{"Nope": ["a"]}`, `{`, `{"User": "a"}`} {
+		if err := ioutil.WriteFile(file, []byte(invalid), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := LoadMapping(file); err == nil {
+			t.Errorf("LoadMapping(%s) succeeded, want an error", invalid)
+		}
+	}
+
+	mappingJSON := `{"User": ["app.user"], "ResourceName": ["app.invoice_id"], "Url": [], "Source": ["app.name"]}`
+	if err := ioutil.WriteFile(file, []byte(mappingJSON), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadMapping(file); err != nil {
+		t.Fatal(err)
+	}
+	e := ToV1(&LogRecord{
+		SeverityText: "INFO",
+		Attributes: map[string]interface{}{
+			"app.user":       "bob",
+			"enduser.id":     "alice",
+			"app.invoice_id": int64(42),
+			"url.full":       "https://x/y",
+			"client.address": "10.0.0.2",
+		},
+		ResourceAttributes: map[string]interface{}{"app.name": "shop", "service.name": "billing"},
+	})
+	if e.UserIdentity == nil || e.UserIdentity.AccountId != "bob" {
+		t.Errorf("user = %+v, want bob", e.UserIdentity)
+	}
+	if len(e.ResourceReports) != 1 || e.ResourceReports[0].ResourceName != "42" {
+		t.Errorf("resources = %+v, want 42", e.ResourceReports)
+	}
+	if e.Url != "" {
+		t.Errorf("url = %q, want none", e.Url)
+	}
+	if e.SourceIpAddress != "10.0.0.2" {
+		t.Errorf("source ip = %q, the default mapping is not kept", e.SourceIpAddress)
+	}
+	if e.EventName != "[shop] INFO" {
+		t.Errorf("event name = %q", e.EventName)
+	}
+}
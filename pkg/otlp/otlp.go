@@ -0,0 +1,109 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package otlp
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"time"
+)
+
+const (
+	ContentTypeProtobuf = "application/x-protobuf"
+	ContentTypeJSON     = "application/json"
+)
+
+// LogRecord is a log record of an OTLP export with the attributes of its resource.
+// Attribute values are string, bool, int64, float64, []byte, []interface{} or
+// map[string]interface{}, and so is the body.
+type LogRecord struct {
+	Time           time.Time
+	SeverityNumber int32
+	SeverityText   string
+	EventName      string
+	Body           interface{}
+	Attributes     map[string]interface{}
+	// TraceID and SpanID are in hex, empty when the record has none
+	TraceID string
+	SpanID  string
+	// Scope is the name of the instrumentation scope
+	Scope              string
+	ResourceAttributes map[string]interface{}
+}
+
+// IsProtobuf reports whether a content type is protobuf, the other one being json
+func IsProtobuf(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return mediaType == ContentTypeProtobuf
+}
+
+// IsJSON reports whether a content type is json
+func IsJSON(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return mediaType == ContentTypeJSON
+}
+
+// Decode reads the log records of an ExportLogsServiceRequest in protobuf or json
+func Decode(contentType string, body []byte) ([]LogRecord, error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case ContentTypeProtobuf:
+		return decodeProtobuf(body)
+	case ContentTypeJSON:
+		return decodeJSON(body)
+	default:
+		return nil, fmt.Errorf("content type %q is not %s or %s", contentType, ContentTypeProtobuf, ContentTypeJSON)
+	}
+}
+
+// Response returns an empty ExportLogsServiceResponse, all the records being accepted
+func Response(contentType string) []byte {
+	if IsProtobuf(contentType) {
+		return []byte{}
+	}
+	return []byte("{}")
+}
+
+// Status returns a google.rpc.Status telling why a request was refused
+func Status(contentType string, code int32, message string) []byte {
+	if IsProtobuf(contentType) {
+		return encodeStatus(code, message)
+	}
+	bs, _ := json.Marshal(map[string]interface{}{"code": code, "message": message})
+	return bs
+}
+
+// CodeInvalidArgument is the google.rpc.Code of the requests which can not be read
+const CodeInvalidArgument = 3
+
+func unixNano(n uint64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, int64(n)).UTC()
+}
+
+func hexID(id []byte) string {
+	for _, b := range id {
+		if b != 0 {
+			return hex.EncodeToString(id)
+		}
+	}
+	return ""
+}
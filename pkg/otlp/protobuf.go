@@ -0,0 +1,300 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package otlp
+
+import (
+	"errors"
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// field numbers of the messages of opentelemetry/proto/collector/logs/v1
+const (
+	requestResourceLogs = 1
+
+	resourceLogsResource  = 1
+	resourceLogsScopeLogs = 2
+	// resourceLogsLibraryLogs are the scope logs of exporters before OTLP 0.19
+	resourceLogsLibraryLogs = 1000
+
+	resourceAttributes = 1
+
+	scopeLogsScope      = 1
+	scopeLogsLogRecords = 2
+
+	scopeName = 1
+
+	logTimeUnixNano         = 1
+	logSeverityNumber       = 2
+	logSeverityText         = 3
+	logBody                 = 5
+	logAttributes           = 6
+	logTraceID              = 9
+	logSpanID               = 10
+	logObservedTimeUnixNano = 11
+	logEventName            = 12
+
+	keyValueKey   = 1
+	keyValueValue = 2
+
+	anyValueString = 1
+	anyValueBool   = 2
+	anyValueInt    = 3
+	anyValueDouble = 4
+	anyValueArray  = 5
+	anyValueKvlist = 6
+	anyValueBytes  = 7
+
+	// the values of ArrayValue and KeyValueList
+	listValues = 1
+
+	statusCode    = 1
+	statusMessage = 2
+)
+
+// maxDepth bounds the nesting of the attribute values
+const maxDepth = 32
+
+var errMalformed = errors.New("malformed protobuf")
+
+// field is a field of a message, value holds the varint and fixed numbers and bytes the length delimited ones
+type field struct {
+	num   protowire.Number
+	typ   protowire.Type
+	value uint64
+	bytes []byte
+}
+
+// eachField calls f with the fields of a message in order
+func eachField(b []byte, f func(fd field) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return errMalformed
+		}
+		b = b[n:]
+		fd := field{num: num, typ: typ}
+		switch typ {
+		case protowire.VarintType:
+			fd.value, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed64Type:
+			fd.value, n = protowire.ConsumeFixed64(b)
+		case protowire.Fixed32Type:
+			var v uint32
+			v, n = protowire.ConsumeFixed32(b)
+			fd.value = uint64(v)
+		case protowire.BytesType:
+			fd.bytes, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return errMalformed
+		}
+		b = b[n:]
+		if err := f(fd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeProtobuf(b []byte) ([]LogRecord, error) {
+	var records []LogRecord
+	err := eachField(b, func(fd field) error {
+		if fd.num != requestResourceLogs || fd.typ != protowire.BytesType {
+			return nil
+		}
+		return decodeResourceLogs(fd.bytes, &records)
+	})
+	return records, err
+}
+
+func decodeResourceLogs(b []byte, records *[]LogRecord) error {
+	// the resource may come after the records
+	var resource map[string]interface{}
+	start := len(*records)
+	err := eachField(b, func(fd field) error {
+		if fd.typ != protowire.BytesType {
+			return nil
+		}
+		switch fd.num {
+		case resourceLogsResource:
+			return eachField(fd.bytes, func(fd field) error {
+				if fd.num == resourceAttributes && fd.typ == protowire.BytesType {
+					if resource == nil {
+						resource = make(map[string]interface{})
+					}
+					return decodeKeyValue(fd.bytes, resource, 0)
+				}
+				return nil
+			})
+		case resourceLogsScopeLogs, resourceLogsLibraryLogs:
+			return decodeScopeLogs(fd.bytes, records)
+		}
+		return nil
+	})
+	for i := start; i < len(*records); i++ {
+		(*records)[i].ResourceAttributes = resource
+	}
+	return err
+}
+
+func decodeScopeLogs(b []byte, records *[]LogRecord) error {
+	scope := ""
+	start := len(*records)
+	err := eachField(b, func(fd field) error {
+		if fd.typ != protowire.BytesType {
+			return nil
+		}
+		switch fd.num {
+		case scopeLogsScope:
+			return eachField(fd.bytes, func(fd field) error {
+				if fd.num == scopeName && fd.typ == protowire.BytesType {
+					scope = string(fd.bytes)
+				}
+				return nil
+			})
+		case scopeLogsLogRecords:
+			r, err := decodeLogRecord(fd.bytes)
+			if err != nil {
+				return err
+			}
+			*records = append(*records, r)
+		}
+		return nil
+	})
+	for i := start; i < len(*records); i++ {
+		(*records)[i].Scope = scope
+	}
+	return err
+}
+
+func decodeLogRecord(b []byte) (LogRecord, error) {
+	var r LogRecord
+	var observed uint64
+	err := eachField(b, func(fd field) error {
+		switch fd.num {
+		case logTimeUnixNano:
+			r.Time = unixNano(fd.value)
+		case logObservedTimeUnixNano:
+			observed = fd.value
+		case logSeverityNumber:
+			r.SeverityNumber = int32(fd.value)
+		case logSeverityText:
+			r.SeverityText = string(fd.bytes)
+		case logEventName:
+			r.EventName = string(fd.bytes)
+		case logBody:
+			v, err := decodeAnyValue(fd.bytes, 0)
+			if err != nil {
+				return err
+			}
+			r.Body = v
+		case logAttributes:
+			if r.Attributes == nil {
+				r.Attributes = make(map[string]interface{})
+			}
+			return decodeKeyValue(fd.bytes, r.Attributes, 0)
+		case logTraceID:
+			r.TraceID = hexID(fd.bytes)
+		case logSpanID:
+			r.SpanID = hexID(fd.bytes)
+		}
+		return nil
+	})
+	if r.Time.IsZero() {
+		r.Time = unixNano(observed)
+	}
+	return r, err
+}
+
+func decodeKeyValue(b []byte, into map[string]interface{}, depth int) error {
+	var key string
+	var value interface{}
+	err := eachField(b, func(fd field) error {
+		switch fd.num {
+		case keyValueKey:
+			key = string(fd.bytes)
+		case keyValueValue:
+			v, err := decodeAnyValue(fd.bytes, depth)
+			if err != nil {
+				return err
+			}
+			value = v
+		}
+		return nil
+	})
+	if err == nil && key != "" {
+		into[key] = value
+	}
+	return err
+}
+
+func decodeAnyValue(b []byte, depth int) (interface{}, error) {
+	if depth > maxDepth {
+		return nil, errors.New("attribute values are nested too deep")
+	}
+	var value interface{}
+	err := eachField(b, func(fd field) error {
+		switch fd.num {
+		case anyValueString:
+			value = string(fd.bytes)
+		case anyValueBool:
+			value = fd.value != 0
+		case anyValueInt:
+			value = int64(fd.value)
+		case anyValueDouble:
+			value = math.Float64frombits(fd.value)
+		case anyValueBytes:
+			value = append([]byte{}, fd.bytes...)
+		case anyValueArray:
+			values := []interface{}{}
+			err := eachField(fd.bytes, func(fd field) error {
+				if fd.num != listValues {
+					return nil
+				}
+				v, err := decodeAnyValue(fd.bytes, depth+1)
+				values = append(values, v)
+				return err
+			})
+			value = values
+			return err
+		case anyValueKvlist:
+			values := map[string]interface{}{}
+			err := eachField(fd.bytes, func(fd field) error {
+				if fd.num != listValues {
+					return nil
+				}
+				return decodeKeyValue(fd.bytes, values, depth+1)
+			})
+			value = values
+			return err
+		}
+		return nil
+	})
+	return value, err
+}
+
+func encodeStatus(code int32, message string) []byte {
+	var b []byte
+	b = protowire.AppendTag(b, statusCode, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(code))
+	b = protowire.AppendTag(b, statusMessage, protowire.BytesType)
+	return protowire.AppendString(b, message)
+}
@@ -0,0 +1,249 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package otlp
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// the helpers below encode an ExportLogsServiceRequest field by field
+
+func pbBytes(num protowire.Number, b ...[]byte) []byte {
+	out := protowire.AppendTag(nil, num, protowire.BytesType)
+	return protowire.AppendBytes(out, bytes.Join(b, nil))
+}
+
+func pbString(num protowire.Number, s string) []byte {
+	return pbBytes(num, []byte(s))
+}
+
+func pbVarint(num protowire.Number, v uint64) []byte {
+	return protowire.AppendVarint(protowire.AppendTag(nil, num, protowire.VarintType), v)
+}
+
+func pbFixed64(num protowire.Number, v uint64) []byte {
+	return protowire.AppendFixed64(protowire.AppendTag(nil, num, protowire.Fixed64Type), v)
+}
+
+func pbKeyValue(key string, value []byte) []byte {
+	return bytes.Join([][]byte{pbString(keyValueKey, key), pbBytes(keyValueValue, value)}, nil)
+}
+
+func pbStringValue(s string) []byte { return pbString(anyValueString, s) }
+
+func pbIntValue(v int64) []byte { return pbVarint(anyValueInt, uint64(v)) }
+
+func pbArrayValue(values ...[]byte) []byte {
+	var list [][]byte
+	for _, v := range values {
+		list = append(list, pbBytes(listValues, v))
+	}
+	return pbBytes(anyValueArray, list...)
+}
+
+func pbKvlistValue(kvs ...[]byte) []byte {
+	var list [][]byte
+	for _, kv := range kvs {
+		list = append(list, pbBytes(listValues, kv))
+	}
+	return pbBytes(anyValueKvlist, list...)
+}
+
+var (
+	traceID = []byte{0x5b, 0x8e, 0xff, 0xf7, 0x98, 0x03, 0x81, 0x03, 0xd2, 0x69, 0xb6, 0x33, 0x81, 0x3f, 0xc6, 0x0c}
+	spanID  = []byte{0xee, 0xe1, 0x9b, 0x7e, 0xc3, 0xc1, 0xb1, 0x74}
+)
+
+// testRequest is the protobuf of the request the json of testJSON writes too
+func testRequest() []byte {
+	record1 := bytes.Join([][]byte{
+		pbFixed64(logTimeUnixNano, 1700000000123456789),
+		pbVarint(logSeverityNumber, 9),
+		pbString(logSeverityText, "INFO"),
+		pbBytes(logBody, pbStringValue("user deleted an invoice\nwith details")),
+		pbBytes(logAttributes, pbKeyValue("enduser.id", pbStringValue("alice"))),
+		pbBytes(logAttributes, pbKeyValue("client.address", pbStringValue("10.0.0.1"))),
+		pbBytes(logAttributes, pbKeyValue("http.response.status_code", pbIntValue(403))),
+		pbBytes(logAttributes, pbKeyValue("http.request.method", pbStringValue("DELETE"))),
+		pbBytes(logAttributes, pbKeyValue("url.path", pbStringValue("/invoices/42"))),
+		pbBytes(logAttributes, pbKeyValue("request", pbKvlistValue(
+			pbKeyValue("id", pbIntValue(42)),
+			pbKeyValue("tags", pbArrayValue(pbStringValue("a"), pbVarint(anyValueBool, 1), pbFixed64(anyValueDouble, math.Float64bits(1.5)))),
+		))),
+		pbBytes(logAttributes, pbKeyValue("blob", pbBytes(anyValueBytes, []byte{1, 2}))),
+		pbBytes(logTraceID, traceID),
+		pbBytes(logSpanID, spanID),
+		pbString(logEventName, "invoice.delete"),
+		// unknown fields are skipped
+		pbVarint(99, 1),
+	}, nil)
+	record2 := bytes.Join([][]byte{
+		pbFixed64(logObservedTimeUnixNano, 1700000001000000000),
+		pbString(logSeverityText, "WARN"),
+		pbBytes(logBody, pbStringValue("disk almost full\nsecond line")),
+		pbBytes(logTraceID, make([]byte, 16)),
+	}, nil)
+	record3 := bytes.Join([][]byte{
+		pbFixed64(logTimeUnixNano, 1700000002000000000),
+		pbString(logSeverityText, "ERROR"),
+	}, nil)
+
+	resourceLogs := bytes.Join([][]byte{
+		pbBytes(resourceLogsResource,
+			pbBytes(resourceAttributes, pbKeyValue("service.name", pbStringValue("billing"))),
+			pbBytes(resourceAttributes, pbKeyValue("host.name", pbStringValue("h1"))),
+		),
+		pbBytes(resourceLogsScopeLogs,
+			pbBytes(scopeLogsScope, pbString(scopeName, "audit-lib")),
+			pbBytes(scopeLogsLogRecords, record1),
+			pbBytes(scopeLogsLogRecords, record2),
+		),
+	}, nil)
+	// the scope logs of old exporters, before the resource
+	oldResourceLogs := bytes.Join([][]byte{
+		pbBytes(resourceLogsLibraryLogs,
+			pbBytes(scopeLogsScope, pbString(scopeName, "old-lib")),
+			pbBytes(scopeLogsLogRecords, record3),
+		),
+		pbBytes(resourceLogsResource,
+			pbBytes(resourceAttributes, pbKeyValue("service.name", pbStringValue("legacy"))),
+		),
+	}, nil)
+	return bytes.Join([][]byte{
+		pbBytes(requestResourceLogs, resourceLogs),
+		pbBytes(requestResourceLogs, oldResourceLogs),
+		// a field of the wrong type is skipped
+		pbVarint(requestResourceLogs, 7),
+	}, nil)
+}
+
+// testRecords are the records of testRequest
+func testRecords() []LogRecord {
+	billing := map[string]interface{}{"service.name": "billing", "host.name": "h1"}
+	return []LogRecord{
+		{
+			Time:           time.Unix(0, 1700000000123456789).UTC(),
+			SeverityNumber: 9,
+			SeverityText:   "INFO",
+			EventName:      "invoice.delete",
+			Body:           "user deleted an invoice\nwith details",
+			Attributes: map[string]interface{}{
+				"enduser.id":                "alice",
+				"client.address":            "10.0.0.1",
+				"http.response.status_code": int64(403),
+				"http.request.method":       "DELETE",
+				"url.path":                  "/invoices/42",
+				"request": map[string]interface{}{
+					"id":   int64(42),
+					"tags": []interface{}{"a", true, 1.5},
+				},
+				"blob": []byte{1, 2},
+			},
+			TraceID:            "5b8efff798038103d269b633813fc60c",
+			SpanID:             "eee19b7ec3c1b174",
+			Scope:              "audit-lib",
+			ResourceAttributes: billing,
+		},
+		{
+			Time:               time.Unix(0, 1700000001000000000).UTC(),
+			SeverityText:       "WARN",
+			Body:               "disk almost full\nsecond line",
+			Scope:              "audit-lib",
+			ResourceAttributes: billing,
+		},
+		{
+			Time:               time.Unix(0, 1700000002000000000).UTC(),
+			SeverityText:       "ERROR",
+			Scope:              "old-lib",
+			ResourceAttributes: map[string]interface{}{"service.name": "legacy"},
+		},
+	}
+}
+
+func TestDecodeProtobuf(t *testing.T) {
+	got, err := Decode("application/x-protobuf", testRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := testRecords(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Decode =\n%#v\nwant\n%#v", got, want)
+	}
+}
+
+func TestDecodeProtobufEmpty(t *testing.T) {
+	got, err := Decode(ContentTypeProtobuf, nil)
+	if err != nil || len(got) != 0 {
+		t.Errorf("Decode of an empty request = %v, %v", got, err)
+	}
+}
+
+func TestDecodeProtobufMalformed(t *testing.T) {
+	request := testRequest()
+	nested := pbStringValue("leaf")
+	for i := 0; i <= maxDepth+1; i++ {
+		nested = pbArrayValue(nested)
+	}
+	deep := pbBytes(requestResourceLogs, pbBytes(resourceLogsScopeLogs, pbBytes(scopeLogsLogRecords,
+		pbBytes(logAttributes, pbKeyValue("deep", nested)))))
+
+	tests := []struct {
+		name string
+		in   []byte
+	}{
+		{"truncated", request[:len(request)/2]},
+		{"bad tag", []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"length past the end", []byte{0x0a, 0x10, 0x01}},
+		{"truncated record", pbBytes(requestResourceLogs, pbBytes(resourceLogsScopeLogs, pbBytes(scopeLogsLogRecords, []byte{0x2a, 0x05})))},
+		{"nested too deep", deep},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Decode(ContentTypeProtobuf, tt.in); err == nil {
+				t.Error("Decode succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestStatus(t *testing.T) {
+	var code uint64
+	var message string
+	err := eachField(Status(ContentTypeProtobuf, CodeInvalidArgument, "bad request"), func(fd field) error {
+		switch fd.num {
+		case statusCode:
+			code = fd.value
+		case statusMessage:
+			message = string(fd.bytes)
+		}
+		return nil
+	})
+	if err != nil || code != CodeInvalidArgument || message != "bad request" {
+		t.Errorf("protobuf status = %d %q, %v", code, message, err)
+	}
+	if got := string(Status(ContentTypeJSON, CodeInvalidArgument, "bad request")); got != `{"code":3,"message":"bad request"}` {
+		t.Errorf("json status = %s", got)
+	}
+	if len(Response(ContentTypeProtobuf)) != 0 || string(Response(ContentTypeJSON)) != "{}" {
+		t.Error("responses are not empty")
+	}
+}
@@ -0,0 +1,161 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package redact
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// jsonPath is the subset of JSONPath selecting fields: $ followed by .name,
+// ['name'], [index], [*] or .* steps, and ..name or ..* descending into every level
+type jsonPath []pathStep
+
+type pathStep struct {
+	name string
+	// index selects an array element when it is not negative
+	index    int
+	wildcard bool
+	// recursive matches the step at any depth
+	recursive bool
+}
+
+func parseJSONPath(path string) (jsonPath, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("path %q does not start with $", path)
+	}
+	var steps jsonPath
+	rest := path[1:]
+	for rest != "" {
+		step := pathStep{index: -1}
+		switch {
+		case strings.HasPrefix(rest, ".."):
+			step.recursive = true
+			rest = rest[2:]
+			if strings.HasPrefix(rest, "[") {
+				// the bracket step that follows is made recursive
+				steps = append(steps, step)
+				continue
+			}
+			rest = step.parseName(rest)
+		case strings.HasPrefix(rest, "."):
+			rest = step.parseName(rest[1:])
+		case strings.HasPrefix(rest, "["):
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("path %q has an unclosed [", path)
+			}
+			inner := strings.TrimSpace(rest[1:end])
+			rest = rest[end+1:]
+			switch {
+			case inner == "*":
+				step.wildcard = true
+			case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+				step.name = inner[1 : len(inner)-1]
+			default:
+				index, err := strconv.Atoi(inner)
+				if err != nil || index < 0 {
+					return nil, fmt.Errorf("path %q has an invalid index %q", path, inner)
+				}
+				step.index = index
+			}
+			if n := len(steps); n > 0 && steps[n-1].recursive && steps[n-1].name == "" && !steps[n-1].wildcard && steps[n-1].index < 0 {
+				step.recursive = true
+				steps = steps[:n-1]
+			}
+		default:
+			return nil, fmt.Errorf("path %q has an unexpected %q", path, rest)
+		}
+		if step.name == "" && !step.wildcard && step.index < 0 && !step.recursive {
+			return nil, fmt.Errorf("path %q has an empty step", path)
+		}
+		steps = append(steps, step)
+	}
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("path %q selects the whole object", path)
+	}
+	if last := steps[len(steps)-1]; last.name == "" && !last.wildcard && last.index < 0 {
+		return nil, fmt.Errorf("path %q ends with ..", path)
+	}
+	return steps, nil
+}
+
+// parseName reads the name of a dot step and returns what follows it
+func (s *pathStep) parseName(rest string) string {
+	end := strings.IndexAny(rest, ".[")
+	if end < 0 {
+		end = len(rest)
+	}
+	s.name = rest[:end]
+	if s.name == "*" {
+		s.name, s.wildcard = "", true
+	}
+	return rest[end:]
+}
+
+func (s pathStep) matchKey(key string) bool {
+	return s.wildcard || (s.name != "" && s.name == key)
+}
+
+func (s pathStep) matchIndex(i int) bool {
+	return s.wildcard || s.index == i
+}
+
+// mask masks the values selected by the path and returns v with them masked
+func (p jsonPath) mask(v interface{}) (interface{}, int) {
+	if len(p) == 0 {
+		return maskValue(v)
+	}
+	step := p[0]
+	n := 0
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			var masked int
+			if step.matchKey(key) {
+				v[key], masked = p[1:].mask(value)
+				n += masked
+			}
+			if step.recursive {
+				v[key], masked = p.mask(v[key])
+				n += masked
+			}
+		}
+	case []interface{}:
+		for i, value := range v {
+			var masked int
+			if step.matchIndex(i) {
+				v[i], masked = p[1:].mask(value)
+				n += masked
+			}
+			if step.recursive {
+				v[i], masked = p.mask(v[i])
+				n += masked
+			}
+		}
+	}
+	return v, n
+}
+
+// maskValue masks a selected value, objects and arrays as a whole
+func maskValue(v interface{}) (interface{}, int) {
+	if v == nil || v == Mask {
+		return v, 0
+	}
+	return Mask, 1
+}
@@ -0,0 +1,308 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package redact
+
+import (
+	v1 "audit/pkg/backend/v1"
+	"audit/pkg/utils/env"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/saashqdev/kubeworkz/pkg/clog"
+)
+
+// Mask replaces the redacted values
+const Mask = "******"
+
+// secretGroup is the group of a pattern masked instead of the whole match
+const secretGroup = "secret"
+
+// Rule masks the matches of its pattern in the text of the events, or the
+// fields at its path in their json request parameters and response elements
+type Rule struct {
+	// Name identifies the rule, a rule named after a built-in one replaces it
+	Name     string
+	Disabled bool
+	// Pattern is a regular expression, only its group named secret is masked when it has one
+	Pattern string
+	// Path is a JSONPath like $.spec.password, $..token or $.items[*].key
+	Path string
+}
+
+// defaultRules are the built-in rules
+var defaultRules = []Rule{
+	{Name: "bearer-token", Pattern: `(?i)\bbearer\s+(?P<secret>[A-Za-z0-9\-._~+/]+=*)`},
+	{Name: "basic-auth", Pattern: `(?i)\bbasic\s+(?P<secret>[A-Za-z0-9+/]{8,}=*)`},
+	{Name: "jwt", Pattern: `\beyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`},
+	{Name: "aws-access-key", Pattern: `\b(AKIA|ASIA)[0-9A-Z]{16}\b`},
+	{Name: "private-key", Pattern: `-----BEGIN[A-Z ]* PRIVATE KEY-----[\s\S]*?(-----END[A-Z ]* PRIVATE KEY-----|$)`},
+	{Name: "url-credentials", Pattern: `\b[a-zA-Z][a-zA-Z0-9+.-]*://[^/\s:@]+:(?P<secret>[^/\s@]+)@`},
+	{Name: "password-assignment", Pattern: `(?i)(password|passwd|pwd|secret|token|api[_-]?key|access[_-]?key|private[_-]?key)["']?\s*[:=]\s*["']?(?P<secret>[^\s"'&,;]+)`},
+}
+
+var redactor = mustNewRedactor(defaultRules)
+
+// Redactor masks sensitive data in the events before they are stored
+type Redactor struct {
+	patterns []*regexp.Regexp
+	paths    []jsonPath
+}
+
+var mu sync.RWMutex
+
+// Start loads the rules of AUDIT_REDACTION_RULE_PATH on top of the built-in ones
+func Start() {
+	file := env.RedactionRulePath()
+	if file == "" {
+		return
+	}
+	r, err := load(file)
+	if err != nil {
+		clog.Error("load redaction rules from %s error: %s, the built-in rules are used", file, err)
+		return
+	}
+	mu.Lock()
+	redactor = r
+	mu.Unlock()
+}
+
+func load(file string) (*Redactor, error) {
+	rules := append([]Rule{}, defaultRules...)
+	bs, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return newRedactor(rules)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var configured []Rule
+	if err = json.Unmarshal(bs, &configured); err != nil {
+		return nil, err
+	}
+	for _, c := range configured {
+		replaced := false
+		for i := range rules {
+			if rules[i].Name == c.Name {
+				rules[i], replaced = c, true
+				break
+			}
+		}
+		if !replaced {
+			rules = append(rules, c)
+		}
+	}
+	return newRedactor(rules)
+}
+
+func newRedactor(rules []Rule) (*Redactor, error) {
+	r := &Redactor{}
+	for _, rule := range rules {
+		if rule.Disabled {
+			continue
+		}
+		switch {
+		case rule.Pattern != "" && rule.Path != "":
+			return nil, fmt.Errorf("redaction rule %s has both a pattern and a path", rule.Name)
+		case rule.Pattern != "":
+			pattern, err := regexp.Compile(rule.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("redaction rule %s: %s", rule.Name, err)
+			}
+			r.patterns = append(r.patterns, pattern)
+		case rule.Path != "":
+			path, err := parseJSONPath(rule.Path)
+			if err != nil {
+				return nil, fmt.Errorf("redaction rule %s: %s", rule.Name, err)
+			}
+			r.paths = append(r.paths, path)
+		default:
+			return nil, fmt.Errorf("redaction rule %s needs a pattern or a path", rule.Name)
+		}
+	}
+	return r, nil
+}
+
+func mustNewRedactor(rules []Rule) *Redactor {
+	r, err := newRedactor(rules)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+func current() *Redactor {
+	mu.RLock()
+	defer mu.RUnlock()
+	return redactor
+}
+
+// Event masks the sensitive data of an event in place and adds what it
+// masked to the redactions of the event
+func Event(e *v1.Event) {
+	current().event(e)
+}
+
+// String masks the patterns of the rules in a text and returns how many it masked
+func String(s string) (string, int) {
+	return current().text(s)
+}
+
+func (r *Redactor) event(e *v1.Event) {
+	n := 0
+	secret := secretEvent(e)
+	for _, field := range []*string{&e.RequestParameters, &e.ResponseElements} {
+		var masked int
+		*field, masked = r.json(*field, secret)
+		n += masked
+	}
+	for _, field := range []*string{&e.EventName, &e.Description, &e.RequestParameters,
+		&e.ResponseElements, &e.ErrorMessage, &e.Url} {
+		var masked int
+		*field, masked = r.text(*field)
+		n += masked
+	}
+	for i := range e.Findings {
+		var masked int
+		e.Findings[i].Message, masked = r.text(e.Findings[i].Message)
+		n += masked
+	}
+	e.Redactions += n
+}
+
+// text masks the matches of the patterns
+func (r *Redactor) text(s string) (string, int) {
+	if s == "" {
+		return s, 0
+	}
+	n := 0
+	for _, pattern := range r.patterns {
+		group := pattern.SubexpIndex(secretGroup)
+		matches := pattern.FindAllStringSubmatchIndex(s, -1)
+		if len(matches) == 0 {
+			continue
+		}
+		var b strings.Builder
+		last := 0
+		for _, m := range matches {
+			start, end := m[0], m[1]
+			if group > 0 {
+				start, end = m[2*group], m[2*group+1]
+			}
+			if start < 0 || start < last || s[start:end] == Mask {
+				continue
+			}
+			b.WriteString(s[last:start])
+			b.WriteString(Mask)
+			last = end
+			n++
+		}
+		b.WriteString(s[last:])
+		s = b.String()
+	}
+	return s, n
+}
+
+// json masks the secret data and the paths of the rules in a json text,
+// other texts are left to the patterns
+func (r *Redactor) json(s string, secret bool) (string, int) {
+	trimmed := strings.TrimSpace(s)
+	if trimmed == "" || (trimmed[0] != '{' && trimmed[0] != '[') {
+		return s, 0
+	}
+	dec := json.NewDecoder(strings.NewReader(trimmed))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil || dec.More() {
+		return s, 0
+	}
+
+	n := maskSecretData(v, secret)
+	for _, path := range r.paths {
+		var masked int
+		v, masked = path.mask(v)
+		n += masked
+	}
+	if n == 0 {
+		return s, 0
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return s, 0
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), n
+}
+
+// secretEvent reports whether the event is a request on secrets, whose
+// bodies are secret objects or patches of them
+func secretEvent(e *v1.Event) bool {
+	for _, r := range e.ResourceReports {
+		if r.ResourceType == "secrets" || r.ResourceType == "Secret" {
+			return true
+		}
+	}
+	path := strings.SplitN(e.Url, "?", 2)[0]
+	return strings.Contains(path, "/secrets/") || strings.HasSuffix(path, "/secrets")
+}
+
+// maskSecretData masks the values of data and stringData in the secret
+// objects found in v, and the values patched into them. The root is taken
+// for a secret when the event is a request on secrets.
+func maskSecretData(v interface{}, secret bool) int {
+	n := 0
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if secret || v["kind"] == "Secret" {
+			for _, key := range []string{"data", "stringData"} {
+				if data, ok := v[key].(map[string]interface{}); ok {
+					for k, value := range data {
+						if value != nil && value != Mask {
+							data[k] = Mask
+							n++
+						}
+					}
+				}
+			}
+		}
+		if p, ok := v["path"].(string); ok && secret {
+			if _, ok := v["value"]; ok && (strings.HasPrefix(p, "/data") || strings.HasPrefix(p, "/stringData")) && v["value"] != Mask {
+				v["value"] = Mask
+				n++
+			}
+		}
+		// the items of a secret list may not have a kind
+		if items, ok := v["items"].([]interface{}); ok && secret {
+			n += maskSecretData(items, true)
+		}
+		for _, value := range v {
+			n += maskSecretData(value, false)
+		}
+	case []interface{}:
+		for _, value := range v {
+			// the operations of a json patch on a secret
+			n += maskSecretData(value, secret)
+		}
+	}
+	return n
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package session
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	CastContentType = "application/x-asciicast"
+
+	DefaultCastWidth  = 120
+	DefaultCastHeight = 40
+)
+
+// castHeader is the first line of an asciinema v2 cast
+type castHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// WriteCast writes the session as an asciinema v2 cast, the output of the
+// terminal as "o" events and its input as "i" events. The webconsole does not
+// report the terminal size, so the player is told width and height.
+func WriteCast(w io.Writer, s *Session, chunks []Chunk, width, height int) error {
+	header := castHeader{
+		Version:   2,
+		Width:     width,
+		Height:    height,
+		Timestamp: s.StartTime,
+		Title:     fmt.Sprintf("%s@%s/%s/%s", s.User, s.Cluster, s.Namespace, s.Pod),
+	}
+	if s.Platform != "" {
+		header.Env = map[string]string{"PLATFORM": s.Platform}
+	}
+	wr := bufio.NewWriter(w)
+	enc := json.NewEncoder(wr)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(header); err != nil {
+		return err
+	}
+
+	var start int64
+	if len(chunks) > 0 {
+		start = chunks[0].Time
+	}
+	for _, chunk := range chunks {
+		code := "o"
+		if chunk.Type == ChunkStdin {
+			code = "i"
+		}
+		offset := float64(chunk.Time-start) / float64(time.Second)
+		if err := enc.Encode([]interface{}{offset, code, chunk.Data}); err != nil {
+			return err
+		}
+	}
+	return wr.Flush()
+}
@@ -0,0 +1,335 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package session
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+const (
+	// editorIdleTimeout is how long the line of a silent session is kept
+	editorIdleTimeout = 30 * time.Minute
+	maxEditors        = 10000
+	maxHistory        = 100
+	// maxKeystrokes bounds the input kept for a line, the line itself is still edited
+	maxKeystrokes = 64 * 1024
+)
+
+var editors = &editorSet{editors: make(map[string]*lineEditor)}
+
+// passwordPrompt matches the output asking for a password, like "[sudo] password for alice: "
+var passwordPrompt = regexp.MustCompile(`(?i)(password|passphrase|passcode)[^:\r\n]{0,40}:\s*$`)
+
+// Command is a command line run in a webconsole terminal
+type Command struct {
+	// Line is the command line as it was when enter was pressed
+	Line string
+	// Keystrokes is everything typed for the line, control keys included
+	Keystrokes string
+	Time       time.Time
+	// Secret tells the line answers a password prompt
+	Secret bool
+}
+
+// editorSet holds the line editor of each session
+type editorSet struct {
+	mu        sync.Mutex
+	editors   map[string]*lineEditor
+	lastSweep time.Time
+}
+
+// Input feeds the keystrokes of a session to its line editor and returns the
+// commands they run
+func Input(id string, t time.Time, data string) []Command {
+	return editors.get(id).input(t, data)
+}
+
+// Output tells the line editor of a session what its terminal printed, to
+// learn the completions of the shell
+func Output(id, data string) {
+	editors.get(id).output(data)
+}
+
+// ReadingSecret reports whether the terminal of a session waits for a password
+func ReadingSecret(id string) bool {
+	e := editors.get(id)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.secret
+}
+
+// get returns the editor of a session and forgets the idle ones
+func (s *editorSet) get(id string) *lineEditor {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if now.Sub(s.lastSweep) > editorIdleTimeout {
+		for key, e := range s.editors {
+			if now.Sub(e.lastUsed) > editorIdleTimeout {
+				delete(s.editors, key)
+			}
+		}
+		s.lastSweep = now
+	}
+	e, ok := s.editors[id]
+	if !ok {
+		e = &lineEditor{}
+		if len(s.editors) < maxEditors {
+			s.editors[id] = e
+		}
+	}
+	e.lastUsed = now
+	return e
+}
+
+// lineEditor follows the line a user edits in a shell with readline like
+// keys, from the keystrokes sent to the terminal. Moving in the history
+// recalls the commands seen in the session, older ones are not known.
+type lineEditor struct {
+	mu       sync.Mutex
+	lastUsed time.Time
+
+	line       []rune
+	cursor     int
+	keystrokes strings.Builder
+	// escape holds an escape sequence split across chunks
+	escape []rune
+	// completing is set by a tab, the next output is the completion of the shell
+	completing bool
+	// pasting is set in a bracketed paste, where newlines do not run the line
+	pasting bool
+	// secret is set by a password prompt, the line is not echoed nor kept in the history
+	secret bool
+
+	history []string
+	// recalled is the position in the history, len(history) for a new line
+	recalled int
+}
+
+func (e *lineEditor) input(t time.Time, data string) []Command {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var commands []Command
+	for _, r := range data {
+		if e.keystrokes.Len() < maxKeystrokes {
+			e.keystrokes.WriteRune(r)
+		}
+		if e.escape != nil {
+			e.escape = append(e.escape, r)
+			if escapeDone(e.escape) {
+				e.key(string(e.escape))
+				e.escape = nil
+			}
+			continue
+		}
+		if r == '\x1b' {
+			e.escape = []rune{r}
+			continue
+		}
+		if (r == '\r' || r == '\n') && !e.pasting {
+			if c, ok := e.enter(t); ok {
+				commands = append(commands, c)
+			}
+			continue
+		}
+		e.key(string(r))
+	}
+	return commands
+}
+
+// escapeDone reports whether an escape sequence is complete: ESC x, ESC O x,
+// or ESC [ with parameters and a final byte
+func escapeDone(seq []rune) bool {
+	if len(seq) < 2 {
+		return false
+	}
+	switch seq[1] {
+	case '[':
+		if len(seq) < 3 {
+			return false
+		}
+		last := seq[len(seq)-1]
+		return last >= 0x40 && last <= 0x7e
+	case 'O':
+		return len(seq) >= 3
+	}
+	return true
+}
+
+// key applies a key, or an escape sequence, to the line
+func (e *lineEditor) key(k string) {
+	if k != "\t" {
+		e.completing = false
+	}
+	switch k {
+	case "\t":
+		e.completing = true
+	case "\x7f", "\b":
+		if e.cursor > 0 {
+			e.line = append(e.line[:e.cursor-1], e.line[e.cursor:]...)
+			e.cursor--
+		}
+	case "\x1b[3~", "\x04":
+		if e.cursor < len(e.line) {
+			e.line = append(e.line[:e.cursor], e.line[e.cursor+1:]...)
+		}
+	case "\x1b[D", "\x1bOD", "\x02":
+		if e.cursor > 0 {
+			e.cursor--
+		}
+	case "\x1b[C", "\x1bOC", "\x06":
+		if e.cursor < len(e.line) {
+			e.cursor++
+		}
+	case "\x1b[H", "\x1bOH", "\x1b[1~", "\x01":
+		e.cursor = 0
+	case "\x1b[F", "\x1bOF", "\x1b[4~", "\x05":
+		e.cursor = len(e.line)
+	case "\x1bb", "\x1b[1;5D":
+		e.cursor = e.wordStart()
+	case "\x1bf", "\x1b[1;5C":
+		e.cursor = e.wordEnd()
+	case "\x15":
+		e.line = append([]rune{}, e.line[e.cursor:]...)
+		e.cursor = 0
+	case "\x0b":
+		e.line = e.line[:e.cursor]
+	case "\x17":
+		start := e.wordStart()
+		e.line = append(e.line[:start], e.line[e.cursor:]...)
+		e.cursor = start
+	case "\x03":
+		e.reset()
+	case "\x1b[A", "\x1bOA", "\x10":
+		if e.recalled > 0 {
+			e.recalled--
+			e.recall()
+		}
+	case "\x1b[B", "\x1bOB", "\x0e":
+		if e.recalled < len(e.history) {
+			e.recalled++
+			e.recall()
+		}
+	case "\x1b[200~":
+		e.pasting = true
+	case "\x1b[201~":
+		e.pasting = false
+	default:
+		r := []rune(k)
+		if len(r) == 1 && (unicode.IsPrint(r[0]) || (e.pasting && (r[0] == '\r' || r[0] == '\n'))) {
+			if r[0] == '\r' {
+				r[0] = '\n'
+			}
+			e.insert(r)
+		}
+	}
+}
+
+func (e *lineEditor) insert(r []rune) {
+	line := make([]rune, 0, len(e.line)+len(r))
+	line = append(line, e.line[:e.cursor]...)
+	line = append(line, r...)
+	e.line = append(line, e.line[e.cursor:]...)
+	e.cursor += len(r)
+}
+
+// wordStart is where the word before the cursor starts
+func (e *lineEditor) wordStart() int {
+	i := e.cursor
+	for i > 0 && unicode.IsSpace(e.line[i-1]) {
+		i--
+	}
+	for i > 0 && !unicode.IsSpace(e.line[i-1]) {
+		i--
+	}
+	return i
+}
+
+// wordEnd is where the word after the cursor ends
+func (e *lineEditor) wordEnd() int {
+	i := e.cursor
+	for i < len(e.line) && unicode.IsSpace(e.line[i]) {
+		i++
+	}
+	for i < len(e.line) && !unicode.IsSpace(e.line[i]) {
+		i++
+	}
+	return i
+}
+
+func (e *lineEditor) recall() {
+	e.line = nil
+	if e.recalled < len(e.history) {
+		e.line = []rune(e.history[e.recalled])
+	}
+	e.cursor = len(e.line)
+}
+
+// enter runs the line, a blank line is no command
+func (e *lineEditor) enter(t time.Time) (Command, bool) {
+	defer e.reset()
+	line := strings.TrimSpace(string(e.line))
+	if line == "" {
+		return Command{}, false
+	}
+	if e.secret {
+		return Command{Line: line, Keystrokes: e.keystrokes.String(), Time: t, Secret: true}, true
+	}
+	e.history = append(e.history, line)
+	if len(e.history) > maxHistory {
+		e.history = e.history[len(e.history)-maxHistory:]
+	}
+	return Command{Line: line, Keystrokes: e.keystrokes.String(), Time: t}, true
+}
+
+func (e *lineEditor) reset() {
+	e.line, e.cursor = nil, 0
+	e.keystrokes.Reset()
+	e.completing, e.pasting, e.secret = false, false, false
+	e.recalled = len(e.history)
+}
+
+// output inserts the completion the shell echoed after a tab. A bell or a
+// list of candidates completes nothing that can be told apart from the prompt.
+// A password prompt makes the next line secret.
+func (e *lineEditor) output(data string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if passwordPrompt.MatchString(data) {
+		e.secret = true
+	} else if strings.ContainsAny(data, "\r\n") {
+		e.secret = false
+	}
+	if !e.completing {
+		return
+	}
+	e.completing = false
+	if strings.ContainsAny(data, "\a\r\n\x1b\b") {
+		return
+	}
+	var completion []rune
+	for _, r := range data {
+		if unicode.IsPrint(r) {
+			completion = append(completion, r)
+		}
+	}
+	e.insert(completion)
+}
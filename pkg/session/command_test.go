@@ -0,0 +1,253 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package session
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func lines(commands []Command) []string {
+	var lines []string
+	for _, c := range commands {
+		lines = append(lines, c.Line)
+	}
+	return lines
+}
+
+func TestLineEditorKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want []string
+	}{
+		{"plain", "ls -la\r", []string{"ls -la"}},
+		{"newline", "ls\n", []string{"ls"}},
+		{"several lines", "ls\rpwd\r", []string{"ls", "pwd"}},
+		{"blank lines", "\r  \r\n", nil},
+		{"no enter", "ls", nil},
+		{"trimmed", "  ls  \r", []string{"ls"}},
+		{"backspace", "lss\x7f\r", []string{"ls"}},
+		{"ctrl-h", "lss\b\r", []string{"ls"}},
+		{"backspace at start", "\x7fls\r", []string{"ls"}},
+		{"left arrow", "ls\x1b[D\x1b[Dx\r", []string{"xls"}},
+		{"application left arrow", "ls\x1bODx\r", []string{"lxs"}},
+		{"right arrow", "ls\x01\x1b[Cx\r", []string{"lxs"}},
+		{"ctrl-b and ctrl-f", "abc\x02\x02\x06x\r", []string{"abxc"}},
+		{"home", "world\x1b[Hhello \r", []string{"hello world"}},
+		{"ctrl-a", "world\x01hello \r", []string{"hello world"}},
+		{"end", "ab\x01x\x1b[Fy\r", []string{"xaby"}},
+		{"ctrl-e", "ab\x01x\x05y\r", []string{"xaby"}},
+		{"delete", "abc\x1b[D\x1b[3~\r", []string{"ab"}},
+		{"ctrl-d", "abc\x02\x02\x04\r", []string{"ac"}},
+		{"delete at end", "abc\x1b[3~\r", []string{"abc"}},
+		{"ctrl-u", "rm -rf /tmp\x15ls\r", []string{"ls"}},
+		{"ctrl-u keeps after the cursor", "xxls\x02\x02\x15\r", []string{"ls"}},
+		{"ctrl-k", "abc\x01\x06\x0b\r", []string{"a"}},
+		{"ctrl-w", "git commit\x17push\r", []string{"git push"}},
+		{"ctrl-w with trailing spaces", "git commit  \x17push\r", []string{"git push"}},
+		{"alt-b", "one two\x1bbX\r", []string{"one Xtwo"}},
+		{"ctrl-left", "one two\x1b[1;5DX\r", []string{"one Xtwo"}},
+		{"alt-f", "one two\x01\x1bfX\r", []string{"oneX two"}},
+		{"ctrl-right", "one two\x01\x1b[1;5CX\r", []string{"oneX two"}},
+		{"ctrl-c", "sleep 100\x03ls\r", []string{"ls"}},
+		{"unknown escape", "\x1b[5~ls\x1b[15~\r", []string{"ls"}},
+		{"control characters", "l\x07s\x00\r", []string{"ls"}},
+		{"unicode", "echo héllo wörld\r", []string{"echo héllo wörld"}},
+		{"bracketed paste", "\x1b[200~echo a\necho b\x1b[201~\r", []string{"echo a\necho b"}},
+		{"bracketed paste with carriage returns", "\x1b[200~a\rb\x1b[201~\r", []string{"a\nb"}},
+		{"enter after paste", "\x1b[200~ls\x1b[201~\rpwd\r", []string{"ls", "pwd"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &lineEditor{}
+			got := lines(e.input(time.Now(), tt.in))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("input(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLineEditorChunks(t *testing.T) {
+	e := &lineEditor{}
+	var got []string
+	for _, chunk := range []string{"ab", "\x1b", "[", "D", "x\r", "\x1b[20", "0~a\nb", "\x1b[201", "~\r"} {
+		got = append(got, lines(e.input(time.Now(), chunk))...)
+	}
+	if want := []string{"axb", "a\nb"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("commands = %q, want %q", got, want)
+	}
+}
+
+func TestLineEditorKeystrokes(t *testing.T) {
+	e := &lineEditor{}
+	now := time.Now()
+	commands := e.input(now, "lss\x7f\x1b[D\r")
+	if len(commands) != 1 {
+		t.Fatalf("got %d commands", len(commands))
+	}
+	if c := commands[0]; c.Line != "ls" || c.Keystrokes != "lss\x7f\x1b[D\r" || !c.Time.Equal(now) || c.Secret {
+		t.Errorf("command = %+v", c)
+	}
+	// the keystrokes of the next line start afresh
+	if c := e.input(now, "pwd\r"); c[0].Keystrokes != "pwd\r" {
+		t.Errorf("keystrokes = %q", c[0].Keystrokes)
+	}
+
+	long := strings.Repeat("a", maxKeystrokes+100)
+	c := e.input(now, long+"\r")
+	if len(c) != 1 || c[0].Line != long || len(c[0].Keystrokes) != maxKeystrokes {
+		t.Errorf("long line gave %d commands, keystrokes of %d", len(c), len(c[0].Keystrokes))
+	}
+}
+
+func TestLineEditorHistory(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []string
+		want []string
+	}{
+		{"up", []string{"ls\r", "pwd\r", "\x1b[A\r"}, []string{"ls", "pwd", "pwd"}},
+		{"up twice", []string{"ls\r", "pwd\r", "\x1b[A\x1b[A\r"}, []string{"ls", "pwd", "ls"}},
+		{"up past the oldest", []string{"ls\r", "\x1b[A\x1b[A\x1b[A\r"}, []string{"ls", "ls"}},
+		{"up and down", []string{"ls\r", "pwd\r", "\x1b[A\x1b[A\x1b[B\r"}, []string{"ls", "pwd", "pwd"}},
+		{"down to a new line", []string{"ls\r", "\x1b[A\x1b[B\r"}, []string{"ls"}},
+		{"ctrl-p and ctrl-n", []string{"ls\r", "pwd\r", "\x10\x10\x0e\r"}, []string{"ls", "pwd", "pwd"}},
+		{"application arrows", []string{"ls\r", "pwd\r", "\x1bOA\x1bOA\x1bOB\r"}, []string{"ls", "pwd", "pwd"}},
+		{"edit a recalled line", []string{"ls\r", "\x1b[A -l\r"}, []string{"ls", "ls -l"}},
+		{"no history", []string{"\x1b[A\r"}, nil},
+		{"blank lines are not kept", []string{"ls\r", "\r", "\x1b[A\r"}, []string{"ls", "ls"}},
+		{"ctrl-c ends recalling", []string{"ls\r", "pwd\r", "\x1b[A\x1b[A\x03\x1b[A\r"}, []string{"ls", "pwd", "pwd"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &lineEditor{}
+			var got []string
+			for _, in := range tt.in {
+				got = append(got, lines(e.input(time.Now(), in))...)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("commands = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLineEditorHistoryLimit(t *testing.T) {
+	e := &lineEditor{}
+	for i := 0; i < maxHistory+50; i++ {
+		e.input(time.Now(), fmt.Sprintf("echo %d\r", i))
+	}
+	got := lines(e.input(time.Now(), strings.Repeat("\x1b[A", maxHistory+10)+"\r"))
+	if want := []string{"echo 50"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("oldest command = %q, want %q", got, want)
+	}
+}
+
+func TestLineEditorCompletion(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []string // inputs, and outputs prefixed with >
+		want  string
+	}{
+		{"completed", []string{"cat /etc/pas\t", ">swd", "\r"}, "cat /etc/passwd"},
+		{"completed in the middle", []string{"cat /etc/pas -n\x1b[D\x1b[D\x1b[D\t", ">swd", "\r"}, "cat /etc/passwd -n"},
+		{"bell", []string{"ls /x\t", ">\a", "\r"}, "ls /x"},
+		{"list of candidates", []string{"ls /e\t", ">\r\netc/  exports/\r\n$ ls /e", "\r"}, "ls /e"},
+		{"only the output after the tab", []string{"ls /et\t", ">c/", ">junk", "\r"}, "ls /etc/"},
+		{"a key cancels the completion", []string{"ls /et\t", "x", ">c/", "\r"}, "ls /etx"},
+		{"output without tab", []string{"ls", ">ls", "\r"}, "ls"},
+		{"double tab", []string{"ls /et\t\t", ">c/", "\r"}, "ls /etc/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &lineEditor{}
+			var got []string
+			for _, step := range tt.steps {
+				if strings.HasPrefix(step, ">") {
+					e.output(step[1:])
+					continue
+				}
+				got = append(got, lines(e.input(time.Now(), step))...)
+			}
+			if want := []string{tt.want}; !reflect.DeepEqual(got, want) {
+				t.Errorf("commands = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestLineEditorPassword(t *testing.T) {
+	for _, prompt := range []string{"[sudo] password for alice: ", "Password:", "Enter passphrase for key '/root/.ssh/id_rsa': ", "\r\nPASSCODE: "} {
+		t.Run(prompt, func(t *testing.T) {
+			e := &lineEditor{}
+			e.input(time.Now(), "sudo ls\r")
+			e.output(prompt)
+			if !e.secret {
+				t.Fatal("password prompt was not seen")
+			}
+			c := e.input(time.Now(), "hunter2\r")
+			if len(c) != 1 || !c[0].Secret || c[0].Line != "hunter2" {
+				t.Fatalf("commands = %+v, want a secret line", c)
+			}
+			if e.secret {
+				t.Error("the line after the password is secret")
+			}
+			// the password is not recalled from the history
+			if got := lines(e.input(time.Now(), "\x1b[A\r")); !reflect.DeepEqual(got, []string{"sudo ls"}) {
+				t.Errorf("recalled %q, want the command before the password", got)
+			}
+		})
+	}
+}
+
+func TestLineEditorNotPassword(t *testing.T) {
+	e := &lineEditor{}
+	e.output("Password: ")
+	e.output("\r\nSorry, try again.\r\n$ ")
+	if e.secret {
+		t.Error("the prompt is secret after the shell printed new lines")
+	}
+	for _, out := range []string{"password changed\r\n", "Password: ok\r\n", "grep password: /etc/x\r\nmatch"} {
+		e := &lineEditor{}
+		e.output(out)
+		if e.secret {
+			t.Errorf("output %q is taken for a password prompt", out)
+		}
+	}
+}
+
+func TestSessionEditors(t *testing.T) {
+	id := fmt.Sprintf("test-%d", time.Now().UnixNano())
+	Output(id, "Password: ")
+	if !ReadingSecret(id) {
+		t.Error("session is not reading a secret")
+	}
+	if c := Input(id, time.Now(), "s3cret\r"); len(c) != 1 || !c[0].Secret {
+		t.Errorf("commands = %+v", c)
+	}
+	if ReadingSecret(id) {
+		t.Error("session is still reading a secret")
+	}
+	if ReadingSecret(id + "-other") {
+		t.Error("another session is reading a secret")
+	}
+}
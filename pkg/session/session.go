@@ -0,0 +1,598 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package session
+
+import (
+	"audit/pkg/utils/env"
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/saashqdev/kubeworkz/pkg/clog"
+)
+
+const (
+	ChunkStdin  = "stdin"
+	ChunkStdout = "stdout"
+
+	// maxSessionBytes bounds the data recorded for a session, the rest is dropped
+	maxSessionBytes = 64 << 20
+	// maxSessions bounds the sessions kept, new ones are not recorded past it
+	maxSessions = 100000
+	// maxOpenRecords bounds the record files kept open, past it a file is
+	// opened for each chunk
+	maxOpenRecords = 1000
+	// recordIdleTimeout is how long the record file of a silent session is kept open
+	recordIdleTimeout = 5 * time.Minute
+
+	indexFile      = "index.json"
+	recordSuffix   = ".log"
+	saveInterval   = time.Minute
+	expireInterval = time.Hour
+	maxLineSize    = 16 << 20
+)
+
+var ErrNotFound = errors.New("session not found")
+
+var recorder *Recorder
+
+// Session is a webconsole session, assembled from the chunks of its terminal
+type Session struct {
+	ID            string
+	User          string
+	Cluster       string
+	Namespace     string
+	Pod           string
+	ContainerUser string
+	RemoteIP      string
+	UserAgent     string
+	Platform      string
+	// StartTime and EndTime are the times of the first and the last chunk, in seconds
+	StartTime int64
+	EndTime   int64
+	// Duration is the length of the session in seconds
+	Duration int64
+	Chunks   int64
+	Bytes    int64
+	// Truncated tells the session went past the size kept for a session
+	Truncated bool
+
+	// startNano and endNano keep the chunk times at full resolution
+	startNano int64
+	endNano   int64
+	// size is the size of the record file, to tell a stale index entry
+	size int64
+}
+
+// Chunk is what the terminal of a session read or wrote at a time
+type Chunk struct {
+	// Time is in nanoseconds
+	Time int64
+	// Type is stdin or stdout
+	Type string
+	Data string
+}
+
+// Filter selects sessions, empty fields match every session
+type Filter struct {
+	User      string
+	Pod       string
+	Namespace string
+	Cluster   string
+	// sessions overlapping StartTime to EndTime are selected, in seconds
+	StartTime int64
+	EndTime   int64
+}
+
+// indexEntry is a session in the index, with what is not exported
+type indexEntry struct {
+	Session
+	StartNano int64
+	EndNano   int64
+	Size      int64
+}
+
+// Recorder records the chunks of webconsole sessions in a file per session,
+// the first line of a file holds the session and each next line a chunk
+type Recorder struct {
+	dir       string
+	retention time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]*Session
+	writers  map[string]*recordWriter
+	dirty    bool
+	// full is set while maxSessions are recorded, so that it is logged once
+	full bool
+}
+
+// recordWriter appends the chunks of one session to its record file, so that
+// sessions are written without holding up each other
+type recordWriter struct {
+	mu       sync.Mutex
+	lastUsed time.Time
+	file     *os.File
+	// cached tells the writer is kept by the recorder, its file stays open
+	cached bool
+	// closed is set once the recorder dropped the writer, a new one is used then
+	closed bool
+}
+
+// Start loads the recorded sessions, saves their index periodically and
+// removes the sessions past their retention
+func Start() {
+	r := &Recorder{
+		dir:       env.SessionPath(),
+		retention: time.Duration(env.SessionRetentionDays()) * 24 * time.Hour,
+		sessions:  make(map[string]*Session),
+		writers:   make(map[string]*recordWriter),
+	}
+	if err := r.load(); err != nil {
+		clog.Error("load webconsole sessions from %s error: %s", r.dir, err)
+		return
+	}
+	go r.save()
+	if r.retention > 0 {
+		go r.expire()
+	}
+	recorder = r
+}
+
+// Record adds a chunk to a session, the session is created from meta on its first chunk
+func Record(meta Session, chunk Chunk) {
+	if recorder == nil || meta.ID == "" {
+		return
+	}
+	if err := recorder.record(meta, chunk); err != nil {
+		clog.Error("record webconsole session %s error: %s", meta.ID, err)
+	}
+}
+
+// List returns the sessions selected by the filter, newest first
+func List(f Filter) []Session {
+	if recorder == nil {
+		return []Session{}
+	}
+	return recorder.list(f)
+}
+
+// Get returns a session and its chunks in time order
+func Get(id string) (*Session, []Chunk, error) {
+	if recorder == nil {
+		return nil, nil, ErrNotFound
+	}
+	return recorder.get(id)
+}
+
+// Info returns a session without its chunks
+func Info(id string) (*Session, error) {
+	if recorder == nil {
+		return nil, ErrNotFound
+	}
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	s, ok := recorder.sessions[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	copied := *s
+	return &copied, nil
+}
+
+func (r *Recorder) record(meta Session, chunk Chunk) error {
+	if chunk.Time == 0 {
+		chunk.Time = time.Now().UnixNano()
+	}
+	// the chunks of a session are written in turn under the lock of its writer,
+	// the lock of the recorder is only held to read and update the sessions
+	var w *recordWriter
+	for {
+		if w = r.writer(meta.ID); w == nil {
+			return nil
+		}
+		w.mu.Lock()
+		if !w.closed {
+			break
+		}
+		w.mu.Unlock()
+	}
+	defer w.mu.Unlock()
+
+	r.mu.Lock()
+	s, ok := r.sessions[meta.ID]
+	var lines []byte
+	if !ok {
+		s = &Session{
+			ID:            meta.ID,
+			User:          meta.User,
+			Cluster:       meta.Cluster,
+			Namespace:     meta.Namespace,
+			Pod:           meta.Pod,
+			ContainerUser: meta.ContainerUser,
+			RemoteIP:      meta.RemoteIP,
+			UserAgent:     meta.UserAgent,
+			Platform:      meta.Platform,
+		}
+		header, err := json.Marshal(s)
+		if err != nil {
+			r.mu.Unlock()
+			return err
+		}
+		lines = append(header, '\n')
+	}
+	if s.Truncated {
+		r.mu.Unlock()
+		return nil
+	}
+	if s.Bytes+int64(len(chunk.Data)) > maxSessionBytes {
+		s.Truncated = true
+		r.dirty = true
+		r.mu.Unlock()
+		return nil
+	}
+	r.mu.Unlock()
+
+	line, err := json.Marshal(chunk)
+	if err != nil {
+		return err
+	}
+	lines = append(lines, line...)
+	lines = append(lines, '\n')
+	n, err := w.write(r.dir, r.recordPath(meta.ID), lines)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !ok {
+		r.sessions[s.ID] = s
+	}
+	s.size += int64(n)
+	if err != nil {
+		return err
+	}
+	s.add(chunk)
+	r.dirty = true
+	return nil
+}
+
+// writer returns the writer of a session, nil when the session is not recorded
+// because maxSessions are
+func (r *Recorder) writer(id string) *recordWriter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.sessions[id]; !ok && len(r.sessions) >= maxSessions {
+		if !r.full {
+			r.full = true
+			clog.Warn("%d webconsole sessions are recorded, new sessions are not recorded until some expire", len(r.sessions))
+		}
+		return nil
+	}
+	w, ok := r.writers[id]
+	if !ok {
+		w = &recordWriter{}
+		if len(r.writers) < maxOpenRecords {
+			w.cached = true
+			r.writers[id] = w
+		}
+	}
+	w.lastUsed = time.Now()
+	return w
+}
+
+// write appends lines to the record file, which is opened on the first write.
+// The file is closed after a failed write, so that the next one opens it again.
+func (w *recordWriter) write(dir, path string, lines []byte) (int, error) {
+	if w.file == nil {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return 0, err
+		}
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return 0, err
+		}
+		w.file = f
+	}
+	n, err := w.file.Write(lines)
+	if err != nil || !w.cached {
+		if closeErr := w.close(); err == nil {
+			err = closeErr
+		}
+	}
+	return n, err
+}
+
+func (w *recordWriter) close() error {
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+// dropWriters closes the record files of the writers selected by drop and
+// forgets them
+func (r *Recorder) dropWriters(drop func(id string, w *recordWriter) bool) {
+	var dropped []*recordWriter
+	r.mu.Lock()
+	for id, w := range r.writers {
+		if drop(id, w) {
+			dropped = append(dropped, w)
+			delete(r.writers, id)
+		}
+	}
+	r.mu.Unlock()
+	for _, w := range dropped {
+		w.mu.Lock()
+		w.closed = true
+		if err := w.close(); err != nil {
+			clog.Error("close webconsole session record error: %s", err)
+		}
+		w.mu.Unlock()
+	}
+}
+
+// add counts a chunk in the session
+func (s *Session) add(chunk Chunk) {
+	if s.startNano == 0 || chunk.Time < s.startNano {
+		s.startNano = chunk.Time
+	}
+	if chunk.Time > s.endNano {
+		s.endNano = chunk.Time
+	}
+	s.StartTime = s.startNano / int64(time.Second)
+	s.EndTime = s.endNano / int64(time.Second)
+	s.Duration = (s.endNano - s.startNano) / int64(time.Second)
+	s.Chunks++
+	s.Bytes += int64(len(chunk.Data))
+}
+
+func (r *Recorder) list(f Filter) []Session {
+	r.mu.Lock()
+	sessions := make([]Session, 0)
+	for _, s := range r.sessions {
+		if f.match(s) {
+			sessions = append(sessions, *s)
+		}
+	}
+	r.mu.Unlock()
+	sort.Slice(sessions, func(i, j int) bool {
+		if sessions[i].startNano != sessions[j].startNano {
+			return sessions[i].startNano > sessions[j].startNano
+		}
+		return sessions[i].ID < sessions[j].ID
+	})
+	return sessions
+}
+
+func (f Filter) match(s *Session) bool {
+	if (f.User != "" && s.User != f.User) || (f.Pod != "" && s.Pod != f.Pod) ||
+		(f.Namespace != "" && s.Namespace != f.Namespace) || (f.Cluster != "" && s.Cluster != f.Cluster) {
+		return false
+	}
+	if f.StartTime > 0 && s.EndTime < f.StartTime {
+		return false
+	}
+	if f.EndTime > 0 && s.StartTime > f.EndTime {
+		return false
+	}
+	return true
+}
+
+func (r *Recorder) get(id string) (*Session, []Chunk, error) {
+	r.mu.Lock()
+	s, ok := r.sessions[id]
+	var copied Session
+	if ok {
+		copied = *s
+	}
+	r.mu.Unlock()
+	if !ok {
+		return nil, nil, ErrNotFound
+	}
+
+	_, chunks, err := readRecord(r.recordPath(id))
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.SliceStable(chunks, func(i, j int) bool {
+		return chunks[i].Time < chunks[j].Time
+	})
+	return &copied, chunks, nil
+}
+
+// recordPath names the file of a session by a hash of its id, which comes from the webconsole
+func (r *Recorder) recordPath(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return filepath.Join(r.dir, hex.EncodeToString(sum[:])+recordSuffix)
+}
+
+// readRecord reads the session and the chunks of a record file, a partly
+// written last line is ignored
+func readRecord(path string) (*Session, []Chunk, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	if !scanner.Scan() {
+		if err = scanner.Err(); err == nil {
+			err = errors.New("empty session record")
+		}
+		return nil, nil, err
+	}
+	s := &Session{}
+	if err = json.Unmarshal(scanner.Bytes(), s); err != nil {
+		return nil, nil, err
+	}
+	var chunks []Chunk
+	for scanner.Scan() {
+		var chunk Chunk
+		if err := json.Unmarshal(scanner.Bytes(), &chunk); err != nil {
+			continue
+		}
+		chunks = append(chunks, chunk)
+	}
+	return s, chunks, scanner.Err()
+}
+
+// load reads the index, and the record files it is missing or out of date with
+func (r *Recorder) load() error {
+	if err := os.MkdirAll(r.dir, 0755); err != nil {
+		return err
+	}
+	indexed := make(map[string]*Session)
+	bs, err := ioutil.ReadFile(filepath.Join(r.dir, indexFile))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		var entries []indexEntry
+		if err = json.Unmarshal(bs, &entries); err != nil {
+			clog.Error("unmarshal webconsole session index error: %s", err)
+		}
+		for _, e := range entries {
+			s := e.Session
+			s.startNano, s.endNano, s.size = e.StartNano, e.EndNano, e.Size
+			indexed[filepath.Base(r.recordPath(s.ID))] = &s
+		}
+	}
+
+	files, err := ioutil.ReadDir(r.dir)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), recordSuffix) {
+			continue
+		}
+		if s, ok := indexed[f.Name()]; ok && s.size == f.Size() {
+			r.sessions[s.ID] = s
+			continue
+		}
+		s, chunks, err := readRecord(filepath.Join(r.dir, f.Name()))
+		if err != nil {
+			clog.Error("read webconsole session %s error: %s", f.Name(), err)
+			continue
+		}
+		if indexed, ok := indexed[f.Name()]; ok {
+			s.Truncated = indexed.Truncated
+		}
+		s.StartTime, s.EndTime, s.Duration, s.Chunks, s.Bytes = 0, 0, 0, 0, 0
+		for _, chunk := range chunks {
+			s.add(chunk)
+		}
+		s.size = f.Size()
+		r.sessions[s.ID] = s
+		r.dirty = true
+	}
+	return nil
+}
+
+func (r *Recorder) save() {
+	ticker := time.NewTicker(saveInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := r.persist(); err != nil {
+			clog.Error("save webconsole session index to %s error: %s", r.dir, err)
+			r.mu.Lock()
+			r.dirty = true
+			r.mu.Unlock()
+		}
+		now := time.Now()
+		r.dropWriters(func(_ string, w *recordWriter) bool {
+			return now.Sub(w.lastUsed) > recordIdleTimeout
+		})
+	}
+}
+
+// persist writes the index of the sessions when they changed
+func (r *Recorder) persist() error {
+	r.mu.Lock()
+	if !r.dirty {
+		r.mu.Unlock()
+		return nil
+	}
+	entries := make([]indexEntry, 0, len(r.sessions))
+	for _, s := range r.sessions {
+		entries = append(entries, indexEntry{Session: *s, StartNano: s.startNano, EndNano: s.endNano, Size: s.size})
+	}
+	bs, err := json.Marshal(entries)
+	r.dirty = false
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(r.dir, indexFile)
+	if err = ioutil.WriteFile(path+".tmp", bs, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// expire removes the sessions which ended before the retention
+func (r *Recorder) expire() {
+	ticker := time.NewTicker(expireInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		r.removeExpired()
+	}
+}
+
+func (r *Recorder) removeExpired() {
+	before := time.Now().Add(-r.retention).Unix()
+	expired := make(map[string]bool)
+	r.mu.Lock()
+	for id, s := range r.sessions {
+		if s.EndTime < before {
+			expired[id] = true
+		}
+	}
+	r.mu.Unlock()
+	if len(expired) == 0 {
+		return
+	}
+	r.dropWriters(func(id string, _ *recordWriter) bool {
+		return expired[id]
+	})
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id := range expired {
+		if err := os.Remove(r.recordPath(id)); err != nil && !os.IsNotExist(err) {
+			clog.Error("remove expired webconsole session %s error: %s", id, err)
+			continue
+		}
+		delete(r.sessions, id)
+		r.dirty = true
+	}
+	if len(r.sessions) < maxSessions {
+		r.full = false
+	}
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package session
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestRecorder(t *testing.T) *Recorder {
+	return &Recorder{
+		dir:       t.TempDir(),
+		retention: 24 * time.Hour,
+		sessions:  make(map[string]*Session),
+		writers:   make(map[string]*recordWriter),
+	}
+}
+
+func TestRecordConcurrently(t *testing.T) {
+	r := newTestRecorder(t)
+	const sessions, chunks = 8, 50
+	var wg sync.WaitGroup
+	for i := 0; i < sessions; i++ {
+		for j := 0; j < 2; j++ {
+			wg.Add(1)
+			go func(id string, j int) {
+				defer wg.Done()
+				for k := 0; k < chunks; k++ {
+					chunk := Chunk{Time: int64(1+j*chunks+k) * int64(time.Second), Type: ChunkStdout, Data: "x"}
+					if err := r.record(Session{ID: id, User: "alice"}, chunk); err != nil {
+						t.Error(err)
+					}
+				}
+			}(fmt.Sprintf("s%d", i), j)
+		}
+	}
+	wg.Wait()
+
+	if len(r.writers) != sessions {
+		t.Errorf("%d record files are open, want %d", len(r.writers), sessions)
+	}
+	for i := 0; i < sessions; i++ {
+		s, recorded, err := r.get(fmt.Sprintf("s%d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Chunks != 2*chunks || len(recorded) != 2*chunks || s.User != "alice" {
+			t.Errorf("session %s has %d chunks, %d recorded", s.ID, s.Chunks, len(recorded))
+		}
+		if s.Duration != 2*chunks-1 {
+			t.Errorf("session %s lasts %d seconds", s.ID, s.Duration)
+		}
+	}
+}
+
+func TestRecordReopen(t *testing.T) {
+	r := newTestRecorder(t)
+	meta := Session{ID: "s1"}
+	if err := r.record(meta, Chunk{Time: 1, Data: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	r.dropWriters(func(string, *recordWriter) bool { return true })
+	if len(r.writers) != 0 {
+		t.Fatal("writers are not dropped")
+	}
+	if err := r.record(meta, Chunk{Time: 2, Data: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	s, chunks, err := r.get("s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Chunks != 2 || len(chunks) != 2 || chunks[1].Data != "b" {
+		t.Errorf("session = %+v, chunks = %+v", s, chunks)
+	}
+	if info, err := os.Stat(r.recordPath("s1")); err != nil || info.Size() != s.size {
+		t.Errorf("record file size = %v, %v, want %d", info, err, s.size)
+	}
+}
+
+func TestRecordTruncated(t *testing.T) {
+	r := newTestRecorder(t)
+	meta := Session{ID: "s1"}
+	big := strings.Repeat("x", maxSessionBytes/2)
+	for i := 0; i < 3; i++ {
+		if err := r.record(meta, Chunk{Time: int64(i + 1), Data: big}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s := r.sessions["s1"]; s == nil || !s.Truncated || s.Chunks != 2 {
+		t.Errorf("session = %+v, want 2 chunks and truncated", s)
+	}
+}
+
+func TestRecordMaxSessions(t *testing.T) {
+	r := newTestRecorder(t)
+	for i := 0; i < maxSessions; i++ {
+		id := fmt.Sprint(i)
+		r.sessions[id] = &Session{ID: id}
+	}
+	if err := r.record(Session{ID: "new"}, Chunk{Time: 1, Data: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := r.sessions["new"]; ok || !r.full {
+		t.Errorf("session past maxSessions is recorded, full = %v", r.full)
+	}
+	// known sessions are still recorded
+	if err := r.record(Session{ID: "1"}, Chunk{Time: time.Now().UnixNano(), Data: "a"}); err != nil || r.sessions["1"].Chunks != 1 {
+		t.Errorf("known session is not recorded: %v", err)
+	}
+
+	r.removeExpired()
+	if r.full || len(r.sessions) != 1 {
+		t.Errorf("%d sessions are left after expiry, full = %v", len(r.sessions), r.full)
+	}
+}
+
+func TestRemoveExpired(t *testing.T) {
+	r := newTestRecorder(t)
+	old := time.Now().Add(-48 * time.Hour).UnixNano()
+	for id, t0 := range map[string]int64{"old": old, "new": time.Now().UnixNano()} {
+		if err := r.record(Session{ID: id}, Chunk{Time: t0, Data: "a"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r.removeExpired()
+	if _, ok := r.sessions["old"]; ok {
+		t.Error("expired session is kept")
+	}
+	if _, ok := r.writers["old"]; ok {
+		t.Error("record file of the expired session is kept open")
+	}
+	if _, err := os.Stat(r.recordPath("old")); !os.IsNotExist(err) {
+		t.Errorf("record file of the expired session is not removed: %v", err)
+	}
+	if _, _, err := r.get("new"); err != nil {
+		t.Errorf("session within the retention is removed: %v", err)
+	}
+}
@@ -0,0 +1,223 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	v1 "audit/pkg/backend/v1"
+	"sort"
+)
+
+// ErrorStatus is the lowest response status counted as an error
+const ErrorStatus = 400
+
+// aggregatableFields are the fields top values and distinct counts can be computed for
+var aggregatableFields = map[Field]bool{
+	FieldUser:         true,
+	FieldIP:           true,
+	FieldVerb:         true,
+	FieldEventName:    true,
+	FieldEventType:    true,
+	FieldResourceName: true,
+	FieldResourceType: true,
+	FieldStatus:       true,
+	FieldErrorCode:    true,
+	FieldUserAgent:    true,
+	FieldFinding:      true,
+	FieldSeverity:     true,
+}
+
+// Aggregatable reports whether top values and distinct counts can be computed for the field
+func (f Field) Aggregatable() bool {
+	return aggregatableFields[f]
+}
+
+// Aggregation asks for statistics over the events matching a query
+type Aggregation struct {
+	// Interval of the histogram in seconds, no histogram when 0
+	Interval int64
+	// Top are the fields to find the most frequent values of
+	Top []Field
+	// TopSize is how many values are returned for each top field
+	TopSize int
+	// Distinct are the fields to count the distinct values of
+	Distinct []Field
+}
+
+// Statistics are the result of an aggregation
+type Statistics struct {
+	Total     int64
+	Histogram []HistogramBucket
+	Top       []TopValues
+	Distinct  []DistinctCount
+}
+
+// HistogramBucket counts the events and errors of one interval, starting at Time
+type HistogramBucket struct {
+	Time   int64
+	Count  int64
+	Errors int64
+	// ErrorRate is Errors divided by Count, 0 for an empty bucket
+	ErrorRate float64
+}
+
+type TopValues struct {
+	Field  Field
+	Values []ValueCount
+}
+
+type ValueCount struct {
+	Value string
+	Count int64
+}
+
+// DistinctCount is the number of distinct values of a field, stores may approximate it
+type DistinctCount struct {
+	Field Field
+	Count int64
+}
+
+// BucketTime returns the start of the histogram bucket holding t, buckets
+// are aligned on multiples of the interval since the epoch
+func BucketTime(t, interval int64) int64 {
+	if t < 0 {
+		return (t - interval + 1) / interval * interval
+	}
+	return t / interval * interval
+}
+
+// FillHistogram sorts the buckets, adds the empty ones between them and up to
+// the query time range, and computes the error rates
+func FillHistogram(buckets []HistogramBucket, query *Query, interval int64) []HistogramBucket {
+	byTime := make(map[int64]HistogramBucket, len(buckets))
+	var first, last int64
+	for i, b := range buckets {
+		byTime[b.Time] = b
+		if i == 0 || b.Time < first {
+			first = b.Time
+		}
+		if i == 0 || b.Time > last {
+			last = b.Time
+		}
+	}
+	if query.StartTime > 0 && (len(buckets) == 0 || BucketTime(query.StartTime, interval) < first) {
+		first = BucketTime(query.StartTime, interval)
+	}
+	if query.EndTime > 0 && (len(buckets) == 0 || BucketTime(query.EndTime, interval) > last) {
+		last = BucketTime(query.EndTime, interval)
+	}
+	if len(buckets) == 0 && (query.StartTime <= 0 || query.EndTime <= 0) {
+		return []HistogramBucket{}
+	}
+
+	filled := make([]HistogramBucket, 0, (last-first)/interval+1)
+	for t := first; t <= last; t += interval {
+		b := byTime[t]
+		b.Time = t
+		if b.Count > 0 {
+			b.ErrorRate = float64(b.Errors) / float64(b.Count)
+		}
+		filled = append(filled, b)
+	}
+	return filled
+}
+
+// Aggregator computes statistics in memory, for stores that read events themselves
+type Aggregator struct {
+	agg       *Aggregation
+	total     int64
+	histogram map[int64]*HistogramBucket
+	top       map[Field]map[string]int64
+}
+
+func NewAggregator(agg *Aggregation) *Aggregator {
+	a := &Aggregator{
+		agg:       agg,
+		histogram: make(map[int64]*HistogramBucket),
+		top:       make(map[Field]map[string]int64),
+	}
+	for _, f := range append(append([]Field{}, agg.Top...), agg.Distinct...) {
+		a.top[f] = make(map[string]int64)
+	}
+	return a
+}
+
+// Add counts an event
+func (a *Aggregator) Add(event *v1.Event) {
+	a.total++
+	if a.agg.Interval > 0 {
+		t := BucketTime(event.EventTime, a.agg.Interval)
+		b, ok := a.histogram[t]
+		if !ok {
+			b = &HistogramBucket{Time: t}
+			a.histogram[t] = b
+		}
+		b.Count++
+		if event.ResponseStatus >= ErrorStatus {
+			b.Errors++
+		}
+	}
+	for f, counts := range a.top {
+		values := FieldValues(f, event)
+		// an event counts once for each distinct value
+		for i, value := range values {
+			if !containsString(values[:i], value) {
+				counts[value]++
+			}
+		}
+	}
+}
+
+// Statistics returns the statistics of the events added so far
+func (a *Aggregator) Statistics(query *Query) *Statistics {
+	stats := &Statistics{Total: a.total, Top: []TopValues{}, Distinct: []DistinctCount{}}
+	if a.agg.Interval > 0 {
+		buckets := make([]HistogramBucket, 0, len(a.histogram))
+		for _, b := range a.histogram {
+			buckets = append(buckets, *b)
+		}
+		stats.Histogram = FillHistogram(buckets, query, a.agg.Interval)
+	}
+	for _, f := range a.agg.Top {
+		values := make([]ValueCount, 0, len(a.top[f]))
+		for value, count := range a.top[f] {
+			values = append(values, ValueCount{Value: value, Count: count})
+		}
+		sort.Slice(values, func(i, j int) bool {
+			if values[i].Count != values[j].Count {
+				return values[i].Count > values[j].Count
+			}
+			return values[i].Value < values[j].Value
+		})
+		if len(values) > a.agg.TopSize {
+			values = values[:a.agg.TopSize]
+		}
+		stats.Top = append(stats.Top, TopValues{Field: f, Values: values})
+	}
+	for _, f := range a.agg.Distinct {
+		stats.Distinct = append(stats.Distinct, DistinctCount{Field: f, Count: int64(len(a.top[f]))})
+	}
+	return stats
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
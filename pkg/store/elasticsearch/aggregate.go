@@ -0,0 +1,160 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package elasticsearch
+
+import (
+	"audit/pkg/store"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/olivere/elastic/v7"
+)
+
+const (
+	histogramAggregation = "histogram"
+	errorsAggregation    = "errors"
+	topAggregation       = "top_"
+	distinctAggregation  = "distinct_"
+)
+
+// aggregationPaths maps fields to the keyword fields their values are aggregated
+// on, in the mapping of the indexes the store creates
+var aggregationPaths = map[store.Field]string{
+	store.FieldUser:         "UserIdentity.AccountId",
+	store.FieldIP:           "SourceIpAddress",
+	store.FieldVerb:         "RequestMethod",
+	store.FieldEventName:    "EventName.keyword",
+	store.FieldEventType:    "EventType",
+	store.FieldResourceName: "ResourceReports.ResourceName.keyword",
+	store.FieldResourceType: "ResourceReports.ResourceType",
+	store.FieldStatus:       "ResponseStatus",
+	store.FieldErrorCode:    "ErrorCode",
+	store.FieldUserAgent:    "UserAgent.keyword",
+	store.FieldFinding:      "Findings.Detection",
+	store.FieldSeverity:     "Findings.Severity",
+}
+
+type aggregateResponse struct {
+	Hits struct {
+		Total json.RawMessage `json:"total"`
+	} `json:"hits"`
+	Aggregations elastic.Aggregations `json:"aggregations"`
+}
+
+func (s *Store) Aggregate(query *store.Query, agg *store.Aggregation) (*store.Statistics, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), SearchTimeout)
+	defer cancel()
+
+	c, err := s.getCluster(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	searchSource := elastic.NewSearchSource().Query(buildQuery(query)).Size(0)
+	if c.tracksTotalHits() {
+		searchSource.TrackTotalHits(true)
+	}
+	if agg.Interval > 0 {
+		histogram := elastic.NewHistogramAggregation().
+			Field("EventTime").
+			Interval(float64(agg.Interval)).
+			MinDocCount(0).
+			SubAggregation(errorsAggregation, elastic.NewFilterAggregation().
+				Filter(elastic.NewRangeQuery("ResponseStatus").Gte(store.ErrorStatus)))
+		searchSource.Aggregation(histogramAggregation, histogram)
+	}
+	for _, f := range agg.Top {
+		searchSource.Aggregation(topAggregation+string(f), elastic.NewTermsAggregation().
+			Field(s.keywordField(aggregationPaths[f])).
+			Size(agg.TopSize))
+	}
+	for _, f := range agg.Distinct {
+		searchSource.Aggregation(distinctAggregation+string(f), elastic.NewCardinalityAggregation().
+			Field(s.keywordField(aggregationPaths[f])))
+	}
+	body, err := searchSource.Source()
+	if err != nil {
+		return nil, err
+	}
+
+	res := &aggregateResponse{}
+	code, err := s.do(ctx, http.MethodPost, "/"+s.index+"/_search", body, res)
+	if err != nil {
+		return nil, fmt.Errorf("aggregate audit log from es error: %s", err)
+	}
+	stats := &store.Statistics{Top: []store.TopValues{}, Distinct: []store.DistinctCount{}}
+	if code == http.StatusNotFound {
+		if agg.Interval > 0 {
+			stats.Histogram = store.FillHistogram(nil, query, agg.Interval)
+		}
+		return stats, nil
+	}
+	if code != http.StatusOK {
+		return nil, fmt.Errorf("aggregate audit log from es error[%d]", code)
+	}
+	if stats.Total, err = parseTotalHits(res.Hits.Total); err != nil {
+		return nil, err
+	}
+
+	if agg.Interval > 0 {
+		var buckets []store.HistogramBucket
+		if items, ok := res.Aggregations.Histogram(histogramAggregation); ok {
+			for _, item := range items.Buckets {
+				b := store.HistogramBucket{Time: int64(item.Key), Count: item.DocCount}
+				if errorBucket, ok := item.Aggregations.Filter(errorsAggregation); ok {
+					b.Errors = errorBucket.DocCount
+				}
+				buckets = append(buckets, b)
+			}
+		}
+		stats.Histogram = store.FillHistogram(buckets, query, agg.Interval)
+	}
+	for _, f := range agg.Top {
+		top := store.TopValues{Field: f, Values: []store.ValueCount{}}
+		if items, ok := res.Aggregations.Terms(topAggregation + string(f)); ok {
+			for _, item := range items.Buckets {
+				top.Values = append(top.Values, store.ValueCount{Value: keyString(item), Count: item.DocCount})
+			}
+		}
+		stats.Top = append(stats.Top, top)
+	}
+	for _, f := range agg.Distinct {
+		distinct := store.DistinctCount{Field: f}
+		if metric, ok := res.Aggregations.Cardinality(distinctAggregation + string(f)); ok && metric.Value != nil {
+			distinct.Count = int64(*metric.Value)
+		}
+		stats.Distinct = append(stats.Distinct, distinct)
+	}
+	return stats, nil
+}
+
+// keyString returns the key of a terms bucket, numbers come without key_as_string
+func keyString(item *elastic.AggregationBucketKeyItem) string {
+	if item.KeyAsString != nil {
+		return *item.KeyAsString
+	}
+	switch key := item.Key.(type) {
+	case string:
+		return key
+	case float64:
+		return strconv.FormatFloat(key, 'f', -1, 64)
+	}
+	return fmt.Sprint(item.Key)
+}
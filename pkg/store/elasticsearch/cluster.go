@@ -0,0 +1,310 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package elasticsearch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/saashqdev/kubeworkz/pkg/clog"
+)
+
+const (
+	distributionElasticsearch = "elasticsearch"
+	distributionOpenSearch    = "opensearch"
+)
+
+// cluster describes the flavour and version of the search cluster,
+// which decide the document endpoints, mappings and search requests to use
+type cluster struct {
+	distribution string
+	version      string
+	major        int
+	minor        int
+}
+
+func (c *cluster) String() string {
+	return c.distribution + " " + c.version
+}
+
+// usesMappingTypes reports whether documents and mappings are still
+// addressed by type, which elasticsearch deprecated in 7 and removed in 8
+func (c *cluster) usesMappingTypes() bool {
+	return c.distribution == distributionElasticsearch && c.major < 7
+}
+
+// atLeast reports whether the cluster version is major.minor or newer
+func (c *cluster) atLeast(major, minor int) bool {
+	return c.major > major || c.major == major && c.minor >= minor
+}
+
+// supportsPointInTime reports whether searches can run against a point in
+// time, added in elasticsearch 7.10 and opensearch 2.4 with different APIs
+func (c *cluster) supportsPointInTime() bool {
+	if c.distribution == distributionOpenSearch {
+		return c.atLeast(2, 4)
+	}
+	return c.atLeast(7, 10)
+}
+
+// tracksTotalHits reports whether the cluster caps hits.total unless
+// track_total_hits is set, and returns it as an object
+func (c *cluster) tracksTotalHits() bool {
+	return !c.usesMappingTypes()
+}
+
+type clusterInfo struct {
+	Version struct {
+		Number       string `json:"number"`
+		Distribution string `json:"distribution"`
+	} `json:"version"`
+}
+
+// getCluster detects the cluster on first use and caches the result,
+// detection is retried on later calls until the cluster is reachable
+func (s *Store) getCluster(ctx context.Context) (*cluster, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cluster != nil {
+		return s.cluster, nil
+	}
+
+	info := &clusterInfo{}
+	code, err := s.do(ctx, http.MethodGet, "/", nil, info)
+	if err != nil {
+		return nil, fmt.Errorf("get cluster info error: %s", err)
+	}
+	if code != http.StatusOK {
+		return nil, fmt.Errorf("get cluster info error[%d]", code)
+	}
+	c, err := parseClusterInfo(info)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.ensureIndex(ctx, c); err != nil {
+		return nil, err
+	}
+	clog.Info("audit store uses %s at %s", c, s.host)
+	s.cluster = c
+	return c, nil
+}
+
+func parseClusterInfo(info *clusterInfo) (*cluster, error) {
+	c := &cluster{
+		distribution: distributionElasticsearch,
+		version:      info.Version.Number,
+	}
+	if info.Version.Distribution == distributionOpenSearch {
+		c.distribution = distributionOpenSearch
+	}
+	parts := strings.SplitN(c.version, ".", 3)
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("unknown cluster version %q", c.version)
+	}
+	c.major = major
+	if len(parts) > 1 {
+		c.minor, _ = strconv.Atoi(parts[1])
+	}
+	return c, nil
+}
+
+// ensureIndex creates the audit index with its mapping when it does not
+// exist yet, an existing index gets the fields added to the mapping since
+func (s *Store) ensureIndex(ctx context.Context, c *cluster) error {
+	code, err := s.do(ctx, http.MethodHead, "/"+s.index, nil, nil)
+	if err != nil {
+		return fmt.Errorf("check audit index error: %s", err)
+	}
+	if code == http.StatusOK {
+		s.updateMapping(ctx, c)
+		s.loadTextFields(ctx, c)
+		return nil
+	}
+
+	var mappings interface{} = eventMapping
+	if c.usesMappingTypes() {
+		mappings = map[string]interface{}{s.docType: eventMapping}
+	}
+	code, err = s.do(ctx, http.MethodPut, "/"+s.index, map[string]interface{}{"mappings": mappings}, nil)
+	if err != nil {
+		return fmt.Errorf("create audit index error: %s", err)
+	}
+	// another replica may have created the index in the meantime
+	if code/100 != 2 && code != http.StatusBadRequest {
+		return fmt.Errorf("create audit index error[%d]", code)
+	}
+	return nil
+}
+
+// updateMapping adds the fields of addedMapping to an existing index
+func (s *Store) updateMapping(ctx context.Context, c *cluster) {
+	path := "/" + s.index + "/_mapping"
+	if c.usesMappingTypes() {
+		path += "/" + s.docType
+	}
+	code, err := s.do(ctx, http.MethodPut, path, addedMapping, nil)
+	if err != nil || code/100 != 2 {
+		clog.Warn("update audit index mapping error[%d]: %v", code, err)
+	}
+}
+
+// fieldMapping is a field of the mapping of an index
+type fieldMapping struct {
+	Type       string                     `json:"type"`
+	Fields     map[string]json.RawMessage `json:"fields"`
+	Properties map[string]*fieldMapping   `json:"properties"`
+}
+
+// collectTextFields adds the paths of the text fields with a keyword subfield
+func (m *fieldMapping) collectTextFields(prefix string, fields map[string]bool) {
+	for name, p := range m.Properties {
+		path := prefix + name
+		if _, ok := p.Fields["keyword"]; ok && p.Type == "text" {
+			fields[path] = true
+		}
+		p.collectTextFields(path+".", fields)
+	}
+}
+
+// loadTextFields reads the mapping of an existing index for its text fields
+// with a keyword subfield. Indexes created before the store set their mapping
+// were mapped dynamically, with every string as such a field, which can not be
+// sorted or aggregated on.
+func (s *Store) loadTextFields(ctx context.Context, c *cluster) {
+	res := make(map[string]struct {
+		Mappings json.RawMessage `json:"mappings"`
+	})
+	code, err := s.do(ctx, http.MethodGet, "/"+s.index+"/_mapping", nil, &res)
+	if err != nil || code != http.StatusOK {
+		clog.Warn("get audit index mapping error[%d]: %v", code, err)
+		return
+	}
+	fields := make(map[string]bool)
+	for index, mapping := range res {
+		var mappings []*fieldMapping
+		if c.usesMappingTypes() {
+			types := make(map[string]*fieldMapping)
+			err = json.Unmarshal(mapping.Mappings, &types)
+			for _, m := range types {
+				mappings = append(mappings, m)
+			}
+		} else {
+			m := &fieldMapping{}
+			err = json.Unmarshal(mapping.Mappings, m)
+			mappings = append(mappings, m)
+		}
+		if err != nil {
+			clog.Warn("read mapping of index %s error: %s", index, err)
+			continue
+		}
+		for _, m := range mappings {
+			m.collectTextFields("", fields)
+		}
+	}
+	s.textFields = fields
+}
+
+// keywordField returns the keyword subfield of a field mapped as text, and other fields as they are
+func (s *Store) keywordField(path string) string {
+	if s.textFields[path] {
+		return path + ".keyword"
+	}
+	return path
+}
+
+var (
+	keyword = map[string]interface{}{"type": "keyword"}
+	text    = map[string]interface{}{"type": "text"}
+	// textKeyword is analyzed for match queries and keeps a keyword for sorting
+	textKeyword = map[string]interface{}{
+		"type":   "text",
+		"fields": map[string]interface{}{"keyword": map[string]interface{}{"type": "keyword", "ignore_above": 256}},
+	}
+
+	resourceMapping = map[string]interface{}{
+		"properties": map[string]interface{}{
+			"ResourceType": keyword,
+			"ResourceId":   keyword,
+			"ResourceName": textKeyword,
+			"Namespace":    keyword,
+			"APIVersion":   keyword,
+		},
+	}
+	// labels are kept but not indexed, so that their keys do not add fields to the mapping
+	labelsMapping = map[string]interface{}{"type": "object", "enabled": false}
+
+	findingsMapping = map[string]interface{}{
+		"properties": map[string]interface{}{
+			"Detection": keyword,
+			"Severity":  keyword,
+			"Message":   text,
+		},
+	}
+
+	// addedMapping holds the fields added after the first release, which
+	// existing indexes are updated with
+	addedMapping = map[string]interface{}{
+		"properties": map[string]interface{}{
+			"Findings":        findingsMapping,
+			"Redactions":      map[string]interface{}{"type": "integer"},
+			"EventTimeNanos":  map[string]interface{}{"type": "integer"},
+			"ResourceReports": resourceMapping,
+			"Cluster":         keyword,
+			"Namespace":       keyword,
+			"Labels":          labelsMapping,
+		},
+	}
+
+	eventMapping = map[string]interface{}{
+		"properties": map[string]interface{}{
+			"EventTime":         map[string]interface{}{"type": "long"},
+			"EventTimeNanos":    map[string]interface{}{"type": "integer"},
+			"EventVersion":      keyword,
+			"EventName":         textKeyword,
+			"Description":       text,
+			"SourceIpAddress":   keyword,
+			"UserAgent":         textKeyword,
+			"RequestId":         keyword,
+			"RequestMethod":     keyword,
+			"RequestParameters": text,
+			"ResponseStatus":    map[string]interface{}{"type": "integer"},
+			"ResponseElements":  text,
+			"EventType":         keyword,
+			"ErrorCode":         keyword,
+			"ErrorMessage":      text,
+			"Url":               map[string]interface{}{"type": "keyword", "ignore_above": 2048},
+			"UserIdentity": map[string]interface{}{
+				"properties": map[string]interface{}{
+					"AccountId": keyword,
+				},
+			},
+			"ApiAction":       keyword,
+			"ApiVersion":      keyword,
+			"ResourceReports": resourceMapping,
+			"Cluster":         keyword,
+			"Namespace":       keyword,
+			"Labels":          labelsMapping,
+			"Findings":        findingsMapping,
+			"Redactions":      map[string]interface{}{"type": "integer"},
+		},
+	}
+)
@@ -0,0 +1,93 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package elasticsearch
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// dynamicProperties is how dynamic mapping maps the string fields of an event
+const dynamicProperties = `{"properties":{
+	"SourceIpAddress":{"type":"text","fields":{"keyword":{"type":"keyword","ignore_above":256}}},
+	"ResponseStatus":{"type":"long"},
+	"UserIdentity":{"properties":{"AccountId":{"type":"text","fields":{"keyword":{"type":"keyword","ignore_above":256}}}}},
+	"RequestMethod":{"type":"keyword"},
+	"Description":{"type":"text"}
+}}`
+
+func TestLoadTextFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		cluster *cluster
+		mapping string
+		want    map[string]string
+	}{
+		{
+			name:    "dynamic mapping",
+			cluster: &cluster{distribution: distributionElasticsearch, major: 7},
+			mapping: `{"audit":{"mappings":` + dynamicProperties + `}}`,
+			want: map[string]string{
+				"UserIdentity.AccountId": "UserIdentity.AccountId.keyword",
+				"SourceIpAddress":        "SourceIpAddress.keyword",
+				"RequestMethod":          "RequestMethod",
+				"ResponseStatus":         "ResponseStatus",
+				"Description":            "Description",
+				"EventName.keyword":      "EventName.keyword",
+			},
+		},
+		{
+			name:    "dynamic mapping with types",
+			cluster: &cluster{distribution: distributionElasticsearch, major: 6},
+			mapping: `{"audit":{"mappings":{"logs":` + dynamicProperties + `}}}`,
+			want: map[string]string{
+				"UserIdentity.AccountId": "UserIdentity.AccountId.keyword",
+				"RequestMethod":          "RequestMethod",
+			},
+		},
+		{
+			name:    "mapping of the store",
+			cluster: &cluster{distribution: distributionOpenSearch, major: 2},
+			mapping: `{"audit":{"mappings":{"properties":{"UserIdentity":{"properties":{"AccountId":{"type":"keyword"}}}}}}}`,
+			want: map[string]string{
+				"UserIdentity.AccountId": "UserIdentity.AccountId",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/audit/_mapping" {
+					http.NotFound(w, r)
+					return
+				}
+				w.Write([]byte(tt.mapping))
+			}))
+			defer server.Close()
+
+			s := &Store{host: server.URL, index: "audit"}
+			s.loadTextFields(context.Background(), tt.cluster)
+			for path, want := range tt.want {
+				if got := s.keywordField(path); got != want {
+					t.Errorf("keyword field of %s = %s, want %s", path, got, want)
+				}
+			}
+		})
+	}
+}
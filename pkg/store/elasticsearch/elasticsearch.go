@@ -0,0 +1,348 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package elasticsearch
+
+import (
+	v1 "audit/pkg/backend/v1"
+	"audit/pkg/store"
+	"audit/pkg/utils/env"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+	"github.com/saashqdev/kubeworkz/pkg/clog"
+)
+
+const (
+	SearchTimeout = time.Second * 30
+	// maxResultWindow is the default index.max_result_window, offset
+	// pagination can not go past it
+	maxResultWindow        = 10000
+	pointInTimeKeepAlive   = "5m"
+	detectRetryInterval    = time.Second * 5
+	maxDetectRetryInterval = time.Minute * 5
+)
+
+// Store sends audit events to elasticsearch or opensearch and searches them.
+// Requests are plain HTTP shaped for the cluster flavour and version, which
+// are detected at startup: elasticsearch 6 to 8 and opensearch 1 and 2.
+type Store struct {
+	host        string
+	index       string
+	docType     string
+	sendTimeout time.Duration
+	client      http.Client
+
+	mu      sync.Mutex
+	cluster *cluster
+	// textFields are the fields of an existing index mapped as text with a
+	// keyword subfield, which are sorted and aggregated on the subfield
+	textFields map[string]bool
+}
+
+type searchResponse struct {
+	PitID string `json:"pit_id"`
+	Hits  struct {
+		Total json.RawMessage `json:"total"`
+		Hits  []struct {
+			Source json.RawMessage   `json:"_source"`
+			Sort   []json.RawMessage `json:"sort"`
+		} `json:"hits"`
+	} `json:"hits"`
+}
+
+func New(esWebhook *env.EsWebhook, sendTimeout time.Duration) *Store {
+	s := &Store{
+		host:        strings.TrimSuffix(esWebhook.Host, "/"),
+		index:       esWebhook.Index,
+		docType:     esWebhook.Type,
+		sendTimeout: sendTimeout,
+		client: http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{
+					InsecureSkipVerify: true,
+				},
+			},
+		},
+	}
+	go s.detect()
+	return s
+}
+
+// detect resolves the cluster at startup, so that the first batch does not wait for it
+func (s *Store) detect() {
+	interval := detectRetryInterval
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), SearchTimeout)
+		_, err := s.getCluster(ctx)
+		cancel()
+		if err == nil {
+			return
+		}
+		clog.Warn("detect audit search cluster error: %s, retry in %s", err, interval)
+		time.Sleep(interval)
+		if interval *= 2; interval > maxDetectRetryInterval {
+			interval = maxDetectRetryInterval
+		}
+	}
+}
+
+func (s *Store) Save(events []v1.Event) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.sendTimeout)
+	defer cancel()
+
+	c, err := s.getCluster(ctx)
+	if err != nil {
+		return err
+	}
+	path := "/" + s.index + "/_doc"
+	if c.usesMappingTypes() {
+		path = "/" + s.index + "/" + s.docType
+	}
+
+	for _, event := range events {
+		code, err := s.do(ctx, http.MethodPost, path, event, nil)
+		if err != nil {
+			return fmt.Errorf("send audit event error, %s", err)
+		}
+		if code != http.StatusOK && code != http.StatusCreated {
+			return fmt.Errorf("send audit event error[%d]", code)
+		}
+		clog.Debug("send event %s success", event.EventName)
+	}
+	return nil
+}
+
+func (s *Store) Search(query *store.Query) (*store.Result, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), SearchTimeout)
+	defer cancel()
+
+	c, err := s.getCluster(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	boolQ := buildQuery(query)
+
+	sortBy := query.SortBy
+	if sortBy == "" {
+		sortBy = store.DefaultSortBy
+	}
+
+	searchSource := elastic.NewSearchSource().
+		Query(boolQ).
+		Sort(s.keywordField(sortBy), query.SortAsc)
+	if c.tracksTotalHits() {
+		searchSource.TrackTotalHits(true)
+	}
+
+	result := &store.Result{}
+	path := "/" + s.index + "/_search"
+	var pitID string
+	if query.Cursor == nil {
+		if query.From+query.Size > maxResultWindow {
+			return nil, store.ErrResultWindowExceeded
+		}
+		searchSource.From(query.From).Size(query.Size)
+	} else {
+		// one extra hit tells whether another page follows
+		searchSource.Size(query.Size + 1)
+		pitID = query.Cursor.PitID
+		if pitID == "" && len(query.Cursor.SearchAfter) == 0 && c.supportsPointInTime() {
+			code, id, err := s.openPointInTime(ctx, c)
+			if err != nil {
+				return nil, err
+			}
+			if code == http.StatusNotFound {
+				return result, nil
+			}
+			pitID = id
+		}
+		if pitID != "" {
+			// a point in time adds the implicit _shard_doc tie breaker
+			path = "/_search"
+			searchSource.PointInTime(elastic.NewPointInTime(pitID, pointInTimeKeepAlive))
+		} else {
+			searchSource.Sort("_id", query.SortAsc)
+		}
+		if len(query.Cursor.SearchAfter) > 0 {
+			values := make([]interface{}, len(query.Cursor.SearchAfter))
+			for i, v := range query.Cursor.SearchAfter {
+				values[i] = v
+			}
+			searchSource.SearchAfter(values...)
+		}
+	}
+	body, err := searchSource.Source()
+	if err != nil {
+		return nil, err
+	}
+
+	res := &searchResponse{}
+	code, err := s.do(ctx, http.MethodPost, path, body, res)
+	if err != nil {
+		return nil, fmt.Errorf("search audit log from es error: %s", err)
+	}
+	if code == http.StatusNotFound {
+		if query.Cursor != nil && query.Cursor.PitID != "" {
+			return nil, store.ErrInvalidCursor
+		}
+		clog.Debug("search audit log from es error: index %s not found", s.index)
+		return result, nil
+	}
+	if code == http.StatusBadRequest && query.Cursor != nil && len(query.Cursor.SearchAfter) > 0 {
+		return nil, store.ErrInvalidCursor
+	}
+	if code != http.StatusOK {
+		return nil, fmt.Errorf("search audit log from es error[%d]", code)
+	}
+
+	result.Total, err = parseTotalHits(res.Hits.Total)
+	if err != nil {
+		return nil, err
+	}
+	// the point in time id may change between requests
+	if res.PitID != "" {
+		pitID = res.PitID
+	}
+	hits := res.Hits.Hits
+	if query.Cursor != nil && len(hits) > query.Size {
+		hits = hits[:query.Size]
+		result.Cursor = &store.Cursor{PitID: pitID, SearchAfter: hits[len(hits)-1].Sort}
+	} else if pitID != "" {
+		s.closePointInTime(ctx, c, pitID)
+	}
+	for _, hit := range hits {
+		var event v1.Event
+		err = json.Unmarshal(hit.Source, &event)
+		if err != nil {
+			clog.Error("json unmarshal audit log error: %s", err)
+			continue
+		}
+		result.Events = append(result.Events, event)
+	}
+	if result.Total == 0 {
+		clog.Debug("search audit log result from es is 0")
+	}
+	return result, nil
+}
+
+// buildQuery turns the query filters into a bool query
+func buildQuery(query *store.Query) *elastic.BoolQuery {
+	// structure filter
+	boolQ := elastic.NewBoolQuery()
+
+	// filter username
+	if len(strings.TrimSpace(query.UserName)) > 0 {
+		boolQ.Filter(elastic.NewTermQuery("UserIdentity.AccountId", query.UserName))
+	}
+
+	// filter time
+	if query.EndTime > 0 && query.StartTime <= 0 {
+		boolQ.Filter(elastic.NewRangeQuery("EventTime").Lte(query.EndTime))
+	} else if query.EndTime <= 0 && query.StartTime > 0 {
+		boolQ.Filter(elastic.NewRangeQuery("EventTime").Gte(query.StartTime))
+	} else if query.EndTime > 0 && query.StartTime > 0 {
+		boolQ.Filter(elastic.NewRangeQuery("EventTime").Lte(query.EndTime).Gte(query.StartTime))
+	}
+
+	// filter ip
+	if len(strings.TrimSpace(query.SourceIpAddress)) > 0 {
+		boolQ.Filter(elastic.NewTermQuery("SourceIpAddress", query.SourceIpAddress))
+	}
+
+	// fuzzy filter resource name
+	if len(strings.TrimSpace(query.ResourceName)) > 0 {
+		boolQ.Must(elastic.NewMatchQuery("ResourceReports.ResourceName", query.ResourceName))
+	}
+
+	// fuzzy filter event name
+	if len(strings.TrimSpace(query.EventName)) > 0 {
+		boolQ.Must(elastic.NewMatchQuery("EventName", query.EventName))
+	}
+
+	// filter status code
+	if query.ResponseStatus > 0 {
+		boolQ.Filter(elastic.NewTermQuery("ResponseStatus", query.ResponseStatus))
+	}
+
+	// filter search expression
+	if query.Expr != nil {
+		boolQ.Filter(exprQuery(query.Expr))
+	}
+	return boolQ
+}
+
+// parseTotalHits reads hits.total, a number before elasticsearch 7 and an object since
+func parseTotalHits(raw json.RawMessage) (int64, error) {
+	if len(raw) == 0 {
+		return 0, nil
+	}
+	var total struct {
+		Value int64 `json:"value"`
+	}
+	if raw[0] == '{' {
+		err := json.Unmarshal(raw, &total)
+		return total.Value, err
+	}
+	err := json.Unmarshal(raw, &total.Value)
+	return total.Value, err
+}
+
+// do sends a json request to the cluster and decodes a successful json response into out
+func (s *Store) do(ctx context.Context, method, path string, body, out interface{}) (int, error) {
+	var reader io.Reader
+	if body != nil {
+		bs, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewReader(bs)
+	}
+	req, err := http.NewRequest(method, s.host+path, reader)
+	if err != nil {
+		return 0, err
+	}
+	req = req.WithContext(ctx)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if out != nil && resp.StatusCode == http.StatusOK {
+		if err = json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return resp.StatusCode, err
+		}
+		return resp.StatusCode, nil
+	}
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package elasticsearch
+
+import (
+	"audit/pkg/store"
+	"strconv"
+	"strings"
+
+	"github.com/olivere/elastic/v7"
+)
+
+// fieldPaths maps expression fields to document fields
+var fieldPaths = map[store.Field]string{
+	store.FieldUser:         "UserIdentity.AccountId",
+	store.FieldIP:           "SourceIpAddress",
+	store.FieldVerb:         "RequestMethod",
+	store.FieldEventName:    "EventName",
+	store.FieldEventType:    "EventType",
+	store.FieldResourceName: "ResourceReports.ResourceName",
+	store.FieldResourceType: "ResourceReports.ResourceType",
+	store.FieldNamespace:    "Url",
+	store.FieldStatus:       "ResponseStatus",
+	store.FieldErrorCode:    "ErrorCode",
+	store.FieldUserAgent:    "UserAgent",
+	store.FieldFinding:      "Findings.Detection",
+	store.FieldSeverity:     "Findings.Severity",
+}
+
+// exprQuery translates a search expression into structured queries, values
+// never reach the query string syntax
+func exprQuery(expr store.Expr) elastic.Query {
+	switch e := expr.(type) {
+	case store.And:
+		q := elastic.NewBoolQuery()
+		for _, sub := range e {
+			q.Filter(exprQuery(sub))
+		}
+		return q
+	case store.Or:
+		q := elastic.NewBoolQuery().MinimumShouldMatch("1")
+		for _, sub := range e {
+			q.Should(exprQuery(sub))
+		}
+		return q
+	case store.Not:
+		return elastic.NewBoolQuery().MustNot(exprQuery(e.Expr))
+	case store.Term:
+		return termQuery(&e)
+	}
+	return elastic.NewMatchNoneQuery()
+}
+
+func termQuery(t *store.Term) elastic.Query {
+	path := fieldPaths[t.Field]
+	switch t.Field.Kind() {
+	case store.KindNumber:
+		value, _ := strconv.Atoi(t.Value)
+		switch t.Op {
+		case store.OpGt:
+			return elastic.NewRangeQuery(path).Gt(value)
+		case store.OpGte:
+			return elastic.NewRangeQuery(path).Gte(value)
+		case store.OpLt:
+			return elastic.NewRangeQuery(path).Lt(value)
+		case store.OpLte:
+			return elastic.NewRangeQuery(path).Lte(value)
+		}
+		return elastic.NewTermQuery(path, value)
+	case store.KindNamespace:
+		// regexp queries are anchored to the whole url
+		return elastic.NewBoolQuery().Should(
+			elastic.NewTermQuery("Namespace", t.Value),
+			elastic.NewRegexpQuery(path, ".*/namespaces/"+quoteRegexp(t.Value)+"([/?].*)?"),
+		).MinimumNumberShouldMatch(1)
+	case store.KindText:
+		return elastic.NewMatchQuery(path, t.Value)
+	}
+	if t.Op == store.OpWildcard {
+		return elastic.NewWildcardQuery(path, quoteWildcard(t.Value))
+	}
+	return elastic.NewTermQuery(path, t.Value)
+}
+
+// quoteWildcard escapes the wildcard syntax but *
+func quoteWildcard(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `?`, `\?`).Replace(value)
+}
+
+// quoteRegexp escapes the lucene regular expression syntax
+func quoteRegexp(value string) string {
+	var b strings.Builder
+	for _, r := range value {
+		if strings.ContainsRune(`.?+*|{}[]()"\#@&<>~`, r) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package elasticsearch
+
+import (
+	"audit/pkg/store"
+	"encoding/json"
+	"testing"
+)
+
+func TestExprQuery(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"user:alice", `{"term":{"UserIdentity.AccountId":"alice"}}`},
+		{`user:"a?b"`, `{"term":{"UserIdentity.AccountId":"a?b"}}`},
+		{"user:a?b*", `{"wildcard":{"UserIdentity.AccountId":{"value":"a\\?b*"}}}`},
+		{`user:a\b*`, `{"wildcard":{"UserIdentity.AccountId":{"value":"a\\\\b*"}}}`},
+		{"status:404", `{"term":{"ResponseStatus":404}}`},
+		{"status>500", `{"range":{"ResponseStatus":{"from":500,"include_lower":false,"include_upper":true,"to":null}}}`},
+		{"status<=299", `{"range":{"ResponseStatus":{"from":null,"include_lower":true,"include_upper":true,"to":299}}}`},
+		{"status:4xx", `{"bool":{"filter":[` +
+			`{"range":{"ResponseStatus":{"from":400,"include_lower":true,"include_upper":true,"to":null}}},` +
+			`{"range":{"ResponseStatus":{"from":null,"include_lower":true,"include_upper":true,"to":499}}}]}}`},
+		{"ns:kube-system", `{"bool":{"minimum_should_match":"1","should":[{"term":{"Namespace":"kube-system"}},{"regexp":{"Url":{"value":".*/namespaces/kube-system([/?].*)?"}}}]}}`},
+		{"event:update", `{"match":{"EventName":{"query":"update"}}}`},
+		{"resource:web", `{"match":{"ResourceReports.ResourceName":{"query":"web"}}}`},
+		{"finding:k8s-pod-exec", `{"term":{"Findings.Detection":"k8s-pod-exec"}}`},
+		{"user:a OR -verb:GET", `{"bool":{"minimum_should_match":"1","should":[` +
+			`{"term":{"UserIdentity.AccountId":"a"}},` +
+			`{"bool":{"must_not":{"term":{"RequestMethod":"GET"}}}}]}}`},
+		{"user:a verb:GET", `{"bool":{"filter":[` +
+			`{"term":{"UserIdentity.AccountId":"a"}},` +
+			`{"term":{"RequestMethod":"GET"}}]}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			expr, err := store.ParseExpr(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			src, err := exprQuery(expr).Source()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := json.Marshal(src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("exprQuery(%s) =\n%s\nwant\n%s", tt.expr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFieldPaths(t *testing.T) {
+	for _, name := range []string{"user", "ip", "verb", "event", "type", "resource", "resourcetype",
+		"namespace", "status", "errorcode", "useragent", "finding", "severity"} {
+		field, ok := store.FieldByName(name)
+		if !ok {
+			t.Fatalf("field %s is unknown", name)
+		}
+		if fieldPaths[field] == "" {
+			t.Errorf("field %s has no document path", field)
+		}
+	}
+}
+
+func TestQuoteRegexp(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"kube-system", "kube-system"},
+		{"a.b", `a\.b`},
+		{`a"b#c@d&e<f>g~h`, `a\"b\#c\@d\&e\<f\>g\~h`},
+		{"(x|y)*?+{1}[z]", `\(x\|y\)\*\?\+\{1\}\[z\]`},
+	}
+	for _, tt := range tests {
+		if got := quoteRegexp(tt.in); got != tt.want {
+			t.Errorf("quoteRegexp(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package elasticsearch
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/saashqdev/kubeworkz/pkg/clog"
+)
+
+type pointInTime struct {
+	// ID is returned by elasticsearch
+	ID string `json:"id"`
+	// PitID is returned by opensearch
+	PitID string `json:"pit_id"`
+}
+
+// openPointInTime opens a point in time on the audit index, so that cursor
+// pages are served from one consistent view of the index
+func (s *Store) openPointInTime(ctx context.Context, c *cluster) (int, string, error) {
+	path := "/" + s.index + "/_pit?keep_alive=" + pointInTimeKeepAlive
+	if c.distribution == distributionOpenSearch {
+		path = "/" + s.index + "/_search/point_in_time?keep_alive=" + pointInTimeKeepAlive
+	}
+	pit := &pointInTime{}
+	code, err := s.do(ctx, http.MethodPost, path, nil, pit)
+	if err != nil {
+		return code, "", fmt.Errorf("open point in time error: %s", err)
+	}
+	if code == http.StatusNotFound {
+		return code, "", nil
+	}
+	if code != http.StatusOK {
+		return code, "", fmt.Errorf("open point in time error[%d]", code)
+	}
+	if pit.PitID != "" {
+		return code, pit.PitID, nil
+	}
+	return code, pit.ID, nil
+}
+
+// closePointInTime releases a point in time once the last page was served,
+// it expires after its keep alive otherwise
+func (s *Store) closePointInTime(ctx context.Context, c *cluster, id string) {
+	var body interface{} = map[string]interface{}{"id": id}
+	path := "/_pit"
+	if c.distribution == distributionOpenSearch {
+		body = map[string]interface{}{"pit_id": []string{id}}
+		path = "/_search/point_in_time"
+	}
+	code, err := s.do(ctx, http.MethodDelete, path, body, nil)
+	if err != nil || code != http.StatusOK {
+		clog.Debug("close point in time error: %v, code %d", err, code)
+	}
+}
@@ -0,0 +1,263 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	v1 "audit/pkg/backend/v1"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Field is an event field that search expressions can filter on
+type Field string
+
+const (
+	FieldUser         Field = "user"
+	FieldIP           Field = "ip"
+	FieldVerb         Field = "verb"
+	FieldEventName    Field = "event"
+	FieldEventType    Field = "type"
+	FieldResourceName Field = "resource"
+	FieldResourceType Field = "resourceType"
+	FieldNamespace    Field = "namespace"
+	FieldStatus       Field = "status"
+	FieldErrorCode    Field = "errorCode"
+	FieldUserAgent    Field = "userAgent"
+	FieldFinding      Field = "finding"
+	FieldSeverity     Field = "severity"
+)
+
+// FieldKind decides how the values of a field are compared
+type FieldKind int
+
+const (
+	// KindKeyword values match exactly, or as a wildcard pattern
+	KindKeyword FieldKind = iota
+	// KindText values match when they share a token, like a match query
+	KindText
+	// KindNumber values are compared as integers
+	KindNumber
+	// KindNamespace values match the namespace of the event, or the one in the request url
+	KindNamespace
+)
+
+var fieldKinds = map[Field]FieldKind{
+	FieldUser:         KindKeyword,
+	FieldIP:           KindKeyword,
+	FieldVerb:         KindKeyword,
+	FieldEventName:    KindText,
+	FieldEventType:    KindKeyword,
+	FieldResourceName: KindText,
+	FieldResourceType: KindKeyword,
+	FieldNamespace:    KindNamespace,
+	FieldStatus:       KindNumber,
+	FieldErrorCode:    KindKeyword,
+	FieldUserAgent:    KindText,
+	FieldFinding:      KindKeyword,
+	FieldSeverity:     KindKeyword,
+}
+
+func (f Field) Kind() FieldKind {
+	return fieldKinds[f]
+}
+
+// Op compares a field with the value of a term
+type Op string
+
+const (
+	OpEq       Op = ":"
+	OpWildcard Op = "*"
+	OpGt       Op = ">"
+	OpGte      Op = ">="
+	OpLt       Op = "<"
+	OpLte      Op = "<="
+)
+
+// Expr is a search expression over event fields. Expressions are built by
+// ParseExpr or by hand and translated to its own query language by each store.
+type Expr interface {
+	isExpr()
+}
+
+// And matches events matching all of its expressions
+type And []Expr
+
+// Or matches events matching any of its expressions
+type Or []Expr
+
+// Not matches events not matching its expression
+type Not struct {
+	Expr Expr
+}
+
+// Term compares one field with a value. OpWildcard values may hold * for any
+// characters, the comparisons only apply to numbers.
+type Term struct {
+	Field Field
+	Op    Op
+	Value string
+}
+
+func (And) isExpr()  {}
+func (Or) isExpr()   {}
+func (Not) isExpr()  {}
+func (Term) isExpr() {}
+
+// NamespacePattern returns the regular expression matching the request urls of a namespace
+func NamespacePattern(namespace string) string {
+	return "/namespaces/" + regexp.QuoteMeta(namespace) + "([/?]|$)"
+}
+
+// Match evaluates the expression against an event, for stores that filter events themselves
+func Match(expr Expr, event *v1.Event) bool {
+	switch e := expr.(type) {
+	case And:
+		for _, sub := range e {
+			if !Match(sub, event) {
+				return false
+			}
+		}
+		return true
+	case Or:
+		for _, sub := range e {
+			if Match(sub, event) {
+				return true
+			}
+		}
+		return false
+	case Not:
+		return !Match(e.Expr, event)
+	case Term:
+		return matchTerm(&e, event)
+	}
+	return false
+}
+
+func matchTerm(t *Term, event *v1.Event) bool {
+	switch t.Field.Kind() {
+	case KindNumber:
+		value, err := strconv.Atoi(t.Value)
+		if err != nil {
+			return false
+		}
+		return compare(event.ResponseStatus, t.Op, value)
+	case KindNamespace:
+		if event.Namespace == t.Value {
+			return true
+		}
+		matched, _ := regexp.MatchString(NamespacePattern(t.Value), event.Url)
+		return matched
+	case KindText:
+		tokens := Tokenize(t.Value)
+		for _, value := range FieldValues(t.Field, event) {
+			for _, token := range Tokenize(value) {
+				for _, wanted := range tokens {
+					if token == wanted {
+						return true
+					}
+				}
+			}
+		}
+		return false
+	}
+	for _, value := range FieldValues(t.Field, event) {
+		if t.Op == OpWildcard && matchWildcard(t.Value, value) || t.Op == OpEq && t.Value == value {
+			return true
+		}
+	}
+	return false
+}
+
+func compare(a int, op Op, b int) bool {
+	switch op {
+	case OpGt:
+		return a > b
+	case OpGte:
+		return a >= b
+	case OpLt:
+		return a < b
+	case OpLte:
+		return a <= b
+	}
+	return a == b
+}
+
+// FieldValues returns the values of a field of the event, resources may give several
+func FieldValues(f Field, event *v1.Event) []string {
+	switch f {
+	case FieldUser:
+		if event.UserIdentity == nil {
+			return nil
+		}
+		return []string{event.UserIdentity.AccountId}
+	case FieldIP:
+		return []string{event.SourceIpAddress}
+	case FieldVerb:
+		return []string{event.RequestMethod}
+	case FieldEventName:
+		return []string{event.EventName}
+	case FieldEventType:
+		return []string{event.EventType}
+	case FieldErrorCode:
+		return []string{event.ErrorCode}
+	case FieldUserAgent:
+		return []string{event.UserAgent}
+	case FieldStatus:
+		return []string{strconv.Itoa(event.ResponseStatus)}
+	case FieldFinding, FieldSeverity:
+		values := make([]string, len(event.Findings))
+		for i, finding := range event.Findings {
+			values[i] = finding.Detection
+			if f == FieldSeverity {
+				values[i] = finding.Severity
+			}
+		}
+		return values
+	case FieldResourceName, FieldResourceType:
+		values := make([]string, len(event.ResourceReports))
+		for i, r := range event.ResourceReports {
+			values[i] = r.ResourceName
+			if f == FieldResourceType {
+				values[i] = r.ResourceType
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// matchWildcard reports whether s matches pattern, where * matches any characters
+func matchWildcard(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == s
+	}
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	last := parts[len(parts)-1]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, part)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(part):]
+	}
+	return len(s) >= len(last) && strings.HasSuffix(s, last)
+}
@@ -0,0 +1,173 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	v1 "audit/pkg/backend/v1"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	event := &v1.Event{
+		EventName:       "[Billing] update invoice",
+		UserIdentity:    &v1.UserIdentity{AccountId: "alice"},
+		SourceIpAddress: "10.0.0.1",
+		RequestMethod:   "DELETE",
+		ResponseStatus:  403,
+		Url:             "/api/v1/namespaces/kube-system/pods/web-0?dryRun=All",
+		UserAgent:       "kubectl/v1.23.2",
+		EventType:       "ResourceOperation",
+		ErrorCode:       "Forbidden",
+		ResourceReports: []v1.Resource{
+			{ResourceType: "pods", ResourceName: "web-0"},
+			{ResourceType: "services", ResourceName: "web"},
+		},
+		Findings: []v1.Finding{{Detection: "k8s-pod-exec", Severity: "high"}},
+	}
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{"user:alice", true},
+		{"user:bob", false},
+		{"user:Alice", false},
+		{"user:ali*", true},
+		{"user:*ice", true},
+		{"user:a*c*e", true},
+		{"user:a*x", false},
+		{"user:*", true},
+		{"ip:10.0.*", true},
+		{"ip:10.1.*", false},
+		{"verb:DELETE", true},
+		{"verb:delete", false},
+		{"type:ResourceOperation", true},
+		{"errorcode:Forbidden", true},
+		{"status:403", true},
+		{"status:4xx", true},
+		{"status:5xx", false},
+		{"status>=400", true},
+		{"status>403", false},
+		{"status<404", true},
+		{"status<=402", false},
+		{"namespace:kube-system", true},
+		{"namespace:kube", false},
+		{"namespace:default", false},
+		{"event:invoice", true},
+		{"event:INVOICE", true},
+		{`event:"update invoice"`, true},
+		{"event:bill", false},
+		{"useragent:kubectl", true},
+		{"resource:web", true},
+		{"resource:web-0", true},
+		{"resource:db", false},
+		{"resourcetype:services", true},
+		{"resourcetype:serv*", true},
+		{"resourcetype:nodes", false},
+		{"finding:k8s-pod-exec", true},
+		{"severity:high", true},
+		{"severity:low", false},
+		{"NOT user:alice", false},
+		{"-user:bob", true},
+		{"user:alice verb:DELETE", true},
+		{"user:alice verb:GET", false},
+		{"user:bob OR verb:DELETE", true},
+		{"user:bob OR verb:GET", false},
+		{"verb:(GET OR DELETE) AND NOT namespace:default", true},
+		{"-(user:alice OR user:bob)", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			expr, err := ParseExpr(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := Match(expr, event); got != tt.want {
+				t.Errorf("Match(%s) = %v, want %v", tt.expr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchWithoutOptionalFields(t *testing.T) {
+	event := &v1.Event{EventName: "login"}
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{"user:alice", false},
+		{"NOT user:alice", true},
+		{"user:*", false},
+		{"resource:web", false},
+		{"finding:k8s-pod-exec", false},
+		{"namespace:default", false},
+		{"status:0", true},
+	}
+	for _, tt := range tests {
+		expr, err := ParseExpr(tt.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := Match(expr, event); got != tt.want {
+			t.Errorf("Match(%s) = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestMatchEventNamespace(t *testing.T) {
+	event := &v1.Event{EventName: "[Billing] update invoice", Namespace: "billing", Url: "/invoices/inv-42"}
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{"namespace:billing", true},
+		{"namespace:bill", false},
+		{"NOT namespace:billing", false},
+	}
+	for _, tt := range tests {
+		expr, err := ParseExpr(tt.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := Match(expr, event); got != tt.want {
+			t.Errorf("Match(%s) = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestMatchWildcard(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"abc", "abc", true},
+		{"abc", "abcd", false},
+		{"*", "", true},
+		{"a*", "a", true},
+		{"*c", "abc", true},
+		{"a*c", "ac", true},
+		{"a*c", "abcbc", true},
+		{"a*b*c", "abc", true},
+		{"a*b*c", "acb", false},
+		{"ab*ba", "aba", false},
+		{"**", "x", true},
+	}
+	for _, tt := range tests {
+		if got := matchWildcard(tt.pattern, tt.s); got != tt.want {
+			t.Errorf("matchWildcard(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
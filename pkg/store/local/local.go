@@ -0,0 +1,330 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package local
+
+import (
+	v1 "audit/pkg/backend/v1"
+	"audit/pkg/store"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/saashqdev/kubeworkz/pkg/clog"
+)
+
+const retentionCheckInterval = time.Hour
+
+// Store is an embedded event store for installs without elasticsearch.
+// Events are partitioned into one segment file per UTC day under the
+// data directory, which is expected to live on a persistent volume.
+type Store struct {
+	dir       string
+	retention time.Duration
+
+	mu        sync.RWMutex
+	segments  map[string]*segment
+	snapshots snapshots
+}
+
+// hit identifies one matched event across segments
+type hit struct {
+	seg *segment
+	pos int32
+}
+
+func New(dir string, retention time.Duration) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &Store{
+		dir:       dir,
+		retention: retention,
+		segments:  make(map[string]*segment),
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), segmentSuffix) {
+			continue
+		}
+		key := strings.TrimSuffix(f.Name(), segmentSuffix)
+		seg, err := openSegment(filepath.Join(dir, f.Name()), key)
+		if err != nil {
+			clog.Error("open audit segment %s error: %s", f.Name(), err)
+			continue
+		}
+		s.segments[key] = seg
+		clog.Info("load audit segment %s with %d events", key, len(seg.entries))
+	}
+
+	if retention > 0 {
+		go s.expire()
+	}
+	return s, nil
+}
+
+func (s *Store) Save(events []v1.Event) error {
+	// group events by day so that each segment is written once per batch
+	batches := make(map[string][]*v1.Event)
+	for i := range events {
+		event := &events[i]
+		t := event.EventTime
+		if t <= 0 {
+			t = time.Now().Unix()
+		}
+		key := segmentKey(t)
+		batches[key] = append(batches[key], event)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, batch := range batches {
+		seg, ok := s.segments[key]
+		if !ok {
+			var err error
+			seg, err = openSegment(filepath.Join(s.dir, key+segmentSuffix), key)
+			if err != nil {
+				return err
+			}
+			s.segments[key] = seg
+		}
+		if err := seg.append(batch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) Search(query *store.Query) (*store.Result, error) {
+	byStatus, err := sortByStatus(query.SortBy)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// later cursor pages are served from the snapshot of the first one
+	var hits []hit
+	pitID := ""
+	if query.Cursor != nil && query.Cursor.PitID != "" {
+		if cached, ok := s.snapshots.get(query.Cursor.PitID, query); ok {
+			hits, pitID = cached, query.Cursor.PitID
+		}
+	}
+	if pitID == "" {
+		hits = s.collect(query, byStatus)
+	}
+
+	result := &store.Result{Total: int64(len(hits))}
+	from := query.From
+	if query.Cursor != nil {
+		from = 0
+		if len(query.Cursor.SearchAfter) > 0 {
+			after, err := decodeHitKey(query.Cursor.SearchAfter)
+			if err != nil {
+				return nil, err
+			}
+			from = sort.Search(len(hits), func(i int) bool {
+				return after.before(hits[i].key(byStatus), query.SortAsc)
+			})
+		}
+	}
+	if from < 0 {
+		from = 0
+	}
+	if from >= len(hits) {
+		return result, nil
+	}
+	end := len(hits)
+	if query.Size > 0 && from+query.Size < end {
+		end = from + query.Size
+	}
+	for _, h := range hits[from:end] {
+		event, err := h.seg.read(h.pos)
+		if err != nil {
+			clog.Error("read audit event from segment %s error: %s", h.seg.key, err)
+			continue
+		}
+		result.Events = append(result.Events, event)
+	}
+
+	if query.Cursor == nil {
+		return result, nil
+	}
+	if end == len(hits) {
+		if pitID != "" {
+			s.snapshots.remove(pitID)
+		}
+		return result, nil
+	}
+	if pitID == "" {
+		// without a snapshot the next page is still found by its sort values
+		if pitID, err = s.snapshots.put(query, hits); err != nil {
+			clog.Warn("snapshot audit search error: %s", err)
+		}
+	}
+	result.Cursor = &store.Cursor{PitID: pitID, SearchAfter: hits[end-1].key(byStatus).encode()}
+	return result, nil
+}
+
+// collect returns the hits of the query across segments, in sort order
+func (s *Store) collect(query *store.Query, byStatus bool) []hit {
+	var hits []hit
+	for _, seg := range s.segments {
+		if !seg.overlaps(query.StartTime, query.EndTime) {
+			continue
+		}
+		for _, pos := range seg.match(query) {
+			hits = append(hits, hit{seg: seg, pos: pos})
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		return hits[i].key(byStatus).before(hits[j].key(byStatus), query.SortAsc)
+	})
+	return hits
+}
+
+// sortByStatus tells which of the indexed fields the events are sorted by
+func sortByStatus(sortBy string) (bool, error) {
+	switch sortBy {
+	case "", store.DefaultSortBy:
+		return false, nil
+	case "ResponseStatus":
+		return true, nil
+	default:
+		return false, &store.UnsupportedSortError{Field: sortBy}
+	}
+}
+
+func (s *Store) Aggregate(query *store.Query, agg *store.Aggregation) (*store.Statistics, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	aggregator := store.NewAggregator(agg)
+	for _, seg := range s.segments {
+		if !seg.overlaps(query.StartTime, query.EndTime) {
+			continue
+		}
+		for _, pos := range seg.match(query) {
+			event, err := seg.read(pos)
+			if err != nil {
+				clog.Error("read audit event from segment %s error: %s", seg.key, err)
+				continue
+			}
+			aggregator.Add(&event)
+		}
+	}
+	return aggregator.Statistics(query), nil
+}
+
+// hitKey orders hits by event time or response status, the fields kept in
+// the index. Ties keep write order.
+type hitKey struct {
+	value int64
+	time  int64
+	seg   string
+	pos   int32
+}
+
+func (h hit) key(byStatus bool) hitKey {
+	e := h.seg.entries[h.pos]
+	k := hitKey{value: e.time, time: e.time, seg: h.seg.key, pos: h.pos}
+	if byStatus {
+		k.value = int64(e.status)
+	}
+	return k
+}
+
+// before reports whether k comes before other in the given sort direction
+func (k hitKey) before(other hitKey, asc bool) bool {
+	a, b := k, other
+	if !asc {
+		a, b = other, k
+	}
+	if a.value != b.value {
+		return a.value < b.value
+	}
+	if a.time != b.time {
+		return a.time < b.time
+	}
+	if a.seg != b.seg {
+		return a.seg < b.seg
+	}
+	return a.pos < b.pos
+}
+
+func (k hitKey) encode() []json.RawMessage {
+	values := []interface{}{k.value, k.time, k.seg, k.pos}
+	raw := make([]json.RawMessage, len(values))
+	for i, v := range values {
+		raw[i], _ = json.Marshal(v)
+	}
+	return raw
+}
+
+func decodeHitKey(raw []json.RawMessage) (hitKey, error) {
+	k := hitKey{}
+	if len(raw) != 4 {
+		return k, store.ErrInvalidCursor
+	}
+	for i, v := range []interface{}{&k.value, &k.time, &k.seg, &k.pos} {
+		if err := json.Unmarshal(raw[i], v); err != nil {
+			return k, store.ErrInvalidCursor
+		}
+	}
+	return k, nil
+}
+
+// expire removes segments older than the retention period
+func (s *Store) expire() {
+	ticker := time.NewTicker(retentionCheckInterval)
+	defer ticker.Stop()
+	for {
+		s.removeExpired()
+		<-ticker.C
+	}
+}
+
+func (s *Store) removeExpired() {
+	deadline := time.Now().Add(-s.retention).Unix()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, seg := range s.segments {
+		if seg.start+segmentSpan > deadline {
+			continue
+		}
+		if err := seg.close(); err != nil {
+			clog.Error("close audit segment %s error: %s", key, err)
+		}
+		delete(s.segments, key)
+		if err := os.Remove(filepath.Join(s.dir, key+segmentSuffix)); err != nil {
+			clog.Error("remove audit segment %s error: %s", key, err)
+			continue
+		}
+		clog.Info("remove expired audit segment %s", key)
+	}
+}
@@ -0,0 +1,361 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package local
+
+import (
+	v1 "audit/pkg/backend/v1"
+	"audit/pkg/store"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// base is the start of the first segment of testEvents
+var base = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).Unix()
+
+// testEvents spans three days, every event is identified by its RequestId
+func testEvents() []v1.Event {
+	var events []v1.Event
+	for i := 0; i < 17; i++ {
+		user, name := "alice", "create pod"
+		if i%2 == 1 {
+			user, name = "bob", "delete deployment"
+		}
+		events = append(events, v1.Event{
+			EventTime:       base + int64(i)*4*3600,
+			EventName:       name,
+			RequestId:       fmt.Sprint(i),
+			UserIdentity:    &v1.UserIdentity{AccountId: user},
+			SourceIpAddress: fmt.Sprintf("10.0.0.%d", i%4),
+			ResponseStatus:  200 + i%3*100,
+			ResourceReports: []v1.Resource{{ResourceName: fmt.Sprintf("web-%d", i%5)}},
+		})
+	}
+	return events
+}
+
+func ids(events []v1.Event) []string {
+	var ids []string
+	for _, e := range events {
+		ids = append(ids, e.RequestId)
+	}
+	return ids
+}
+
+func newTestStore(t *testing.T) *Store {
+	s, err := New(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Save(testEvents()); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestSegmentMatch(t *testing.T) {
+	seg, err := openSegment(filepath.Join(t.TempDir(), "20240101"+segmentSuffix), "20240101")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer seg.close()
+	events := testEvents()[:6]
+	var batch []*v1.Event
+	for i := range events {
+		batch = append(batch, &events[i])
+	}
+	if err = seg.append(batch); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		query store.Query
+		want  []int32
+	}{
+		{"all", store.Query{}, []int32{0, 1, 2, 3, 4, 5}},
+		{"user", store.Query{UserName: "bob"}, []int32{1, 3, 5}},
+		{"unknown user", store.Query{UserName: "carol"}, []int32{}},
+		{"ip", store.Query{SourceIpAddress: "10.0.0.1"}, []int32{1, 5}},
+		{"status", store.Query{ResponseStatus: 300}, []int32{1, 4}},
+		{"resource token", store.Query{ResourceName: "WEB"}, []int32{0, 1, 2, 3, 4, 5}},
+		{"resource", store.Query{ResourceName: "web-2"}, []int32{0, 1, 2, 3, 4, 5}},
+		{"event name token", store.Query{EventName: "deployment"}, []int32{1, 3, 5}},
+		{"event name any token", store.Query{EventName: "delete pod"}, []int32{0, 1, 2, 3, 4, 5}},
+		{"several indexes", store.Query{UserName: "alice", ResponseStatus: 200}, []int32{0}},
+		{"start time", store.Query{StartTime: base + 12*3600}, []int32{3, 4, 5}},
+		{"end time", store.Query{EndTime: base + 4*3600}, []int32{0, 1}},
+		{"expression", store.Query{Expr: store.Term{Field: store.FieldStatus, Op: store.OpGte, Value: "300"}}, []int32{1, 2, 4, 5}},
+		{"expression and index", store.Query{UserName: "alice", Expr: store.Not{Expr: store.Term{Field: store.FieldStatus, Op: store.OpEq, Value: "200"}}}, []int32{2, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := seg.match(&tt.query); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("match = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if seg.overlaps(base+segmentSpan, 0) || seg.overlaps(0, base-1) || !seg.overlaps(base+3600, base+7200) {
+		t.Error("segment overlaps the wrong time ranges")
+	}
+}
+
+func TestSegmentLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "20240101"+segmentSuffix)
+	seg, err := openSegment(path, "20240101")
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := testEvents()[:3]
+	if err = seg.append([]*v1.Event{&events[0], &events[1], &events[2]}); err != nil {
+		t.Fatal(err)
+	}
+	size := seg.size
+	// a write cut short by a crash
+	if _, err = seg.file.WriteAt([]byte(`{"EventName":"cut`), size); err != nil {
+		t.Fatal(err)
+	}
+	seg.close()
+
+	seg, err = openSegment(path, "20240101")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer seg.close()
+	if info, err := os.Stat(path); err != nil || info.Size() != size {
+		t.Errorf("segment file is not truncated to %d: %v, %v", size, info, err)
+	}
+	if got := seg.match(&store.Query{UserName: "bob"}); !reflect.DeepEqual(got, []int32{1}) {
+		t.Errorf("reloaded index matches %v", got)
+	}
+	event, err := seg.read(2)
+	if err != nil || event.RequestId != "2" {
+		t.Errorf("read = %+v, %v", event, err)
+	}
+
+	if _, err = openSegment(filepath.Join(t.TempDir(), "x"+segmentSuffix), "x"); err == nil {
+		t.Error("segment with an invalid name opened")
+	}
+}
+
+func TestSearch(t *testing.T) {
+	s := newTestStore(t)
+	if len(s.segments) != 3 {
+		t.Fatalf("events are in %d segments, want 3", len(s.segments))
+	}
+
+	tests := []struct {
+		name      string
+		query     store.Query
+		wantTotal int64
+		want      []string
+	}{
+		{"newest first", store.Query{Size: 3}, 17, []string{"16", "15", "14"}},
+		{"oldest first", store.Query{Size: 3, SortAsc: true}, 17, []string{"0", "1", "2"}},
+		{"second page", store.Query{From: 3, Size: 3, SortAsc: true}, 17, []string{"3", "4", "5"}},
+		{"past the end", store.Query{From: 20, Size: 3}, 17, nil},
+		{"by status", store.Query{Size: 4, SortBy: "ResponseStatus", SortAsc: true}, 17, []string{"0", "3", "6", "9"}},
+		{"by status descending", store.Query{Size: 4, SortBy: "ResponseStatus"}, 17, []string{"14", "11", "8", "5"}},
+		{"filtered across segments", store.Query{UserName: "bob", StartTime: base + 20*3600, EndTime: base + 44*3600, SortAsc: true},
+			4, []string{"5", "7", "9", "11"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := s.Search(&tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Total != tt.wantTotal || !reflect.DeepEqual(ids(result.Events), tt.want) || result.Cursor != nil {
+				t.Errorf("Search = %d %v %v, want %d %v", result.Total, ids(result.Events), result.Cursor, tt.wantTotal, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchErrors(t *testing.T) {
+	s := newTestStore(t)
+	_, err := s.Search(&store.Query{SortBy: "EventName"})
+	if sortErr, ok := err.(*store.UnsupportedSortError); !ok || sortErr.Field != "EventName" {
+		t.Errorf("Search sorted by EventName error = %v", err)
+	}
+	for _, after := range [][]string{{`1`}, {`"x"`, `1`, `"20240101"`, `0`}} {
+		cursor := &store.Cursor{}
+		for _, v := range after {
+			cursor.SearchAfter = append(cursor.SearchAfter, json.RawMessage(v))
+		}
+		if _, err = s.Search(&store.Query{Size: 3, Cursor: cursor}); err != store.ErrInvalidCursor {
+			t.Errorf("Search after %v error = %v", after, err)
+		}
+	}
+}
+
+// page runs a cursor search to the end, saving more events after the first page
+func page(t *testing.T, s *Store, query store.Query, more []v1.Event) (int64, []string) {
+	query.Cursor = &store.Cursor{}
+	var total int64
+	var got []string
+	for i := 0; ; i++ {
+		result, err := s.Search(&query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			total = result.Total
+			if err = s.Save(more); err != nil {
+				t.Fatal(err)
+			}
+		} else if result.Total != total {
+			t.Errorf("page %d total = %d, want %d", i, result.Total, total)
+		}
+		got = append(got, ids(result.Events)...)
+		if result.Cursor == nil {
+			return total, got
+		}
+		if result.Cursor.PitID == "" {
+			t.Fatal("cursor has no snapshot")
+		}
+		query.Cursor = result.Cursor
+	}
+}
+
+func TestSearchCursor(t *testing.T) {
+	for _, sortBy := range []string{"", "ResponseStatus"} {
+		for _, asc := range []bool{true, false} {
+			t.Run(fmt.Sprintf("%s asc=%v", sortBy, asc), func(t *testing.T) {
+				s := newTestStore(t)
+				query := store.Query{Size: 4, SortBy: sortBy, SortAsc: asc}
+				all, err := s.Search(&store.Query{SortBy: sortBy, SortAsc: asc})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				// events saved while paging do not shift the pages
+				more := testEvents()[:2]
+				for i := range more {
+					more[i].RequestId = "late"
+				}
+				total, got := page(t, s, query, more)
+				if total != 17 || !reflect.DeepEqual(got, ids(all.Events)) {
+					t.Errorf("pages = %d %v, want %v", total, got, ids(all.Events))
+				}
+				if len(s.snapshots.items) != 0 {
+					t.Errorf("%d snapshots are left after the last page", len(s.snapshots.items))
+				}
+			})
+		}
+	}
+}
+
+func TestSearchCursorWithoutSnapshot(t *testing.T) {
+	s := newTestStore(t)
+	first, err := s.Search(&store.Query{Size: 5, SortAsc: true, Cursor: &store.Cursor{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		pitID    string
+		userName string
+		want     []string
+	}{
+		{"expired", "expired", "", []string{"5", "6", "7", "8", "9"}},
+		{"other query", first.Cursor.PitID, "alice", []string{"6", "8", "10", "12", "14"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := s.Search(&store.Query{
+				Size:     5,
+				SortAsc:  true,
+				UserName: tt.userName,
+				Cursor:   &store.Cursor{PitID: tt.pitID, SearchAfter: first.Cursor.SearchAfter},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(ids(result.Events), tt.want) {
+				t.Errorf("next page = %v, want %v", ids(result.Events), tt.want)
+			}
+			if result.Cursor == nil || result.Cursor.PitID == "" || result.Cursor.PitID == tt.pitID {
+				t.Errorf("cursor = %+v, want a new snapshot", result.Cursor)
+			}
+		})
+	}
+}
+
+func TestSnapshotsLimit(t *testing.T) {
+	s := &snapshots{}
+	query := &store.Query{}
+	var first string
+	for i := 0; i < maxSnapshots+1; i++ {
+		id, err := s.put(query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			first = id
+		}
+	}
+	if len(s.items) != maxSnapshots {
+		t.Errorf("%d snapshots are kept, want %d", len(s.items), maxSnapshots)
+	}
+	if _, ok := s.get(first, query); ok {
+		t.Error("the oldest snapshot is not evicted")
+	}
+}
+
+func TestRemoveExpired(t *testing.T) {
+	dir := t.TempDir()
+	s, err := New(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().Unix()
+	events := []v1.Event{
+		{EventTime: now - 10*segmentSpan, RequestId: "old"},
+		{EventTime: now - 2*segmentSpan, RequestId: "kept"},
+		{EventTime: now, RequestId: "today"},
+	}
+	if err = s.Save(events); err != nil {
+		t.Fatal(err)
+	}
+
+	s.retention = 5 * 24 * time.Hour
+	s.removeExpired()
+	if _, err = os.Stat(filepath.Join(dir, segmentKey(now-10*segmentSpan)+segmentSuffix)); !os.IsNotExist(err) {
+		t.Errorf("expired segment file is not removed: %v", err)
+	}
+	result, err := s.Search(&store.Query{SortAsc: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"kept", "today"}; !reflect.DeepEqual(ids(result.Events), want) {
+		t.Errorf("events after expiry = %v, want %v", ids(result.Events), want)
+	}
+
+	reopened, err := New(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reopened.segments) != 2 {
+		t.Errorf("reopened store has %d segments, want 2", len(reopened.segments))
+	}
+}
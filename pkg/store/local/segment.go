@@ -0,0 +1,296 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package local
+
+import (
+	v1 "audit/pkg/backend/v1"
+	"audit/pkg/store"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	segmentSuffix = ".ndjson"
+	segmentLayout = "20060102"
+	segmentSpan   = int64(24 * time.Hour / time.Second)
+)
+
+// entry locates one event inside the segment file
+type entry struct {
+	offset int64
+	size   int32
+	time   int64
+	status int
+}
+
+// segment holds the events of one UTC day in an append-only ndjson file,
+// together with in-memory indexes rebuilt from the file when it is opened
+type segment struct {
+	key   string
+	start int64
+	file  *os.File
+	size  int64
+
+	entries   []entry
+	users     map[string][]int32
+	ips       map[string][]int32
+	statuses  map[int][]int32
+	resources map[string][]int32
+	names     map[string][]int32
+}
+
+func segmentKey(eventTime int64) string {
+	return time.Unix(eventTime, 0).UTC().Format(segmentLayout)
+}
+
+func openSegment(path, key string) (*segment, error) {
+	start, err := time.ParseInLocation(segmentLayout, key, time.UTC)
+	if err != nil {
+		return nil, fmt.Errorf("invalid segment name %s: %s", key, err)
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s := &segment{
+		key:       key,
+		start:     start.Unix(),
+		file:      file,
+		users:     make(map[string][]int32),
+		ips:       make(map[string][]int32),
+		statuses:  make(map[int][]int32),
+		resources: make(map[string][]int32),
+		names:     make(map[string][]int32),
+	}
+	if err = s.load(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// load scans the segment file and rebuilds the indexes, dropping a
+// partially written tail left behind by a crash
+func (s *segment) load() error {
+	reader := bufio.NewReader(s.file)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		event := v1.Event{}
+		if err = json.Unmarshal(line, &event); err != nil {
+			break
+		}
+		s.index(&event, offset, int32(len(line)))
+		offset += int64(len(line))
+	}
+	if err := s.file.Truncate(offset); err != nil {
+		return err
+	}
+	s.size = offset
+	return nil
+}
+
+// append writes events to the end of the segment file and indexes them
+func (s *segment) append(events []*v1.Event) error {
+	buf := &bytes.Buffer{}
+	sizes := make([]int32, 0, len(events))
+	for _, event := range events {
+		before := buf.Len()
+		bs, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		buf.Write(bs)
+		buf.WriteByte('\n')
+		sizes = append(sizes, int32(buf.Len()-before))
+	}
+	if _, err := s.file.WriteAt(buf.Bytes(), s.size); err != nil {
+		return err
+	}
+	if err := s.file.Sync(); err != nil {
+		return err
+	}
+	offset := s.size
+	for i, event := range events {
+		s.index(event, offset, sizes[i])
+		offset += int64(sizes[i])
+	}
+	s.size = offset
+	return nil
+}
+
+func (s *segment) index(event *v1.Event, offset int64, size int32) {
+	pos := int32(len(s.entries))
+	s.entries = append(s.entries, entry{offset: offset, size: size, time: event.EventTime, status: event.ResponseStatus})
+	if event.UserIdentity != nil {
+		s.users[event.UserIdentity.AccountId] = append(s.users[event.UserIdentity.AccountId], pos)
+	}
+	s.ips[event.SourceIpAddress] = append(s.ips[event.SourceIpAddress], pos)
+	s.statuses[event.ResponseStatus] = append(s.statuses[event.ResponseStatus], pos)
+	for _, resource := range event.ResourceReports {
+		addTokens(s.resources, resource.ResourceName, pos)
+	}
+	addTokens(s.names, event.EventName, pos)
+}
+
+func (s *segment) read(pos int32) (v1.Event, error) {
+	e := s.entries[pos]
+	buf := make([]byte, e.size)
+	event := v1.Event{}
+	if _, err := s.file.ReadAt(buf, e.offset); err != nil {
+		return event, err
+	}
+	err := json.Unmarshal(buf, &event)
+	return event, err
+}
+
+// overlaps reports whether the segment day intersects the query time range
+func (s *segment) overlaps(startTime, endTime int64) bool {
+	if endTime > 0 && s.start > endTime {
+		return false
+	}
+	if startTime > 0 && s.start+segmentSpan <= startTime {
+		return false
+	}
+	return true
+}
+
+// match returns the positions of the events matching the query, in append order
+func (s *segment) match(query *store.Query) []int32 {
+	var lists [][]int32
+	if len(strings.TrimSpace(query.UserName)) > 0 {
+		lists = append(lists, s.users[query.UserName])
+	}
+	if len(strings.TrimSpace(query.SourceIpAddress)) > 0 {
+		lists = append(lists, s.ips[query.SourceIpAddress])
+	}
+	if query.ResponseStatus > 0 {
+		lists = append(lists, s.statuses[query.ResponseStatus])
+	}
+	if len(strings.TrimSpace(query.ResourceName)) > 0 {
+		lists = append(lists, lookupTokens(s.resources, query.ResourceName))
+	}
+	if len(strings.TrimSpace(query.EventName)) > 0 {
+		lists = append(lists, lookupTokens(s.names, query.EventName))
+	}
+
+	var candidates []int32
+	if len(lists) == 0 {
+		candidates = make([]int32, len(s.entries))
+		for i := range candidates {
+			candidates[i] = int32(i)
+		}
+	} else {
+		candidates = lists[0]
+		for _, list := range lists[1:] {
+			candidates = intersect(candidates, list)
+		}
+	}
+
+	matched := make([]int32, 0, len(candidates))
+	for _, pos := range candidates {
+		t := s.entries[pos].time
+		if query.StartTime > 0 && t < query.StartTime {
+			continue
+		}
+		if query.EndTime > 0 && t > query.EndTime {
+			continue
+		}
+		if query.Expr != nil {
+			// expressions address fields the indexes do not hold
+			event, err := s.read(pos)
+			if err != nil || !store.Match(query.Expr, &event) {
+				continue
+			}
+		}
+		matched = append(matched, pos)
+	}
+	return matched
+}
+
+func (s *segment) close() error {
+	return s.file.Close()
+}
+
+func addTokens(index map[string][]int32, text string, pos int32) {
+	for _, token := range store.Tokenize(text) {
+		list := index[token]
+		if len(list) > 0 && list[len(list)-1] == pos {
+			continue
+		}
+		index[token] = append(list, pos)
+	}
+}
+
+// lookupTokens returns the events containing any token of text
+func lookupTokens(index map[string][]int32, text string) []int32 {
+	var result []int32
+	for _, token := range store.Tokenize(text) {
+		result = union(result, index[token])
+	}
+	return result
+}
+
+func intersect(a, b []int32) []int32 {
+	result := make([]int32, 0)
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			result = append(result, a[i])
+			i++
+			j++
+		}
+	}
+	return result
+}
+
+func union(a, b []int32) []int32 {
+	result := make([]int32, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] < b[j]:
+			result = append(result, a[i])
+			i++
+		case a[i] > b[j]:
+			result = append(result, b[j])
+			j++
+		default:
+			result = append(result, a[i])
+			i++
+			j++
+		}
+	}
+	result = append(result, a[i:]...)
+	return append(result, b[j:]...)
+}
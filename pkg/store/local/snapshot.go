@@ -0,0 +1,104 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package local
+
+import (
+	"audit/pkg/store"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// snapshotKeepAlive matches the point in time keep alive of elasticsearch
+	snapshotKeepAlive = 5 * time.Minute
+	maxSnapshots      = 64
+)
+
+// snapshot holds the sorted hits of a cursor search, so that the following
+// pages are sliced from it instead of matching and sorting every segment again.
+// Like a point in time of elasticsearch, events saved later are not part of it.
+type snapshot struct {
+	query   string
+	hits    []hit
+	expires time.Time
+}
+
+// snapshots caches the snapshots of running cursor searches by id
+type snapshots struct {
+	mu    sync.Mutex
+	items map[string]*snapshot
+}
+
+// snapshotQuery identifies the filters and sorting of a query, leaving out its page
+func snapshotQuery(query *store.Query) string {
+	q := *query
+	q.From, q.Size, q.Cursor = 0, 0, nil
+	return fmt.Sprintf("%#v", q)
+}
+
+// get returns the hits of a snapshot taken for the same query, and extends its keep alive
+func (s *snapshots) get(id string, query *store.Query) ([]hit, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snap, ok := s.items[id]
+	if !ok || time.Now().After(snap.expires) || snap.query != snapshotQuery(query) {
+		return nil, false
+	}
+	snap.expires = time.Now().Add(snapshotKeepAlive)
+	return snap.hits, true
+}
+
+// put stores the hits of a new snapshot and returns its id, evicting expired
+// snapshots and the one closest to expiring when the cache is full
+func (s *snapshots) put(query *store.Query, hits []hit) (string, error) {
+	bs := make([]byte, 16)
+	if _, err := rand.Read(bs); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(bs)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.items == nil {
+		s.items = make(map[string]*snapshot)
+	}
+	now := time.Now()
+	oldest := ""
+	for key, snap := range s.items {
+		if now.After(snap.expires) {
+			delete(s.items, key)
+			continue
+		}
+		if oldest == "" || snap.expires.Before(s.items[oldest].expires) {
+			oldest = key
+		}
+	}
+	if len(s.items) >= maxSnapshots {
+		delete(s.items, oldest)
+	}
+	s.items[id] = &snapshot{query: snapshotQuery(query), hits: hits, expires: now.Add(snapshotKeepAlive)}
+	return id, nil
+}
+
+func (s *snapshots) remove(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.items, id)
+}
@@ -0,0 +1,422 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	maxExprLength = 4096
+	maxExprTerms  = 100
+	maxExprDepth  = 20
+)
+
+// fieldNames maps the names and aliases usable in expressions to fields
+var fieldNames = map[string]Field{
+	"user":         FieldUser,
+	"username":     FieldUser,
+	"ip":           FieldIP,
+	"sourceip":     FieldIP,
+	"verb":         FieldVerb,
+	"method":       FieldVerb,
+	"event":        FieldEventName,
+	"eventname":    FieldEventName,
+	"type":         FieldEventType,
+	"eventtype":    FieldEventType,
+	"resource":     FieldResourceName,
+	"resourcename": FieldResourceName,
+	"resourcetype": FieldResourceType,
+	"namespace":    FieldNamespace,
+	"ns":           FieldNamespace,
+	"status":       FieldStatus,
+	"errorcode":    FieldErrorCode,
+	"useragent":    FieldUserAgent,
+	"finding":      FieldFinding,
+	"detection":    FieldFinding,
+	"severity":     FieldSeverity,
+}
+
+var (
+	// namespaceRegexp is a kubernetes namespace name, a DNS-1123 label
+	namespaceRegexp = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+	// statusClassRegexp is a class of status codes, like 4xx
+	statusClassRegexp = regexp.MustCompile(`^([1-5])xx$`)
+)
+
+// SyntaxError tells what is wrong with a search expression and where
+type SyntaxError struct {
+	// Pos is the 1-based character position of the error
+	Pos int
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s at position %d", e.Msg, e.Pos)
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenWord
+	tokenQuoted
+	tokenLParen
+	tokenRParen
+	tokenColon
+	tokenCompare
+	tokenAnd
+	tokenOr
+	tokenNot
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// ParseExpr parses a search expression such as
+//
+//	user:alice AND verb:(delete OR patch) AND NOT namespace:kube-system AND status>=400
+//
+// Terms are field:value or a comparison of status with a number, values are
+// bare words or double quoted strings and bare values may use * as wildcard.
+// status also matches a class of codes, like status:4xx.
+// Terms are joined with AND, OR and NOT (or a leading -) and grouped with
+// parentheses, juxtaposed terms are joined with AND. There is no free text
+// search, every value is bound to a known field.
+func ParseExpr(text string) (Expr, error) {
+	if len(text) > maxExprLength {
+		return nil, &SyntaxError{Pos: 1, Msg: fmt.Sprintf("expression is longer than %d characters", maxExprLength)}
+	}
+	tokens, err := lex(text)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	if p.peek().kind == tokenEOF {
+		return nil, &SyntaxError{Pos: 1, Msg: "expression is empty"}
+	}
+	expr, err := p.parseOr(nil)
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, p.unexpected(t)
+	}
+	return expr, nil
+}
+
+func lex(text string) ([]token, error) {
+	var tokens []token
+	runes := []rune(text)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		start := i
+		switch {
+		case unicode.IsSpace(r):
+			i++
+			continue
+		case r == '(':
+			tokens = append(tokens, token{kind: tokenLParen, text: "(", pos: start + 1})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{kind: tokenRParen, text: ")", pos: start + 1})
+			i++
+		case r == ':':
+			tokens = append(tokens, token{kind: tokenColon, text: ":", pos: start + 1})
+			i++
+		case r == '>' || r == '<':
+			op := string(r)
+			i++
+			if i < len(runes) && runes[i] == '=' {
+				op += "="
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenCompare, text: op, pos: start + 1})
+		case r == '=':
+			return nil, &SyntaxError{Pos: start + 1, Msg: `unexpected "=", use field:value`}
+		case r == '"':
+			var b strings.Builder
+			i++
+			for ; i < len(runes) && runes[i] != '"'; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				b.WriteRune(runes[i])
+			}
+			if i == len(runes) {
+				return nil, &SyntaxError{Pos: start + 1, Msg: "unterminated quoted value"}
+			}
+			i++
+			tokens = append(tokens, token{kind: tokenQuoted, text: b.String(), pos: start + 1})
+		case r == '-' && (len(tokens) == 0 || tokens[len(tokens)-1].kind != tokenColon):
+			// a leading - negates the following term or group
+			tokens = append(tokens, token{kind: tokenNot, text: "-", pos: start + 1})
+			i++
+		default:
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && !strings.ContainsRune(`()":<>=`, runes[i]) {
+				i++
+			}
+			word := string(runes[start:i])
+			t := token{kind: tokenWord, text: word, pos: start + 1}
+			switch word {
+			case "AND":
+				t.kind = tokenAnd
+			case "OR":
+				t.kind = tokenOr
+			case "NOT":
+				t.kind = tokenNot
+			}
+			tokens = append(tokens, t)
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(runes) + 1}), nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+	terms  int
+	depth  int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) unexpected(t token) error {
+	if t.kind == tokenEOF {
+		return &SyntaxError{Pos: t.pos, Msg: "unexpected end of expression"}
+	}
+	return &SyntaxError{Pos: t.pos, Msg: fmt.Sprintf("unexpected %q", t.text)}
+}
+
+// the parse functions parse values of field when it is set, inside field:(...)
+
+func (p *parser) parseOr(field *Field) (Expr, error) {
+	left, err := p.parseAnd(field)
+	if err != nil {
+		return nil, err
+	}
+	exprs := Or{left}
+	for p.peek().kind == tokenOr {
+		p.next()
+		right, err := p.parseAnd(field)
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, right)
+	}
+	if len(exprs) == 1 {
+		return left, nil
+	}
+	return exprs, nil
+}
+
+func (p *parser) parseAnd(field *Field) (Expr, error) {
+	left, err := p.parseUnary(field)
+	if err != nil {
+		return nil, err
+	}
+	exprs := And{left}
+	for {
+		switch p.peek().kind {
+		case tokenAnd:
+			p.next()
+		case tokenWord, tokenQuoted, tokenLParen, tokenNot:
+			// juxtaposed terms are joined with AND
+		default:
+			if len(exprs) == 1 {
+				return left, nil
+			}
+			return exprs, nil
+		}
+		right, err := p.parseUnary(field)
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, right)
+	}
+}
+
+func (p *parser) parseUnary(field *Field) (Expr, error) {
+	if p.peek().kind == tokenNot {
+		p.next()
+		expr, err := p.parseUnary(field)
+		if err != nil {
+			return nil, err
+		}
+		return Not{Expr: expr}, nil
+	}
+	return p.parsePrimary(field)
+}
+
+func (p *parser) parsePrimary(field *Field) (Expr, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenLParen:
+		if p.depth++; p.depth > maxExprDepth {
+			return nil, &SyntaxError{Pos: t.pos, Msg: fmt.Sprintf("expression is nested deeper than %d", maxExprDepth)}
+		}
+		expr, err := p.parseOr(field)
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.kind != tokenRParen {
+			if closing.kind == tokenEOF {
+				return nil, &SyntaxError{Pos: t.pos, Msg: "unclosed parenthesis"}
+			}
+			return nil, p.unexpected(closing)
+		}
+		p.depth--
+		return expr, nil
+	case tokenWord, tokenQuoted:
+		if field != nil {
+			return p.term(*field, OpEq, t)
+		}
+		if t.kind == tokenQuoted {
+			return nil, &SyntaxError{Pos: t.pos, Msg: fmt.Sprintf("expected a field before %q, e.g. user:alice", t.text)}
+		}
+		return p.parseTerm(t)
+	}
+	return nil, p.unexpected(t)
+}
+
+func (p *parser) parseTerm(name token) (Expr, error) {
+	field, ok := fieldNames[strings.ToLower(name.text)]
+	if !ok {
+		op := p.peek()
+		if op.kind != tokenColon && op.kind != tokenCompare {
+			return nil, &SyntaxError{Pos: name.pos, Msg: fmt.Sprintf("expected field:value, e.g. user:%s", name.text)}
+		}
+		return nil, &SyntaxError{Pos: name.pos, Msg: fmt.Sprintf("unknown field %q, expected one of %s", name.text, knownFields())}
+	}
+
+	op := p.next()
+	switch op.kind {
+	case tokenColon:
+		if p.peek().kind == tokenLParen {
+			return p.parsePrimary(&field)
+		}
+		if p.peek().kind == tokenCompare {
+			op = p.next()
+			return p.term(field, Op(op.text), p.next())
+		}
+		return p.term(field, OpEq, p.next())
+	case tokenCompare:
+		return p.term(field, Op(op.text), p.next())
+	}
+	return nil, &SyntaxError{Pos: op.pos, Msg: fmt.Sprintf("expected \":\" after field %q", name.text)}
+}
+
+// term checks a value against its field and operator
+func (p *parser) term(field Field, op Op, value token) (Expr, error) {
+	if value.kind != tokenWord && value.kind != tokenQuoted {
+		if value.kind == tokenEOF {
+			return nil, &SyntaxError{Pos: value.pos, Msg: fmt.Sprintf("expected a value for %s", field)}
+		}
+		return nil, p.unexpected(value)
+	}
+	if p.terms++; p.terms > maxExprTerms {
+		return nil, &SyntaxError{Pos: value.pos, Msg: fmt.Sprintf("expression has more than %d terms", maxExprTerms)}
+	}
+	expr, err := newTerm(field, op, value.text, value.kind == tokenWord)
+	if err != nil {
+		return nil, &SyntaxError{Pos: value.pos, Msg: err.Error()}
+	}
+	return expr, nil
+}
+
+// FieldByName returns the field with the name or alias used in search expressions
+func FieldByName(name string) (Field, bool) {
+	f, ok := fieldNames[strings.ToLower(name)]
+	return f, ok
+}
+
+// NewTerm returns the expression matching a value of a field, the way a
+// bare value of field:value does in a search expression
+func NewTerm(field Field, value string) (Expr, error) {
+	if _, ok := fieldKinds[field]; !ok {
+		return nil, fmt.Errorf("unknown field %q, expected one of %s", field, knownFields())
+	}
+	return newTerm(field, OpEq, value, true)
+}
+
+// newTerm checks a value against its field and operator. Bare values of
+// keyword fields may hold wildcards and status also takes a class like 4xx.
+func newTerm(field Field, op Op, value string, bare bool) (Expr, error) {
+	t := Term{Field: field, Op: op, Value: value}
+
+	switch field.Kind() {
+	case KindNumber:
+		if class := statusClassRegexp.FindStringSubmatch(strings.ToLower(value)); class != nil && bare {
+			if op != OpEq {
+				return nil, fmt.Errorf("status class %s can not be compared with %s", value, op)
+			}
+			return And{Term{Field: field, Op: OpGte, Value: class[1] + "00"}, Term{Field: field, Op: OpLte, Value: class[1] + "99"}}, nil
+		}
+		if _, err := strconv.Atoi(value); err != nil {
+			return nil, fmt.Errorf("%s must be a number or a class like 4xx, got %q", field, value)
+		}
+		return t, nil
+	case KindNamespace:
+		if !namespaceRegexp.MatchString(value) {
+			return nil, fmt.Errorf("%q is not a valid namespace", value)
+		}
+	}
+	if op != OpEq {
+		return nil, fmt.Errorf("%s can not be compared with %s", field, op)
+	}
+	if bare && strings.Contains(value, "*") {
+		if field.Kind() != KindKeyword {
+			return nil, fmt.Errorf("%s does not support wildcards", field)
+		}
+		t.Op = OpWildcard
+	}
+	if strings.TrimSpace(value) == "" {
+		return nil, fmt.Errorf("expected a value for %s", field)
+	}
+	return t, nil
+}
+
+func knownFields() string {
+	seen := make(map[Field]bool)
+	var names []string
+	for _, f := range fieldNames {
+		if !seen[f] {
+			seen[f] = true
+			names = append(names, string(f))
+		}
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,181 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseExpr(t *testing.T) {
+	user := func(v string) Term { return Term{Field: FieldUser, Op: OpEq, Value: v} }
+	verb := func(v string) Term { return Term{Field: FieldVerb, Op: OpEq, Value: v} }
+	tests := []struct {
+		in   string
+		want Expr
+	}{
+		{"user:alice", user("alice")},
+		{"  user:alice  ", user("alice")},
+		{"USERNAME:alice", user("alice")},
+		{"user:alice verb:delete", And{user("alice"), verb("delete")}},
+		{"user:alice AND verb:delete", And{user("alice"), verb("delete")}},
+		{"user:alice OR user:bob verb:delete", Or{user("alice"), And{user("bob"), verb("delete")}}},
+		{"(user:alice OR user:bob) verb:delete", And{Or{user("alice"), user("bob")}, verb("delete")}},
+		{"verb:(delete OR patch)", Or{verb("delete"), verb("patch")}},
+		{"verb:(delete OR (patch AND NOT update))", Or{verb("delete"), And{verb("patch"), Not{Expr: verb("update")}}}},
+		{"NOT user:alice", Not{Expr: user("alice")}},
+		{"-user:alice", Not{Expr: user("alice")}},
+		{"user:alice -verb:get", And{user("alice"), Not{Expr: verb("get")}}},
+		{"-(user:alice OR user:bob)", Not{Expr: Or{user("alice"), user("bob")}}},
+		{"NOT NOT user:alice", Not{Expr: Not{Expr: user("alice")}}},
+		{"user:-alice", user("-alice")},
+		{`user:"alice smith"`, user("alice smith")},
+		{`user:"al\"ice"`, user(`al"ice`)},
+		{`user:"ali*"`, user("ali*")},
+		{"user:ali*", Term{Field: FieldUser, Op: OpWildcard, Value: "ali*"}},
+		{"ip:10.0.*", Term{Field: FieldIP, Op: OpWildcard, Value: "10.0.*"}},
+		{"ns:kube-system", Term{Field: FieldNamespace, Op: OpEq, Value: "kube-system"}},
+		{"event:delete", Term{Field: FieldEventName, Op: OpEq, Value: "delete"}},
+		{"detection:k8s-pod-exec severity:high", And{
+			Term{Field: FieldFinding, Op: OpEq, Value: "k8s-pod-exec"},
+			Term{Field: FieldSeverity, Op: OpEq, Value: "high"},
+		}},
+		{"status:404", Term{Field: FieldStatus, Op: OpEq, Value: "404"}},
+		{"status>=400", Term{Field: FieldStatus, Op: OpGte, Value: "400"}},
+		{"status:>400", Term{Field: FieldStatus, Op: OpGt, Value: "400"}},
+		{"status<300", Term{Field: FieldStatus, Op: OpLt, Value: "300"}},
+		{"status<=299", Term{Field: FieldStatus, Op: OpLte, Value: "299"}},
+		{"status:4xx", And{
+			Term{Field: FieldStatus, Op: OpGte, Value: "400"},
+			Term{Field: FieldStatus, Op: OpLte, Value: "499"},
+		}},
+		{"status:5XX", And{
+			Term{Field: FieldStatus, Op: OpGte, Value: "500"},
+			Term{Field: FieldStatus, Op: OpLte, Value: "599"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseExpr(tt.in)
+			if err != nil {
+				t.Fatalf("ParseExpr(%q) error: %v", tt.in, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseExpr(%q) = %#v, want %#v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseExprErrors(t *testing.T) {
+	tests := []struct {
+		in      string
+		wantPos int
+		wantMsg string
+	}{
+		{"", 1, "expression is empty"},
+		{"   ", 1, "expression is empty"},
+		{"alice", 1, "expected field:value"},
+		{`"alice"`, 1, "expected a field before"},
+		{"foo:bar", 1, `unknown field "foo"`},
+		{"user=alice", 5, `unexpected "="`},
+		{`user:"alice`, 6, "unterminated quoted value"},
+		{"(user:alice", 1, "unclosed parenthesis"},
+		{"user:alice)", 11, `unexpected ")"`},
+		{"user:", 6, "expected a value for user"},
+		{`user:""`, 6, "expected a value for user"},
+		{"user:alice AND", 15, "unexpected end of expression"},
+		{"user:alice OR OR user:bob", 15, `unexpected "OR"`},
+		{"user alice", 6, `expected ":" after field "user"`},
+		{"user:(alice", 6, "unclosed parenthesis"},
+		{"user:()", 7, `unexpected ")"`},
+		{"status:abc", 8, "status must be a number"},
+		{"status>4xx", 8, "status class 4xx can not be compared with >"},
+		{"status:6xx", 8, "status must be a number"},
+		{"user>3", 6, "user can not be compared with >"},
+		{"namespace:Kube_System", 11, "is not a valid namespace"},
+		{"namespace:kube*", 11, "is not a valid namespace"},
+		{"event:dele*", 7, "event does not support wildcards"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			_, err := ParseExpr(tt.in)
+			syntaxErr, ok := err.(*SyntaxError)
+			if !ok {
+				t.Fatalf("ParseExpr(%q) error = %v, want a syntax error", tt.in, err)
+			}
+			if syntaxErr.Pos != tt.wantPos || !strings.Contains(syntaxErr.Msg, tt.wantMsg) {
+				t.Errorf("ParseExpr(%q) error = %q at %d, want %q at %d", tt.in, syntaxErr.Msg, syntaxErr.Pos, tt.wantMsg, tt.wantPos)
+			}
+		})
+	}
+}
+
+func TestParseExprLimits(t *testing.T) {
+	terms := func(n int) string {
+		return strings.TrimSpace(strings.Repeat("user:a ", n))
+	}
+	nested := func(n int) string {
+		return strings.Repeat("(", n) + "user:a" + strings.Repeat(")", n)
+	}
+	tests := []struct {
+		name    string
+		in      string
+		wantMsg string
+	}{
+		{"longest", "user:" + strings.Repeat("a", maxExprLength-len("user:")), ""},
+		{"too long", "user:" + strings.Repeat("a", maxExprLength-len("user:")+1), "longer than 4096 characters"},
+		{"most terms", terms(maxExprTerms), ""},
+		{"too many terms", terms(maxExprTerms + 1), "more than 100 terms"},
+		{"status classes count once", strings.TrimSpace(strings.Repeat("status:4xx ", maxExprTerms)), ""},
+		{"deepest", nested(maxExprDepth), ""},
+		{"too deep", nested(maxExprDepth + 1), "nested deeper than 20"},
+		{"deep value groups", "verb:" + nested(maxExprDepth+1), "nested deeper than 20"},
+		{"sibling groups are not nested", strings.TrimSpace(strings.Repeat(nested(maxExprDepth)+" ", 3)), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseExpr(tt.in)
+			if tt.wantMsg == "" {
+				if err != nil {
+					t.Errorf("ParseExpr error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantMsg) {
+				t.Errorf("ParseExpr error = %v, want %q", err, tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestNewTerm(t *testing.T) {
+	if _, err := NewTerm(Field("nope"), "x"); err == nil {
+		t.Error("NewTerm of an unknown field succeeded")
+	}
+	got, err := NewTerm(FieldUser, "adm*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Term{Field: FieldUser, Op: OpWildcard, Value: "adm*"}); got != want {
+		t.Errorf("NewTerm = %#v, want %#v", got, want)
+	}
+	if f, ok := FieldByName("SourceIP"); !ok || f != FieldIP {
+		t.Errorf("FieldByName(SourceIP) = %q, %v", f, ok)
+	}
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package postgres
+
+import (
+	"audit/pkg/store"
+	"fmt"
+	"strconv"
+)
+
+// aggregationColumns maps fields to the expressions their values are
+// aggregated on, fields of json arrays are read from the joined element r
+var aggregationColumns = map[store.Field]string{
+	store.FieldUser:         "user_name",
+	store.FieldIP:           "source_ip_address",
+	store.FieldVerb:         "request_method",
+	store.FieldEventName:    "event_name",
+	store.FieldEventType:    "event_type",
+	store.FieldResourceName: "r->>'ResourceName'",
+	store.FieldResourceType: "r->>'ResourceType'",
+	store.FieldStatus:       "response_status::text",
+	store.FieldErrorCode:    "error_code",
+	store.FieldUserAgent:    "user_agent",
+	store.FieldFinding:      "r->>'Detection'",
+	store.FieldSeverity:     "r->>'Severity'",
+}
+
+// aggregationFrom returns the rows to aggregate a field over, one per
+// element for the fields of json arrays
+func aggregationFrom(f store.Field) string {
+	if f == store.FieldResourceName {
+		return "audit_events, jsonb_array_elements(resource_reports) r"
+	}
+	if arrayField, ok := jsonArrayFields[f]; ok {
+		return "audit_events, jsonb_array_elements(" + arrayField.column + ") r"
+	}
+	return "audit_events"
+}
+
+func (s *Store) Aggregate(query *store.Query, agg *store.Aggregation) (*store.Statistics, error) {
+	where, args := buildWhere(query)
+	stats := &store.Statistics{Top: []store.TopValues{}, Distinct: []store.DistinctCount{}}
+
+	err := s.db.QueryRow("SELECT count(*) FROM audit_events"+where, args...).Scan(&stats.Total)
+	if err != nil {
+		return nil, fmt.Errorf("count audit events error: %s", err)
+	}
+
+	if agg.Interval > 0 {
+		interval := "$" + strconv.Itoa(len(args)+1)
+		buckets, err := s.histogram("SELECT floor(extract(epoch FROM event_time) / "+interval+")::bigint * "+interval+
+			", count(*), count(*) FILTER (WHERE response_status >= "+strconv.Itoa(store.ErrorStatus)+")"+
+			" FROM audit_events"+where+" GROUP BY 1", append(args, agg.Interval)...)
+		if err != nil {
+			return nil, err
+		}
+		stats.Histogram = store.FillHistogram(buckets, query, agg.Interval)
+	}
+
+	for _, f := range agg.Top {
+		column := aggregationColumns[f]
+		// an event counts once for each distinct value
+		rows, err := s.db.Query("SELECT v, count(*) FROM (SELECT DISTINCT event_time, id, "+column+" AS v FROM "+
+			aggregationFrom(f)+where+") t GROUP BY v ORDER BY 2 DESC, 1 LIMIT $"+strconv.Itoa(len(args)+1),
+			append(args, agg.TopSize)...)
+		if err != nil {
+			return nil, fmt.Errorf("aggregate top %s error: %s", f, err)
+		}
+		top := store.TopValues{Field: f, Values: []store.ValueCount{}}
+		for rows.Next() {
+			var value store.ValueCount
+			var v *string
+			if err = rows.Scan(&v, &value.Count); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if v != nil {
+				value.Value = *v
+			}
+			top.Values = append(top.Values, value)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return nil, err
+		}
+		stats.Top = append(stats.Top, top)
+	}
+
+	for _, f := range agg.Distinct {
+		distinct := store.DistinctCount{Field: f}
+		err = s.db.QueryRow("SELECT count(DISTINCT "+aggregationColumns[f]+") FROM "+aggregationFrom(f)+where, args...).
+			Scan(&distinct.Count)
+		if err != nil {
+			return nil, fmt.Errorf("aggregate distinct %s error: %s", f, err)
+		}
+		stats.Distinct = append(stats.Distinct, distinct)
+	}
+	return stats, nil
+}
+
+func (s *Store) histogram(sql string, args ...interface{}) ([]store.HistogramBucket, error) {
+	rows, err := s.db.Query(sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("aggregate histogram error: %s", err)
+	}
+	defer rows.Close()
+
+	var buckets []store.HistogramBucket
+	for rows.Next() {
+		var b store.HistogramBucket
+		if err = rows.Scan(&b.Time, &b.Count, &b.Errors); err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, b)
+	}
+	return buckets, rows.Err()
+}
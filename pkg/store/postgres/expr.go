@@ -0,0 +1,130 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package postgres
+
+import (
+	"audit/pkg/store"
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// fieldColumns maps expression fields to columns, the fields of jsonArrayFields are read from json arrays
+var fieldColumns = map[store.Field]string{
+	store.FieldUser:         "user_name",
+	store.FieldIP:           "source_ip_address",
+	store.FieldVerb:         "request_method",
+	store.FieldEventName:    "event_name",
+	store.FieldEventType:    "event_type",
+	store.FieldResourceName: "resource_names",
+	store.FieldNamespace:    "url",
+	store.FieldStatus:       "response_status",
+	store.FieldErrorCode:    "error_code",
+	store.FieldUserAgent:    "user_agent",
+}
+
+type jsonArrayField struct {
+	column string
+	key    string
+}
+
+// jsonArrayFields maps the fields held by the objects of jsonb array columns
+var jsonArrayFields = map[store.Field]jsonArrayField{
+	store.FieldResourceType: {"resource_reports", "ResourceType"},
+	store.FieldFinding:      {"findings", "Detection"},
+	store.FieldSeverity:     {"findings", "Severity"},
+}
+
+// compareOps are the sql operators of the comparisons, nothing else reaches the sql text
+var compareOps = map[store.Op]string{
+	store.OpEq:  "=",
+	store.OpGt:  ">",
+	store.OpGte: ">=",
+	store.OpLt:  "<",
+	store.OpLte: "<=",
+}
+
+// whereBuilder collects the positional arguments of a where clause
+type whereBuilder struct {
+	args []interface{}
+}
+
+// arg adds an argument and returns its placeholder
+func (w *whereBuilder) arg(v interface{}) string {
+	w.args = append(w.args, v)
+	return "$" + strconv.Itoa(len(w.args))
+}
+
+// expr translates a search expression into a condition, values are always passed as arguments
+func (w *whereBuilder) expr(expr store.Expr) string {
+	switch e := expr.(type) {
+	case store.And:
+		return w.join(e, " AND ")
+	case store.Or:
+		return w.join(e, " OR ")
+	case store.Not:
+		return "NOT " + w.expr(e.Expr)
+	case store.Term:
+		return w.term(&e)
+	}
+	return "FALSE"
+}
+
+func (w *whereBuilder) join(exprs []store.Expr, sep string) string {
+	conds := make([]string, len(exprs))
+	for i, sub := range exprs {
+		conds[i] = w.expr(sub)
+	}
+	return "(" + strings.Join(conds, sep) + ")"
+}
+
+func (w *whereBuilder) term(t *store.Term) string {
+	column := fieldColumns[t.Field]
+	switch t.Field.Kind() {
+	case store.KindNumber:
+		value, _ := strconv.Atoi(t.Value)
+		op, ok := compareOps[t.Op]
+		if !ok {
+			op = "="
+		}
+		return column + " " + op + " " + w.arg(value)
+	case store.KindNamespace:
+		return "(namespace = " + w.arg(t.Value) + " OR " + column + " ~ " + w.arg(store.NamespacePattern(t.Value)) + ")"
+	case store.KindText:
+		return "to_tsvector('simple', " + column + ") @@ to_tsquery('simple', " + w.arg(matchQuery(t.Value)) + ")"
+	}
+
+	if f, ok := jsonArrayFields[t.Field]; ok {
+		if t.Op == store.OpWildcard {
+			return "EXISTS (SELECT 1 FROM jsonb_array_elements(" + f.column + ") r WHERE r->>'" + f.key + "' LIKE " +
+				w.arg(likePattern(t.Value)) + ")"
+		}
+		contains, _ := json.Marshal([]map[string]string{{f.key: t.Value}})
+		// the column is null for events without elements, which NOT must keep
+		return "COALESCE(" + f.column + " @> " + w.arg(string(contains)) + "::jsonb, FALSE)"
+	}
+	if t.Op == store.OpWildcard {
+		return column + " LIKE " + w.arg(likePattern(t.Value))
+	}
+	return column + " = " + w.arg(t.Value)
+}
+
+// likePattern turns a wildcard value into a LIKE pattern with the default escape character
+func likePattern(value string) string {
+	value = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+	return strings.Replace(value, "*", "%", -1)
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package postgres
+
+import (
+	"audit/pkg/store"
+	"reflect"
+	"testing"
+)
+
+func TestWhereExpr(t *testing.T) {
+	tests := []struct {
+		expr     string
+		wantCond string
+		wantArgs []interface{}
+	}{
+		{"user:alice", "user_name = $1", []interface{}{"alice"}},
+		{`user:"a*b"`, "user_name = $1", []interface{}{"a*b"}},
+		{"user:a?b*", "user_name LIKE $1", []interface{}{"a?b%"}},
+		{`user:a_b%\*`, "user_name LIKE $1", []interface{}{`a\_b\%\\%`}},
+		{"status:404", "response_status = $1", []interface{}{404}},
+		{"status>500", "response_status > $1", []interface{}{500}},
+		{"status<=299", "response_status <= $1", []interface{}{299}},
+		{"status:4xx", "(response_status >= $1 AND response_status <= $2)", []interface{}{400, 499}},
+		{"ns:kube-system", "(namespace = $1 OR url ~ $2)", []interface{}{"kube-system", "/namespaces/kube-system([/?]|$)"}},
+		{"event:update", "to_tsvector('simple', event_name) @@ to_tsquery('simple', $1)", []interface{}{"'update'"}},
+		{"resourceType:pods", "COALESCE(resource_reports @> $1::jsonb, FALSE)", []interface{}{`[{"ResourceType":"pods"}]`}},
+		{"finding:k8s-pod-exec", "COALESCE(findings @> $1::jsonb, FALSE)", []interface{}{`[{"Detection":"k8s-pod-exec"}]`}},
+		{"severity:hi*", "EXISTS (SELECT 1 FROM jsonb_array_elements(findings) r WHERE r->>'Severity' LIKE $1)", []interface{}{"hi%"}},
+		{"user:a OR -verb:GET", "(user_name = $1 OR NOT request_method = $2)", []interface{}{"a", "GET"}},
+		{"user:a verb:GET", "(user_name = $1 AND request_method = $2)", []interface{}{"a", "GET"}},
+		{"(user:a OR user:b) -status>=500", "((user_name = $1 OR user_name = $2) AND NOT response_status >= $3)",
+			[]interface{}{"a", "b", 500}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			expr, err := store.ParseExpr(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := &whereBuilder{}
+			if got := w.expr(expr); got != tt.wantCond {
+				t.Errorf("expr(%s) =\n%s\nwant\n%s", tt.expr, got, tt.wantCond)
+			}
+			if !reflect.DeepEqual(w.args, tt.wantArgs) {
+				t.Errorf("args = %#v, want %#v", w.args, tt.wantArgs)
+			}
+		})
+	}
+
+	if got := (&whereBuilder{}).expr(nil); got != "FALSE" {
+		t.Errorf("expr(nil) = %s", got)
+	}
+}
+
+func TestLikePattern(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"kube-*", "kube-%"},
+		{"*a*b*", "%a%b%"},
+		{"100%", `100\%`},
+		{"a_b", `a\_b`},
+		{`C:\dir`, `C:\\dir`},
+	}
+	for _, tt := range tests {
+		if got := likePattern(tt.in); got != tt.want {
+			t.Errorf("likePattern(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestMatchQuery(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"delete", "'delete'"},
+		{"Delete Pod", "'delete' | 'pod'"},
+		{"it's a:b & c|d", "'it' | 's' | 'a' | 'b' | 'c' | 'd'"},
+		{"!&|", ""},
+	}
+	for _, tt := range tests {
+		if got := matchQuery(tt.in); got != tt.want {
+			t.Errorf("matchQuery(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
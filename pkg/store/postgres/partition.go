@@ -0,0 +1,115 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package postgres
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/saashqdev/kubeworkz/pkg/clog"
+)
+
+const (
+	partitionPrefix        = "audit_events_"
+	partitionLayout        = "20060102"
+	partitionSpan          = 24 * time.Hour
+	retentionCheckInterval = time.Hour
+)
+
+func partitionDay(t time.Time) time.Time {
+	return t.UTC().Truncate(partitionSpan)
+}
+
+// ensurePartition creates the daily partition holding day if it is missing
+func (s *Store) ensurePartition(day time.Time) error {
+	name := partitionPrefix + day.Format(partitionLayout)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.partitions[name] {
+		return nil
+	}
+	_, err := s.db.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s PARTITION OF audit_events FOR VALUES FROM (%s) TO (%s)",
+		pq.QuoteIdentifier(name),
+		pq.QuoteLiteral(day.Format(time.RFC3339)),
+		pq.QuoteLiteral(day.Add(partitionSpan).Format(time.RFC3339))))
+	if err != nil {
+		return fmt.Errorf("create partition %s error: %s", name, err)
+	}
+	s.partitions[name] = true
+	return nil
+}
+
+// maintain keeps the partition of the next day ready and drops the
+// partitions older than the retention period
+func (s *Store) maintain() {
+	ticker := time.NewTicker(retentionCheckInterval)
+	defer ticker.Stop()
+	for {
+		tomorrow := partitionDay(time.Now()).Add(partitionSpan)
+		if err := s.ensurePartition(tomorrow); err != nil {
+			clog.Error("%s", err)
+		}
+		if s.retention > 0 {
+			if err := s.dropExpired(); err != nil {
+				clog.Error("drop expired audit partitions error: %s", err)
+			}
+		}
+		<-ticker.C
+	}
+}
+
+func (s *Store) dropExpired() error {
+	rows, err := s.db.Query(`SELECT c.relname FROM pg_inherits i
+		JOIN pg_class c ON c.oid = i.inhrelid
+		JOIN pg_class p ON p.oid = i.inhparent
+		WHERE p.relname = 'audit_events'`)
+	if err != nil {
+		return err
+	}
+	var names []string
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		names = append(names, name)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(-s.retention)
+	for _, name := range names {
+		day, err := time.ParseInLocation(partitionLayout, strings.TrimPrefix(name, partitionPrefix), time.UTC)
+		if err != nil || day.Add(partitionSpan).After(deadline) {
+			continue
+		}
+		if _, err = s.db.Exec("DROP TABLE IF EXISTS " + pq.QuoteIdentifier(name)); err != nil {
+			return err
+		}
+		s.mu.Lock()
+		delete(s.partitions, name)
+		s.mu.Unlock()
+		clog.Info("drop expired audit partition %s", name)
+	}
+	return nil
+}
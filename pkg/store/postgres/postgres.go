@@ -0,0 +1,375 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package postgres
+
+import (
+	v1 "audit/pkg/backend/v1"
+	"audit/pkg/store"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var copyColumns = []string{
+	"event_time", "event_version", "event_name", "description", "source_ip_address", "user_agent",
+	"request_id", "request_method", "request_parameters", "response_status", "response_elements",
+	"event_type", "error_code", "error_message", "url", "user_name", "user_identity",
+	"api_action", "api_version", "resource_names", "resource_reports", "findings", "redactions",
+	"cluster", "namespace", "labels",
+}
+
+type sortColumn struct {
+	name string
+	// typ casts cursor values back to the column type
+	typ string
+}
+
+// sortColumns maps the sortable event fields to their columns
+var sortColumns = map[string]sortColumn{
+	"EventTime":              {"event_time", "timestamptz"},
+	"EventName":              {"event_name", "text"},
+	"SourceIpAddress":        {"source_ip_address", "text"},
+	"RequestMethod":          {"request_method", "text"},
+	"ResponseStatus":         {"response_status", "integer"},
+	"EventType":              {"event_type", "text"},
+	"UserIdentity.AccountId": {"user_name", "text"},
+}
+
+// selectColumns reads the time in seconds and its fraction in nanoseconds, kept to the microsecond
+const selectColumns = `floor(extract(epoch FROM event_time))::bigint,
+	(extract(microseconds FROM event_time)::bigint % 1000000 * 1000)::integer, event_version, event_name,
+	description, source_ip_address, user_agent, request_id, request_method, request_parameters,
+	response_status, response_elements, event_type, error_code, error_message, url, user_identity,
+	api_action, api_version, resource_reports, findings, redactions, cluster, namespace, labels`
+
+// Store keeps audit events in daily partitions of a PostgreSQL table
+type Store struct {
+	db        *sql.DB
+	retention time.Duration
+
+	mu         sync.Mutex
+	partitions map[string]bool
+}
+
+func New(dsn string, retention time.Duration) (*Store, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err = migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	s := &Store{
+		db:         db,
+		retention:  retention,
+		partitions: make(map[string]bool),
+	}
+	if err = s.ensurePartition(partitionDay(time.Now())); err != nil {
+		db.Close()
+		return nil, err
+	}
+	go s.maintain()
+	return s, nil
+}
+
+// Save copies a batch of events into the table in one transaction
+func (s *Store) Save(events []v1.Event) error {
+	for _, event := range events {
+		if err := s.ensurePartition(partitionDay(eventTime(&event))); err != nil {
+			return err
+		}
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(pq.CopyIn("audit_events", copyColumns...))
+	if err != nil {
+		return err
+	}
+	for i := range events {
+		event := &events[i]
+		var userName string
+		if event.UserIdentity != nil {
+			userName = event.UserIdentity.AccountId
+		}
+		userIdentity, err := jsonValue(event.UserIdentity)
+		if err != nil {
+			return err
+		}
+		resourceReports, err := jsonValue(event.ResourceReports)
+		if err != nil {
+			return err
+		}
+		var findings, labels interface{}
+		if len(event.Findings) > 0 {
+			if findings, err = jsonValue(event.Findings); err != nil {
+				return err
+			}
+		}
+		if len(event.Labels) > 0 {
+			if labels, err = jsonValue(event.Labels); err != nil {
+				return err
+			}
+		}
+		resourceNames := make([]string, 0, len(event.ResourceReports))
+		for _, resource := range event.ResourceReports {
+			resourceNames = append(resourceNames, resource.ResourceName)
+		}
+		_, err = stmt.Exec(eventTime(event), event.EventVersion, event.EventName, event.Description,
+			event.SourceIpAddress, event.UserAgent, event.RequestId, event.RequestMethod,
+			event.RequestParameters, event.ResponseStatus, event.ResponseElements, event.EventType,
+			event.ErrorCode, event.ErrorMessage, event.Url, userName, userIdentity, event.ApiAction,
+			event.ApiVersion, strings.Join(resourceNames, " "), resourceReports, findings, event.Redactions,
+			event.Cluster, event.Namespace, labels)
+		if err != nil {
+			stmt.Close()
+			return err
+		}
+	}
+	if _, err = stmt.Exec(); err != nil {
+		stmt.Close()
+		return err
+	}
+	if err = stmt.Close(); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *Store) Search(query *store.Query) (*store.Result, error) {
+	where, args := buildWhere(query)
+	page, pageArgs, err := pageQuery(query, where, args)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &store.Result{}
+	err = s.db.QueryRow("SELECT count(*) FROM audit_events"+where, args...).Scan(&result.Total)
+	if err != nil {
+		return nil, fmt.Errorf("count audit events error: %s", err)
+	}
+	if result.Total == 0 {
+		return result, nil
+	}
+
+	rows, err := s.db.Query(page, pageArgs...)
+	if err != nil {
+		return nil, fmt.Errorf("search audit events error: %s", err)
+	}
+	defer rows.Close()
+
+	var lastSortValue string
+	var lastID int64
+	for rows.Next() {
+		if query.Cursor != nil && len(result.Events) == query.Size {
+			result.Cursor = encodeCursor(lastSortValue, lastID)
+			break
+		}
+		event, err := scanEvent(rows, &lastSortValue, &lastID)
+		if err != nil {
+			return nil, err
+		}
+		result.Events = append(result.Events, *event)
+	}
+	return result, rows.Err()
+}
+
+// pageQuery builds the select of the page of a search from its where clause and arguments
+func pageQuery(query *store.Query, where string, args []interface{}) (string, []interface{}, error) {
+	sortBy := query.SortBy
+	if sortBy == "" {
+		sortBy = store.DefaultSortBy
+	}
+	column, ok := sortColumns[sortBy]
+	if !ok {
+		return "", nil, &store.UnsupportedSortError{Field: sortBy}
+	}
+	order, compare := " DESC", " < "
+	if query.SortAsc {
+		order, compare = " ASC", " > "
+	}
+	// the arguments of the count query are kept as they are
+	args = append([]interface{}{}, args...)
+
+	var page string
+	if query.Cursor == nil {
+		from := query.From
+		if from < 0 {
+			from = 0
+		}
+		args = append(args, query.Size, from)
+		page = " LIMIT $" + strconv.Itoa(len(args)-1) + " OFFSET $" + strconv.Itoa(len(args))
+	} else {
+		// keyset pagination on the sort column with id as tie breaker,
+		// one extra row tells whether another page follows
+		if len(query.Cursor.SearchAfter) > 0 {
+			value, id, err := decodeCursor(query.Cursor)
+			if err != nil {
+				return "", nil, err
+			}
+			args = append(args, value, id)
+			cond := "(" + column.name + ", id)" + compare +
+				"($" + strconv.Itoa(len(args)-1) + "::" + column.typ + ", $" + strconv.Itoa(len(args)) + "::bigint)"
+			if where == "" {
+				where = " WHERE " + cond
+			} else {
+				where += " AND " + cond
+			}
+		}
+		args = append(args, query.Size+1)
+		page = " LIMIT $" + strconv.Itoa(len(args))
+	}
+	return "SELECT " + selectColumns + ", " + column.name + "::text, id FROM audit_events" + where +
+		" ORDER BY " + column.name + order + ", id" + order + page, args, nil
+}
+
+func encodeCursor(value string, id int64) *store.Cursor {
+	v, _ := json.Marshal(value)
+	i, _ := json.Marshal(id)
+	return &store.Cursor{SearchAfter: []json.RawMessage{v, i}}
+}
+
+func decodeCursor(cursor *store.Cursor) (string, int64, error) {
+	var value string
+	var id int64
+	if len(cursor.SearchAfter) != 2 ||
+		json.Unmarshal(cursor.SearchAfter[0], &value) != nil ||
+		json.Unmarshal(cursor.SearchAfter[1], &id) != nil {
+		return "", 0, store.ErrInvalidCursor
+	}
+	return value, id, nil
+}
+
+// buildWhere turns the query filters into a where clause with positional arguments
+func buildWhere(query *store.Query) (string, []interface{}) {
+	w := &whereBuilder{}
+	var conds []string
+	add := func(cond string, arg interface{}) {
+		conds = append(conds, strings.Replace(cond, "?", w.arg(arg), 1))
+	}
+
+	if len(strings.TrimSpace(query.UserName)) > 0 {
+		add("user_name = ?", query.UserName)
+	}
+	if query.StartTime > 0 {
+		add("event_time >= ?", time.Unix(query.StartTime, 0))
+	}
+	if query.EndTime > 0 {
+		add("event_time <= ?", time.Unix(query.EndTime, 0))
+	}
+	if len(strings.TrimSpace(query.SourceIpAddress)) > 0 {
+		add("source_ip_address = ?", query.SourceIpAddress)
+	}
+	if len(strings.TrimSpace(query.ResourceName)) > 0 {
+		add("to_tsvector('simple', resource_names) @@ to_tsquery('simple', ?)", matchQuery(query.ResourceName))
+	}
+	if len(strings.TrimSpace(query.EventName)) > 0 {
+		add("to_tsvector('simple', event_name) @@ to_tsquery('simple', ?)", matchQuery(query.EventName))
+	}
+	if query.ResponseStatus > 0 {
+		add("response_status = ?", query.ResponseStatus)
+	}
+	if query.Expr != nil {
+		conds = append(conds, w.expr(query.Expr))
+	}
+
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), w.args
+}
+
+// matchQuery builds a tsquery matching any token of text, like an elasticsearch match query.
+// Text without any token yields a query that matches nothing.
+func matchQuery(text string) string {
+	tokens := store.Tokenize(text)
+	for i, token := range tokens {
+		tokens[i] = pq.QuoteLiteral(token)
+	}
+	return strings.Join(tokens, " | ")
+}
+
+// scanEvent reads an event row followed by its sort value and id
+func scanEvent(rows *sql.Rows, sortValue *string, id *int64) (*v1.Event, error) {
+	event := &v1.Event{}
+	var userIdentity, resourceReports, findings, labels []byte
+	err := rows.Scan(&event.EventTime, &event.EventTimeNanos, &event.EventVersion, &event.EventName,
+		&event.Description, &event.SourceIpAddress, &event.UserAgent, &event.RequestId, &event.RequestMethod,
+		&event.RequestParameters, &event.ResponseStatus, &event.ResponseElements, &event.EventType,
+		&event.ErrorCode, &event.ErrorMessage, &event.Url, &userIdentity, &event.ApiAction,
+		&event.ApiVersion, &resourceReports, &findings, &event.Redactions, &event.Cluster,
+		&event.Namespace, &labels, sortValue, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(userIdentity) > 0 {
+		if err = json.Unmarshal(userIdentity, &event.UserIdentity); err != nil {
+			return nil, err
+		}
+	}
+	if len(resourceReports) > 0 {
+		if err = json.Unmarshal(resourceReports, &event.ResourceReports); err != nil {
+			return nil, err
+		}
+	}
+	if len(findings) > 0 {
+		if err = json.Unmarshal(findings, &event.Findings); err != nil {
+			return nil, err
+		}
+	}
+	if len(labels) > 0 {
+		if err = json.Unmarshal(labels, &event.Labels); err != nil {
+			return nil, err
+		}
+	}
+	return event, nil
+}
+
+func eventTime(event *v1.Event) time.Time {
+	if event.EventTime <= 0 {
+		return time.Now()
+	}
+	return time.Unix(event.EventTime, int64(event.EventTimeNanos))
+}
+
+// jsonValue encodes v for a jsonb column, nil values are stored as null
+func jsonValue(v interface{}) (interface{}, error) {
+	bs, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if string(bs) == "null" {
+		return nil, nil
+	}
+	return string(bs), nil
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package postgres
+
+import (
+	"audit/pkg/store"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBuildWhere(t *testing.T) {
+	where, args := buildWhere(&store.Query{})
+	if where != "" || args != nil {
+		t.Errorf("buildWhere of an empty query = %q, %v", where, args)
+	}
+
+	where, args = buildWhere(&store.Query{
+		UserName:        "alice",
+		StartTime:       100,
+		EndTime:         200,
+		SourceIpAddress: "10.0.0.1",
+		ResourceName:    "web-1",
+		EventName:       "delete pod",
+		ResponseStatus:  404,
+		Expr:            store.Term{Field: store.FieldStatus, Op: store.OpGte, Value: "500"},
+	})
+	wantWhere := " WHERE user_name = $1 AND event_time >= $2 AND event_time <= $3 AND source_ip_address = $4" +
+		" AND to_tsvector('simple', resource_names) @@ to_tsquery('simple', $5)" +
+		" AND to_tsvector('simple', event_name) @@ to_tsquery('simple', $6)" +
+		" AND response_status = $7 AND response_status >= $8"
+	wantArgs := []interface{}{"alice", time.Unix(100, 0), time.Unix(200, 0), "10.0.0.1", "'web' | '1'", "'delete' | 'pod'", 404, 500}
+	if where != wantWhere {
+		t.Errorf("where =\n%s\nwant\n%s", where, wantWhere)
+	}
+	if !reflect.DeepEqual(args, wantArgs) {
+		t.Errorf("args = %#v, want %#v", args, wantArgs)
+	}
+}
+
+func TestPageQuery(t *testing.T) {
+	selectFrom := func(column string) string {
+		return "SELECT " + selectColumns + ", " + column + "::text, id FROM audit_events"
+	}
+	after := encodeCursor("2024-01-01 00:00:00+00", 42)
+
+	tests := []struct {
+		name     string
+		query    store.Query
+		where    string
+		wantSQL  string
+		wantArgs []interface{}
+	}{
+		{
+			name:     "offset",
+			query:    store.Query{From: 20, Size: 10},
+			where:    " WHERE user_name = $1",
+			wantSQL:  selectFrom("event_time") + " WHERE user_name = $1 ORDER BY event_time DESC, id DESC LIMIT $2 OFFSET $3",
+			wantArgs: []interface{}{"alice", 10, 20},
+		},
+		{
+			name:     "negative offset",
+			query:    store.Query{From: -10, Size: 10, SortBy: "UserIdentity.AccountId", SortAsc: true},
+			where:    " WHERE user_name = $1",
+			wantSQL:  selectFrom("user_name") + " WHERE user_name = $1 ORDER BY user_name ASC, id ASC LIMIT $2 OFFSET $3",
+			wantArgs: []interface{}{"alice", 10, 0},
+		},
+		{
+			name:     "first cursor page",
+			query:    store.Query{Size: 10, SortBy: "ResponseStatus", SortAsc: true, Cursor: &store.Cursor{}},
+			where:    " WHERE user_name = $1",
+			wantSQL:  selectFrom("response_status") + " WHERE user_name = $1 ORDER BY response_status ASC, id ASC LIMIT $2",
+			wantArgs: []interface{}{"alice", 11},
+		},
+		{
+			name:  "next cursor page",
+			query: store.Query{Size: 10, Cursor: after},
+			where: " WHERE user_name = $1",
+			wantSQL: selectFrom("event_time") + " WHERE user_name = $1 AND (event_time, id) < ($2::timestamptz, $3::bigint)" +
+				" ORDER BY event_time DESC, id DESC LIMIT $4",
+			wantArgs: []interface{}{"alice", "2024-01-01 00:00:00+00", int64(42), 11},
+		},
+		{
+			name:  "next cursor page without filters",
+			query: store.Query{Size: 10, SortBy: "ResponseStatus", SortAsc: true, Cursor: after},
+			wantSQL: selectFrom("response_status") + " WHERE (response_status, id) > ($1::integer, $2::bigint)" +
+				" ORDER BY response_status ASC, id ASC LIMIT $3",
+			wantArgs: []interface{}{"2024-01-01 00:00:00+00", int64(42), 11},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var args, wantCountArgs []interface{}
+			if tt.where != "" {
+				args = make([]interface{}, 1, 4)
+				args[0] = "alice"
+				wantCountArgs = []interface{}{"alice"}
+			}
+			sql, pageArgs, err := pageQuery(&tt.query, tt.where, args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sql != tt.wantSQL {
+				t.Errorf("sql =\n%s\nwant\n%s", sql, tt.wantSQL)
+			}
+			if !reflect.DeepEqual(pageArgs, tt.wantArgs) {
+				t.Errorf("args = %#v, want %#v", pageArgs, tt.wantArgs)
+			}
+			if !reflect.DeepEqual(args, wantCountArgs) {
+				t.Errorf("the count query args changed to %#v", args)
+			}
+		})
+	}
+}
+
+func TestPageQueryErrors(t *testing.T) {
+	_, _, err := pageQuery(&store.Query{SortBy: "Description"}, "", nil)
+	if sortErr, ok := err.(*store.UnsupportedSortError); !ok || sortErr.Field != "Description" {
+		t.Errorf("pageQuery sorted by Description error = %v", err)
+	}
+	cursor := &store.Cursor{SearchAfter: []json.RawMessage{json.RawMessage(`1`)}}
+	if _, _, err = pageQuery(&store.Query{Cursor: cursor}, "", nil); err != store.ErrInvalidCursor {
+		t.Errorf("pageQuery with an invalid cursor error = %v", err)
+	}
+}
+
+func TestCursor(t *testing.T) {
+	cursor := encodeCursor(`2024-01-01 00:00:00+00 "quoted"`, 1<<60)
+	// the cursor survives the round trip through its token
+	decoded, err := store.DecodeCursor(store.EncodeCursor(cursor))
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, id, err := decodeCursor(decoded)
+	if err != nil || value != `2024-01-01 00:00:00+00 "quoted"` || id != 1<<60 {
+		t.Errorf("decodeCursor = %q, %d, %v", value, id, err)
+	}
+
+	for _, after := range [][]string{nil, {`"a"`}, {`1`, `1`}, {`"a"`, `"1"`}, {`"a"`, `1.5`}, {`"a"`, `1`, `1`}} {
+		cursor := &store.Cursor{}
+		for _, v := range after {
+			cursor.SearchAfter = append(cursor.SearchAfter, json.RawMessage(v))
+		}
+		if _, _, err := decodeCursor(cursor); err != store.ErrInvalidCursor {
+			t.Errorf("decodeCursor(%v) error = %v", after, err)
+		}
+	}
+}
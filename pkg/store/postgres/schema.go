@@ -0,0 +1,115 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/saashqdev/kubeworkz/pkg/clog"
+)
+
+// migrationLockKey serializes migrations of replicas starting at the same time
+const migrationLockKey = 7209533861
+
+// migrations are applied in order and recorded in schema_migrations, so
+// released entries must never change; append a new one instead
+var migrations = []string{
+	// 1: partitioned event table and its indexes
+	`CREATE TABLE audit_events (
+		id                 BIGSERIAL,
+		event_time         TIMESTAMPTZ NOT NULL,
+		event_version      TEXT NOT NULL DEFAULT '',
+		event_name         TEXT NOT NULL DEFAULT '',
+		description        TEXT NOT NULL DEFAULT '',
+		source_ip_address  TEXT NOT NULL DEFAULT '',
+		user_agent         TEXT NOT NULL DEFAULT '',
+		request_id         TEXT NOT NULL DEFAULT '',
+		request_method     TEXT NOT NULL DEFAULT '',
+		request_parameters TEXT NOT NULL DEFAULT '',
+		response_status    INTEGER NOT NULL DEFAULT 0,
+		response_elements  TEXT NOT NULL DEFAULT '',
+		event_type         TEXT NOT NULL DEFAULT '',
+		error_code         TEXT NOT NULL DEFAULT '',
+		error_message      TEXT NOT NULL DEFAULT '',
+		url                TEXT NOT NULL DEFAULT '',
+		user_name          TEXT NOT NULL DEFAULT '',
+		user_identity      JSONB,
+		api_action         TEXT NOT NULL DEFAULT '',
+		api_version        TEXT NOT NULL DEFAULT '',
+		resource_names     TEXT NOT NULL DEFAULT '',
+		resource_reports   JSONB,
+		PRIMARY KEY (event_time, id)
+	) PARTITION BY RANGE (event_time);
+	CREATE INDEX audit_events_user_name_idx ON audit_events (user_name, event_time);
+	CREATE INDEX audit_events_source_ip_address_idx ON audit_events (source_ip_address, event_time);
+	CREATE INDEX audit_events_response_status_idx ON audit_events (response_status, event_time);
+	CREATE INDEX audit_events_event_name_idx ON audit_events USING GIN (to_tsvector('simple', event_name));
+	CREATE INDEX audit_events_resource_names_idx ON audit_events USING GIN (to_tsvector('simple', resource_names));`,
+	// 2: security findings, null for most events
+	`ALTER TABLE audit_events ADD COLUMN findings JSONB;
+	CREATE INDEX audit_events_findings_idx ON audit_events USING GIN (findings jsonb_path_ops);`,
+	// 3: count of the values masked by redaction
+	`ALTER TABLE audit_events ADD COLUMN redactions INTEGER NOT NULL DEFAULT 0;`,
+	// 4: cluster, namespace and labels of V2 events
+	`ALTER TABLE audit_events ADD COLUMN cluster TEXT NOT NULL DEFAULT '';
+	ALTER TABLE audit_events ADD COLUMN namespace TEXT NOT NULL DEFAULT '';
+	ALTER TABLE audit_events ADD COLUMN labels JSONB;`,
+}
+
+// migrate brings the schema up to the latest version
+func migrate(db *sql.DB) error {
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`); err != nil {
+		return err
+	}
+
+	for i, migration := range migrations {
+		version := i + 1
+		if err := applyMigration(db, version, migration); err != nil {
+			return fmt.Errorf("apply schema migration %d error: %s", version, err)
+		}
+	}
+	return nil
+}
+
+func applyMigration(db *sql.DB, version int, migration string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec("SELECT pg_advisory_xact_lock($1)", migrationLockKey); err != nil {
+		return err
+	}
+	var applied bool
+	err = tx.QueryRow("SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)", version).Scan(&applied)
+	if err != nil || applied {
+		return err
+	}
+	if _, err = tx.Exec(migration); err != nil {
+		return err
+	}
+	if _, err = tx.Exec("INSERT INTO schema_migrations (version) VALUES ($1)", version); err != nil {
+		return err
+	}
+	clog.Info("apply audit schema migration %d", version)
+	return tx.Commit()
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	v1 "audit/pkg/backend/v1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"unicode"
+)
+
+const DefaultSortBy = "EventTime"
+
+var (
+	// ErrInvalidCursor is returned for a cursor that cannot be decoded or has expired
+	ErrInvalidCursor = errors.New("invalid or expired cursor")
+	// ErrResultWindowExceeded is returned when offset pagination goes deeper than the store allows
+	ErrResultWindowExceeded = errors.New("result window is too large, use cursor pagination")
+)
+
+// UnsupportedSortError is returned for a sort field the store can not order events by
+type UnsupportedSortError struct {
+	Field string
+}
+
+func (e *UnsupportedSortError) Error() string {
+	return "sorting by " + e.Field + " is not supported"
+}
+
+// Store persists audit events and serves queries over them
+type Store interface {
+	// Save writes a batch of events to the store
+	Save(events []v1.Event) error
+	// Search returns the events matching the query
+	Search(query *Query) (*Result, error)
+	// Aggregate computes statistics over the events matching the query,
+	// pagination and sorting of the query are ignored
+	Aggregate(query *Query, agg *Aggregation) (*Statistics, error)
+}
+
+type Query struct {
+	UserName        string
+	SourceIpAddress string
+	ResourceName    string
+	EventName       string
+	ResponseStatus  int
+	StartTime       int64
+	EndTime         int64
+	From            int
+	Size            int
+	SortBy          string
+	SortAsc         bool
+	// Expr filters events further, on top of the fields above
+	Expr Expr
+	// Cursor switches to cursor pagination when set, From is ignored then.
+	// An empty cursor starts from the first page.
+	Cursor *Cursor
+}
+
+type Result struct {
+	Total  int64
+	Events []v1.Event
+	// Cursor continues after the last returned event, nil when there are no more
+	Cursor *Cursor
+}
+
+// Cursor holds the position of a cursor paginated search, it is handed to
+// clients as an opaque token
+type Cursor struct {
+	// PitID is the point in time the search runs against, if the store has one
+	PitID string `json:"pit,omitempty"`
+	// SearchAfter holds the sort values of the last returned event
+	SearchAfter []json.RawMessage `json:"after,omitempty"`
+}
+
+func EncodeCursor(cursor *Cursor) string {
+	if cursor == nil {
+		return ""
+	}
+	bs, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(bs)
+}
+
+// DecodeCursor parses a cursor token, the empty token is the start of a search
+func DecodeCursor(token string) (*Cursor, error) {
+	cursor := &Cursor{}
+	if token == "" {
+		return cursor, nil
+	}
+	bs, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if err = json.Unmarshal(bs, cursor); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return cursor, nil
+}
+
+// Tokenize splits text the way the elasticsearch standard analyzer roughly does,
+// so that stores without full text search match like a match query
+func Tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
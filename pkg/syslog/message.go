@@ -0,0 +1,246 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package syslog
+
+import (
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	FormatRFC3164 = "rfc3164"
+	FormatRFC5424 = "rfc5424"
+
+	// nilValue is an empty field of RFC 5424
+	nilValue = "-"
+	// defaultPriority is user.notice, for messages without a priority
+	defaultPriority = 13
+	// maxPriority is local7.debug
+	maxPriority = 191
+	// bom may start the message of RFC 5424
+	bom = "\ufeff"
+)
+
+var severities = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+var facilities = []string{"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news", "uucp", "cron",
+	"authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7"}
+
+// Message is a syslog message, with the empty fields of RFC 5424 left empty
+type Message struct {
+	Format    string
+	Facility  string
+	Severity  string
+	Timestamp time.Time
+	Hostname  string
+	AppName   string
+	ProcID    string
+	MsgID     string
+	// StructuredData holds the params of the elements of RFC 5424 by element id
+	StructuredData map[string]map[string]string
+	Message        string
+}
+
+// Parse reads a message in RFC 5424 or RFC 3164, taking the year of the time
+// it was received for the timestamps of RFC 3164 which have none. Messages
+// without a priority are taken for the text of a user.notice message.
+func Parse(b []byte, received time.Time) (*Message, error) {
+	s := strings.TrimRight(strings.ToValidUTF8(string(b), string(utf8.RuneError)), "\r\n\x00")
+	if s == "" {
+		return nil, errors.New("empty message")
+	}
+	pri, rest := defaultPriority, s
+	if strings.HasPrefix(s, "<") {
+		end := strings.IndexByte(s, '>')
+		if end < 2 || end > 4 {
+			return nil, errors.New("invalid priority")
+		}
+		pri = 0
+		for _, c := range s[1:end] {
+			if c < '0' || c > '9' {
+				return nil, errors.New("invalid priority")
+			}
+			pri = pri*10 + int(c-'0')
+		}
+		if pri > maxPriority {
+			return nil, errors.New("invalid priority")
+		}
+		rest = s[end+1:]
+	}
+	m := &Message{Facility: facilities[pri/8], Severity: severities[pri%8]}
+	if strings.HasPrefix(rest, "1 ") {
+		return m, m.parse5424(rest[2:])
+	}
+	m.parse3164(rest, received)
+	return m, nil
+}
+
+// parse5424 reads TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA [MSG]
+func (m *Message) parse5424(s string) error {
+	m.Format = FormatRFC5424
+	header := make([]string, 5)
+	for i := range header {
+		var ok bool
+		if header[i], s, ok = nextToken(s); !ok {
+			return errors.New("truncated rfc5424 header")
+		}
+	}
+	if header[0] != nilValue {
+		t, err := time.Parse(time.RFC3339Nano, header[0])
+		if err != nil {
+			return errors.New("invalid rfc5424 timestamp")
+		}
+		m.Timestamp = t
+	}
+	m.Hostname, m.AppName, m.ProcID, m.MsgID = nilToEmpty(header[1]), nilToEmpty(header[2]), nilToEmpty(header[3]), nilToEmpty(header[4])
+
+	rest, err := m.parseStructuredData(s)
+	if err != nil {
+		return err
+	}
+	m.Message = strings.TrimPrefix(strings.TrimPrefix(rest, " "), bom)
+	return nil
+}
+
+// parseStructuredData reads - or [id name="value" ...]... and returns what follows
+func (m *Message) parseStructuredData(s string) (string, error) {
+	if strings.HasPrefix(s, nilValue) {
+		return s[1:], nil
+	}
+	for strings.HasPrefix(s, "[") {
+		s = s[1:]
+		end := strings.IndexAny(s, " ]")
+		if end <= 0 {
+			return "", errors.New("invalid structured data")
+		}
+		id := s[:end]
+		s = s[end:]
+		params := make(map[string]string)
+		for strings.HasPrefix(s, " ") {
+			s = strings.TrimLeft(s, " ")
+			eq := strings.Index(s, `="`)
+			if eq <= 0 {
+				return "", errors.New("invalid structured data param")
+			}
+			name := s[:eq]
+			s = s[eq+2:]
+			var value strings.Builder
+			closed := false
+			for i := 0; i < len(s); i++ {
+				if s[i] == '\\' && i+1 < len(s) && strings.IndexByte(`"\]`, s[i+1]) >= 0 {
+					value.WriteByte(s[i+1])
+					i++
+					continue
+				}
+				if s[i] == '"' {
+					s, closed = s[i+1:], true
+					break
+				}
+				value.WriteByte(s[i])
+			}
+			if !closed {
+				return "", errors.New("unterminated structured data param")
+			}
+			params[name] = value.String()
+		}
+		if !strings.HasPrefix(s, "]") {
+			return "", errors.New("unterminated structured data element")
+		}
+		s = s[1:]
+		if m.StructuredData == nil {
+			m.StructuredData = make(map[string]map[string]string)
+		}
+		m.StructuredData[id] = params
+	}
+	if m.StructuredData == nil {
+		return "", errors.New("invalid structured data")
+	}
+	return s, nil
+}
+
+// parse3164 reads TIMESTAMP HOSTNAME TAG: MSG as loosely as the senders
+// write it, the timestamp being Mmm dd hh:mm:ss or in RFC 3339. Whatever can
+// not be read is left in the message.
+func (m *Message) parse3164(s string, received time.Time) {
+	m.Format = FormatRFC3164
+	s = strings.TrimLeft(s, " ")
+	if t, rest, ok := parse3164Timestamp(s, received); ok {
+		m.Timestamp, s = t, rest
+	}
+	// the hostname is missing when the first token is the tag
+	if token, rest, ok := nextToken(s); ok && !isTag(token) && !m.Timestamp.IsZero() {
+		m.Hostname, s = token, rest
+	}
+	if token, rest, ok := nextToken(s); ok && isTag(token) {
+		tag := strings.TrimSuffix(token, ":")
+		if i := strings.IndexByte(tag, '['); i > 0 && strings.HasSuffix(tag, "]") {
+			m.ProcID = tag[i+1 : len(tag)-1]
+			tag = tag[:i]
+		}
+		m.AppName, s = tag, rest
+	}
+	m.Message = s
+}
+
+func parse3164Timestamp(s string, received time.Time) (time.Time, string, bool) {
+	if token, rest, ok := nextToken(s); ok {
+		if t, err := time.Parse(time.RFC3339Nano, token); err == nil {
+			return t, rest, true
+		}
+	}
+	const layout = "Jan _2 15:04:05"
+	if len(s) < len(layout) {
+		return time.Time{}, s, false
+	}
+	t, err := time.ParseInLocation(layout, s[:len(layout)], received.Location())
+	if err != nil {
+		return time.Time{}, s, false
+	}
+	t = t.AddDate(received.Year(), 0, 0)
+	// a message of december received in january
+	if t.After(received.Add(24 * time.Hour)) {
+		t = t.AddDate(-1, 0, 0)
+	}
+	return t, strings.TrimPrefix(s[len(layout):], " "), true
+}
+
+// isTag reports whether a token is a tag like sshd: or sshd[42]:
+func isTag(token string) bool {
+	return strings.HasSuffix(token, ":") && len(token) > 1 && len(token) <= 64
+}
+
+// nextToken returns the text up to the next space and what follows it
+func nextToken(s string) (string, string, bool) {
+	i := strings.IndexByte(s, ' ')
+	if i < 0 {
+		return s, "", s != ""
+	}
+	if i == 0 {
+		return "", s, false
+	}
+	return s[:i], s[i+1:], true
+}
+
+func nilToEmpty(s string) string {
+	if s == nilValue {
+		return ""
+	}
+	return s
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package syslog
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	received := time.Date(2024, 1, 3, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		in      string
+		want    *Message
+		wantErr bool
+	}{
+		{
+			name: "rfc5424 with structured data and bom",
+			in:   `<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut="3" user="bo\"b"][meta seq="1"] ` + bom + "An application event",
+			want: &Message{
+				Format: FormatRFC5424, Facility: "local4", Severity: "notice",
+				Timestamp: time.Date(2003, 10, 11, 22, 14, 15, 3000000, time.UTC),
+				Hostname:  "mymachine.example.com", AppName: "evntslog", MsgID: "ID47",
+				StructuredData: map[string]map[string]string{
+					"exampleSDID@32473": {"iut": "3", "user": `bo"b`},
+					"meta":              {"seq": "1"},
+				},
+				Message: "An application event",
+			},
+		},
+		{
+			name: "rfc5424 with nil values",
+			in:   "<13>1 - - - - - -",
+			want: &Message{Format: FormatRFC5424, Facility: "user", Severity: "notice"},
+		},
+		{
+			name: "rfc5424 with procid and trailing newline",
+			in:   "<86>1 2024-01-02T03:04:05+01:00 host sshd 42 - - accepted\r\n",
+			want: &Message{
+				Format: FormatRFC5424, Facility: "authpriv", Severity: "info",
+				Timestamp: time.Date(2024, 1, 2, 3, 4, 5, 0, time.FixedZone("", 3600)),
+				Hostname:  "host", AppName: "sshd", ProcID: "42", Message: "accepted",
+			},
+		},
+		{
+			name: "rfc3164",
+			in:   "<34>Oct 11 22:14:15 mymachine su: 'su root' failed",
+			want: &Message{
+				Format: FormatRFC3164, Facility: "auth", Severity: "crit",
+				Timestamp: time.Date(2023, 10, 11, 22, 14, 15, 0, time.UTC),
+				Hostname:  "mymachine", AppName: "su", Message: "'su root' failed",
+			},
+		},
+		{
+			name: "rfc3164 with pid in the tag",
+			in:   "<38>Jan  2 23:59:00 fw01 sshd[4242]: Accepted password",
+			want: &Message{
+				Format: FormatRFC3164, Facility: "auth", Severity: "info",
+				Timestamp: time.Date(2024, 1, 2, 23, 59, 0, 0, time.UTC),
+				Hostname:  "fw01", AppName: "sshd", ProcID: "4242", Message: "Accepted password",
+			},
+		},
+		{
+			name: "rfc3164 with rfc3339 timestamp",
+			in:   "<14>2024-01-02T03:04:05Z web nginx: GET /",
+			want: &Message{
+				Format: FormatRFC3164, Facility: "user", Severity: "info",
+				Timestamp: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+				Hostname:  "web", AppName: "nginx", Message: "GET /",
+			},
+		},
+		{
+			name: "rfc3164 without timestamp nor hostname",
+			in:   "<13>sshd: no host",
+			want: &Message{Format: FormatRFC3164, Facility: "user", Severity: "notice", AppName: "sshd", Message: "no host"},
+		},
+		{
+			name: "no priority",
+			in:   "plain text",
+			want: &Message{Format: FormatRFC3164, Facility: "user", Severity: "notice", Message: "plain text"},
+		},
+		{
+			name: "lowest priority",
+			in:   "<0>x",
+			want: &Message{Format: FormatRFC3164, Facility: "kern", Severity: "emerg", Message: "x"},
+		},
+		{
+			name: "highest priority",
+			in:   "<191>x",
+			want: &Message{Format: FormatRFC3164, Facility: "local7", Severity: "debug", Message: "x"},
+		},
+		{name: "empty", in: "\r\n", wantErr: true},
+		{name: "negative priority", in: "<-1>hello", wantErr: true},
+		{name: "negative two digit priority", in: "<-9>hello", wantErr: true},
+		{name: "signed priority", in: "<+1>hello", wantErr: true},
+		{name: "priority too high", in: "<192>hello", wantErr: true},
+		{name: "priority too long", in: "<0013>hello", wantErr: true},
+		{name: "empty priority", in: "<>hello", wantErr: true},
+		{name: "unterminated priority", in: "<13 hello", wantErr: true},
+		{name: "priority not a number", in: "<1a>hello", wantErr: true},
+		{name: "truncated rfc5424 header", in: "<13>1 - - - -", wantErr: true},
+		{name: "invalid rfc5424 timestamp", in: "<13>1 yesterday - - - - -", wantErr: true},
+		{name: "missing structured data", in: "<13>1 - - - - - hello", wantErr: true},
+		{name: "unterminated structured data param", in: `<13>1 - - - - - [id a="1] x`, wantErr: true},
+		{name: "unterminated structured data element", in: `<13>1 - - - - - [id a="1"`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse([]byte(tt.in), received)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Parse(%q) = %+v, want error", tt.in, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse(%q) error: %v", tt.in, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse(%q) =\n%+v\nwant\n%+v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseYearRollover(t *testing.T) {
+	received := time.Date(2024, 1, 1, 0, 0, 30, 0, time.UTC)
+	m, err := Parse([]byte("<13>Dec 31 23:59:50 host app: late"), received)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2023, 12, 31, 23, 59, 50, 0, time.UTC); !m.Timestamp.Equal(want) {
+		t.Errorf("timestamp = %s, want %s", m.Timestamp, want)
+	}
+}
@@ -0,0 +1,301 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package syslog
+
+import (
+	v1 "audit/pkg/backend/v1"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	eventResourceSyslog = "[Syslog]"
+
+	// maxNameLength bounds the event names taken from the messages
+	maxNameLength = 200
+)
+
+// Rule extracts the fields of the events from the messages in its scope. All
+// the rules in scope apply in order, a field set by a rule replacing what the
+// rules before it set.
+type Rule struct {
+	// Name identifies the rule, a rule named after a built-in one replaces it
+	Name     string
+	Disabled bool
+	// AppNames and Hostnames are globs scoping the rule, any when empty
+	AppNames  []string
+	Hostnames []string
+	// Pattern is a regular expression on the message, its groups named after a field fill it, e.g. (?P<User>\S+)
+	Pattern string
+	// KeyValue reads the key=value pairs of the message and the params of its structured data
+	KeyValue bool
+	// Keys maps the keys of the pairs to fields, keys named after a field fill it
+	Keys map[string]string
+}
+
+// defaultRules are the built-in rules
+var defaultRules = []Rule{
+	{
+		Name:     "key-value",
+		KeyValue: true,
+		Keys: map[string]string{
+			"user": v1.FieldUser, "username": v1.FieldUser, "usr": v1.FieldUser, "suser": v1.FieldUser,
+			"src": v1.FieldSourceIpAddress, "src_ip": v1.FieldSourceIpAddress, "srcip": v1.FieldSourceIpAddress,
+			"client": v1.FieldSourceIpAddress, "client_ip": v1.FieldSourceIpAddress, "rhost": v1.FieldSourceIpAddress,
+			"action": v1.FieldEventName, "act": v1.FieldEventName, "event": v1.FieldEventName, "operation": v1.FieldEventName,
+			"method": v1.FieldRequestMethod,
+			"status": v1.FieldResponseStatus, "status_code": v1.FieldResponseStatus,
+			"url": v1.FieldUrl, "uri": v1.FieldUrl, "path": v1.FieldUrl,
+			"reason": v1.FieldErrorMessage, "error": v1.FieldErrorMessage,
+			"resource": v1.FieldResourceName, "object": v1.FieldResourceName,
+			"request_id": v1.FieldRequestId, "req_id": v1.FieldRequestId,
+		},
+	},
+}
+
+var pairRegexp = regexp.MustCompile(`([A-Za-z_][\w.-]*)=("(?:[^"\\]|\\.)*"|'[^']*'|[^\s,;]+)`)
+
+var rules = struct {
+	sync.RWMutex
+	rules []*compiledRule
+}{rules: mustCompileRules(defaultRules)}
+
+type compiledRule struct {
+	Rule
+	pattern *regexp.Regexp
+}
+
+// LoadRules replaces and adds to the built-in rules with those of a json file
+func LoadRules(file string) error {
+	merged := append([]Rule{}, defaultRules...)
+	bs, err := ioutil.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		var configured []Rule
+		if err = json.Unmarshal(bs, &configured); err != nil {
+			return err
+		}
+		for _, c := range configured {
+			replaced := false
+			for i := range merged {
+				if merged[i].Name == c.Name {
+					merged[i], replaced = c, true
+					break
+				}
+			}
+			if !replaced {
+				merged = append(merged, c)
+			}
+		}
+	}
+	compiled, err := compileRules(merged)
+	if err != nil {
+		return err
+	}
+	rules.Lock()
+	rules.rules = compiled
+	rules.Unlock()
+	return nil
+}
+
+func compileRules(list []Rule) ([]*compiledRule, error) {
+	var compiled []*compiledRule
+	for _, rule := range list {
+		if rule.Disabled {
+			continue
+		}
+		if rule.Name == "" {
+			return nil, fmt.Errorf("syslog rule name is required")
+		}
+		if rule.Pattern == "" && !rule.KeyValue {
+			return nil, fmt.Errorf("syslog rule %s: a pattern or key value is required", rule.Name)
+		}
+		r := &compiledRule{Rule: rule}
+		if rule.Pattern != "" {
+			pattern, err := regexp.Compile(rule.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("syslog rule %s: %s", rule.Name, err)
+			}
+			for _, name := range pattern.SubexpNames() {
+				if name != "" && !v1.IsField(name) {
+					return nil, fmt.Errorf("syslog rule %s: group %s is not a field", rule.Name, name)
+				}
+			}
+			r.pattern = pattern
+		}
+		for key, field := range rule.Keys {
+			if !v1.IsField(field) {
+				return nil, fmt.Errorf("syslog rule %s: key %s is mapped to %s, which is not a field", rule.Name, key, field)
+			}
+		}
+		for _, glob := range append(append([]string{}, rule.AppNames...), rule.Hostnames...) {
+			if _, err := path.Match(glob, ""); err != nil {
+				return nil, fmt.Errorf("syslog rule %s: pattern %q: %s", rule.Name, glob, err)
+			}
+		}
+		compiled = append(compiled, r)
+	}
+	return compiled, nil
+}
+
+func mustCompileRules(list []Rule) []*compiledRule {
+	compiled, err := compileRules(list)
+	if err != nil {
+		panic(err)
+	}
+	return compiled
+}
+
+// ToV1 maps a message onto an event, named after the field extracted by the
+// rules, its message id or its first line, and tagged as coming from syslog.
+// The address of the sender is the source ip unless one is extracted, and the
+// header, the structured data and the pairs of the message are the request parameters.
+func ToV1(m *Message, sender string, received time.Time) *v1.Event {
+	rules.RLock()
+	list := rules.rules
+	rules.RUnlock()
+
+	e := &v1.Event{
+		EventTime:       received.Unix(),
+		Description:     m.Message,
+		SourceIpAddress: sender,
+	}
+	if !m.Timestamp.IsZero() {
+		e.EventTime = m.Timestamp.Unix()
+	}
+	var pairs map[string]string
+	for _, r := range list {
+		if !matchAny(r.AppNames, m.AppName) || !matchAny(r.Hostnames, m.Hostname) {
+			continue
+		}
+		if r.pattern != nil {
+			match := r.pattern.FindStringSubmatch(m.Message)
+			for i, name := range r.pattern.SubexpNames() {
+				if match != nil && name != "" && match[i] != "" {
+					v1.SetField(e, name, match[i])
+				}
+			}
+		}
+		if r.KeyValue {
+			if pairs == nil {
+				pairs = m.pairs()
+			}
+			// keys in a fixed order, for those filling the same field
+			keys := make([]string, 0, len(pairs))
+			for key := range pairs {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				if field, ok := r.Keys[key]; ok {
+					v1.SetField(e, field, pairs[key])
+				} else if v1.IsField(key) {
+					v1.SetField(e, key, pairs[key])
+				}
+			}
+		}
+	}
+
+	name := e.EventName
+	if name == "" {
+		name = m.MsgID
+	}
+	if name == "" {
+		name = strings.TrimSpace(strings.SplitN(m.Message, "\n", 2)[0])
+		if len(name) > maxNameLength {
+			name = strings.ToValidUTF8(name[:maxNameLength], "")
+		}
+	}
+	if name == "" {
+		name = m.AppName
+	}
+	if name == "" {
+		name = m.Facility + "." + m.Severity
+	}
+	e.EventName = eventResourceSyslog + " " + name
+	e.RequestParameters = m.parameters(sender, pairs)
+	return e
+}
+
+// pairs returns the key=value pairs of the message and the params of its structured data
+func (m *Message) pairs() map[string]string {
+	pairs := make(map[string]string)
+	for _, match := range pairRegexp.FindAllStringSubmatch(m.Message, -1) {
+		value := match[2]
+		switch value[0] {
+		case '"':
+			if unquoted, err := strconv.Unquote(value); err == nil {
+				value = unquoted
+			} else {
+				value = value[1 : len(value)-1]
+			}
+		case '\'':
+			value = value[1 : len(value)-1]
+		}
+		pairs[match[1]] = value
+	}
+	for _, params := range m.StructuredData {
+		for name, value := range params {
+			pairs[name] = value
+		}
+	}
+	return pairs
+}
+
+// parameters returns the header, structured data and pairs of the message in json
+func (m *Message) parameters(sender string, pairs map[string]string) string {
+	bs, err := json.Marshal(struct {
+		Format         string
+		Sender         string
+		Facility       string
+		Severity       string
+		Hostname       string                       `json:",omitempty"`
+		AppName        string                       `json:",omitempty"`
+		ProcID         string                       `json:",omitempty"`
+		MsgID          string                       `json:",omitempty"`
+		StructuredData map[string]map[string]string `json:",omitempty"`
+		Pairs          map[string]string            `json:",omitempty"`
+	}{m.Format, sender, m.Facility, m.Severity, m.Hostname, m.AppName, m.ProcID, m.MsgID, m.StructuredData, pairs})
+	if err != nil {
+		return ""
+	}
+	return string(bs)
+}
+
+// matchAny reports whether a value matches one of the globs, or there are none
+func matchAny(globs []string, value string) bool {
+	if len(globs) == 0 {
+		return true
+	}
+	for _, glob := range globs {
+		if matched, _ := path.Match(glob, value); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,143 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package syslog
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestToV1(t *testing.T) {
+	received := time.Date(2024, 1, 3, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		in         string
+		wantName   string
+		wantUser   string
+		wantSource string
+		wantStatus int
+		wantUrl    string
+	}{
+		{
+			name:       "key value pairs",
+			in:         "<38>Jan  2 23:59:00 fw01 sshd[42]: session user=alice src=10.1.2.3 action=login",
+			wantName:   "[Syslog] login",
+			wantUser:   "alice",
+			wantSource: "10.1.2.3",
+		},
+		{
+			name:       "quoted values",
+			in:         `<13>web: method=GET url="/a b" status=403`,
+			wantName:   `[Syslog] method=GET url="/a b" status=403`,
+			wantSource: "192.0.2.1",
+			wantStatus: 403,
+			wantUrl:    "/a b",
+		},
+		{
+			name:       "structured data params",
+			in:         `<165>1 - host app - ID47 [meta user="bob"] hello`,
+			wantName:   "[Syslog] ID47",
+			wantUser:   "bob",
+			wantSource: "192.0.2.1",
+		},
+		{
+			name:       "empty message named after the app",
+			in:         "<13>1 - - app - - -",
+			wantName:   "[Syslog] app",
+			wantSource: "192.0.2.1",
+		},
+		{
+			name:       "empty message named after the priority",
+			in:         "<13>1 - - - - - -",
+			wantName:   "[Syslog] user.notice",
+			wantSource: "192.0.2.1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := Parse([]byte(tt.in), received)
+			if err != nil {
+				t.Fatal(err)
+			}
+			e := ToV1(m, "192.0.2.1", received)
+			if e.EventName != tt.wantName {
+				t.Errorf("EventName = %q, want %q", e.EventName, tt.wantName)
+			}
+			user := ""
+			if e.UserIdentity != nil {
+				user = e.UserIdentity.AccountId
+			}
+			if user != tt.wantUser {
+				t.Errorf("user = %q, want %q", user, tt.wantUser)
+			}
+			if e.SourceIpAddress != tt.wantSource {
+				t.Errorf("SourceIpAddress = %q, want %q", e.SourceIpAddress, tt.wantSource)
+			}
+			if e.ResponseStatus != tt.wantStatus {
+				t.Errorf("ResponseStatus = %d, want %d", e.ResponseStatus, tt.wantStatus)
+			}
+			if e.Url != tt.wantUrl {
+				t.Errorf("Url = %q, want %q", e.Url, tt.wantUrl)
+			}
+		})
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+	defer func() { rules.rules = mustCompileRules(defaultRules) }()
+	file := filepath.Join(t.TempDir(), "rules.json")
+
+	for _, invalid := range []string{
+		`[{"Name": "bad", "KeyValue": true, "Keys": {"x": "Nope"}}]`,
+		`[{"Name": "bad", "Pattern": "(?P<Nope>.*)"}]`,
+		`[{"Name": "bad", "Pattern": "("}]`,
+		`[{"Name": "bad"}]`,
+		`[{"Pattern": ".*"}]`,
+		`{`,
+	} {
+		if err := ioutil.WriteFile(file, []byte(invalid), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := LoadRules(file); err == nil {
+			t.Errorf("LoadRules(%s) succeeded, want an error", invalid)
+		}
+	}
+
+	rulesJSON := `[
+		{"Name": "sshd", "AppNames": ["ssh*"], "Pattern": "Accepted \\w+ for (?P<User>\\S+) from (?P<SourceIpAddress>\\S+)"},
+		{"Name": "key-value", "Disabled": true}
+	]`
+	if err := ioutil.WriteFile(file, []byte(rulesJSON), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadRules(file); err != nil {
+		t.Fatal(err)
+	}
+	received := time.Date(2024, 1, 3, 10, 0, 0, 0, time.UTC)
+	m, _ := Parse([]byte("<38>Jan  2 23:59:00 fw01 sshd[42]: Accepted password for carol from 10.9.9.9 user=alice"), received)
+	e := ToV1(m, "192.0.2.1", received)
+	if e.UserIdentity == nil || e.UserIdentity.AccountId != "carol" || e.SourceIpAddress != "10.9.9.9" {
+		t.Errorf("pattern rule gave user %+v and source %q", e.UserIdentity, e.SourceIpAddress)
+	}
+	// the key-value rule is disabled, and the pattern is out of scope for other apps
+	m, _ = Parse([]byte("<38>Jan  2 23:59:00 fw01 su: Accepted password for carol from 10.9.9.9 user=alice"), received)
+	if e = ToV1(m, "192.0.2.1", received); e.UserIdentity != nil {
+		t.Errorf("out of scope message gave user %+v", e.UserIdentity)
+	}
+}
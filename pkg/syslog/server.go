@@ -0,0 +1,259 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package syslog
+
+import (
+	"audit/pkg/backend"
+	"audit/pkg/utils/env"
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"time"
+
+	"github.com/saashqdev/kubeworkz/pkg/clog"
+)
+
+const (
+	// maxMessageSize is the largest message read, longer ones are cut
+	maxMessageSize = 64 << 10
+	// maxOctetCountDigits is the length of the octet counts of the largest messages
+	maxOctetCountDigits = 5
+	// maxConnections is the number of senders connected at once over tcp
+	maxConnections = 1000
+	// idleTimeout closes the tcp connections of the senders which stay silent
+	idleTimeout = 10 * time.Minute
+	// acceptRetryDelay waits after an error accepting a connection, like too many open files
+	acceptRetryDelay = 100 * time.Millisecond
+)
+
+type server struct {
+	conns chan struct{}
+}
+
+// Start receives syslog messages on the udp, tcp and tls addresses which are set
+func Start() {
+	cfg := env.Syslog()
+	if cfg.UDPAddr == "" && cfg.TCPAddr == "" && cfg.TLSAddr == "" {
+		return
+	}
+	if cfg.RulePath != "" {
+		if err := LoadRules(cfg.RulePath); err != nil {
+			clog.Error("load syslog rules from %s error: %s, the built-in rules are used", cfg.RulePath, err)
+		}
+	}
+
+	s := &server{conns: make(chan struct{}, maxConnections)}
+	if cfg.UDPAddr != "" {
+		conn, err := net.ListenPacket("udp", cfg.UDPAddr)
+		if err != nil {
+			clog.Error("listen syslog on udp %s error: %s", cfg.UDPAddr, err)
+		} else {
+			go s.serveUDP(conn)
+		}
+	}
+	if cfg.TCPAddr != "" {
+		ln, err := net.Listen("tcp", cfg.TCPAddr)
+		if err != nil {
+			clog.Error("listen syslog on tcp %s error: %s", cfg.TCPAddr, err)
+		} else {
+			go s.serveTCP(ln)
+		}
+	}
+	if cfg.TLSAddr != "" {
+		tlsConfig, err := newTLSConfig(cfg)
+		if err != nil {
+			clog.Error("load syslog tls certificates error: %s", err)
+			return
+		}
+		ln, err := tls.Listen("tcp", cfg.TLSAddr, tlsConfig)
+		if err != nil {
+			clog.Error("listen syslog on tls %s error: %s", cfg.TLSAddr, err)
+		} else {
+			go s.serveTCP(ln)
+		}
+	}
+}
+
+func newTLSConfig(cfg *env.SyslogServer) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey)
+	if err != nil {
+		return nil, err
+	}
+	c := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if cfg.TLSClientCA != "" {
+		bs, err := ioutil.ReadFile(cfg.TLSClientCA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(bs) {
+			return nil, fmt.Errorf("no certificate in %s", cfg.TLSClientCA)
+		}
+		c.ClientCAs = pool
+		c.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return c, nil
+}
+
+// serveUDP reads a message per datagram
+func (s *server) serveUDP(conn net.PacketConn) {
+	clog.Info("receive syslog on udp %s", conn.LocalAddr())
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			clog.Error("read syslog on udp error: %s", err)
+			time.Sleep(acceptRetryDelay)
+			continue
+		}
+		receive(buf[:n], host(addr))
+	}
+}
+
+func (s *server) serveTCP(ln net.Listener) {
+	clog.Info("receive syslog on tcp %s", ln.Addr())
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			clog.Error("accept syslog connection error: %s", err)
+			time.Sleep(acceptRetryDelay)
+			continue
+		}
+		select {
+		case s.conns <- struct{}{}:
+		default:
+			clog.Warn("too many syslog connections, close the one of %s", conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+		go func() {
+			defer func() { <-s.conns }()
+			serveConn(conn)
+		}()
+	}
+}
+
+// serveConn reads the messages of a connection, framed by octet counting or newlines
+func serveConn(conn net.Conn) {
+	defer conn.Close()
+	sender := host(conn.RemoteAddr())
+	r := bufio.NewReader(conn)
+	for {
+		conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		msg, err := readFrame(r)
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			clog.Warn("read syslog from %s error: %s", sender, err)
+			return
+		}
+		receive(msg, sender)
+	}
+}
+
+// readFrame reads a message as LEN SP MSG when it starts with a digit, or up to
+// the end of its line. Lines longer than maxMessageSize are cut.
+func readFrame(r *bufio.Reader) ([]byte, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if first[0] >= '1' && first[0] <= '9' {
+		n := 0
+		for i := 0; ; i++ {
+			c, err := r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			if c == ' ' && i > 0 {
+				break
+			}
+			if c < '0' || c > '9' || i >= maxOctetCountDigits {
+				return nil, errors.New("invalid octet count")
+			}
+			n = n*10 + int(c-'0')
+		}
+		if n > maxMessageSize {
+			return nil, fmt.Errorf("message of %d bytes is larger than %d bytes", n, maxMessageSize)
+		}
+		msg := make([]byte, n)
+		if _, err = io.ReadFull(r, msg); err != nil {
+			// a frame cut by the end of the connection is not a message
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		return msg, nil
+	}
+
+	var msg []byte
+	for {
+		line, err := r.ReadSlice('\n')
+		if len(msg) < maxMessageSize {
+			msg = append(msg, line[:min(len(line), maxMessageSize-len(msg))]...)
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == io.EOF && len(msg) > 0 {
+			return msg, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return msg, nil
+	}
+}
+
+// receive caches the event of a message, dropping what can not be read
+func receive(b []byte, sender string) {
+	// a message which breaks the parser must not stop the server
+	defer func() {
+		if r := recover(); r != nil {
+			clog.Error("drop syslog message from %s: %v", sender, r)
+		}
+	}()
+	received := time.Now()
+	m, err := Parse(b, received)
+	if err != nil {
+		clog.Warn("drop syslog message from %s: %s", sender, err)
+		return
+	}
+	backend.CacheEvent(backend.GetCacheCh(), ToV1(m, sender, received))
+}
+
+func host(addr net.Addr) string {
+	h, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return h
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2024 Kubeworkz Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package syslog
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestReadFrame(t *testing.T) {
+	long := strings.Repeat("a", maxMessageSize+100)
+	tests := []struct {
+		name    string
+		in      string
+		want    []string
+		wantErr error
+	}{
+		{
+			name:    "octet counted",
+			in:      "11 <13>hello x5 <13>a",
+			want:    []string{"<13>hello x", "<13>a"},
+			wantErr: io.EOF,
+		},
+		{
+			name:    "octet counted with newlines in the message",
+			in:      "9 <13>a\nb\nc",
+			want:    []string{"<13>a\nb\nc"},
+			wantErr: io.EOF,
+		},
+		{
+			name:    "newline delimited",
+			in:      "<13>one\n<13>two\n",
+			want:    []string{"<13>one\n", "<13>two\n"},
+			wantErr: io.EOF,
+		},
+		{
+			name:    "newline delimited without a final newline",
+			in:      "<13>one\nlast",
+			want:    []string{"<13>one\n", "last"},
+			wantErr: io.EOF,
+		},
+		{
+			name:    "mixed framing",
+			in:      "4 <1>a<13>b\n",
+			want:    []string{"<1>a", "<13>b\n"},
+			wantErr: io.EOF,
+		},
+		{
+			name:    "long line is cut",
+			in:      long + "\nnext\n",
+			want:    []string{long[:maxMessageSize], "next\n"},
+			wantErr: io.EOF,
+		},
+		{
+			name:    "truncated octet counted frame",
+			in:      "20 <13>short",
+			wantErr: io.ErrUnexpectedEOF,
+		},
+		{
+			name:    "frame cut after the count",
+			in:      "5 ",
+			wantErr: io.ErrUnexpectedEOF,
+		},
+		{
+			name:    "count cut before the space",
+			in:      "12",
+			wantErr: io.EOF,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bufio.NewReader(strings.NewReader(tt.in))
+			var got []string
+			var err error
+			for {
+				var msg []byte
+				if msg, err = readFrame(r); err != nil {
+					if msg != nil {
+						t.Errorf("readFrame returned %q with error %v", msg, err)
+					}
+					break
+				}
+				got = append(got, string(msg))
+			}
+			if err != tt.wantErr {
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d frames, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("frame %d = %.40q, want %.40q", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestReadFrameInvalidCount(t *testing.T) {
+	for _, in := range []string{"1234567 x", "12a x", "99999 x"} {
+		if msg, err := readFrame(bufio.NewReader(strings.NewReader(in))); err == nil || err == io.EOF {
+			t.Errorf("readFrame(%q) = %q, %v, want an error", in, msg, err)
+		}
+	}
+}
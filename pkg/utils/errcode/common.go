@@ -17,9 +17,51 @@ limitations under the License.
 package errcode
 
 var (
-	InternalServerError = New(internalServerError)
-	InvalidBodyFormat   = New(invalidBodyFormat)
-	NoAuthority         = New(noAuthority)
-	AuthenticateError   = New(authenticateError)
-	NotFound            = New(notFound)
+	InternalServerError     = New(internalServerError)
+	InvalidBodyFormat       = New(invalidBodyFormat)
+	NoAuthority             = New(noAuthority)
+	AuthenticateError       = New(authenticateError)
+	NotFound                = New(notFound)
+	InvalidCursor           = New(invalidCursor)
+	ResultWindowExceeded    = New(resultWindowExceeded)
+	TooManyExportJobs       = New(tooManyExportJobs)
+	ExportJobNotReady       = New(exportJobNotReady)
+	UnsupportedExportFormat = New(unsupportedExportFormat)
+	TooManyTailSubscribers  = New(tooManyTailSubscribers)
+	TooManyAlertRules       = New(tooManyAlertRules)
 )
+
+// InvalidEvent tells why a received event can not be read
+func InvalidEvent(reason string) *ErrorInfo {
+	return New(invalidEvent, reason)
+}
+
+// UnsupportedContentEncoding tells the content encoding of a request body which can not be read
+func UnsupportedContentEncoding(encoding string) *ErrorInfo {
+	return New(unsupportedContentEncoding, encoding)
+}
+
+// InvalidQuery tells why a search expression can not be parsed
+func InvalidQuery(reason string) *ErrorInfo {
+	return New(invalidQuery, reason)
+}
+
+// InvalidFilter tells why a filter value can not be used
+func InvalidFilter(reason string) *ErrorInfo {
+	return New(invalidFilter, reason)
+}
+
+// InvalidStatisticsOption tells why an interval or aggregated field can not be used
+func InvalidStatisticsOption(reason string) *ErrorInfo {
+	return New(invalidStatisticsOption, reason)
+}
+
+// InvalidAlertRule tells why an alert rule can not be used
+func InvalidAlertRule(reason string) *ErrorInfo {
+	return New(invalidAlertRule, reason)
+}
+
+// InvalidExportOption tells which export option is invalid, e.g. "time zone Mars/Olympus"
+func InvalidExportOption(option string) *ErrorInfo {
+	return New(invalidExportOption, option)
+}
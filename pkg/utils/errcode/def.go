@@ -43,4 +43,30 @@ var (
 	authenticateError = &ErrorInfo{http.StatusUnauthorized, "Authenticate failed."}
 
 	notFound = &ErrorInfo{http.StatusNotFound, "No result found."}
+
+	// ingest
+	invalidEvent               = &ErrorInfo{http.StatusBadRequest, "Event is invalid: %s."}
+	unsupportedContentEncoding = &ErrorInfo{http.StatusUnsupportedMediaType, "Content encoding %s is not supported, please use gzip or zstd."}
+
+	// search
+	invalidCursor        = &ErrorInfo{http.StatusBadRequest, "Cursor is invalid or expired, please search again."}
+	resultWindowExceeded = &ErrorInfo{http.StatusBadRequest, "Page is too deep, please use cursor pagination."}
+	invalidQuery         = &ErrorInfo{http.StatusBadRequest, "Query is invalid: %s."}
+	invalidFilter        = &ErrorInfo{http.StatusBadRequest, "Filter is invalid: %s."}
+
+	// statistics
+	invalidStatisticsOption = &ErrorInfo{http.StatusBadRequest, "Statistics option is invalid: %s."}
+
+	// tail
+	tooManyTailSubscribers = &ErrorInfo{http.StatusTooManyRequests, "Too many live tails, please try again later."}
+
+	// alert
+	invalidAlertRule  = &ErrorInfo{http.StatusBadRequest, "Alert rule is invalid: %s."}
+	tooManyAlertRules = &ErrorInfo{http.StatusBadRequest, "Too many alert rules, please delete unused ones."}
+
+	// export
+	invalidExportOption     = &ErrorInfo{http.StatusBadRequest, "Export option %s is invalid."}
+	unsupportedExportFormat = &ErrorInfo{http.StatusBadRequest, "Export format is not supported, please use csv, json, ndjson, xlsx or cloudevents."}
+	tooManyExportJobs       = &ErrorInfo{http.StatusTooManyRequests, "Too many export jobs, please try again later."}
+	exportJobNotReady       = &ErrorInfo{http.StatusConflict, "Export job is not finished."}
 )